@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// How much the slave prints about the replicated statements it applies.
+// By default only failures, plus one line per second in which statements
+// were applied, so heavy replication doesn't bury the menu; -verbose adds
+// a line for every statement.
+
+var verbose bool
+
+const applySummaryInterval = time.Second
+
+// Statements applied since the last summary
+var appliedSinceSummary atomic.Int64
+
+// Print a line about a single statement, only with -verbose
+func verbosef(format string, args ...any) {
+	if verbose {
+		fmt.Printf(format, args...)
+	}
+}
+
+// Count a successfully applied replicated statement
+func noteApplied() {
+	appliedSinceSummary.Add(1)
+	verbosef("Query applied successfully to local database\n")
+}
+
+func startApplySummary() {
+	if verbose {
+		return
+	}
+	go func() {
+		for range time.Tick(applySummaryInterval) {
+			if n := appliedSinceSummary.Swap(0); n > 0 {
+				fmt.Printf("Applied %d replicated statement(s) in the last second\n", n)
+			}
+		}
+	}()
+}
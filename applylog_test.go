@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestOnlyFailuresArePrintedPerStatementByDefault(t *testing.T) {
+	f := useFakeDB(t)
+	f.fail(`^INSERT INTO orders VALUES \(2\)$`, &mysql.MySQLError{Number: 1062, Message: "Duplicate entry '2' for key 'PRIMARY'"})
+	clearPending(t)
+	oldVerbose := verbose
+	t.Cleanup(func() {
+		verbose = oldVerbose
+		appliedSinceSummary.Store(0)
+		resetReplicated()
+	})
+
+	for _, tc := range []struct {
+		verbose bool
+		want    []string
+		notWant []string
+	}{
+		{false, []string{"Failed to execute replicated query"}, []string{"Applying replicated query", "Query applied successfully"}},
+		{true, []string{"Failed to execute replicated query", "Applying replicated query", "Query applied successfully"}, nil},
+	} {
+		verbose = tc.verbose
+		appliedSinceSummary.Store(0)
+		lines := pipeMaster(t)
+		out := captureOutput(t, func() {
+			applyReplicatedQuery(1, "INSERT INTO orders VALUES (1)")
+			applyReplicatedQuery(2, "INSERT INTO orders VALUES (2)")
+		})
+		nextAck(t, lines)
+		nextAck(t, lines)
+
+		for _, w := range tc.want {
+			if !strings.Contains(out, w) {
+				t.Errorf("verbose %v: printed %q, want %q", tc.verbose, out, w)
+			}
+		}
+		for _, w := range tc.notWant {
+			if strings.Contains(out, w) {
+				t.Errorf("verbose %v: printed %q for a successful apply", tc.verbose, w)
+			}
+		}
+		// Only the successful statement goes into the summary
+		if n := appliedSinceSummary.Load(); n != 1 {
+			t.Errorf("verbose %v: %d statement(s) counted for the summary, want 1", tc.verbose, n)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// Parallel apply of replicated statements (-apply-workers). The listener
+// stays the dispatcher: a replicate_query that writes a single table is
+// queued to the worker owning that table, picked by hashing its name, so
+// statements for one table keep their order while different tables are
+// applied at the same time. Everything else is applied by the listener
+// after the pool has gone idle: transactions, DDL, statements that read
+// or write other tables (INSERT ... SELECT, joins, subqueries), statements
+// for tables linked to others by a foreign key or trigger, and every other
+// message (acks that depend on what was applied, verification, syncs).
+// With one worker, the default, everything is applied by the listener.
+
+var applyWorkers = 1
+
+const applyQueueSize = 256
+
+var (
+	applyQueues  []chan applyJob
+	applyPending sync.WaitGroup
+	applyBacklog atomic.Int64 // statements queued or being applied
+)
+
+type applyJob struct {
+	seq   uint64
+	query string
+}
+
+// Statements that touch exactly the one table named in them
+var singleTableRe = regexp.MustCompile("(?is)^\\s*(?:" +
+	"(?:INSERT(?:\\s+IGNORE)?|REPLACE)\\s+INTO\\s+[`\"]?\\w+[`\"]?\\s*(?:\\(|VALUES?\\b|SET\\b)|" +
+	"UPDATE\\s+[`\"]?\\w+[`\"]?\\s+SET\\b|" +
+	"DELETE\\s+FROM\\s+[`\"]?\\w+[`\"]?\\s*(?:WHERE\\b|ORDER\\b|LIMIT\\b|;?\\s*$))")
+
+// Tables with a foreign key or trigger connecting them to other tables,
+// loaded when first needed and forgotten whenever the schema may change.
+// Only the listener touches them.
+var (
+	linkedTables      map[string]bool
+	linkedTablesKnown bool
+)
+
+func startApplyPool() {
+	if applyWorkers <= 1 || applyQueues != nil {
+		return
+	}
+	applyQueues = make([]chan applyJob, applyWorkers)
+	for i := range applyQueues {
+		applyQueues[i] = make(chan applyJob, applyQueueSize)
+		go applyWorker(applyQueues[i])
+	}
+	fmt.Printf("Applying replicated statements with %d workers\n", applyWorkers)
+}
+
+func applyWorker(queue chan applyJob) {
+	for job := range queue {
+		applyReplicatedQuery(job.seq, job.query)
+		applyBacklog.Add(-1)
+		applyPending.Done()
+	}
+}
+
+// Hand a replicated statement to the pool. False if it has to be applied
+// by the listener, after settleApplyPool.
+func dispatchApply(seq uint64, query string) bool {
+	if applyQueues == nil {
+		return false
+	}
+	table := independentTable(query)
+	if table == "" {
+		return false
+	}
+	h := fnv.New32a()
+	h.Write([]byte(table))
+	applyPending.Add(1)
+	applyBacklog.Add(1)
+	applyQueues[h.Sum32()%uint32(len(applyQueues))] <- applyJob{seq, query}
+	return true
+}
+
+// Wait until everything handed to the pool has been applied. The schema
+// may change after this, so the table links are read again when next
+// needed.
+func settleApplyPool() {
+	if applyQueues == nil {
+		return
+	}
+	applyPending.Wait()
+	linkedTables, linkedTablesKnown = nil, false
+}
+
+// The table a statement can be applied for in parallel, "" if it can't
+func independentTable(query string) string {
+	if !singleTableRe.MatchString(query) {
+		return ""
+	}
+	_, table := statementInfo(query)
+	words, err := statementWords(query)
+	if table == "" || err != nil || slices.Contains(words, "SELECT") {
+		return ""
+	}
+	if !linkedTablesKnown {
+		links, err := loadLinkedTables()
+		if err != nil {
+			return ""
+		}
+		linkedTables, linkedTablesKnown = links, true
+	}
+	if linkedTables[table] {
+		return ""
+	}
+	return table
+}
+
+// Tables on either side of a foreign key, and tables with triggers
+func loadLinkedTables() (map[string]bool, error) {
+	if db == nil {
+		return nil, fmt.Errorf("local database connection not established")
+	}
+	rows, err := db.Query(`SELECT TABLE_NAME, REFERENCED_TABLE_NAME FROM information_schema.KEY_COLUMN_USAGE
+		WHERE TABLE_SCHEMA = DATABASE() AND REFERENCED_TABLE_NAME IS NOT NULL
+		UNION SELECT EVENT_OBJECT_TABLE, NULL FROM information_schema.TRIGGERS
+		WHERE TRIGGER_SCHEMA = DATABASE()`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	links := make(map[string]bool)
+	for rows.Next() {
+		var table string
+		var referenced *string
+		if err := rows.Scan(&table, &referenced); err != nil {
+			return nil, err
+		}
+		links[table] = true
+		if referenced != nil {
+			links[*referenced] = true
+		}
+	}
+	return links, rows.Err()
+}
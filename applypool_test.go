@@ -0,0 +1,101 @@
+package main
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Run the apply pool with n workers for the length of the test
+func useApplyPool(t *testing.T, n int) {
+	t.Helper()
+	old := applyWorkers
+	applyWorkers = n
+	startApplyPool()
+	t.Cleanup(func() {
+		settleApplyPool()
+		for _, q := range applyQueues {
+			close(q)
+		}
+		applyQueues, applyWorkers = nil, old
+		linkedTables, linkedTablesKnown = nil, false
+	})
+}
+
+// Tables the pool hands to n different workers
+func tablesOnEveryWorker(n int) []string {
+	var tables []string
+	used := make(map[uint32]bool)
+	for i := 0; len(tables) < n; i++ {
+		name := "t" + strconv.Itoa(i)
+		h := fnv.New32a()
+		h.Write([]byte(name))
+		if w := h.Sum32() % uint32(n); !used[w] {
+			used[w] = true
+			tables = append(tables, name)
+		}
+	}
+	return tables
+}
+
+func TestApplyPoolKeepsEachTablesOrderAndRunsTablesInParallel(t *testing.T) {
+	const workers, rows, delay = 4, 20, 5 * time.Millisecond
+	f := useFakeDB(t)
+	acks := pipeMaster(t)
+	clearPending(t)
+	f.rows(`information_schema.KEY_COLUMN_USAGE`, []string{"TABLE_NAME", "REFERENCED_TABLE_NAME"})
+	f.on(`^INSERT INTO`, func([]driver.Value) fakeResult {
+		time.Sleep(delay)
+		return fakeResult{affected: 1}
+	})
+	useApplyPool(t, workers)
+	t.Cleanup(func() {
+		lastReplicated.Lock()
+		lastReplicated.seq, lastReplicated.affected, lastReplicated.err = 0, 0, nil
+		lastReplicated.Unlock()
+	})
+	tables := tablesOnEveryWorker(workers)
+
+	start := time.Now()
+	seq := uint64(0)
+	for i := 0; i < rows; i++ {
+		for _, table := range tables {
+			seq++
+			if !dispatchApply(seq, fmt.Sprintf("INSERT INTO %s (id) VALUES (%d)", table, i)) {
+				t.Fatalf("insert into %s not handed to the pool", table)
+			}
+		}
+	}
+	settleApplyPool()
+	took := time.Since(start)
+
+	insertRe := regexp.MustCompile(`^INSERT INTO (\w+) \(id\) VALUES \((\d+)\)$`)
+	next := make(map[string]int)
+	for _, stmt := range f.matching(`^INSERT INTO`) {
+		m := insertRe.FindStringSubmatch(stmt)
+		id, _ := strconv.Atoi(m[2])
+		if id != next[m[1]] {
+			t.Fatalf("%s got row %d when row %d was next", m[1], id, next[m[1]])
+		}
+		next[m[1]]++
+	}
+	for _, table := range tables {
+		if next[table] != rows {
+			t.Errorf("%s got %d rows, want %d", table, next[table], rows)
+		}
+	}
+	for i := uint64(0); i < seq; i++ {
+		if ack := nextAck(t, acks); ack != "replicate_ack:ok:" {
+			t.Fatalf("ack %q", ack)
+		}
+	}
+
+	// One at a time would take at least rows*len(tables)*delay
+	if serial := time.Duration(rows*len(tables)) * delay; took > serial/2 {
+		t.Errorf("applying took %v, one at a time takes %v", took, serial)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Append-only log of destructive operator actions, in the data directory
+
+const auditLogFile = "audit.log"
+
+// Where the audit log was kept before the data directory, relative to the
+// working directory
+const legacyAuditLog = "ddb-audit.log"
+
+func auditLog(action, detail string) {
+	path := statePath(auditLogFile)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		fmt.Printf("Could not write audit log %s: %v\n", path, err)
+		return
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "%s %s %s\n", time.Now().Format(time.RFC3339), action, detail)
+}
+
+// Move an audit log left in the working directory by an older version into
+// the data directory, unless that already has one
+func migrateLegacyAuditLog() {
+	if _, err := os.Stat(legacyAuditLog); err != nil {
+		return
+	}
+	path := statePath(auditLogFile)
+	if _, err := os.Stat(path); err == nil {
+		fmt.Printf("Warning: %s from an older version is left where it is, the audit log is now %s\n", legacyAuditLog, path)
+		return
+	}
+	if err := os.Rename(legacyAuditLog, path); err != nil {
+		fmt.Printf("Warning: could not move %s to %s, new entries are written there: %v\n", legacyAuditLog, path, err)
+		return
+	}
+	fmt.Printf("Moved the audit log %s from an older version to %s\n", legacyAuditLog, path)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Auto-increment partitioning for running several masters side by side.
+// Each master gets the same -auto-increment-increment and its own
+// -auto-increment-offset, so their generated ids never collide. The values
+// are set on every connection of the master's pool and sent to slaves
+// (auto_increment:<increment>:<offset>, before init_replication) so they
+// generate the same ids for replicated INSERTs, and a slave promoted to
+// master keeps the partitioning.
+
+// 0 leaves the server's settings alone
+var autoIncIncrement, autoIncOffset int
+
+// Check the settings against the offsets used by the other masters
+// (-auto-increment-peers, a comma separated list)
+func validateAutoIncrement(peers string) error {
+	if autoIncIncrement == 0 && autoIncOffset == 0 {
+		if peers != "" {
+			return fmt.Errorf("-auto-increment-peers needs -auto-increment-increment and -auto-increment-offset")
+		}
+		return nil
+	}
+	if autoIncIncrement < 1 || autoIncIncrement > 65535 {
+		return fmt.Errorf("auto-increment increment must be between 1 and 65535, got %d", autoIncIncrement)
+	}
+	if autoIncOffset < 1 || autoIncOffset > autoIncIncrement {
+		return fmt.Errorf("auto-increment offset must be between 1 and the increment (%d), got %d",
+			autoIncIncrement, autoIncOffset)
+	}
+
+	used := map[int]bool{autoIncOffset: true}
+	for _, p := range strings.Split(peers, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		offset, err := strconv.Atoi(p)
+		if err != nil {
+			return fmt.Errorf("invalid peer offset %q", p)
+		}
+		if offset < 1 || offset > autoIncIncrement {
+			return fmt.Errorf("peer offset %d is outside 1..%d", offset, autoIncIncrement)
+		}
+		if used[offset] {
+			return fmt.Errorf("auto-increment offset %d is used by more than one master", offset)
+		}
+		used[offset] = true
+	}
+	return nil
+}
+
+// Session variables for a connection config, nil if not configured
+func autoIncrementParams() map[string]string {
+	if autoIncIncrement == 0 {
+		return nil
+	}
+	return map[string]string{
+		"auto_increment_increment": strconv.Itoa(autoIncIncrement),
+		"auto_increment_offset":    strconv.Itoa(autoIncOffset),
+	}
+}
+
+// Slave side of auto_increment:<increment>:<offset>. Takes effect for the
+// connections opened by the init_replication that follows.
+func recordAutoIncrement(content string) {
+	inc, off, ok := parseMessage(content)
+	increment, err1 := strconv.Atoi(inc)
+	offset, err2 := strconv.Atoi(off)
+	if !ok || err1 != nil || err2 != nil {
+		fmt.Printf("Invalid auto_increment message from master: %s\n", content)
+		return
+	}
+	autoIncIncrement, autoIncOffset = increment, offset
+	fmt.Printf("Using the master's auto-increment partitioning (increment %d, offset %d)\n", increment, offset)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAutoIncrementOffsetsMustNotOverlap(t *testing.T) {
+	oldInc, oldOffset := autoIncIncrement, autoIncOffset
+	t.Cleanup(func() { autoIncIncrement, autoIncOffset = oldInc, oldOffset })
+
+	for _, tc := range []struct {
+		increment, offset int
+		peers             string
+		err               string
+	}{
+		{0, 0, "", ""},
+		{3, 1, "2,3", ""},
+		{3, 2, " 1 , 3 ,", ""},
+		{3, 1, "1", "offset 1 is used by more than one master"},
+		{3, 1, "2,2", "offset 2 is used by more than one master"},
+		{3, 1, "4", "peer offset 4 is outside 1..3"},
+		{3, 1, "two", `invalid peer offset "two"`},
+		{3, 4, "", "offset must be between 1 and the increment (3), got 4"},
+		{0, 0, "2", "-auto-increment-peers needs"},
+	} {
+		autoIncIncrement, autoIncOffset = tc.increment, tc.offset
+		err := validateAutoIncrement(tc.peers)
+		if tc.err == "" {
+			if err != nil {
+				t.Errorf("increment %d, offset %d, peers %q: %v", tc.increment, tc.offset, tc.peers, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("increment %d, offset %d, peers %q: got %v, want %q", tc.increment, tc.offset, tc.peers, err, tc.err)
+		}
+	}
+}
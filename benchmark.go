@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Replication throughput benchmark run from the master menu. Rows are
+// written to a throwaway table and replicated like any other insert; a
+// bench_done marker is sent after the last row and each slave answers with
+// bench_ack once it has applied everything before it.
+
+const benchAckTimeout = 60 * time.Second
+
+var benchMu sync.Mutex
+var benchWaiters = make(map[string]chan string)
+
+type benchResult struct {
+	rows     int
+	bytes    int
+	elapsed  time.Duration
+	perSlave map[string]time.Duration
+	missing  []string
+}
+
+// Called by the slave handler when a bench_ack arrives
+func benchAcked(token, addr string) {
+	benchMu.Lock()
+	ch, ok := benchWaiters[token]
+	benchMu.Unlock()
+	if !ok {
+		return
+	}
+	// A repeated or late ack must not block the slave's handler
+	select {
+	case ch <- addr:
+	default:
+	}
+}
+
+func runBenchmark(numRows int) (*benchResult, error) {
+	mu.Lock()
+	targets := make(map[string]bool, len(slaves))
+	for addr := range slaves {
+		targets[addr] = true
+	}
+	mu.Unlock()
+
+	token := fmt.Sprintf("%d", time.Now().UnixNano())
+	table := "ddb_bench_" + token
+	createQuery := fmt.Sprintf("CREATE TABLE %s (id INT PRIMARY KEY AUTO_INCREMENT, payload VARCHAR(100))", table)
+	dropQuery := "DROP TABLE " + table
+
+	if _, err := db.Exec(createQuery); err != nil {
+		return nil, fmt.Errorf("error creating benchmark table: %v", err)
+	}
+	broadcast(nil, "create_table:%s\n", createQuery)
+	resetReplayLog()
+
+	// Always clean up, on the master and on the slaves
+	defer func() {
+		if _, err := db.Exec(dropQuery); err != nil {
+			fmt.Printf("Error dropping benchmark table %s: %v\n", table, err)
+		}
+		replicate(nil, dropQuery)
+	}()
+
+	acks := make(chan string, len(targets))
+	benchMu.Lock()
+	benchWaiters[token] = acks
+	benchMu.Unlock()
+	defer func() {
+		benchMu.Lock()
+		delete(benchWaiters, token)
+		benchMu.Unlock()
+	}()
+
+	res := &benchResult{perSlave: make(map[string]time.Duration)}
+	padding := strings.Repeat("x", 64)
+	start := time.Now()
+
+	for i := 0; i < numRows; i++ {
+		payload := fmt.Sprintf("row-%d-%s", i, padding)
+		endWrite := beginWrite()
+		_, err := db.Exec("INSERT INTO "+table+" (payload) VALUES (?)", payload)
+		if err == nil {
+			query := buildInsert(replicaDialect, table, []string{"payload"}, []interface{}{payload})
+			seq := replicate(nil, query)
+			res.bytes += len(fmt.Sprintf("replicate_query:%d:%s\n", seq, query))
+			res.rows++
+		}
+		endWrite()
+		if err != nil {
+			return nil, fmt.Errorf("error inserting benchmark row: %v", err)
+		}
+	}
+
+	broadcast(nil, "bench_done:%s\n", token)
+
+	timeout := time.After(benchAckTimeout)
+	for len(res.perSlave) < len(targets) {
+		select {
+		case addr := <-acks:
+			if targets[addr] {
+				res.perSlave[addr] = time.Since(start)
+			}
+		case <-timeout:
+			for addr := range targets {
+				if _, ok := res.perSlave[addr]; !ok {
+					res.missing = append(res.missing, addr)
+				}
+			}
+			sort.Strings(res.missing)
+			res.elapsed = time.Since(start)
+			return res, nil
+		}
+	}
+	res.elapsed = time.Since(start)
+	return res, nil
+}
+
+func RunBenchmark() {
+	if txOpen() {
+		return
+	}
+	if binlogEngine() {
+		fmt.Println("The benchmark measures the statement engine, it can't run with -replication-engine binlog")
+		return
+	}
+	if dryRun {
+		fmt.Println("The benchmark needs slaves to apply its rows, it can't run with -dry-run")
+		return
+	}
+
+	mu.Lock()
+	numSlaves := len(slaves)
+	mu.Unlock()
+	if numSlaves == 0 {
+		fmt.Println("No slaves connected, nothing to benchmark")
+		return
+	}
+
+	fmt.Print("Enter number of rows to generate (default 1000): ")
+	numRows := readRowCount(1000)
+
+	fmt.Printf("Replicating %d rows to %d slave(s)...\n", numRows, numSlaves)
+	res, err := runBenchmark(numRows)
+	if err != nil {
+		fmt.Printf("Benchmark failed: %v\n", err)
+		return
+	}
+
+	secs := res.elapsed.Seconds()
+	fmt.Println("\n===== BENCHMARK RESULTS =====")
+	fmt.Printf("Rows: %d, data: %.2f MB, total time: %v\n",
+		res.rows, float64(res.bytes)/(1024*1024), res.elapsed.Round(time.Millisecond))
+	if secs > 0 {
+		fmt.Printf("Throughput: %.0f rows/sec, %.2f MB/sec\n",
+			float64(res.rows)/secs, float64(res.bytes)/(1024*1024)/secs)
+	}
+
+	addrs := make([]string, 0, len(res.perSlave))
+	for addr := range res.perSlave {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	for _, addr := range addrs {
+		fmt.Printf("- %s completed in %v\n", addr, res.perSlave[addr].Round(time.Millisecond))
+	}
+	for _, addr := range res.missing {
+		fmt.Printf("- %s did not acknowledge within %v\n", addr, benchAckTimeout)
+	}
+}
+
+// Read a row count, def if left blank. Asks again until it gets a
+// positive number.
+func readRowCount(def int) int {
+	for {
+		input := strings.TrimSpace(readLine())
+		if input == "" {
+			return def
+		}
+		if n, err := strconv.Atoi(input); err == nil && n > 0 {
+			return n
+		}
+		fmt.Print("Please enter a positive number of rows: ")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBenchAckNeverBlocks(t *testing.T) {
+	acks := make(chan string, 1)
+	benchMu.Lock()
+	benchWaiters["tok"] = acks
+	benchMu.Unlock()
+	defer func() {
+		benchMu.Lock()
+		delete(benchWaiters, "tok")
+		benchMu.Unlock()
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		benchAcked("tok", "a")
+		benchAcked("tok", "a") // the buffer is full now
+		benchAcked("gone", "a")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("benchAcked blocked on a full waiter")
+	}
+	if got := <-acks; got != "a" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestReadRowCount(t *testing.T) {
+	feedInput(t, " 250 ", "", "lots", "-5", "7")
+	for _, want := range []int{250, 1000, 7} {
+		if got := readRowCount(1000); got != want {
+			t.Fatalf("got %d, want %d", got, want)
+		}
+	}
+}
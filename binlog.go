@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gomysql "github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+// Binlog replication engine (-replication-engine binlog). Instead of
+// re-rendering the statements the master runs, the master tails its own
+// MySQL binary log and replicates the row changes MySQL actually made, so
+// rows written by triggers, column defaults and generated values reach the
+// slaves exactly. Each binlog transaction goes out as one statement, or as
+// a begin_tx/commit_tx group if it changed several rows (see tx.go).
+//
+// Only row changes come from the binlog. Schema changes are still sent the
+// statement way, as are the replication sequence numbers, so slaves don't
+// need to know which engine the master runs.
+//
+// The server needs log_bin on, binlog_format=ROW and binlog_row_image=FULL.
+// Column names and signedness are read from information_schema unless
+// binlog_row_metadata=FULL puts them in the binlog.
+//
+// A write made through the master waits, before it releases opMu, until
+// the tailer has replicated it, so the writes and the DDL sent the
+// statement way go out in the order they were made. A write forwarded by a
+// slave runs on a connection of its own, registered by its MySQL
+// connection id: the tailer matches it against the thread id of each
+// binlog transaction to leave that slave out.
+//
+// The tailer replicates each transaction under snapshotMu.RLock, like a
+// statement engine write, and an initial sync notes the binlog position it
+// takes its snapshot at: the transactions up to that cut are in the
+// snapshot, so the tailer doesn't send them to that slave. A write that
+// commits without going through the master right as the snapshot is taken
+// may still reach the slave twice. So may a write made while a table or a
+// block of rows is copied to a live slave (see tableread.go and
+// catchup.go), which is harmless: the tailer sends row images, and the
+// copy already has the row as the write left it.
+
+const (
+	engineStatement = "statement"
+	engineBinlog    = "binlog"
+)
+
+var replicationEngine = engineStatement
+
+var binlogServerID uint
+
+// How long a write waits for the tailer before going on without it
+const binlogWaitTimeout = 5 * time.Second
+
+// Binlog position the tailer has replicated up to, whether it stopped, the
+// slaves whose forwarded writes are being made, by MySQL connection id, and
+// the position each syncing slave's snapshot was taken at
+var (
+	binlogMu      sync.Mutex
+	binlogDone    gomysql.Position
+	binlogFailed  bool
+	binlogOrigins = make(map[uint32]*slaveConn)
+	binlogCuts    = make(map[*slaveConn]gomysql.Position)
+)
+
+func binlogEngine() bool {
+	return replicationEngine == engineBinlog
+}
+
+func registerBinlogOrigin(id uint32, s *slaveConn) {
+	binlogMu.Lock()
+	binlogOrigins[id] = s
+	binlogMu.Unlock()
+}
+
+func forgetBinlogOrigin(id uint32) {
+	binlogMu.Lock()
+	delete(binlogOrigins, id)
+	binlogMu.Unlock()
+}
+
+// Note the binlog position slave s's sync snapshot is taken at. Called
+// under snapshotMu.Lock, just before the snapshot starts.
+func cutBinlog(s *slaveConn) {
+	if !binlogEngine() {
+		return
+	}
+	pos, err := currentBinlogPos()
+	if err != nil {
+		fmt.Printf("Could not read binlog position for the sync of %s: %v\n", s.addr, err)
+		return
+	}
+	binlogMu.Lock()
+	binlogCuts[s] = pos
+	binlogMu.Unlock()
+}
+
+// Current end of the server's binary log
+func currentBinlogPos() (gomysql.Position, error) {
+	rows, err := db.Query("SHOW BINARY LOG STATUS")
+	if err != nil {
+		// Before MySQL 8.2, and MariaDB
+		rows, err = db.Query("SHOW MASTER STATUS")
+	}
+	if err != nil {
+		return gomysql.Position{}, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return gomysql.Position{}, err
+	}
+	if !rows.Next() {
+		return gomysql.Position{}, fmt.Errorf("binary logging is off")
+	}
+	fields := make([]sql.RawBytes, len(columns))
+	scanArgs := make([]interface{}, len(columns))
+	for i := range fields {
+		scanArgs[i] = &fields[i]
+	}
+	if err := rows.Scan(scanArgs...); err != nil {
+		return gomysql.Position{}, err
+	}
+	pos, err := strconv.ParseUint(string(fields[1]), 10, 32)
+	if err != nil {
+		return gomysql.Position{}, err
+	}
+	return gomysql.Position{Name: string(fields[0]), Pos: uint32(pos)}, nil
+}
+
+// Check the server logs what the tailer needs
+func checkBinlogSettings() error {
+	var logBin int
+	var format, image string
+	err := db.QueryRow("SELECT @@log_bin, @@binlog_format, @@binlog_row_image").Scan(&logBin, &format, &image)
+	if err != nil {
+		return err
+	}
+	switch {
+	case logBin != 1:
+		return fmt.Errorf("binary logging is off, start MySQL with log_bin")
+	case !strings.EqualFold(format, "ROW"):
+		return fmt.Errorf("binlog_format is %s, it must be ROW", format)
+	case !strings.EqualFold(image, "FULL"):
+		return fmt.Errorf("binlog_row_image is %s, it must be FULL", image)
+	}
+	return nil
+}
+
+// Start tailing the binlog from its current end
+func startBinlogTailer() error {
+	if err := checkBinlogSettings(); err != nil {
+		return err
+	}
+	pos, err := currentBinlogPos()
+	if err != nil {
+		return fmt.Errorf("reading binlog position: %v", err)
+	}
+
+	host, portStr, err := net.SplitHostPort(masterConfig.Addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid MySQL port %q", portStr)
+	}
+	syncer := replication.NewBinlogSyncer(replication.BinlogSyncerConfig{
+		ServerID: uint32(binlogServerID),
+		Host:     host,
+		Port:     uint16(port),
+		User:     masterConfig.User,
+		Password: masterConfig.Passwd,
+		Logger:   slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})),
+	})
+	streamer, err := syncer.StartSync(pos)
+	if err != nil {
+		syncer.Close()
+		return err
+	}
+
+	markBinlogDone(pos.Name, pos.Pos)
+	fmt.Printf("Replicating row changes from the binary log, starting at %s:%d\n", pos.Name, pos.Pos)
+	go tailBinlog(syncer, streamer, pos.Name)
+	return nil
+}
+
+func tailBinlog(syncer *replication.BinlogSyncer, streamer *replication.BinlogStreamer, file string) {
+	defer syncer.Close()
+	var pending []string
+	var thread uint32
+	inTx := false
+	for {
+		ev, err := streamer.GetEvent(context.Background())
+		if err != nil {
+			fmt.Printf("ERROR: binlog replication stopped: %v\n", err)
+			fmt.Println("Row changes are no longer replicated. Restart the master to resume.")
+			binlogMu.Lock()
+			binlogFailed = true
+			binlogMu.Unlock()
+			return
+		}
+
+		switch e := ev.Event.(type) {
+		case *replication.RotateEvent:
+			file = string(e.NextLogName)
+			continue
+		case *replication.RowsEvent:
+			release := useDB()
+			if string(e.Table.Schema) == dbName {
+				pending = append(pending, rowStatements(e)...)
+			}
+			release()
+			continue
+		case *replication.QueryEvent:
+			query := strings.TrimSpace(string(e.Query))
+			if strings.EqualFold(query, "BEGIN") {
+				inTx, thread = true, e.SlaveProxyID
+				continue
+			}
+			if !strings.EqualFold(query, "COMMIT") {
+				// DDL, which is replicated the statement way. The
+				// cached columns may be out of date now.
+				clearBinlogColumns()
+			}
+		case *replication.XIDEvent:
+		default:
+			// Between transactions, e.g. the header of a new binlog
+			// file: nothing to replicate, but a write that logged
+			// nothing mustn't wait for the next transaction.
+			if !inTx && ev.Header.LogPos > 0 {
+				markBinlogDone(file, ev.Header.LogPos)
+			}
+			continue
+		}
+
+		// A transaction ended
+		replicateBinlogTx(thread, gomysql.Position{Name: file, Pos: ev.Header.LogPos}, pending)
+		pending, inTx, thread = nil, false, 0
+		markBinlogDone(file, ev.Header.LogPos)
+	}
+}
+
+// Replicate the statements of a binlog transaction made by MySQL connection
+// thread and ending at end. The slave that forwarded it and the slaves
+// whose sync snapshot already has it are left out.
+func replicateBinlogTx(thread uint32, end gomysql.Position, stmts []string) {
+	if len(stmts) == 0 {
+		return
+	}
+	snapshotMu.RLock()
+	defer snapshotMu.RUnlock()
+
+	binlogMu.Lock()
+	origin := binlogOrigins[thread]
+	inSnapshot := make(map[*slaveConn]bool)
+	for s, cut := range binlogCuts {
+		if end.Compare(cut) <= 0 {
+			inSnapshot[s] = true
+		} else {
+			delete(binlogCuts, s)
+		}
+	}
+	binlogMu.Unlock()
+	keep := func(s *slaveConn) bool { return !inSnapshot[s] }
+
+	if len(stmts) == 1 {
+		seqMu.Lock()
+		defer seqMu.Unlock()
+		replicateToLocked(origin, keep, stmts[0])
+		return
+	}
+	replicateTxTo(origin, keep, stmts)
+}
+
+func markBinlogDone(file string, pos uint32) {
+	binlogMu.Lock()
+	binlogDone = gomysql.Position{Name: file, Pos: pos}
+	binlogMu.Unlock()
+}
+
+// Wait until everything committed so far has been replicated
+func waitForBinlog() {
+	target, err := currentBinlogPos()
+	if err != nil {
+		fmt.Printf("Could not read binlog position: %v\n", err)
+		return
+	}
+	deadline := time.Now().Add(binlogWaitTimeout)
+	for {
+		binlogMu.Lock()
+		done, failed := binlogDone.Compare(target) >= 0, binlogFailed
+		binlogMu.Unlock()
+		if done || failed {
+			return
+		}
+		if time.Now().After(deadline) {
+			fmt.Printf("WARNING: binlog replication is more than %v behind\n", binlogWaitTimeout)
+			return
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+}
+
+// Column names, key flags and signedness of a table, by schema.table
+type binlogTable struct {
+	columns  []string
+	key      []bool
+	unsigned []bool
+}
+
+var (
+	binlogColumnsMu sync.Mutex
+	binlogColumns   = make(map[string]*binlogTable)
+)
+
+func clearBinlogColumns() {
+	binlogColumnsMu.Lock()
+	binlogColumns = make(map[string]*binlogTable)
+	binlogColumnsMu.Unlock()
+}
+
+func lookupBinlogTable(schema, table string, count int) (*binlogTable, error) {
+	name := schema + "." + table
+	binlogColumnsMu.Lock()
+	t, ok := binlogColumns[name]
+	binlogColumnsMu.Unlock()
+	if ok && len(t.columns) == count {
+		return t, nil
+	}
+
+	rows, err := db.Query(`SELECT COLUMN_NAME, COLUMN_KEY = 'PRI', COLUMN_TYPE LIKE '%unsigned%'
+		FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?
+		ORDER BY ORDINAL_POSITION`, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	t = &binlogTable{}
+	for rows.Next() {
+		var col string
+		var key, unsigned bool
+		if err := rows.Scan(&col, &key, &unsigned); err != nil {
+			return nil, err
+		}
+		t.columns = append(t.columns, col)
+		t.key = append(t.key, key)
+		t.unsigned = append(t.unsigned, unsigned)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(t.columns) != count {
+		return nil, fmt.Errorf("table %s has %d columns, the binlog event %d", name, len(t.columns), count)
+	}
+
+	binlogColumnsMu.Lock()
+	binlogColumns[name] = t
+	binlogColumnsMu.Unlock()
+	return t, nil
+}
+
+// Replica statements for the rows of one binlog event
+func rowStatements(e *replication.RowsEvent) []string {
+	table := string(e.Table.Table)
+	t, err := lookupBinlogTable(string(e.Table.Schema), table, int(e.ColumnCount))
+	if err != nil {
+		fmt.Printf("ERROR: can't replicate binlog row change to %s: %v\n", table, err)
+		return nil
+	}
+	rows := make([][]interface{}, len(e.Rows))
+	for i, row := range e.Rows {
+		rows[i] = unsignedValues(row, t.unsigned, e.Table.ColumnType)
+	}
+
+	var stmts []string
+	switch e.Type() {
+	case replication.EnumRowsEventTypeInsert:
+		for _, row := range rows {
+			stmts = append(stmts, conflictQuery(buildInsert(replicaDialect, table, t.columns, row)))
+		}
+	case replication.EnumRowsEventTypeUpdate:
+		// Before and after images alternate
+		for i := 0; i+1 < len(rows); i += 2 {
+			if stmt := rowUpdate(table, t, rows[i], rows[i+1]); stmt != "" {
+				stmts = append(stmts, stmt)
+			}
+		}
+	case replication.EnumRowsEventTypeDelete:
+		for _, row := range rows {
+			stmts = append(stmts, fmt.Sprintf("DELETE FROM %s WHERE %s",
+				quoteIdent(replicaDialect, table), rowCondition(t, row)))
+		}
+	}
+	return stmts
+}
+
+// UPDATE setting the columns that changed, "" if none did
+func rowUpdate(table string, t *binlogTable, before, after []interface{}) string {
+	var sets []string
+	for i, col := range t.columns {
+		if sqlLiteral(before[i]) == sqlLiteral(after[i]) {
+			continue
+		}
+		sets = append(sets, fmt.Sprintf("%s = %s", quoteIdent(replicaDialect, col), sqlLiteralFor(replicaDialect, after[i])))
+	}
+	if len(sets) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("UPDATE %s SET %s WHERE %s",
+		quoteIdent(replicaDialect, table), strings.Join(sets, ", "), rowCondition(t, before))
+}
+
+// WHERE condition matching a row by its primary key, or by every column
+// if the table has none
+func rowCondition(t *binlogTable, row []interface{}) string {
+	hasKey := false
+	for _, k := range t.key {
+		hasKey = hasKey || k
+	}
+	var conds []string
+	for i, col := range t.columns {
+		if hasKey && !t.key[i] {
+			continue
+		}
+		if row[i] == nil {
+			conds = append(conds, quoteIdent(replicaDialect, col)+" IS NULL")
+			continue
+		}
+		conds = append(conds, fmt.Sprintf("%s = %s", quoteIdent(replicaDialect, col), sqlLiteralFor(replicaDialect, row[i])))
+	}
+	where := strings.Join(conds, " AND ")
+	if !hasKey {
+		// Only one of several identical rows
+		where += " LIMIT 1"
+	}
+	return where
+}
+
+// The binlog carries integers as signed, fix up unsigned columns
+func unsignedValues(row []interface{}, unsigned []bool, types []byte) []interface{} {
+	out := make([]interface{}, len(row))
+	for i, v := range row {
+		out[i] = v
+		if i >= len(unsigned) || !unsigned[i] {
+			continue
+		}
+		switch n := v.(type) {
+		case int8:
+			out[i] = uint8(n)
+		case int16:
+			out[i] = uint16(n)
+		case int32:
+			if i < len(types) && types[i] == gomysql.MYSQL_TYPE_INT24 {
+				out[i] = uint32(n) & 0xFFFFFF
+			} else {
+				out[i] = uint32(n)
+			}
+		case int64:
+			out[i] = uint64(n)
+		}
+	}
+	return out
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	gomysql "github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// Run the test with the binlog engine, its tailer caught up at done
+func useBinlogEngine(t *testing.T, f *fakeDB, done gomysql.Position) {
+	t.Helper()
+	old := replicationEngine
+	replicationEngine = engineBinlog
+	markBinlogDone(done.Name, done.Pos)
+	f.rows(`^SHOW BINARY LOG STATUS$`, []string{"File", "Position"}, []driver.Value{done.Name, int64(done.Pos)})
+	t.Cleanup(func() {
+		replicationEngine = old
+		binlogMu.Lock()
+		binlogOrigins = make(map[uint32]*slaveConn)
+		binlogCuts = make(map[*slaveConn]gomysql.Position)
+		binlogMu.Unlock()
+	})
+}
+
+// Whether a frame arrives on frames soon
+func frameArrives(frames <-chan string) (string, bool) {
+	select {
+	case f := <-frames:
+		return f, true
+	case <-time.After(50 * time.Millisecond):
+		return "", false
+	}
+}
+
+func TestForwardedWriteIsKnownToTheTailerByConnection(t *testing.T) {
+	f := useFakeDB(t)
+	useBinlogEngine(t, f, gomysql.Position{Name: "bin.000001", Pos: 100})
+	s, sc := pipeSlave(t)
+	s.syncFinished()
+
+	var origins map[uint32]*slaveConn
+	f.on(`^INSERT`, func([]driver.Value) fakeResult {
+		binlogMu.Lock()
+		origins = make(map[uint32]*slaveConn)
+		for id, o := range binlogOrigins {
+			origins[id] = o
+		}
+		binlogMu.Unlock()
+		return fakeResult{affected: 1}
+	})
+
+	go handleSlaveMessage(s, "insert:INSERT INTO t VALUES (1)")
+	if got := nextFrame(t, sc); got != "success:query executed" {
+		t.Fatalf("got %q", got)
+	}
+
+	ids := f.connsOf(`^SELECT CONNECTION_ID\(\)$`)
+	writes := f.connsOf(`^INSERT`)
+	if len(ids) != 1 || len(writes) != 1 || ids[0] != writes[0] {
+		t.Fatalf("write ran on connection %v, the id was read on %v", writes, ids)
+	}
+	if len(origins) != 1 || origins[uint32(writes[0])] != s {
+		t.Fatalf("origins during the write %v, want connection %d for the slave", origins, writes[0])
+	}
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(time.Millisecond) {
+		binlogMu.Lock()
+		n := len(binlogOrigins)
+		binlogMu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("connection still registered after the write")
+		}
+	}
+}
+
+func TestBinlogTxLeavesOutItsSender(t *testing.T) {
+	f := useFakeDB(t)
+	useBinlogEngine(t, f, gomysql.Position{Name: "bin.000001", Pos: 100})
+	sender, senderSc := pipeSlave(t)
+	sender.addr = "sender"
+	registerSlave(t, sender)
+	sender.syncFinished()
+	other, otherSc := pipeSlave(t)
+	other.addr = "other"
+	registerSlave(t, other)
+	other.syncFinished()
+	senderFrames, otherFrames := collectFrames(senderSc), collectFrames(otherSc)
+
+	registerBinlogOrigin(42, sender)
+	replicateBinlogTx(42, gomysql.Position{Name: "bin.000001", Pos: 200}, []string{"INSERT INTO `t` (`id`) VALUES (1)"})
+	replicateBinlogTx(7, gomysql.Position{Name: "bin.000001", Pos: 300}, []string{"INSERT INTO `t` (`id`) VALUES (2)"})
+
+	for _, want := range []string{"VALUES (1)", "VALUES (2)"} {
+		if got, ok := frameArrives(otherFrames); !ok || !strings.Contains(got, want) {
+			t.Fatalf("other slave got %q, want the write with %s", got, want)
+		}
+	}
+	if got, ok := frameArrives(senderFrames); !ok || !strings.Contains(got, "VALUES (2)") {
+		t.Fatalf("sender got %q, want only the write made on another connection", got)
+	}
+}
+
+func TestSyncSnapshotCutsTheBinlog(t *testing.T) {
+	f := useFakeDB(t)
+	useBinlogEngine(t, f, gomysql.Position{Name: "bin.000002", Pos: 400})
+	s, sc := pipeSlave(t)
+	registerSlave(t, s)
+	frames := collectFrames(sc)
+
+	// Committed before the snapshot, tailed after it
+	s.snapshotTaken()
+	s.syncFinished()
+	replicateBinlogTx(0, gomysql.Position{Name: "bin.000002", Pos: 350}, []string{"DELETE FROM `t` WHERE `id` = 1"})
+	if got, ok := frameArrives(frames); ok {
+		t.Fatalf("slave got %q, which its snapshot already has", got)
+	}
+
+	replicateBinlogTx(0, gomysql.Position{Name: "bin.000002", Pos: 450}, []string{"DELETE FROM `t` WHERE `id` = 2"})
+	if got, ok := frameArrives(frames); !ok || !strings.Contains(got, "`id` = 2") {
+		t.Fatalf("slave got %q, want the write made after its snapshot", got)
+	}
+	binlogMu.Lock()
+	_, cut := binlogCuts[s]
+	binlogMu.Unlock()
+	if cut {
+		t.Fatal("cut kept once the tailer is past it")
+	}
+}
+
+func TestBinlogTxWaitsForASnapshot(t *testing.T) {
+	f := useFakeDB(t)
+	useBinlogEngine(t, f, gomysql.Position{Name: "bin.000001", Pos: 100})
+	s, sc := pipeSlave(t)
+	registerSlave(t, s)
+	s.syncFinished()
+	frames := collectFrames(sc)
+
+	snapshotMu.Lock()
+	go replicateBinlogTx(0, gomysql.Position{Name: "bin.000001", Pos: 200}, []string{"DELETE FROM `t` WHERE `id` = 1"})
+	if got, ok := frameArrives(frames); ok {
+		snapshotMu.Unlock()
+		t.Fatalf("slave got %q while a snapshot was being taken", got)
+	}
+	snapshotMu.Unlock()
+	if _, ok := frameArrives(frames); !ok {
+		t.Fatal("binlog transaction not replicated once the snapshot was taken")
+	}
+}
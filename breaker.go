@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Circuit breaker for slaves that keep failing to apply replicated
+// statements. Once breakerThreshold errors in a row are reported within
+// breakerWindow the slave is marked broken and live replication to it stops
+// until an operator resets it (or it reconnects and resyncs).
+
+var breakerThreshold = 5
+var breakerWindow = time.Minute
+
+// Last replicate_ack of each slave for the slave list, kept by slave id
+// (by address for slaves that don't send one) so it outlives a reconnect.
+// The position is the highest the slave reported acking with
+// slave_info:acked_position, 0 if it doesn't. Guarded by mu.
+type ackStatus struct {
+	at  time.Time
+	err string // "" for ok
+	pos uint64
+}
+
+var slaveAcks = make(map[string]ackStatus)
+
+// Key of the slave in slaveAcks
+func (s *slaveConn) ackKey() string {
+	s.smu.Lock()
+	defer s.smu.Unlock()
+	if s.slaveID != "" {
+		return s.slaveID
+	}
+	return s.addr
+}
+
+// Handle a replicate_ack from the slave ("ok:[<latency>]" or "err:<message>")
+func (s *slaveConn) recordAck(content string) {
+	status, msg, _ := parseMessage(content)
+	ackErr := ""
+	if status != "ok" {
+		ackErr = msg
+		if ackErr == "" {
+			ackErr = "unknown error"
+		}
+	}
+	key := s.ackKey()
+	mu.Lock()
+	ack := slaveAcks[key]
+	ack.at, ack.err = clock(), ackErr
+	slaveAcks[key] = ack
+	mu.Unlock()
+
+	s.smu.Lock()
+	defer s.smu.Unlock()
+	if status == "ok" {
+		s.errStreak = 0
+		s.recordLatencyLocked(msg)
+		return
+	}
+
+	now := clock()
+	if s.errStreak == 0 || now.Sub(s.streakStart) > breakerWindow {
+		s.errStreak = 0
+		s.streakStart = now
+	}
+	s.errStreak++
+
+	if !s.broken && breakerThreshold > 0 && s.errStreak >= breakerThreshold {
+		s.broken = true
+		fmt.Printf("\nCircuit breaker tripped for slave %s after %d consecutive errors (last: %s). Live replication to it is stopped.\n",
+			s.addr, s.errStreak, msg)
+	}
+}
+
+// slave_info:acked_position:<seq>, sent by the slave after each ack
+func (s *slaveConn) recordAckedPosition(value string) {
+	pos, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return
+	}
+	key := s.ackKey()
+	mu.Lock()
+	defer mu.Unlock()
+	if ack := slaveAcks[key]; pos > ack.pos {
+		ack.pos = pos
+		slaveAcks[key] = ack
+	}
+}
+
+// Last ack for the slave list, so a slave that stopped applying, keeps
+// failing or fell behind stands out. mu must be held.
+func (s *slaveConn) ackNoteLocked() string {
+	ack, ok := slaveAcks[s.ackKey()]
+	if !ok || ack.at.IsZero() {
+		return "(no statements acked yet)"
+	}
+	when := fmt.Sprintf("last ack %v ago", clock().Sub(ack.at).Round(time.Second))
+	if ack.pos > 0 {
+		when += fmt.Sprintf(", up to position %d", ack.pos)
+	}
+	if ack.err != "" {
+		return fmt.Sprintf("(%s: error: %s)", when, ack.err)
+	}
+	return fmt.Sprintf("(%s: ok)", when)
+}
+
+func (s *slaveConn) isBroken() bool {
+	s.smu.Lock()
+	defer s.smu.Unlock()
+	return s.broken
+}
+
+func (s *slaveConn) resetBreaker() {
+	s.smu.Lock()
+	defer s.smu.Unlock()
+	if s.broken {
+		fmt.Printf("Circuit breaker reset for slave %s (%d frames were dropped while broken)\n", s.addr, s.dropped)
+	}
+	s.broken = false
+	s.errStreak = 0
+	s.dropped = 0
+}
+
+// Menu action to re-enable replication to a broken slave
+func ResetSlaveBreaker() {
+	mu.Lock()
+	var broken []*slaveConn
+	for _, s := range slaves {
+		if s.isBroken() {
+			broken = append(broken, s)
+		}
+	}
+	mu.Unlock()
+
+	if len(broken) == 0 {
+		fmt.Println("No slaves have a tripped circuit breaker")
+		return
+	}
+	sort.Slice(broken, func(i, j int) bool { return broken[i].addr < broken[j].addr })
+
+	fmt.Println("\nSlaves with a tripped circuit breaker:")
+	for i, s := range broken {
+		fmt.Printf("%d. %s\n", i+1, s.addr)
+	}
+	fmt.Print("Select slave to reset (number): ")
+	choice := readChoice()
+	if choice < 1 || choice > len(broken) {
+		fmt.Println("Invalid slave selection")
+		return
+	}
+	broken[choice-1].resetBreaker()
+}
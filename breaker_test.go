@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreakerTripsOnErrorsInsideTheWindowAndResets(t *testing.T) {
+	oldThreshold, oldWindow, oldClock := breakerThreshold, breakerWindow, clock
+	now := time.Unix(1_700_000_000, 0)
+	breakerThreshold, breakerWindow = 3, time.Minute
+	clock = func() time.Time { return now }
+	t.Cleanup(func() { breakerThreshold, breakerWindow, clock = oldThreshold, oldWindow, oldClock })
+
+	s, _ := pipeSlave(t)
+	registerSlave(t, s)
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(slaveAcks, s.ackKey())
+		mu.Unlock()
+	})
+	fail := func(after time.Duration) {
+		now = now.Add(after)
+		s.recordAck("err:Duplicate entry")
+	}
+
+	// Two errors, then one outside the window starts the streak again
+	fail(0)
+	fail(10 * time.Second)
+	fail(2 * time.Minute)
+	fail(10 * time.Second)
+	if s.isBroken() {
+		t.Fatal("tripped on errors spread over more than the window")
+	}
+	// An ok ends the streak too
+	s.recordAck("ok:")
+	fail(time.Second)
+	fail(time.Second)
+	if s.isBroken() {
+		t.Fatal("tripped although an ack succeeded in between")
+	}
+	fail(time.Second)
+	if !s.isBroken() {
+		t.Fatalf("not tripped after %d errors in a row within %v", breakerThreshold, breakerWindow)
+	}
+
+	s.syncFinished()
+	s.sendLive("replicate_query:1:INSERT INTO t VALUES (1)\n")
+	s.smu.Lock()
+	dropped := s.dropped
+	s.smu.Unlock()
+	if dropped != 1 {
+		t.Fatalf("%d frames dropped while broken, want 1", dropped)
+	}
+
+	feedInput(t, "1")
+	ResetSlaveBreaker()
+	if s.isBroken() {
+		t.Fatal("still broken after ResetSlaveBreaker")
+	}
+	fail(time.Second)
+	if s.isBroken() {
+		t.Fatal("the reset kept the old streak")
+	}
+}
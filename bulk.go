@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bulk updates and deletes by an arbitrary filter, run from the table
+// menu. The statement runs on the master, is replicated unchanged, and is
+// followed by a check asking every slave how many rows it changed:
+//
+//	replicate_query:<seq>:<statement>
+//	affected_check:<token>:<seq>
+//
+// Both go out under seqMu, so the check is the next frame each slave gets
+// after the statement. The slave answers affected_count:<token>:<rows>, or
+// affected_count:<token>:error:<message> if the statement failed there or
+// never ran. A count that differs from the master's means the slave's copy
+// of the table had diverged. Older slaves ignore the check and are listed
+// as not reporting.
+
+const bulkAckTimeout = 10 * time.Second
+
+type affectedReport struct {
+	addr  string
+	count int64
+	err   string
+}
+
+var (
+	bulkMu      sync.Mutex
+	bulkWaiters = make(map[string]chan affectedReport)
+	bulkSeq     uint64
+)
+
+type bulkResult struct {
+	affected int64
+	perSlave map[string]affectedReport
+	missing  []string
+	checked  bool
+}
+
+// Called by the slave handler when an affected_count arrives
+func bulkReported(content, addr string) {
+	token, rest, ok := parseMessage(content)
+	if !ok {
+		fmt.Printf("Invalid affected_count from slave %s\n", addr)
+		return
+	}
+	report := affectedReport{addr: addr}
+	if msg, failed := strings.CutPrefix(rest, "error:"); failed {
+		report.err = msg
+	} else if n, err := strconv.ParseInt(rest, 10, 64); err == nil {
+		report.count = n
+	} else {
+		report.err = "unreadable count " + rest
+	}
+
+	bulkMu.Lock()
+	ch, ok := bulkWaiters[token]
+	bulkMu.Unlock()
+	if !ok {
+		return
+	}
+	// The channel has room for one report per slave asked. One more means
+	// a slave answered twice, and mustn't hold up its handler.
+	select {
+	case ch <- report:
+	default:
+		fmt.Printf("Ignoring extra affected_count from slave %s\n", addr)
+	}
+}
+
+// Run a bulk statement, replicate it and collect the slaves' counts
+func runBulk(query string) (*bulkResult, error) {
+	bulkMu.Lock()
+	bulkSeq++
+	token := fmt.Sprintf("%d-%d", time.Now().UnixNano(), bulkSeq)
+	bulkMu.Unlock()
+
+	endWrite := beginWrite()
+	result, err := db.Exec(query)
+	if err != nil {
+		endWrite()
+		return nil, err
+	}
+	res := &bulkResult{perSlave: make(map[string]affectedReport)}
+	res.affected, _ = result.RowsAffected()
+	if binlogEngine() || dryRun {
+		if !binlogEngine() {
+			replicate(nil, query)
+		}
+		endWrite()
+		return res, nil
+	}
+
+	seqMu.Lock()
+	seq := replicateLocked(nil, query)
+	var targets []*slaveConn
+	for _, s := range slaveTargets(nil) {
+		// Schema-only slaves don't get the rows, broken ones get nothing
+		if !s.schemaOnly && !s.isBroken() {
+			targets = append(targets, s)
+		}
+	}
+	reports := make(chan affectedReport, len(targets))
+	bulkMu.Lock()
+	bulkWaiters[token] = reports
+	bulkMu.Unlock()
+	for _, s := range targets {
+		s.sendLive("affected_check:%s:%d\n", token, seq)
+	}
+	seqMu.Unlock()
+	endWrite()
+	defer func() {
+		bulkMu.Lock()
+		delete(bulkWaiters, token)
+		bulkMu.Unlock()
+	}()
+
+	res.checked = true
+	pending := make(map[string]bool, len(targets))
+	for _, s := range targets {
+		pending[s.addr] = true
+	}
+	timeout := time.After(bulkAckTimeout)
+	for len(pending) > 0 {
+		select {
+		case r := <-reports:
+			if pending[r.addr] {
+				delete(pending, r.addr)
+				res.perSlave[r.addr] = r
+			}
+		case <-timeout:
+			for addr := range pending {
+				res.missing = append(res.missing, addr)
+			}
+			sort.Strings(res.missing)
+			return res, nil
+		}
+	}
+	return res, nil
+}
+
+// Menu action: update or delete every row of the current table matching a
+// filter
+func BulkOperation() {
+	if txOpen() {
+		return
+	}
+	fmt.Println("1. Bulk Delete")
+	fmt.Println("2. Bulk Update")
+	fmt.Print("Enter choice: ")
+	choice := readChoice()
+	if choice != 1 && choice != 2 {
+		fmt.Println("Invalid choice")
+		return
+	}
+
+	var sets string
+	if choice == 2 {
+		fmt.Print("Enter the assignments, e.g. status = 'archived': ")
+		sets = strings.TrimSpace(readLine())
+		if sets == "" {
+			fmt.Println("No assignments given, cancelled")
+			return
+		}
+	}
+	fmt.Print("Enter the WHERE filter, e.g. status = 'cancelled': ")
+	filter := strings.TrimSpace(readLine())
+	if filter == "" {
+		fmt.Println("A filter is required, cancelled")
+		return
+	}
+
+	op, verb := "bulk_delete", "deleted"
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s", currentTable, filter)
+	if choice == 2 {
+		op, verb = "bulk_update", "updated"
+		query = fmt.Sprintf("UPDATE %s SET %s WHERE %s", currentTable, sets, filter)
+	} else if isSoftDelete(currentTable) {
+		query = softDeleteQuery(query)
+		verb = "marked as deleted"
+	}
+	if _, err := statementWords(query); err != nil {
+		fmt.Printf("Invalid statement: %v\n", err)
+		return
+	}
+
+	var matching int64
+	err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", currentTable, filter)).Scan(&matching)
+	if err != nil {
+		fmt.Printf("Invalid filter: %v\n", err)
+		return
+	}
+	if !confirm(fmt.Sprintf("%d row(s) match. Run %s here and on every slave? (y/n): ", matching, query)) {
+		return
+	}
+
+	res, err := runBulk(query)
+	if err != nil {
+		fmt.Printf("Bulk operation failed: %v\n", err)
+		return
+	}
+	auditLog(op, fmt.Sprintf("%s (%d rows)", query, res.affected))
+	fmt.Printf("%d row(s) %s on the master\n", res.affected, verb)
+	switch {
+	case binlogEngine():
+		fmt.Println("The changes are replicated from the binary log, slave counts aren't checked")
+		return
+	case !res.checked:
+		return
+	}
+
+	addrs := make([]string, 0, len(res.perSlave))
+	for addr := range res.perSlave {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	diverged := 0
+	for _, addr := range addrs {
+		r := res.perSlave[addr]
+		switch {
+		case r.err != "":
+			diverged++
+			fmt.Printf("- %s failed: %s\n", addr, r.err)
+		case r.count != res.affected:
+			diverged++
+			fmt.Printf("- %s DIVERGED: %d row(s) %s, the master %d\n", addr, r.count, verb, res.affected)
+		default:
+			fmt.Printf("- %s matched\n", addr)
+		}
+	}
+	for _, addr := range res.missing {
+		fmt.Printf("- %s did not report within %v\n", addr, bulkAckTimeout)
+	}
+	if diverged > 0 {
+		fmt.Printf("WARNING: %d slave(s) don't match the master, verify or resync them\n", diverged)
+	}
+}
+
+// Slave side. Outcome of the last replicated statement, for affected_check.
+// Apply workers finish out of order, so the highest position is kept.
+var lastReplicated struct {
+	sync.Mutex
+	seq      uint64
+	affected int64
+	err      error
+}
+
+// Positions start over with a new sync
+func resetReplicated() {
+	lastReplicated.Lock()
+	defer lastReplicated.Unlock()
+	lastReplicated.seq, lastReplicated.affected, lastReplicated.err = 0, 0, nil
+}
+
+func recordReplicated(seq uint64, affected int64, err error) {
+	lastReplicated.Lock()
+	defer lastReplicated.Unlock()
+	if seq >= lastReplicated.seq {
+		lastReplicated.seq, lastReplicated.affected, lastReplicated.err = seq, affected, err
+	}
+}
+
+// Slave side of affected_check:<token>:<seq>
+func answerAffectedCheck(content string) {
+	token, seqStr, ok := parseMessage(content)
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if !ok || err != nil {
+		fmt.Println("Invalid affected_check message from master")
+		return
+	}
+	lastReplicated.Lock()
+	defer lastReplicated.Unlock()
+	switch {
+	case lastReplicated.seq != seq:
+		fmt.Fprintf(master, "affected_count:%s:error:statement %d was not applied here\n", token, seq)
+	case lastReplicated.err != nil:
+		msg := strings.ReplaceAll(lastReplicated.err.Error(), "\n", " ")
+		fmt.Fprintf(master, "affected_count:%s:error:%s\n", token, msg)
+	default:
+		fmt.Fprintf(master, "affected_count:%s:%d\n", token, lastReplicated.affected)
+		if lastReplicated.affected > 0 {
+			fmt.Printf("Bulk change from master affected %d row(s)\n", lastReplicated.affected)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+func TestBulkReportsADivergedCount(t *testing.T) {
+	f := useFakeDB(t)
+	f.on(`^DELETE FROM t WHERE`, func([]driver.Value) fakeResult { return fakeResult{affected: 3} })
+	// Each slave answers the check with its own count
+	for addr, count := range map[string]string{"in-step": "3", "diverged": "2"} {
+		s, sc := pipeSlave(t)
+		s.addr = addr
+		registerSlave(t, s)
+		s.syncFinished()
+		go func() {
+			for sc.Scan() {
+				if check, ok := strings.CutPrefix(sc.Text(), "affected_check:"); ok {
+					token, _, _ := strings.Cut(check, ":")
+					bulkReported(token+":"+count, addr)
+					// A second answer is dropped, not waited on
+					bulkReported(token+":"+count, addr)
+				}
+			}
+		}()
+	}
+
+	res, err := runBulk("DELETE FROM t WHERE v > 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.affected != 3 || !res.checked || len(res.missing) != 0 {
+		t.Fatalf("got %+v", res)
+	}
+	if r := res.perSlave["in-step"]; r.count != 3 || r.err != "" {
+		t.Fatalf("in-step slave reported %+v", r)
+	}
+	if r := res.perSlave["diverged"]; r.count != 2 || r.err != "" {
+		t.Fatalf("diverged slave reported %+v, want its count of 2", r)
+	}
+}
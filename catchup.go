@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Block-checksum catch-up. Instead of reloading a whole table, the slave
+// compares it with the master in blocks of primary key ranges and only
+// fetches the rows of the blocks that differ:
+//
+//	slave:  get_block_checksums:<table>
+//	master: block_checksums:<json blockList>
+//	slave:  get_block_rows:<table>:<lo>:<hi>      (one per differing block)
+//	master: block_begin:<table>:<lo>:<hi>[:<kept keys>]
+//	        block_data:<INSERT ...>               (zero or more)
+//	        block_end:<table>:<lo>:<hi>:<rows>
+//
+// On block_begin the slave deletes its rows in the range, so rows the
+// master no longer has go away too. Rows too large to send are listed by
+// key after the range, the slave keeps its own copy of those. The block
+// is read from a consistent snapshot and live frames are held until it
+// is sent, so writes made meanwhile are applied on top of it. The ranges
+// are contiguous and together cover every key, so a row the slave has
+// outside the master's keys is in some block as well. Only tables with a
+// single integer primary key can be caught up this way; the rest are
+// reloaded as before.
+
+const checksumBlockRows = 1000
+
+type checksumBlock struct {
+	Lo   int64  `json:"lo"`
+	Hi   int64  `json:"hi"`
+	Rows int    `json:"rows"`
+	Sum  uint64 `json:"sum"`
+}
+
+type blockList struct {
+	Table  string          `json:"table"`
+	Blocks []checksumBlock `json:"blocks"`
+}
+
+// Name of the table's single integer primary key column
+func integerKey(table string) (string, error) {
+	if !tableNameRe.MatchString(table) {
+		return "", fmt.Errorf("invalid table name %q", table)
+	}
+	keys, err := primaryKeyColumns(table)
+	if err != nil {
+		return "", err
+	}
+	if len(keys) != 1 {
+		return "", fmt.Errorf("table %s has no single-column primary key", table)
+	}
+	var dataType string
+	err = db.QueryRow(`SELECT DATA_TYPE FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?`, table, keys[0]).Scan(&dataType)
+	if err != nil {
+		return "", err
+	}
+	switch strings.ToLower(dataType) {
+	case "tinyint", "smallint", "mediumint", "int", "bigint":
+		return keys[0], nil
+	}
+	return "", fmt.Errorf("primary key %s.%s is %s, not an integer", table, keys[0], dataType)
+}
+
+// Per-row CRC32 expression over every column, in column order. NULLs are
+// flagged separately since CONCAT_WS skips them.
+func rowChecksumExpr(table string) (string, error) {
+	rows, err := db.Query(`SELECT COLUMN_NAME FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION`, table)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var cols, nulls []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return "", err
+		}
+		cols = append(cols, quoteIdent(dialectMySQL, name))
+		nulls = append(nulls, "ISNULL("+quoteIdent(dialectMySQL, name)+")")
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if len(cols) == 0 {
+		return "", fmt.Errorf("table %s has no columns", table)
+	}
+	return fmt.Sprintf("CRC32(CONCAT_WS('|', %s, CONCAT(%s)))", strings.Join(cols, ", "), strings.Join(nulls, ", ")), nil
+}
+
+// Row count and checksum of the rows with keys in [lo, hi]
+func blockChecksum(table, key, expr string, lo, hi int64) (checksumBlock, error) {
+	b := checksumBlock{Lo: lo, Hi: hi}
+	err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*), COALESCE(SUM(%s), 0) FROM `%s` WHERE `%s` BETWEEN ? AND ?",
+		expr, table, key), lo, hi).Scan(&b.Rows, &b.Sum)
+	return b, err
+}
+
+// Master side: split the table into blocks of checksumBlockRows keys and
+// checksum each of them
+func tableBlocks(table string) (*blockList, error) {
+	key, err := integerKey(table)
+	if err != nil {
+		return nil, err
+	}
+	expr, err := rowChecksumExpr(table)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(fmt.Sprintf("SELECT `%s` FROM `%s` ORDER BY `%s`", key, table, key))
+	if err != nil {
+		return nil, err
+	}
+	var bounds []int64
+	n := 0
+	for rows.Next() {
+		var k int64
+		if err := rows.Scan(&k); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if n++; n%checksumBlockRows == 0 {
+			bounds = append(bounds, k)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	list := &blockList{Table: table}
+	lo := int64(math.MinInt64)
+	for _, hi := range append(bounds, math.MaxInt64) {
+		b, err := blockChecksum(table, key, expr, lo, hi)
+		if err != nil {
+			return nil, err
+		}
+		list.Blocks = append(list.Blocks, b)
+		if hi == math.MaxInt64 {
+			break
+		}
+		lo = hi + 1
+	}
+	return list, nil
+}
+
+// Master side of get_block_checksums
+func sendBlockChecksums(s *slaveConn, table string) {
+	list, err := tableBlocks(table)
+	if err != nil {
+		s.reply("error:Failed to checksum table %s: %v\n", table, err)
+		return
+	}
+	data, _ := json.Marshal(list)
+	s.reply("block_checksums:%s\n", data)
+}
+
+// Master side of get_block_rows:<table>:<lo>:<hi>
+func sendBlockRows(s *slaveConn, content string) {
+	parts := strings.Split(content, ":")
+	if len(parts) != 3 {
+		s.reply("error:get_block_rows needs <table>:<lo>:<hi>\n")
+		return
+	}
+	table := parts[0]
+	lo, errLo := strconv.ParseInt(parts[1], 10, 64)
+	hi, errHi := strconv.ParseInt(parts[2], 10, 64)
+	if errLo != nil || errHi != nil {
+		s.reply("error:invalid block range %s:%s\n", parts[1], parts[2])
+		return
+	}
+	key, err := integerKey(table)
+	if err != nil {
+		s.reply("error:Failed to read block of %s: %v\n", table, err)
+		return
+	}
+
+	// Read from a snapshot taken while live frames start being held, so a
+	// write made after it reaches the slave after the block and one made
+	// before it is in the rows
+	ctx := context.Background()
+	snap, err := consistentSnapshot(ctx, s.holdLive)
+	defer s.releaseLive()
+	if err != nil {
+		s.reply("error:Failed to read block of %s: %v\n", table, err)
+		return
+	}
+	defer closeSnapshot(ctx, snap)
+	rows, err := snap.QueryContext(ctx, fmt.Sprintf("SELECT * FROM `%s` WHERE `%s` BETWEEN ? AND ? ORDER BY `%s`",
+		table, key, key), lo, hi)
+	if err != nil {
+		s.reply("error:Failed to read block of %s: %v\n", table, err)
+		return
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		s.reply("error:Failed to read block of %s: %v\n", table, err)
+		return
+	}
+	var batch [][]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		scanArgs := make([]interface{}, len(columns))
+		for i := range values {
+			scanArgs[i] = &values[i]
+		}
+		if err := rows.Scan(scanArgs...); err != nil {
+			fmt.Printf("Error scanning row: %v\n", err)
+			continue
+		}
+		batch = append(batch, values)
+	}
+	rows.Close()
+
+	limit := s.packetLimit() - len("block_data:\n")
+	stmts, tooLarge := buildInsertBatches(replicaDialect, table, columns, batch, limit)
+	keyIndex := 0
+	for i, c := range columns {
+		if c == key {
+			keyIndex = i
+		}
+	}
+	var keep []string
+	for _, r := range tooLarge {
+		fmt.Printf("Skipping %d byte row in table %s for slave %s: over the %d byte limit\n",
+			r.size, table, s.addr, limit)
+		switch k := batch[r.row][keyIndex].(type) {
+		case []byte:
+			keep = append(keep, string(k))
+		default:
+			keep = append(keep, fmt.Sprint(k))
+		}
+	}
+
+	// The slave keeps its copy of the rows that can't be sent
+	if len(keep) > 0 {
+		s.sendBulk("block_begin:%s:%d:%d:%s\n", table, lo, hi, strings.Join(keep, ","))
+	} else {
+		s.sendBulk("block_begin:%s:%d:%d\n", table, lo, hi)
+	}
+	for _, stmt := range stmts {
+		s.sendBulk("block_data:%s\n", stmt)
+	}
+	s.sendBulk("block_end:%s:%d:%d:%d\n", table, lo, hi, len(batch)-len(tooLarge))
+}
+
+// Slave side: ask the master for a table's block checksums. Returns false
+// if the table can't be caught up block-wise.
+func catchUpTable(table string) bool {
+	if _, err := integerKey(table); err != nil {
+		fmt.Printf("Table '%s' can't be caught up block-wise: %v\n", table, err)
+		return false
+	}
+	fmt.Printf("Comparing table '%s' with the master block by block\n", table)
+	fmt.Fprintf(master, "get_block_checksums:%s\n", table)
+	return true
+}
+
+// Slave side of block_checksums: request the rows of every block whose
+// local checksum differs
+func compareBlocks(content string) {
+	var list blockList
+	if err := json.Unmarshal([]byte(content), &list); err != nil {
+		fmt.Printf("Invalid block checksums from master: %v\n", err)
+		return
+	}
+	key, err := integerKey(list.Table)
+	if err != nil {
+		fmt.Printf("Cannot compare table '%s': %v\n", list.Table, err)
+		return
+	}
+	expr, err := rowChecksumExpr(list.Table)
+	if err != nil {
+		fmt.Printf("Cannot compare table '%s': %v\n", list.Table, err)
+		return
+	}
+
+	differing := 0
+	for _, mb := range list.Blocks {
+		lb, err := blockChecksum(list.Table, key, expr, mb.Lo, mb.Hi)
+		if err != nil {
+			fmt.Printf("Error checksumming local block %d..%d of %s: %v\n", mb.Lo, mb.Hi, list.Table, err)
+			continue
+		}
+		if lb.Rows == mb.Rows && lb.Sum == mb.Sum {
+			continue
+		}
+		differing++
+		fmt.Fprintf(master, "get_block_rows:%s:%d:%d\n", list.Table, mb.Lo, mb.Hi)
+	}
+	if differing == 0 {
+		fmt.Printf("Table '%s': all %d block(s) match the master\n", list.Table, len(list.Blocks))
+		return
+	}
+	fmt.Printf("Table '%s': %d of %d block(s) differ, fetching their rows\n",
+		list.Table, differing, len(list.Blocks))
+}
+
+// Slave side of block_begin: clear the range before the master's rows arrive
+func beginBlock(content string) {
+	parts := strings.Split(content, ":")
+	if len(parts) != 3 && len(parts) != 4 {
+		fmt.Printf("Invalid block_begin message from master: %s\n", content)
+		return
+	}
+	key, err := integerKey(parts[0])
+	if err != nil {
+		fmt.Printf("Cannot replace block of '%s': %v\n", parts[0], err)
+		return
+	}
+	query := fmt.Sprintf("DELETE FROM `%s` WHERE `%s` BETWEEN ? AND ?", parts[0], key)
+	args := []interface{}{parts[1], parts[2]}
+	if len(parts) == 4 {
+		// Rows the master couldn't send, the local copy stays
+		var marks []string
+		for _, k := range strings.Split(parts[3], ",") {
+			if _, err := strconv.ParseInt(k, 10, 64); err != nil {
+				fmt.Printf("Invalid block_begin message from master: %s\n", content)
+				return
+			}
+			marks = append(marks, "?")
+			args = append(args, k)
+		}
+		query += fmt.Sprintf(" AND `%s` NOT IN (%s)", key, strings.Join(marks, ", "))
+		fmt.Printf("Table '%s': keeping %d local row(s) of block %s..%s the master couldn't send\n",
+			parts[0], len(marks), parts[1], parts[2])
+	}
+	_, err = db.Exec(query, args...)
+	if err != nil {
+		fmt.Printf("Error clearing block %s..%s of '%s': %v\n", parts[1], parts[2], parts[0], err)
+	}
+}
+
+// Menu action: catch up every local table that has an integer key
+func catchUpAll() {
+	if db == nil {
+		fmt.Println("Local database not set up yet")
+		return
+	}
+	if !connected {
+		fmt.Println("Not connected to master server")
+		return
+	}
+	if schemaOnly {
+		fmt.Println("This slave only replicates the schema, there are no rows to catch up")
+		return
+	}
+
+	rows, err := db.Query("SHOW TABLES")
+	if err != nil {
+		fmt.Printf("Error getting local tables: %v\n", err)
+		return
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if rows.Scan(&name) == nil {
+			names = append(names, name)
+		}
+	}
+	rows.Close()
+
+	for _, name := range names {
+		catchUpTable(name)
+	}
+	// The results are reported as the master's answers arrive
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"database/sql/driver"
+	"math"
+	"strings"
+	"testing"
+)
+
+// An integer keyed table for block catch-up
+func useIntegerKey(f *fakeDB, key string) {
+	f.rows(`KEY_COLUMN_USAGE`, []string{"COLUMN_NAME"}, []driver.Value{key})
+	f.rows(`^SELECT DATA_TYPE FROM information_schema.COLUMNS`, []string{"DATA_TYPE"}, []driver.Value{"int"})
+}
+
+func TestBlockRowsHoldLaterWrites(t *testing.T) {
+	f := useFakeDB(t)
+	useIntegerKey(f, "id")
+	s, sc := pipeSlave(t)
+	registerSlave(t, s)
+	s.syncFinished()
+
+	// A write lands on the master while the block is being read
+	f.on("^SELECT \\* FROM `t` WHERE `id` BETWEEN", func([]driver.Value) fakeResult {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			defer beginWrite()()
+			replicate(nil, "UPDATE t SET v = 2 WHERE id = 1")
+		}()
+		<-done
+		return fakeResult{cols: []string{"id", "v"}, rows: [][]driver.Value{{int64(1), int64(1)}}}
+	})
+
+	go sendBlockRows(s, "t:1:1000")
+
+	want := []string{"block_begin:t:1:1000", "block_data:INSERT INTO `t` (`id`, `v`) VALUES (1, 1)", "block_end:t:1:1000:1"}
+	for _, w := range want {
+		if got := nextFrame(t, sc); got != w {
+			t.Fatalf("got %q, want %q", got, w)
+		}
+	}
+	if got := nextFrame(t, sc); !strings.HasSuffix(got, ":UPDATE t SET v = 2 WHERE id = 1") {
+		t.Fatalf("got %q, want the write made during the read", got)
+	}
+	if n := len(f.matching(`^START TRANSACTION WITH CONSISTENT SNAPSHOT`)); n != 1 {
+		t.Fatalf("%d snapshot(s) taken, want 1", n)
+	}
+}
+
+func TestBlockKeepsRowsTooLargeToSend(t *testing.T) {
+	f := useFakeDB(t)
+	useIntegerKey(f, "id")
+	s, sc := pipeSlave(t)
+	s.recordInfo("max_allowed_packet:200")
+	s.syncFinished()
+
+	f.rows("^SELECT \\* FROM `t` WHERE `id` BETWEEN", []string{"id", "v"},
+		[]driver.Value{int64(1), "small"},
+		[]driver.Value{int64(2), []byte(strings.Repeat("x", 300))},
+		[]driver.Value{int64(3), "small"})
+	go sendBlockRows(s, "t:1:1000")
+
+	begin := nextFrame(t, sc)
+	if begin != "block_begin:t:1:1000:2" {
+		t.Fatalf("got %q, want the skipped row listed", begin)
+	}
+	if got := nextFrame(t, sc); !strings.Contains(got, "(1, 'small'), (3, 'small')") {
+		t.Fatalf("got %q", got)
+	}
+	if got := nextFrame(t, sc); got != "block_end:t:1:1000:2" {
+		t.Fatalf("got %q", got)
+	}
+
+	// The slave clears the range but keeps its copy of row 2
+	local := useFakeDB(t)
+	useIntegerKey(local, "id")
+	beginBlock(strings.TrimPrefix(begin, "block_begin:"))
+	got := local.matching(`^DELETE`)
+	if len(got) != 1 || got[0] != "DELETE FROM `t` WHERE `id` BETWEEN ? AND ? AND `id` NOT IN (?)" {
+		t.Fatalf("ran %q", got)
+	}
+}
+
+// Block checksums of t: the rows and sum of each range, by its low end
+func blockSums(f *fakeDB, sums map[int64]int64) {
+	f.rows(`information_schema\.COLUMNS\s+WHERE TABLE_SCHEMA = DATABASE\(\) AND TABLE_NAME = \? ORDER BY`,
+		[]string{"COLUMN_NAME"}, []driver.Value{"id"}, []driver.Value{"v"})
+	f.on("^SELECT COUNT\\(\\*\\), COALESCE\\(SUM\\(.*\\), 0\\) FROM `t` WHERE `id` BETWEEN", func(args []driver.Value) fakeResult {
+		return fakeResult{cols: []string{"COUNT(*)", "SUM"}, rows: [][]driver.Value{{int64(1000), sums[args[0].(int64)]}}}
+	})
+}
+
+func TestOnlyTheDifferingBlockIsSent(t *testing.T) {
+	masterDB, masterConn := openFakeDB(t)
+	useIntegerKey(masterDB, "id")
+	var keys [][]driver.Value
+	for k := int64(1); k <= 2500; k++ {
+		keys = append(keys, []driver.Value{k})
+	}
+	masterDB.rows("^SELECT `id` FROM `t` ORDER BY `id`$", []string{"id"}, keys...)
+	blockSums(masterDB, map[int64]int64{math.MinInt64: 11, 1001: 22, 2001: 33})
+	var read [][]driver.Value
+	masterDB.on("^SELECT \\* FROM `t` WHERE `id` BETWEEN", func(args []driver.Value) fakeResult {
+		read = append(read, args)
+		return fakeResult{cols: []string{"id", "v"}, rows: [][]driver.Value{{int64(1500), int64(7)}}}
+	})
+	oldDB := db
+	t.Cleanup(func() { db = oldDB })
+	db = masterConn
+
+	s, sc := pipeSlave(t)
+	s.syncFinished()
+	go sendBlockChecksums(s, "t")
+	checksums, ok := strings.CutPrefix(nextFrame(t, sc), "block_checksums:")
+	if !ok {
+		t.Fatalf("got %q, want the block checksums", checksums)
+	}
+
+	// The slave differs in the middle block only
+	slaveDB, slaveConn := openFakeDB(t)
+	useIntegerKey(slaveDB, "id")
+	blockSums(slaveDB, map[int64]int64{math.MinInt64: 11, 1001: 23, 2001: 33})
+	db = slaveConn
+	requests := pipeMaster(t)
+	compareBlocks(checksums)
+	if got := nextLine(t, requests); got != "get_block_rows:t:1001:2000" {
+		t.Fatalf("slave asked for %q, want the differing block", got)
+	}
+	noMoreLines(t, requests)
+
+	db = masterConn
+	go sendBlockRows(s, "t:1001:2000")
+	for _, w := range []string{"block_begin:t:1001:2000", "block_data:INSERT INTO `t` (`id`, `v`) VALUES (1500, 7)", "block_end:t:1001:2000:1"} {
+		if got := nextFrame(t, sc); got != w {
+			t.Fatalf("got %q, want %q", got, w)
+		}
+	}
+	if len(read) != 1 || read[0][0] != int64(1001) || read[0][1] != int64(2000) {
+		t.Fatalf("master read the ranges %v, want only 1001..2000", read)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// In-memory changelog of replication operations applied on this node, kept
+// in a ring buffer of the last changelogSize entries. Unlike the audit log
+// it can be listed and filtered from the menu while running.
+
+const changelogSize = 1000
+
+type changeEntry struct {
+	seq    uint64 // 0 for operations outside the replication sequence (DDL)
+	op     string
+	table  string
+	at     time.Time
+	source string
+}
+
+var (
+	changelogMu   sync.Mutex
+	changelog     = make([]changeEntry, changelogSize)
+	changelogNext int // index of the next entry to write
+	changelogLen  int
+)
+
+var statementTableRe = regexp.MustCompile("(?is)^\\s*(INSERT\\s+(?:IGNORE\\s+|OR\\s+\\w+\\s+)?INTO|REPLACE\\s+INTO|UPDATE|DELETE\\s+FROM|CREATE\\s+TABLE(?:\\s+IF\\s+NOT\\s+EXISTS)?|DROP\\s+TABLE(?:\\s+IF\\s+EXISTS)?|ALTER\\s+TABLE)\\s+[`\"]?(\\w+)")
+
+// Operation keyword and table name of a statement, as far as they can be told
+func statementInfo(query string) (op, table string) {
+	fields := strings.Fields(query)
+	if len(fields) > 0 {
+		op = strings.ToUpper(fields[0])
+	}
+	if m := statementTableRe.FindStringSubmatch(query); m != nil {
+		table = m[2]
+	}
+	return op, table
+}
+
+func recordChange(seq uint64, query, source string) {
+	op, table := statementInfo(query)
+
+	entry := changeEntry{seq: seq, op: op, table: table, at: time.Now(), source: source}
+	changelogMu.Lock()
+	changelog[changelogNext] = entry
+	changelogNext = (changelogNext + 1) % changelogSize
+	if changelogLen < changelogSize {
+		changelogLen++
+	}
+	changelogMu.Unlock()
+	publishEvent(entry)
+}
+
+// Entries oldest first, filtered by table ("" for all) and time range
+// (zero times leave that end open)
+func changesFor(table string, from, to time.Time) []changeEntry {
+	changelogMu.Lock()
+	defer changelogMu.Unlock()
+
+	var out []changeEntry
+	start := (changelogNext - changelogLen + changelogSize) % changelogSize
+	for i := 0; i < changelogLen; i++ {
+		e := changelog[(start+i)%changelogSize]
+		if table != "" && !strings.EqualFold(e.table, table) {
+			continue
+		}
+		if !from.IsZero() && e.at.Before(from) {
+			continue
+		}
+		if !to.IsZero() && e.at.After(to) {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+const changelogTimeLayout = "2006-01-02 15:04"
+
+// Parse a time filter in local time, blank means no limit
+func parseChangelogTime(input string) (time.Time, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation(changelogTimeLayout, input, time.Local)
+}
+
+// Menu action: list the changelog with optional filters
+func showChangelog() {
+	fmt.Print("Filter by table (blank for all): ")
+	table := strings.TrimSpace(readLine())
+
+	fmt.Printf("From time (%s, blank for no limit): ", changelogTimeLayout)
+	from, err := parseChangelogTime(readLine())
+	if err != nil {
+		fmt.Printf("Invalid time: %v\n", err)
+		return
+	}
+	fmt.Printf("To time (%s, blank for no limit): ", changelogTimeLayout)
+	to, err := parseChangelogTime(readLine())
+	if err != nil {
+		fmt.Printf("Invalid time: %v\n", err)
+		return
+	}
+	if !to.IsZero() {
+		// Include the whole minute that was entered
+		to = to.Add(time.Minute - time.Nanosecond)
+	}
+
+	entries := changesFor(table, from, to)
+	if len(entries) == 0 {
+		fmt.Println("No matching changelog entries")
+		return
+	}
+	fmt.Printf("\n%-8s %-19s %-8s %-20s %s\n", "SEQ", "TIME", "OP", "TABLE", "SOURCE")
+	for _, e := range entries {
+		seq := "-"
+		if e.seq > 0 {
+			seq = fmt.Sprint(e.seq)
+		}
+		fmt.Printf("%-8s %-19s %-8s %-20s %s\n", seq, e.at.Format("2006-01-02 15:04:05"), e.op, e.table, e.source)
+	}
+	fmt.Printf("%d matching entries (only the last %d operations are kept)\n", len(entries), changelogSize)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Start the test with an empty changelog, and leave one behind
+func clearChangelog(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		changelogMu.Lock()
+		changelog = make([]changeEntry, changelogSize)
+		changelogNext, changelogLen = 0, 0
+		changelogMu.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+// Record query as if it was applied at the given local time
+func recordChangeAt(t *testing.T, at string, seq uint64, query string) {
+	t.Helper()
+	when, err := time.ParseInLocation("2006-01-02 15:04:05", at, time.Local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordChange(seq, query, "master")
+	changelogMu.Lock()
+	changelog[(changelogNext-1+changelogSize)%changelogSize].at = when
+	changelogMu.Unlock()
+}
+
+func TestChangelogFiltersByTableAndTime(t *testing.T) {
+	clearChangelog(t)
+	recordChangeAt(t, "2026-03-01 09:59:59", 1, "INSERT INTO orders (id) VALUES (1)")
+	recordChangeAt(t, "2026-03-01 10:00:00", 2, "UPDATE `orders` SET qty = 2 WHERE id = 1")
+	recordChangeAt(t, "2026-03-01 10:01:30", 3, "DELETE FROM users WHERE id = 4")
+	recordChangeAt(t, "2026-03-01 10:05:59", 4, "delete from ORDERS where id = 1")
+	recordChangeAt(t, "2026-03-01 10:06:00", 5, "INSERT INTO orders (id) VALUES (2)")
+
+	// The end minute is included whole
+	feedInput(t, "orders", "2026-03-01 10:00", "2026-03-01 10:05")
+	out := captureOutput(t, showChangelog)
+	var seqs []string
+	for _, line := range strings.Split(out, "\n") {
+		if f := strings.Fields(line); len(f) > 0 && f[0] != "SEQ" && strings.Contains(line, "master") {
+			seqs = append(seqs, f[0])
+		}
+	}
+	if strings.Join(seqs, ",") != "2,4" {
+		t.Fatalf("listed %v, want 2 and 4:\n%s", seqs, out)
+	}
+	if !strings.Contains(out, "2 matching entries") {
+		t.Fatalf("printed:\n%s", out)
+	}
+
+	feedInput(t, "", "", "")
+	if out := captureOutput(t, showChangelog); !strings.Contains(out, "5 matching entries") {
+		t.Fatalf("unfiltered list:\n%s", out)
+	}
+	feedInput(t, "", "yesterday")
+	if out := captureOutput(t, showChangelog); !strings.Contains(out, "Invalid time") {
+		t.Fatalf("bad time accepted:\n%s", out)
+	}
+}
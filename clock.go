@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Clock skew between the master and its slaves, estimated the way simple
+// NTP does it. Once a slave confirms its sync the master sends
+// time_probe:<t1>, the slave answers time_reply:<t1>:<t2>:<t3> with the
+// times it received and answered the probe, and the master notes when the
+// reply arrived (t4):
+//
+//	offset = ((t2 - t1) + (t3 - t4)) / 2   slave clock minus master clock
+//	rtt    = (t4 - t1) - (t3 - t2)
+//
+// The estimate is only good to within rtt/2. Skew beyond clockSkewWarn is
+// reported to the operator. The master keeps the offset per slave and
+// tells the slave (clock_offset:<ns>) so it can correct the master's
+// timestamps, e.g. the age of cluster stats.
+//
+// Times are Unix nanoseconds.
+
+// Replaced in tests to simulate a skewed clock
+var clock = time.Now
+
+const clockSkewWarn = time.Second
+
+// Offset and round trip time from one probe
+func estimateClock(t1, t2, t3, t4 int64) (offset, rtt time.Duration) {
+	offset = time.Duration(((t2 - t1) + (t3 - t4)) / 2)
+	rtt = time.Duration((t4 - t1) - (t3 - t2))
+	return offset, rtt
+}
+
+// Whether an offset is beyond the warning threshold even allowing for the
+// estimate's uncertainty
+func skewed(offset, rtt time.Duration) bool {
+	if offset < 0 {
+		offset = -offset
+	}
+	return offset-rtt/2 > clockSkewWarn
+}
+
+// Master side: start a measurement
+func sendClockProbe(s *slaveConn) {
+	s.reply("time_probe:%d\n", clock().UnixNano())
+}
+
+// Master side of time_reply
+func (s *slaveConn) recordClock(content string) {
+	t4 := clock().UnixNano()
+	parts := strings.Split(content, ":")
+	if len(parts) != 3 {
+		fmt.Printf("Invalid time_reply from slave %s: %s\n", s.addr, content)
+		return
+	}
+	var times [3]int64
+	for i, p := range parts {
+		t, err := strconv.ParseInt(p, 10, 64)
+		if err != nil {
+			fmt.Printf("Invalid time_reply from slave %s: %s\n", s.addr, content)
+			return
+		}
+		times[i] = t
+	}
+	offset, rtt := estimateClock(times[0], times[1], times[2], t4)
+
+	s.smu.Lock()
+	s.clockOffset, s.rtt, s.clockKnown = offset, rtt, true
+	s.smu.Unlock()
+
+	if skewed(offset, rtt) {
+		fmt.Printf("WARNING: slave %s's clock is about %s of the master's (round trip %v). Sync its clock, e.g. with NTP.\n",
+			s.addr, describeOffset(offset), rtt.Round(time.Microsecond))
+	}
+	s.reply("clock_offset:%d\n", int64(offset))
+}
+
+// "1.5s ahead" or "1.5s behind"
+func describeOffset(offset time.Duration) string {
+	if offset < 0 {
+		return fmt.Sprintf("%v behind", (-offset).Round(time.Millisecond))
+	}
+	return fmt.Sprintf("%v ahead", offset.Round(time.Millisecond))
+}
+
+// Skew for the slave list, "" unless it is significant
+func (s *slaveConn) clockNote() string {
+	s.smu.Lock()
+	defer s.smu.Unlock()
+	if !s.clockKnown || !skewed(s.clockOffset, s.rtt) {
+		return ""
+	}
+	return fmt.Sprintf("(clock %s)", describeOffset(s.clockOffset))
+}
+
+// Slave side. Our clock minus the master's, as last estimated by the master.
+var masterClockOffset atomic.Int64
+
+// Slave side of time_probe
+func answerClockProbe(t1 string) {
+	t2 := clock().UnixNano()
+	fmt.Fprintf(master, "time_reply:%s:%d:%d\n", t1, t2, clock().UnixNano())
+}
+
+// Slave side of clock_offset
+func recordClockOffset(content string) {
+	offset, err := strconv.ParseInt(content, 10, 64)
+	if err != nil {
+		return
+	}
+	masterClockOffset.Store(offset)
+	if d := time.Duration(offset); d.Abs() > clockSkewWarn {
+		fmt.Printf("WARNING: this host's clock is about %s of the master's\n", describeOffset(d))
+	}
+}
+
+// Slave side: a master timestamp translated to our clock
+func fromMasterTime(t time.Time) time.Time {
+	return t.Add(time.Duration(masterClockOffset.Load()))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestClockOffsetIsNotedOnlyWhenSkewed(t *testing.T) {
+	sent := time.Unix(1700000000, 0)
+	oldClock := clock
+	t.Cleanup(func() {
+		clock = oldClock
+		masterClockOffset.Store(0)
+	})
+
+	for _, tc := range []struct {
+		name        string
+		skew, delay time.Duration // slave clock minus master's, one way trip
+		note        string
+	}{
+		{"ahead", 3 * time.Second, 5 * time.Millisecond, "(clock 3s ahead)"},
+		{"behind", -1500 * time.Millisecond, 5 * time.Millisecond, "(clock 1.5s behind)"},
+		{"within the threshold", 800 * time.Millisecond, 5 * time.Millisecond, ""},
+		// 1.2s off, but the estimate could be 1.5s out
+		{"within the round trip", 1200 * time.Millisecond, 1500 * time.Millisecond, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, sc := pipeSlave(t)
+			// Probe sent at master, answered at once on arrival
+			t1 := sent
+			t2 := t1.Add(tc.delay + tc.skew)
+			t4 := t1.Add(2 * tc.delay)
+			clock = func() time.Time { return t4 }
+			go s.recordClock(fmt.Sprintf("%d:%d:%d", t1.UnixNano(), t2.UnixNano(), t2.UnixNano()))
+
+			want := fmt.Sprintf("clock_offset:%d", int64(tc.skew))
+			if got := nextFrame(t, sc); got != want {
+				t.Fatalf("got %q, want %q", got, want)
+			}
+			if got := s.clockNote(); got != tc.note {
+				t.Fatalf("clock note %q, want %q", got, tc.note)
+			}
+
+			// The slave corrects the master's timestamps with it
+			recordClockOffset(fmt.Sprintf("%d", int64(tc.skew)))
+			if got := fromMasterTime(t1); !got.Equal(t1.Add(tc.skew)) {
+				t.Fatalf("master time %v read as %v on the slave, want %v", t1, got, t1.Add(tc.skew))
+			}
+		})
+	}
+}
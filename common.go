@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Code shared by the master and slave subcommands
+
+// Database connection (the master's primary DB or the slave's local replica)
+var db *sql.DB
+
+// MySQL connection config for the given credentials. Connections always use
+// utf8mb4 so 4-byte characters (emoji, some CJK) survive replication.
+func newMySQLConfig(user, password string) *mysql.Config {
+	cfg := mysql.NewConfig()
+	cfg.User = user
+	cfg.Passwd = password
+	cfg.Net = "tcp"
+	cfg.Addr = mysqlAddr()
+	cfg.Apply(mysql.Charset("utf8mb4", "utf8mb4_unicode_ci"))
+	// Session variables, see autoinc.go
+	cfg.Params = autoIncrementParams()
+	return cfg
+}
+
+// Open a connection pool for cfg. The driver gets the Config itself rather
+// than a DSN string, which can't carry a user name holding a ':'.
+func openMySQL(cfg *mysql.Config) (*sql.DB, error) {
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(connector), nil
+}
+
+// Server's max_allowed_packet, or 0 if it can't be read
+func queryMaxPacket(conn *sql.DB) int {
+	var maxPacket int
+	if err := conn.QueryRow("SELECT @@max_allowed_packet").Scan(&maxPacket); err != nil {
+		fmt.Printf("Could not read max_allowed_packet: %v\n", err)
+		return 0
+	}
+	return maxPacket
+}
+
+func readPassword() string {
+	fmt.Print("Enter MySQL password: ")
+
+	// In a production environment, you would use a package like "golang.org/x/term"
+	// to read passwords securely without displaying them on screen
+	// Example:
+	// bytePassword, _ := term.ReadPassword(int(syscall.Stdin))
+	// return string(bytePassword)
+
+	// For simplicity, we'll just read it directly here. The whole line is
+	// the password, spaces included.
+	return readLine()
+}
+
+// Read a whole line from stdin, without the trailing newline
+func readLine() string {
+	line, _ := readInputLine()
+	return line
+}
+
+// All interactive input is read by one goroutine and handed over line by
+// line, so a read can give up after idleTimeout.
+var (
+	inputSource io.Reader = os.Stdin
+	inputOnce   sync.Once
+	inputLines  chan inputLine
+)
+
+// Exit when no input arrives for this long (-idle-timeout, 0 = wait forever)
+var idleTimeout time.Duration
+
+// Run before exiting on an idle timeout to close connections
+var idleCleanup func()
+
+type inputLine struct {
+	text string
+	err  error
+}
+
+func readInputLine() (string, error) {
+	inputOnce.Do(func() {
+		lines := make(chan inputLine)
+		inputLines = lines
+		go func() {
+			r := bufio.NewReader(inputSource)
+			for {
+				line, err := r.ReadString('\n')
+				if err == io.EOF && line != "" {
+					err = nil
+				}
+				lines <- inputLine{strings.TrimRight(line, "\r\n"), err}
+				if err != nil {
+					// Later reads see EOF too instead of blocking
+					close(lines)
+					return
+				}
+			}
+		}()
+	})
+
+	var timeout <-chan time.Time
+	if idleTimeout > 0 {
+		timer := time.NewTimer(idleTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case in, ok := <-inputLines:
+		if !ok {
+			return "", io.EOF
+		}
+		return in.text, in.err
+	case <-timeout:
+		idleExit()
+		return "", io.EOF
+	}
+}
+
+func idleExit() {
+	fmt.Printf("\nNo input for %v, exiting.\n", idleTimeout)
+	if idleCleanup != nil {
+		idleCleanup()
+	}
+	exitProgram(0)
+}
+
+// Read a menu choice, asking again until a number is entered. Exits when
+// stdin is closed so a menu loop can't spin on it.
+func readChoice() int {
+	for {
+		line, err := readInputLine()
+		if err != nil {
+			fmt.Println("\nEnd of input, exiting.")
+			exitProgram(0)
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(line)); err == nil {
+			return n
+		}
+		fmt.Print("Please enter a number: ")
+	}
+}
+
+// Whether a y/n answer means yes. Surrounding space and case are ignored
+// and "yes" is accepted as well as "y".
+func isYes(answer string) bool {
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// Ask a y/n question on a full line of input
+func confirm(prompt string) bool {
+	fmt.Print(prompt)
+	return isYes(readLine())
+}
+
+// Split a protocol line of the form "type:content"
+func parseMessage(line string) (string, string, bool) {
+	parts := strings.SplitN(line, ":", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// A word of a statement outside parentheses, upper-cased, and where it starts
+type outerWord struct {
+	word  string
+	start int
+}
+
+// The words of a statement outside parentheses (so not in subqueries),
+// skipping comments, string literals and quoted identifiers, and the offset
+// the statement ends at, before any trailing comment or semicolon. ok is
+// false if the statement can't be read, e.g. for an unterminated quote.
+func outerWords(query string) (words []outerWord, end int, ok bool) {
+	depth := 0
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ';':
+			i++
+			continue
+		case strings.HasPrefix(query[i:], "/*"):
+			stop := strings.Index(query[i+2:], "*/")
+			if stop < 0 {
+				return nil, 0, false
+			}
+			i += stop + 4
+			continue
+		case c == '#' || strings.HasPrefix(query[i:], "-- "):
+			nl := strings.IndexByte(query[i:], '\n')
+			if nl < 0 {
+				i = len(query)
+			} else {
+				i += nl + 1
+			}
+			continue
+		}
+
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			stop := closingQuote(query, i)
+			if stop < 0 {
+				return nil, 0, false
+			}
+			i = stop + 1
+		case c == '(':
+			depth++
+			i++
+		case c == ')':
+			depth--
+			i++
+		case isWordByte(c):
+			start := i
+			for i < len(query) && isWordByte(query[i]) {
+				i++
+			}
+			if depth == 0 {
+				words = append(words, outerWord{strings.ToUpper(query[start:i]), start})
+			}
+		default:
+			i++
+		}
+		end = i
+	}
+	return words, end, true
+}
+
+// Report whether a SELECT already has its own LIMIT clause. A LIMIT in a
+// subquery, a string or a comment doesn't count.
+func hasLimitClause(query string) bool {
+	words, _, _ := outerWords(query)
+	for _, w := range words {
+		if w.word == "LIMIT" {
+			return true
+		}
+	}
+	return false
+}
+
+// Add LIMIT n to a SELECT without one. It goes before a locking clause
+// (FOR UPDATE, FOR SHARE, LOCK IN SHARE MODE), and a trailing comment or
+// semicolon is dropped so it can't swallow the LIMIT.
+func addLimitClause(query string, n int) string {
+	words, end, ok := outerWords(query)
+	if !ok {
+		return fmt.Sprintf("%s LIMIT %d", strings.TrimRight(strings.TrimSpace(query), ";"), n)
+	}
+	at := end
+	for i := 0; i+1 < len(words); i++ {
+		w, next := words[i].word, words[i+1].word
+		if w == "FOR" && (next == "UPDATE" || next == "SHARE") || w == "LOCK" && next == "IN" {
+			at = words[i].start
+			break
+		}
+	}
+	limited := fmt.Sprintf("%s LIMIT %d", strings.TrimRight(query[:at], " \t\r\n"), n)
+	if at < end {
+		limited += " " + query[at:end]
+	}
+	return limited
+}
+
+// Make result column labels unique. A JOIN can return several columns with
+// the same name (e.g. two "id"s); later ones get a _2, _3... suffix.
+func dedupeColumnNames(columns []string) []string {
+	seen := make(map[string]bool, len(columns))
+	for _, c := range columns {
+		seen[c] = true
+	}
+
+	out := make([]string, len(columns))
+	used := make(map[string]bool, len(columns))
+	for i, c := range columns {
+		label := c
+		// Don't steal the name of a real column further along either
+		for n := 2; used[label] || (label != c && seen[label]); n++ {
+			label = fmt.Sprintf("%s_%d", c, n)
+		}
+		used[label] = true
+		out[i] = label
+	}
+	return out
+}
+
+// Format a value as a SQL literal for statements sent to slaves
+func sqlLiteral(val interface{}) string {
+	if val == nil {
+		return "NULL"
+	}
+	switch v := val.(type) {
+	case []byte:
+		// Binary data as hex, so statements stay valid UTF-8 text (see
+		// utf8check.go)
+		if !utf8.Valid(v) {
+			return "X'" + hex.EncodeToString(v) + "'"
+		}
+		return quoteString(string(v))
+	case string:
+		return quoteString(v)
+	case decimalValue:
+		// Digits checked against decimalRe, exact without quotes
+		return string(v)
+	case bool:
+		// MySQL stores booleans as 1 and 0
+		if v {
+			return "1"
+		}
+		return "0"
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// Quote a string literal. Backslashes are escaped too so text such as JSON
+// reaches the other side unchanged.
+func quoteString(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\\\")
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// Primary key columns of a table in key order, none if it has no primary key
+func primaryKeyColumns(table string) ([]string, error) {
+	rows, err := db.Query(`SELECT k.COLUMN_NAME FROM information_schema.KEY_COLUMN_USAGE k
+		WHERE k.TABLE_SCHEMA = DATABASE() AND k.TABLE_NAME = ? AND k.CONSTRAINT_NAME = 'PRIMARY'
+		ORDER BY k.ORDINAL_POSITION`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var name string
+		if rows.Scan(&name) == nil {
+			keys = append(keys, name)
+		}
+	}
+	return keys, nil
+}
+
+// ORDER BY clause giving a table's rows the same order on the master and
+// the slaves: the primary key, or every column for a table without one.
+func displayOrder(table string) string {
+	keys, err := primaryKeyColumns(table)
+	if err != nil {
+		return ""
+	}
+	if len(keys) > 0 {
+		return " ORDER BY `" + strings.Join(keys, "`, `") + "`"
+	}
+
+	var count int
+	err = db.QueryRow(`SELECT COUNT(*) FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?`, table).Scan(&count)
+	if err != nil || count == 0 {
+		return ""
+	}
+	positions := make([]string, count)
+	for i := range positions {
+		positions[i] = fmt.Sprint(i + 1)
+	}
+	return " ORDER BY " + strings.Join(positions, ", ")
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+func TestHasLimitClause(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"SELECT * FROM t LIMIT 5", true},
+		{"select * from t limit 5, 10", true},
+		{"SELECT * FROM t WHERE id IN (SELECT id FROM u LIMIT 3)", false},
+		{"SELECT * FROM t WHERE name = 'no LIMIT 1 here'", false},
+		{"SELECT * FROM t /* LIMIT 10 */", false},
+		{"SELECT * FROM t -- LIMIT 10", false},
+		{"SELECT `limit` FROM t", false},
+		{"(SELECT a FROM t) UNION (SELECT a FROM u) LIMIT 2", true},
+	}
+	for _, tt := range tests {
+		if got := hasLimitClause(tt.query); got != tt.want {
+			t.Errorf("hasLimitClause(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestAddLimitClause(t *testing.T) {
+	tests := []struct{ query, want string }{
+		{"SELECT * FROM t", "SELECT * FROM t LIMIT 11"},
+		{"SELECT * FROM t;", "SELECT * FROM t LIMIT 11"},
+		{"SELECT * FROM t -- all of them", "SELECT * FROM t LIMIT 11"},
+		{"SELECT * FROM t /* all */ ;", "SELECT * FROM t LIMIT 11"},
+		{"SELECT * FROM t WHERE id = 1 FOR UPDATE", "SELECT * FROM t WHERE id = 1 LIMIT 11 FOR UPDATE"},
+		{"SELECT * FROM t FOR SHARE NOWAIT;", "SELECT * FROM t LIMIT 11 FOR SHARE NOWAIT"},
+		{"SELECT * FROM t LOCK IN SHARE MODE", "SELECT * FROM t LIMIT 11 LOCK IN SHARE MODE"},
+		{"SELECT * FROM t WHERE note = 'for update'", "SELECT * FROM t WHERE note = 'for update' LIMIT 11"},
+	}
+	for _, tt := range tests {
+		if got := addLimitClause(tt.query, 11); got != tt.want {
+			t.Errorf("addLimitClause(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestConfirmAcceptsPaddedAndUppercase(t *testing.T) {
+	feedInput(t, " y", "YES ", "\tY\t", "yEs", "n", " no", "", "yeah")
+	for i, want := range []bool{true, true, true, true, false, false, false, false} {
+		if got := confirm(""); got != want {
+			t.Fatalf("answer %d: got %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestReadChoiceAsksAgainOnNonNumbers(t *testing.T) {
+	feedInput(t, "two", "", " 3 ")
+	if got := readChoice(); got != 3 {
+		t.Fatalf("got %d, want 3", got)
+	}
+}
+
+// The rows of a record listing, below its header
+func listedRows(out string) string {
+	_, rows, _ := strings.Cut(out, "-----------------------------------------------------------\n")
+	return rows
+}
+
+func TestMasterAndSlaveListRowsInTheSameOrder(t *testing.T) {
+	cases := []struct {
+		name    string
+		keys    []driver.Value
+		columns int64
+		order   string
+	}{
+		{"composite key", []driver.Value{"region", "id"}, 3, " ORDER BY `region`, `id`"},
+		{"no key", nil, 3, " ORDER BY 1, 2, 3"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var selects []string
+			var listings []string
+			for _, side := range []func(){
+				DisplayRecords,
+				func() {
+					feedInput(t, "1")
+					viewLocalDatabase()
+				},
+			} {
+				f := useFakeDB(t)
+				var keyRows [][]driver.Value
+				for _, k := range c.keys {
+					keyRows = append(keyRows, []driver.Value{k})
+				}
+				f.rows(`KEY_COLUMN_USAGE`, []string{"COLUMN_NAME"}, keyRows...)
+				f.rows(`^SELECT COUNT\(\*\) FROM information_schema\.COLUMNS`, []string{"COUNT(*)"}, []driver.Value{c.columns})
+				f.rows(`^SHOW TABLES$`, []string{"Tables"}, []driver.Value{"sales"})
+				f.rows(`^SELECT \* FROM sales`, []string{"region", "id", "amount"},
+					[]driver.Value{"eu", int64(1), int64(10)}, []driver.Value{"us", int64(1), nil})
+				old := currentTable
+				currentTable = "sales"
+				listings = append(listings, listedRows(captureOutput(t, side)))
+				currentTable = old
+				selects = append(selects, f.matching(`^SELECT \* FROM sales`)...)
+			}
+			if len(selects) != 2 || selects[0] != "SELECT * FROM sales"+c.order || selects[1] != selects[0] {
+				t.Fatalf("master and slave ran %q, want both ordered%s", selects, c.order)
+			}
+			if listings[0] == "" || listings[0] != listings[1] {
+				t.Fatalf("master listed:\n%s\nslave listed:\n%s", listings[0], listings[1])
+			}
+		})
+	}
+}
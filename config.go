@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// The configuration in effect: every flag of the subcommand with its value,
+// and whether it was given, came from the config file or is the default, plus the MySQL connection
+// settings resolved the way mysqlenv.go does. -print-config prints it and
+// exits, "Show Effective Configuration" shows it at runtime, with the
+// answers given at the prompts and the database switched to from the menu.
+// The password is never shown.
+
+// Flags of the running subcommand, set by masterMain and slaveMain
+var configFlags *flag.FlagSet
+
+type configSetting struct {
+	name, value, source string
+}
+
+// Environment variables behind the MySQL flags, and the default used when
+// neither is set ("" if it is asked for)
+var mysqlFlagEnv = map[string]struct{ env, fallback string }{
+	"mysql-host":     {"MYSQL_HOST", "127.0.0.1"},
+	"mysql-port":     {"MYSQL_PORT", "3306"},
+	"mysql-user":     {"MYSQL_USER", ""},
+	"mysql-database": {"MYSQL_DATABASE", ""},
+}
+
+const askedSetting = "asked at startup"
+
+func effectiveConfig() []configSetting {
+	given := make(map[string]bool)
+	configFlags.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	var settings []configSetting
+	configFlags.VisitAll(func(f *flag.Flag) {
+		if f.Name == "version" || f.Name == "print-config" {
+			return
+		}
+		s := configSetting{f.Name, f.Value.String(), "default"}
+		switch m, isMySQL := mysqlFlagEnv[f.Name]; {
+		case fromConfigFile[f.Name]:
+			s.source = "config file"
+		case given[f.Name]:
+			s.source = "flag"
+		case isMySQL:
+			if v, ok := os.LookupEnv(m.env); ok && v != "" {
+				s.value, s.source = v, "env "+m.env
+			} else if m.fallback == "" {
+				s.value, s.source = askedSettings[f.Name], askedSetting
+			} else {
+				s.value = m.fallback
+			}
+		}
+		// Only the master has -mysql-database, a slave's dbName is set
+		// by its listener
+		if f.Name == "mysql-database" && dbName != "" && dbName != s.value {
+			s.value, s.source = dbName, "switched from the menu"
+		}
+		settings = append(settings, s)
+	})
+
+	password := configSetting{"mysql-password", askedSettings["mysql-password"], askedSetting}
+	if configPassword != nil {
+		password.value, password.source = redactedText, "config file"
+	} else if _, ok := os.LookupEnv("MYSQL_PWD"); ok {
+		password.value, password.source = redactedText, "env MYSQL_PWD"
+	}
+	settings = append(settings, password)
+	slices.SortFunc(settings, func(a, b configSetting) int {
+		return strings.Compare(a.name, b.name)
+	})
+	return settings
+}
+
+func printConfig(out io.Writer) {
+	settings := effectiveConfig()
+	width := 0
+	for _, s := range settings {
+		width = max(width, len(s.name))
+	}
+	fmt.Fprintln(out, "\n===== EFFECTIVE CONFIGURATION =====")
+	for _, s := range settings {
+		value := s.value
+		if value == "" {
+			value = `""`
+		}
+		fmt.Fprintf(out, "%-*s  %s (%s)\n", width, s.name, redact(value), s.source)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Parse args into a master-like FlagSet as the one in effect
+func useConfigFlags(t *testing.T, args ...string) {
+	t.Helper()
+	oldFlags, oldConn, oldAsked, oldDB := configFlags, connFlags, askedSettings, dbName
+	t.Cleanup(func() {
+		configFlags, connFlags, askedSettings, dbName = oldFlags, oldConn, oldAsked, oldDB
+	})
+	askedSettings = make(map[string]string)
+	fs := flag.NewFlagSet("master", flag.ContinueOnError)
+	fs.Bool("compress", false, "")
+	addMySQLFlags(fs, true)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	configFlags = fs
+	for _, env := range []string{"MYSQL_HOST", "MYSQL_USER", "MYSQL_DATABASE", "MYSQL_PWD"} {
+		t.Setenv(env, "")
+		os.Unsetenv(env)
+	}
+}
+
+func configLine(t *testing.T, out, name string) string {
+	t.Helper()
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, name+" ") {
+			return strings.Join(strings.Fields(line), " ")
+		}
+	}
+	t.Fatalf("no %s in\n%s", name, out)
+	return ""
+}
+
+func TestConfigShowsOverriddenValuesAndHidesThePassword(t *testing.T) {
+	useConfigFlags(t, "-compress", "-mysql-host", "db.internal")
+	t.Setenv("MYSQL_PWD", "hunter22")
+
+	var out strings.Builder
+	printConfig(&out)
+
+	if got := configLine(t, out.String(), "compress"); got != "compress true (flag)" {
+		t.Errorf("got %q", got)
+	}
+	if got := configLine(t, out.String(), "mysql-host"); got != "mysql-host db.internal (flag)" {
+		t.Errorf("got %q", got)
+	}
+	if got := configLine(t, out.String(), "mysql-password"); got != "mysql-password "+redactedText+" (env MYSQL_PWD)" {
+		t.Errorf("got %q", got)
+	}
+	if strings.Contains(out.String(), "hunter22") {
+		t.Errorf("the password is shown:\n%s", out.String())
+	}
+}
+
+func TestConfigShowsTheAnswersGivenAtThePrompts(t *testing.T) {
+	useConfigFlags(t)
+	feedInput(t, "alice", "s3cretpw", "shop")
+	mysqlUser("user: ")
+	mysqlPassword()
+	dbName = mysqlDatabase()
+
+	var out strings.Builder
+	printConfig(&out)
+
+	if got := configLine(t, out.String(), "mysql-user"); got != "mysql-user alice ("+askedSetting+")" {
+		t.Errorf("got %q", got)
+	}
+	if got := configLine(t, out.String(), "mysql-database"); got != "mysql-database shop ("+askedSetting+")" {
+		t.Errorf("got %q", got)
+	}
+	if got := configLine(t, out.String(), "mysql-password"); got != "mysql-password "+redactedText+" ("+askedSetting+")" {
+		t.Errorf("got %q", got)
+	}
+	if strings.Contains(out.String(), "s3cretpw") {
+		t.Errorf("the password is shown:\n%s", out.String())
+	}
+}
+
+func TestConfigShowsTheDatabaseSwitchedTo(t *testing.T) {
+	useConfigFlags(t, "-mysql-database", "shop")
+	dbName = "archive"
+
+	var out strings.Builder
+	printConfig(&out)
+
+	if got := configLine(t, out.String(), "mysql-database"); got != "mysql-database archive (switched from the menu)" {
+		t.Errorf("got %q", got)
+	}
+}
+
+// Settings for a master that needs nobody at the terminal
+type masterSettings struct {
+	User       string `json:"mysql-user"`
+	Password   string `json:"mysql-password"`
+	Database   string `json:"mysql-database"`
+	Host       string `json:"mysql-host"`
+	Port       int    `json:"mysql-port"`
+	ListenPort int    `json:"listen-port"`
+	DataDir    string `json:"data-dir"`
+}
+
+func TestMasterStartsFromAConfigFileAlone(t *testing.T) {
+	// A port nothing listens on, so the start ends at the connection
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mysqlPort := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	dir := t.TempDir()
+	settings := masterSettings{
+		User: "repl", Password: "s3cretpw", Database: "shop",
+		Host: "127.0.0.1", Port: mysqlPort, ListenPort: 19999,
+		DataDir: filepath.Join(dir, "state"),
+	}
+	data, _ := json.Marshal(settings)
+	path := filepath.Join(dir, "master.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	out, _ := runDDB(t, "master -config "+path+" -print-config")
+	for _, want := range []string{"mysql-user repl (config file)", "mysql-database shop (config file)",
+		"listen-port 19999 (config file)", "mysql-password " + redactedText + " (config file)"} {
+		if !strings.Contains(strings.Join(strings.Fields(out), " "), want) {
+			t.Errorf("-print-config is missing %q:\n%s", want, out)
+		}
+	}
+
+	out, code := runDDB(t, "master -config "+path)
+	if strings.Contains(out, "Enter ") {
+		t.Errorf("asked for a setting the config file has:\n%s", out)
+	}
+	if code == 0 || !strings.Contains(out, fmt.Sprintf("127.0.0.1:%d", mysqlPort)) {
+		t.Errorf("exit %d, want the connection to the configured server to fail:\n%s", code, out)
+	}
+	if strings.Contains(out, "s3cretpw") {
+		t.Errorf("the password is printed:\n%s", out)
+	}
+	if _, err := os.Stat(settings.DataDir); err != nil {
+		t.Errorf("data dir from the config file: %v", err)
+	}
+}
+
+func TestMySQLSettingsTakeFlagThenEnvThenPrompt(t *testing.T) {
+	cases := []struct {
+		name   string
+		args   []string
+		env    string
+		want   string
+		source string
+	}{
+		{"flag over env", []string{"-mysql-user", "from-flag"}, "from-env", "from-flag", "flag"},
+		{"env over prompt", nil, "from-env", "from-env", "env MYSQL_USER"},
+		{"prompt last", nil, "", "from-prompt", askedSetting},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			useConfigFlags(t, c.args...)
+			if c.env != "" {
+				t.Setenv("MYSQL_USER", c.env)
+			}
+			feedInput(t, "from-prompt")
+			if got := mysqlUser("user: "); got != c.want {
+				t.Fatalf("user %q, want %q", got, c.want)
+			}
+
+			var out strings.Builder
+			printConfig(&out)
+			if got := configLine(t, out.String(), "mysql-user"); got != "mysql-user "+c.want+" ("+c.source+")" {
+				t.Errorf("got %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Settings from a JSON file (-config), so the master and slave can start
+// without anyone at the terminal, e.g. under systemd or in a container.
+// The keys are the flag names, plus mysql-password, which has no flag:
+//
+//	{"mysql-user": "repl", "mysql-password": "secret", "mysql-database": "shop", "listen-port": 9999}
+//
+// A flag given on the command line wins over the file, and the file over
+// the environment. Whatever is still missing is asked for as before.
+
+// Keys taken from the config file, for the effective configuration
+var fromConfigFile = make(map[string]bool)
+
+// Password from the config file, nil if it doesn't have one
+var configPassword *string
+
+func addConfigFlag(fs *flag.FlagSet) *string {
+	return fs.String("config", "", "read settings from this JSON file, keyed by flag name (plus mysql-password)")
+}
+
+// Apply a config file to the flags not given on the command line
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var settings map[string]json.RawMessage
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value, err := configValue(settings[name])
+		if err != nil {
+			return fmt.Errorf("%s: %s: %v", path, name, err)
+		}
+		if name == "mysql-password" {
+			registerSecret(value)
+			configPassword = &value
+			fromConfigFile[name] = true
+			continue
+		}
+		if fs.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+		if given[name] {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("%s: %s: %v", path, name, err)
+		}
+		fromConfigFile[name] = true
+	}
+	return nil
+}
+
+// A setting as flag text: strings as they are, numbers and booleans as
+// written in the file
+func configValue(raw json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	raw = bytes.TrimSpace(raw)
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return "", err
+	}
+	switch v.(type) {
+	case float64, bool:
+		return string(raw), nil
+	}
+	return "", fmt.Errorf("must be a string, number or boolean")
+}
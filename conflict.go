@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Per-table policy for replicated INSERTs that hit a duplicate key on a
+// slave. The master only uses it when rendering the statement it sends to
+// slaves; its own INSERT still fails on a real conflict.
+//
+// A policy other than error is kept in the table's comment, as
+// "ddb:conflict=<policy>" after whatever the comment says otherwise, so it
+// survives restarts and stays with the table. The comment change is
+// replicated like any ALTER.
+
+type conflictPolicy string
+
+const (
+	conflictError   conflictPolicy = "error"   // plain INSERT, the slave reports the error
+	conflictIgnore  conflictPolicy = "ignore"  // keep the slave's existing row
+	conflictReplace conflictPolicy = "replace" // overwrite it with the master's row
+)
+
+var (
+	conflictMu       sync.Mutex
+	conflictPolicies = make(map[string]conflictPolicy)
+)
+
+var conflictCommentRe = regexp.MustCompile(`\s*\bddb:conflict=(\w+)`)
+
+var insertStmtRe = regexp.MustCompile("(?is)^\\s*INSERT\\s+INTO\\s+([`\"]?(\\w+)[`\"]?.*)$")
+
+// Policy for a table, conflictError unless one was set
+func tableConflictPolicy(table string) conflictPolicy {
+	conflictMu.Lock()
+	defer conflictMu.Unlock()
+	if p, ok := conflictPolicies[table]; ok {
+		return p
+	}
+	return conflictError
+}
+
+func setConflictPolicy(table string, p conflictPolicy) {
+	conflictMu.Lock()
+	defer conflictMu.Unlock()
+	if p == conflictError {
+		delete(conflictPolicies, table)
+	} else {
+		conflictPolicies[table] = p
+	}
+}
+
+// Read the policies of the active database's tables from their comments
+func loadConflictPolicies() {
+	policies := make(map[string]conflictPolicy)
+	rows, err := db.Query(`SELECT TABLE_NAME, TABLE_COMMENT FROM information_schema.TABLES
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_COMMENT LIKE '%ddb:conflict=%'`)
+	if err != nil {
+		fmt.Printf("Could not read the conflict policies: %v\n", err)
+	} else {
+		defer rows.Close()
+		for rows.Next() {
+			var table, comment string
+			if rows.Scan(&table, &comment) != nil {
+				continue
+			}
+			m := conflictCommentRe.FindStringSubmatch(comment)
+			switch p := conflictPolicy(m[1]); p {
+			case conflictIgnore, conflictReplace:
+				policies[table] = p
+			}
+		}
+	}
+	conflictMu.Lock()
+	conflictPolicies = policies
+	conflictMu.Unlock()
+}
+
+// Set a table's policy and store it in the table's comment
+func saveConflictPolicy(table string, p conflictPolicy) error {
+	var comment string
+	err := db.QueryRow(`SELECT TABLE_COMMENT FROM information_schema.TABLES
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?`, table).Scan(&comment)
+	if err != nil {
+		return err
+	}
+	comment = conflictCommentRe.ReplaceAllString(comment, "")
+	if p != conflictError {
+		comment = strings.TrimSpace(comment + " ddb:conflict=" + string(p))
+	}
+	query := fmt.Sprintf("ALTER TABLE %s COMMENT = %s", quoteIdent(dialectMySQL, table), quoteString(comment))
+
+	defer beginWrite()()
+	if _, err := db.Exec(query); err != nil {
+		return err
+	}
+	setConflictPolicy(table, p)
+	replicate(nil, query)
+	return nil
+}
+
+// Rewrite an INSERT for replication according to its table's policy.
+// Anything else is returned unchanged.
+func conflictQuery(query string) string {
+	m := insertStmtRe.FindStringSubmatch(query)
+	if m == nil {
+		return query
+	}
+	return insertKeyword(replicaDialect, tableConflictPolicy(m[2])) + " " + m[1]
+}
+
+// Statement keyword implementing a policy on the given backend
+func insertKeyword(d sqlDialect, p conflictPolicy) string {
+	switch {
+	case p == conflictIgnore && d == dialectANSI:
+		return "INSERT OR IGNORE INTO"
+	case p == conflictIgnore:
+		return "INSERT IGNORE INTO"
+	case p == conflictReplace && d == dialectANSI:
+		return "INSERT OR REPLACE INTO"
+	case p == conflictReplace:
+		return "REPLACE INTO"
+	}
+	return "INSERT INTO"
+}
+
+// Menu action: choose the conflict policy for the current table
+func SetConflictPolicy() {
+	fmt.Printf("Current INSERT conflict policy for '%s': %s\n", currentTable, tableConflictPolicy(currentTable))
+	fmt.Println("1. error   - the slave reports the duplicate key")
+	fmt.Println("2. ignore  - the slave keeps its existing row (INSERT IGNORE)")
+	fmt.Println("3. replace - the slave's row is overwritten (REPLACE)")
+	fmt.Print("Select policy (number): ")
+
+	var p conflictPolicy
+	switch readChoice() {
+	case 1:
+		p = conflictError
+	case 2:
+		p = conflictIgnore
+	case 3:
+		p = conflictReplace
+	default:
+		fmt.Println("Invalid policy selection")
+		return
+	}
+	if err := saveConflictPolicy(currentTable, p); err != nil {
+		fmt.Printf("Error saving the conflict policy: %v\n", err)
+		return
+	}
+	fmt.Printf("Replicated INSERTs into '%s' now use the %s policy.\n", currentTable, p)
+}
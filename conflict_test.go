@@ -0,0 +1,45 @@
+package main
+
+import (
+	"database/sql/driver"
+	"testing"
+)
+
+func TestConflictPolicyIsKeptInTheTableComment(t *testing.T) {
+	f := useFakeDB(t)
+	t.Cleanup(func() { setConflictPolicy("orders", conflictError) })
+	comment := "Customer orders"
+	f.on(`^SELECT TABLE_COMMENT FROM information_schema.TABLES`, func([]driver.Value) fakeResult {
+		return fakeResult{cols: []string{"TABLE_COMMENT"}, rows: [][]driver.Value{{comment}}}
+	})
+
+	if err := saveConflictPolicy("orders", conflictIgnore); err != nil {
+		t.Fatal(err)
+	}
+	got := f.matching(`^ALTER TABLE`)
+	if len(got) != 1 || got[0] != "ALTER TABLE `orders` COMMENT = 'Customer orders ddb:conflict=ignore'" {
+		t.Fatalf("ran %q", got)
+	}
+
+	// Read back at the next start
+	setConflictPolicy("orders", conflictError)
+	comment = "Customer orders ddb:conflict=ignore"
+	f.rows(`^SELECT TABLE_NAME, TABLE_COMMENT FROM information_schema.TABLES`,
+		[]string{"TABLE_NAME", "TABLE_COMMENT"}, []driver.Value{"orders", comment})
+	loadConflictPolicies()
+	if p := tableConflictPolicy("orders"); p != conflictIgnore {
+		t.Fatalf("loaded %s, want ignore", p)
+	}
+
+	// Back to error leaves the comment as it was before
+	if err := saveConflictPolicy("orders", conflictError); err != nil {
+		t.Fatal(err)
+	}
+	got = f.matching(`^ALTER TABLE`)
+	if len(got) != 2 || got[1] != "ALTER TABLE `orders` COMMENT = 'Customer orders'" {
+		t.Fatalf("ran %q", got)
+	}
+	if p := tableConflictPolicy("orders"); p != conflictError {
+		t.Fatalf("policy %s, want error", p)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Directory for everything ddb keeps on disk between runs (-data-dir), so
+// an instance can be deployed and backed up as a unit: the audit log
+// (audit.go) and a slave's dead-lettered statements (retry.go). Created
+// when missing. Unless -data-dir is given, each instance gets its own
+// directory under the per user state directory, named after what tells
+// instances on one host apart: a master's listen port or socket, a slave's
+// local MySQL server.
+
+var dataDir string
+
+// The per user state directory of the OS, or ddb-data if there is none
+func defaultDataDir() string {
+	switch runtime.GOOS {
+	case "windows":
+		if dir := os.Getenv("LOCALAPPDATA"); dir != "" {
+			return filepath.Join(dir, "ddb")
+		}
+	case "darwin":
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, "Library", "Application Support", "ddb")
+		}
+	default:
+		if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+			return filepath.Join(dir, "ddb")
+		}
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, ".local", "state", "ddb")
+		}
+	}
+	return "ddb-data"
+}
+
+// Use the default directory of instance unless -data-dir was given
+func useInstanceDataDir(instance string) {
+	if dataDir != "" {
+		return
+	}
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:[]`, r) {
+			return '-'
+		}
+		return r
+	}, instance)
+	dataDir = filepath.Join(defaultDataDir(), strings.Trim(name, "-"))
+}
+
+// Create the data directory if it doesn't exist yet
+func openDataDir() error {
+	if dataDir == "" {
+		return fmt.Errorf("the data directory can't be empty")
+	}
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return fmt.Errorf("can't create data directory: %v", err)
+	}
+	return nil
+}
+
+// Path of a state file in the data directory
+func statePath(name string) string {
+	return filepath.Join(dataDir, name)
+}
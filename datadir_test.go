@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func withDataDir(t *testing.T, dir string) {
+	old := dataDir
+	dataDir = dir
+	t.Cleanup(func() { dataDir = old })
+}
+
+func TestInstancesGetTheirOwnDataDir(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	base := defaultDataDir()
+
+	withDataDir(t, "")
+	useInstanceDataDir("master-9999")
+	master := dataDir
+	dataDir = ""
+	useInstanceDataDir("slave-127.0.0.1:3307")
+	slave := dataDir
+
+	if master != filepath.Join(base, "master-9999") {
+		t.Errorf("master data dir = %q", master)
+	}
+	if slave != filepath.Join(base, "slave-127.0.0.1-3307") {
+		t.Errorf("slave data dir = %q", slave)
+	}
+}
+
+func TestDataDirFlagWinsOverTheInstanceDefault(t *testing.T) {
+	withDataDir(t, "/srv/ddb")
+	useInstanceDataDir("master-9999")
+	if dataDir != "/srv/ddb" {
+		t.Errorf("data dir = %q, want the one given", dataDir)
+	}
+}
+
+func TestLegacyAuditLogIsMovedIntoTheDataDir(t *testing.T) {
+	t.Chdir(t.TempDir())
+	withDataDir(t, t.TempDir())
+	if err := os.WriteFile(legacyAuditLog, []byte("old entry\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	migrateLegacyAuditLog()
+
+	data, err := os.ReadFile(statePath(auditLogFile))
+	if err != nil || string(data) != "old entry\n" {
+		t.Errorf("audit log = %q, %v; want the old entries", data, err)
+	}
+	if _, err := os.Stat(legacyAuditLog); !os.IsNotExist(err) {
+		t.Errorf("%s is still there", legacyAuditLog)
+	}
+}
+
+func TestLegacyAuditLogIsLeftWhenTheDataDirHasOne(t *testing.T) {
+	t.Chdir(t.TempDir())
+	withDataDir(t, t.TempDir())
+	os.WriteFile(legacyAuditLog, []byte("old entry\n"), 0600)
+	os.WriteFile(statePath(auditLogFile), []byte("new entry\n"), 0600)
+
+	migrateLegacyAuditLog()
+
+	if data, _ := os.ReadFile(statePath(auditLogFile)); string(data) != "new entry\n" {
+		t.Errorf("audit log = %q, want it untouched", data)
+	}
+	if data, _ := os.ReadFile(legacyAuditLog); string(data) != "old entry\n" {
+		t.Errorf("%s = %q, want it left in place", legacyAuditLog, data)
+	}
+}
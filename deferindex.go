@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// With -defer-indexes the initial sync sends each table without its
+// secondary indexes, then the rows, then one create_index:<table>:<ALTER>
+// per index so the slave loads the rows into a bare table and builds each
+// index in one pass at the end.
+//
+// The indexes are sent even when some rows couldn't be sent, so the table
+// always ends up with the master's indexes. A slave that already has an
+// index (e.g. from a sync that was cut off and is now repeated) keeps it.
+
+var deferIndexes bool
+
+// Split the secondary index definitions out of a SHOW CREATE TABLE
+// statement. The primary key stays in the CREATE TABLE. So do all indexes
+// of a table with foreign keys, since MySQL would otherwise create its own
+// index for each foreign key and the deferred ADD would then clash with it.
+func splitSecondaryIndexes(def string) (string, []string) {
+	lines := strings.Split(def, "\n")
+	if len(lines) < 3 || strings.Contains(strings.ToUpper(def), "FOREIGN KEY") {
+		return def, nil
+	}
+
+	var kept, indexes []string
+	for i, line := range lines {
+		item := strings.TrimSpace(line)
+		if i > 0 && i < len(lines)-1 && isSecondaryIndex(item) {
+			indexes = append(indexes, strings.TrimSuffix(item, ","))
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if len(indexes) == 0 {
+		return def, nil
+	}
+
+	// The last definition before the closing line must not end in a comma
+	last := len(kept) - 2
+	kept[last] = strings.TrimSuffix(kept[last], ",")
+	return strings.Join(kept, "\n"), indexes
+}
+
+func isSecondaryIndex(item string) bool {
+	upper := strings.ToUpper(item)
+	for _, prefix := range []string{"KEY ", "INDEX ", "UNIQUE KEY ", "UNIQUE INDEX ", "FULLTEXT KEY ", "SPATIAL KEY "} {
+		if strings.HasPrefix(upper, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Statement adding a deferred index back to its table
+func addIndexStatement(table, index string) string {
+	return fmt.Sprintf("ALTER TABLE `%s` ADD %s", table, index)
+}
+
+// Send a table's CREATE TABLE and rows to a slave, deferring the secondary
+// indexes until after the rows if -defer-indexes is set. Returns what went
+// wrong with the rows, like sendTableData.
+func sendTableContents(ctx context.Context, q queryer, tableName, tableDefinition string, s *slaveConn) []string {
+	indexes := beginTableCopy(tableName, tableDefinition, s)
+	problems := sendTableData(ctx, q, tableName, s)
+	endTableCopy(tableName, s, indexes)
+	return problems
+}
+
+// Send the CREATE TABLE a copy of a table starts with. Returns the indexes
+// deferred to endTableCopy.
+func beginTableCopy(tableName, tableDefinition string, s *slaveConn) []string {
+	def, indexes := tableDefinition, []string(nil)
+	if deferIndexes && !s.schemaOnly {
+		def, indexes = splitSecondaryIndexes(tableDefinition)
+	}
+
+	// Send the CREATE TABLE statement to the slave
+	// Make sure to encode any newlines or special characters
+	encodedDef := strings.ReplaceAll(def, "\n", " ")
+	s.sendBulk("create_table:%s\n", s.tailorDDL(encodedDef))
+	return indexes
+}
+
+// Send the deferred indexes once a table's rows are out
+func endTableCopy(tableName string, s *slaveConn, indexes []string) {
+	if len(indexes) > 0 {
+		fmt.Printf("Sending %d deferred index(es) for table %s\n", len(indexes), tableName)
+	}
+	for _, index := range indexes {
+		s.sendBulk("create_index:%s:%s\n", tableName, addIndexStatement(tableName, index))
+	}
+}
+
+// Slave side of create_index. An index the table already has is fine.
+func applyDeferredIndex(tableName, statement string) {
+	err := executeLocalQuery(statement)
+	if isDuplicateIndex(err) {
+		fmt.Printf("Table '%s' already has this index, skipping\n", tableName)
+		return
+	}
+	if err != nil {
+		fmt.Printf("WARNING: failed to build deferred index on table '%s': %v\n", tableName, err)
+		fmt.Printf("The table has its rows but not this index. Statement was: %s\n", statement)
+		return
+	}
+	fmt.Printf("Built deferred index on table '%s'\n", tableName)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeferredIndexesAreBuiltAfterTheRows(t *testing.T) {
+	f := useFakeDB(t)
+	old := deferIndexes
+	deferIndexes = true
+	t.Cleanup(func() { deferIndexes = old })
+	s, _ := throughSlave(t, f, func(*slaveConn) {})
+
+	f.rows(`^SELECT COUNT\(\*\) FROM t$`, []string{"COUNT(*)"}, []driver.Value{int64(2)})
+	f.rows(`^SELECT \* FROM t LIMIT`, []string{"id", "name"},
+		[]driver.Value{int64(1), "a"}, []driver.Value{int64(2), "b"})
+	def := "CREATE TABLE `t` (\n" +
+		"  `id` int NOT NULL,\n" +
+		"  `name` varchar(20) DEFAULT NULL,\n" +
+		"  PRIMARY KEY (`id`),\n" +
+		"  UNIQUE KEY `uq_name` (`name`),\n" +
+		"  KEY `idx_name` (`name`)\n" +
+		") ENGINE=InnoDB"
+	sendTableContents(context.Background(), db, "t", def, s)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(f.matching(`^ALTER TABLE`)) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("slave ran %q", f.statements())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The rows go into the table with only its primary key
+	var order []string
+	for _, stmt := range f.statements() {
+		switch {
+		case strings.HasPrefix(stmt, "CREATE TABLE"):
+			if strings.Contains(stmt, "KEY `") {
+				t.Fatalf("table created with its secondary indexes: %s", stmt)
+			}
+			order = append(order, "create")
+		case strings.HasPrefix(stmt, "INSERT"):
+			order = append(order, "insert")
+		case strings.HasPrefix(stmt, "ALTER TABLE"):
+			order = append(order, stmt)
+		}
+	}
+	want := []string{"create", "insert",
+		"ALTER TABLE `t` ADD UNIQUE KEY `uq_name` (`name`)",
+		"ALTER TABLE `t` ADD KEY `idx_name` (`name`)"}
+	if strings.Join(order, "|") != strings.Join(want, "|") {
+		t.Fatalf("slave ran %q, want %q", order, want)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+)
+
+// Disk space check before an initial sync. The slave reports the free
+// space on its MySQL data directory in the handshake
+// (slave_info:disk_free:<bytes>), the master estimates the size of its
+// database from information_schema.TABLES and tells the slave
+// (sync_estimate:<bytes>). A sync that wouldn't fit is refused up front
+// rather than failing after most of the data has been sent.
+//
+// The estimate includes index space and InnoDB's free pages, so it is
+// usually on the high side. -ignore-slave-space only warns instead.
+
+var ignoreSlaveSpace bool
+
+// Warn when the sync would leave the slave less than this fraction free
+const spaceHeadroom = 0.1
+
+// Master side: expected on-disk size of the database
+func syncSizeEstimate() (int64, error) {
+	var size sql.NullInt64
+	err := db.QueryRow(`SELECT SUM(DATA_LENGTH + INDEX_LENGTH) FROM information_schema.TABLES
+		WHERE TABLE_SCHEMA = DATABASE()`).Scan(&size)
+	return size.Int64, err
+}
+
+// Master side: tell the slave how much data is coming and refuse the sync
+// if its disk can't hold it. Returns false if the sync mustn't start.
+func checkSlaveSpace(s *slaveConn) bool {
+	if s.schemaOnly {
+		return true
+	}
+	defer useDB()()
+	need, err := syncSizeEstimate()
+	if err != nil {
+		fmt.Printf("Could not estimate the database size for slave %s: %v\n", s.addr, err)
+		return true
+	}
+	s.reply("sync_estimate:%d\n", need)
+
+	s.smu.Lock()
+	free := s.diskFree
+	s.smu.Unlock()
+	if free < 0 {
+		fmt.Printf("Slave %s didn't report its free disk space, syncing about %s without checking\n",
+			s.addr, formatBytes(need))
+		return true
+	}
+
+	switch {
+	case need > free && !ignoreSlaveSpace:
+		fmt.Printf("Refusing to sync slave %s: the database needs about %s, the slave has %s free\n",
+			s.addr, formatBytes(need), formatBytes(free))
+		s.reply("error:not enough disk space for the initial sync: it needs about %s and only %s is free; free up space or start the master with -ignore-slave-space\n",
+			formatBytes(need), formatBytes(free))
+		return false
+	case need > free:
+		fmt.Printf("WARNING: slave %s has %s free but the database needs about %s, syncing anyway (-ignore-slave-space)\n",
+			s.addr, formatBytes(free), formatBytes(need))
+	case float64(free-need) < spaceHeadroom*float64(free):
+		fmt.Printf("WARNING: the sync will leave slave %s with little disk space (%s free, about %s needed)\n",
+			s.addr, formatBytes(free), formatBytes(need))
+	}
+	return true
+}
+
+// Slave side: free space where the local MySQL server keeps its data.
+// Only known if the server runs on this host.
+func localFreeSpace() (int64, error) {
+	host, _, err := net.SplitHostPort(mysqlAddr())
+	if err != nil {
+		return 0, err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return 0, fmt.Errorf("MySQL server %s isn't on this host", host)
+	}
+
+	conn := db
+	if conn == nil {
+		conn, err = openMySQL(newMySQLConfig(dbUser, dbPassword))
+		if err != nil {
+			return 0, err
+		}
+		defer conn.Close()
+	}
+	var dataDir string
+	if err := conn.QueryRow("SELECT @@datadir").Scan(&dataDir); err != nil {
+		return 0, err
+	}
+	return freeSpace(dataDir)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+func freeSpace(path string) (int64, error) {
+	return 0, errors.New("free disk space can't be read on this platform")
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+func TestSlaveWithoutRoomForTheSyncIsRefused(t *testing.T) {
+	f := useFakeDB(t)
+	f.rows(`^SELECT SUM\(DATA_LENGTH \+ INDEX_LENGTH\) FROM information_schema\.TABLES`,
+		[]string{"SUM"}, []driver.Value{int64(4096)})
+	old := ignoreSlaveSpace
+	t.Cleanup(func() { ignoreSlaveSpace = old })
+
+	for _, ignore := range []bool{false, true} {
+		ignoreSlaveSpace = ignore
+		s, sc := pipeSlave(t)
+		s.recordInfo("disk_free:1024")
+		result := make(chan bool)
+		go func() { result <- checkSlaveSpace(s) }()
+
+		if got := nextFrame(t, sc); got != "sync_estimate:4096" {
+			t.Fatalf("ignore=%v: got %q, want the estimate", ignore, got)
+		}
+		if !ignore {
+			if got := nextFrame(t, sc); !strings.HasPrefix(got, "error:not enough disk space for the initial sync: it needs about 4.0 KiB and only 1.0 KiB is free") {
+				t.Fatalf("got %q, want the refusal", got)
+			}
+		}
+		if ok := <-result; ok != ignore {
+			t.Fatalf("ignore=%v: sync allowed = %v", ignore, ok)
+		}
+	}
+}
+
+func TestSlaveWithRoomIsSynced(t *testing.T) {
+	f := useFakeDB(t)
+	f.rows(`^SELECT SUM\(DATA_LENGTH \+ INDEX_LENGTH\) FROM information_schema\.TABLES`,
+		[]string{"SUM"}, []driver.Value{int64(4096)})
+	s, sc := pipeSlave(t)
+	s.recordInfo("disk_free:1048576")
+	result := make(chan bool)
+	go func() { result <- checkSlaveSpace(s) }()
+	nextFrame(t, sc)
+	if !<-result {
+		t.Fatal("refused a slave with room to spare")
+	}
+}
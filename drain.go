@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Graceful slave shutdown. Before exiting, the slave keeps reading from the
+// master for up to -drain-timeout so that whatever the master already sent
+// is applied: messages still in the socket, a transaction group whose
+// commit_tx hasn't arrived yet, and operations waiting for a table schema.
+// It is drained once the listener has been idle for drainQuiet with none of
+// these outstanding. What is left at the timeout is reported; a partly
+// received transaction is rolled back when the connection closes.
+
+var drainTimeout = 5 * time.Second
+
+const drainQuiet = 200 * time.Millisecond
+
+// Listener state for the drain, guarded by drainMu
+var (
+	drainMu      sync.Mutex
+	applying     bool      // handling a message from the master
+	idleSince    time.Time // when it last finished one
+	txIncomplete bool      // a master transaction is partly applied
+)
+
+// Running listenToMaster goroutines, so shutdown can wait for them
+var listeners sync.WaitGroup
+
+// Read the next message from the master, keeping the drain state up to date
+func nextMessage(scanner *messageScanner) bool {
+	drainMu.Lock()
+	applying, idleSince, txIncomplete = false, time.Now(), inSlaveTx()
+	drainMu.Unlock()
+
+	ok := scanner.Scan()
+
+	drainMu.Lock()
+	applying = ok
+	drainMu.Unlock()
+	return ok
+}
+
+// Wait, up to drainTimeout, until everything received has been applied.
+// Returns what is still outstanding, empty if nothing.
+func drainReceived() []string {
+	deadline := time.Now().Add(drainTimeout)
+	for {
+		var left []string
+		drainMu.Lock()
+		quiet := !applying && time.Since(idleSince) >= drainQuiet
+		if applying {
+			left = append(left, "a message from the master is still being applied")
+		}
+		if n := applyBacklog.Load(); n > 0 {
+			quiet = false
+			left = append(left, fmt.Sprintf("%d replicated statement(s) are still being applied", n))
+		}
+		if txIncomplete {
+			left = append(left, "a transaction from the master is incomplete and will be rolled back")
+		}
+		drainMu.Unlock()
+		for category, ops := range pendingSnapshot() {
+			if category == pendingDeadLetter {
+				// Already given up on, waiting won't apply them
+				continue
+			}
+			left = append(left, fmt.Sprintf("%d operation(s) %s will be lost", len(ops), category))
+		}
+
+		if quiet && len(left) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return left
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// Drain, then close the master connection and the local database
+func shutdownSlave() {
+	connectMu.Lock()
+	conn, up := master, connected
+	connectMu.Unlock()
+
+	if up && drainTimeout > 0 {
+		fmt.Printf("Applying what was already received from the master (up to %v)...\n", drainTimeout)
+		if left := drainReceived(); len(left) > 0 {
+			fmt.Println("Drain timed out:")
+			for _, l := range left {
+				fmt.Println("  -", l)
+			}
+		} else {
+			fmt.Println("Everything received from the master is applied")
+		}
+	}
+	if up {
+		conn.Close()
+	}
+
+	// The listener rolls back an incomplete transaction on its way out, it
+	// needs the database until then
+	stopped := make(chan struct{})
+	go func() {
+		listeners.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+	}
+	closeEventSocket()
+	if db != nil {
+		db.Close()
+	}
+}
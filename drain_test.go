@@ -0,0 +1,69 @@
+package main
+
+import (
+	"database/sql/driver"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExitAppliesWhatTheMasterAlreadySent(t *testing.T) {
+	f := useFakeDB(t)
+	clearPending(t)
+	release := make(chan struct{})
+	f.on(`^INSERT INTO orders VALUES \(1\)$`, func([]driver.Value) fakeResult {
+		<-release
+		return fakeResult{affected: 1}
+	})
+
+	server, client := net.Pipe()
+	oldMaster, oldConnected, oldSeq, oldTimeout := master, connected, appliedSeq, drainTimeout
+	connectMu.Lock()
+	master, connected = client, true
+	connectMu.Unlock()
+	drainTimeout = 5 * time.Second
+	go io.Copy(io.Discard, server) // the slave's acks
+	t.Cleanup(func() {
+		server.Close()
+		connectMu.Lock()
+		master, connected = oldMaster, oldConnected
+		connectMu.Unlock()
+		appliedSeq, drainTimeout = oldSeq, oldTimeout
+		resetReplicated()
+	})
+	listeners.Add(1)
+	go listenToMaster(client)
+
+	s := newSlaveConn(server)
+	t.Cleanup(s.close)
+	s.startFraming()
+	s.syncFinished()
+	for i := 1; i <= 3; i++ {
+		s.sendLive("replicate_query:%d:INSERT INTO orders VALUES (%d)\n", i, i)
+	}
+
+	// The first insert is still being applied, the other two are queued
+	// behind it, when the slave starts to exit
+	done := make(chan string)
+	go func() {
+		done <- captureOutput(t, shutdownSlave)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	out := <-done
+
+	if got := f.matching(`^INSERT INTO orders`); len(got) != 3 {
+		t.Fatalf("slave applied %q before closing, want all 3 inserts", got)
+	}
+	if !strings.Contains(out, "Everything received from the master is applied") {
+		t.Fatalf("printed %q, want the drain to finish before the timeout", out)
+	}
+	connectMu.Lock()
+	up := connected
+	connectMu.Unlock()
+	if up {
+		t.Fatal("still connected to the master after the drain")
+	}
+}
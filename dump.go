@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// SQL dump of the master database: CREATE TABLE statements and, optionally,
+// the data as INSERTs, then the triggers and routines. Tables come in the
+// order a sync uses, referenced tables first, so loading the file into an
+// empty database with the mysql client recreates it. Like a sync, the file
+// turns foreign key checks off while it loads, for tables that reference
+// each other and rows that reference later rows.
+
+const dumpInsertBytes = 1024 * 1024
+
+func writeSQLDump(w io.Writer, withData bool) error {
+	ctx := context.Background()
+	fmt.Fprintf(w, "-- ddb dump of database %s, %s\n\n", dbName, time.Now().Format(time.RFC3339))
+	fmt.Fprintf(w, "SET FOREIGN_KEY_CHECKS=0;\n\n")
+
+	for _, tableName := range syncOrder(ctx, db, tables) {
+		var name, tableDefinition string
+		err := db.QueryRow("SHOW CREATE TABLE "+tableName).Scan(&name, &tableDefinition)
+		if err != nil {
+			return fmt.Errorf("error getting CREATE TABLE for %s: %v", tableName, err)
+		}
+		fmt.Fprintf(w, "%s;\n\n", tableDefinition)
+
+		if withData {
+			if err := dumpTableData(w, tableName); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Last, as in a sync, so loading the rows doesn't fire triggers.
+	// Routine bodies contain semicolons, hence the delimiter.
+	routines, err := listRoutines(ctx, db)
+	if err != nil {
+		return fmt.Errorf("error listing triggers and routines: %v", err)
+	}
+	if len(routines) > 0 {
+		fmt.Fprintln(w, "DELIMITER ;;")
+		for _, r := range routines {
+			fmt.Fprintf(w, "%s;;\n\n", r.definition)
+		}
+		fmt.Fprintln(w, "DELIMITER ;")
+	}
+	fmt.Fprintf(w, "SET FOREIGN_KEY_CHECKS=1;\n")
+	return nil
+}
+
+func dumpTableData(w io.Writer, tableName string) error {
+	rows, err := db.Query("SELECT * FROM " + tableName)
+	if err != nil {
+		return fmt.Errorf("error selecting data from %s: %v", tableName, err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("error getting columns for %s: %v", tableName, err)
+	}
+
+	var batch [][]interface{}
+	flush := func() {
+		stmts, _ := buildInsertBatches(replicaDialect, tableName, columns, batch, 0)
+		for _, stmt := range stmts {
+			fmt.Fprintf(w, "%s;\n", stmt)
+		}
+		batch = batch[:0]
+	}
+
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		scanArgs := make([]interface{}, len(columns))
+		for i := range values {
+			scanArgs[i] = &values[i]
+		}
+		if err := rows.Scan(scanArgs...); err != nil {
+			return fmt.Errorf("error scanning row of %s: %v", tableName, err)
+		}
+		batch = append(batch, values)
+		if len(batch) == 100 {
+			flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading %s: %v", tableName, err)
+	}
+	flush()
+	fmt.Fprintln(w)
+	return nil
+}
+
+// Write a schema+data dump to a file
+func exportSQLDump(path string) error {
+	return writeDumpFile(path, true)
+}
+
+// Write a schema-only dump to a file
+func exportSchemaDump(path string) error {
+	return writeDumpFile(path, false)
+}
+
+func writeDumpFile(path string, withData bool) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeSQLDump(f, withData); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Menu action: write the CREATE statements of the database to a file
+func ExportSchema() {
+	defaultPath := fmt.Sprintf("%s-schema-%s.sql", dbName, time.Now().Format("20060102-150405"))
+	fmt.Printf("Enter schema file path (default: %s): ", defaultPath)
+	path := strings.TrimSpace(readLine())
+	if path == "" {
+		path = defaultPath
+	}
+	if err := exportSchemaDump(path); err != nil {
+		fmt.Printf("Error exporting schema: %v\n", err)
+		return
+	}
+	fmt.Printf("Schema of '%s' (%d tables) written to %s\n", dbName, len(tables), path)
+}
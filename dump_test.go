@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+func TestDumpLoadsWithForeignKeyChecksOff(t *testing.T) {
+	f := useFakeDB(t)
+	withTables(t, "shop", "a", "b")
+	// a and b reference each other, so no order satisfies both
+	f.rows(`REFERENCED_TABLE_NAME`, []string{"TABLE_NAME", "REFERENCED_TABLE_NAME"},
+		[]driver.Value{"a", "b"}, []driver.Value{"b", "a"})
+	for _, table := range []string{"a", "b"} {
+		f.rows(`^SHOW CREATE TABLE `+table+`$`, []string{"Table", "Create Table"},
+			[]driver.Value{table, "CREATE TABLE `" + table + "` (`id` int)"})
+	}
+	f.rows(`^SHOW (PROCEDURE|FUNCTION) STATUS`, []string{"Name"})
+	f.rows(`^SHOW TRIGGERS$`, []string{"Trigger"})
+
+	var out bytes.Buffer
+	if err := writeSQLDump(&out, false); err != nil {
+		t.Fatal(err)
+	}
+	dump := out.String()
+	off := strings.Index(dump, "SET FOREIGN_KEY_CHECKS=0;")
+	on := strings.LastIndex(dump, "SET FOREIGN_KEY_CHECKS=1;")
+	first := strings.Index(dump, "CREATE TABLE")
+	last := strings.LastIndex(dump, "CREATE TABLE")
+	if off < 0 || on < 0 || first < off || last > on {
+		t.Fatalf("foreign key checks aren't off around the tables:\n%s", dump)
+	}
+	if !strings.HasSuffix(dump, "SET FOREIGN_KEY_CHECKS=1;\n") {
+		t.Fatalf("checks not turned back on at the end:\n%s", dump)
+	}
+}
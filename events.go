@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Feed of applied replication events for local tools (-event-socket). Every
+// change the slave records in its changelog is also sent, as one JSON line,
+// to each program connected to the Unix socket:
+//
+//	{"op":"INSERT","table":"orders","seq":42,"ts":"2024-05-01T12:00:00.123Z","source":"master"}
+//
+// Subscribers only read. Each has a queue of eventQueueSize lines; one that
+// falls that far behind is disconnected rather than slowing replication.
+
+const eventQueueSize = 256
+
+var eventSocket string
+
+type replicationEvent struct {
+	Op     string    `json:"op"`
+	Table  string    `json:"table"`
+	Seq    uint64    `json:"seq"`
+	TS     time.Time `json:"ts"`
+	Source string    `json:"source"`
+}
+
+var (
+	eventMu          sync.Mutex
+	eventListener    net.Listener
+	eventSubscribers = make(map[net.Conn]chan []byte)
+)
+
+// Open the event socket and accept subscribers in the background
+func startEventSocket(path string) error {
+	removeStaleSocket(path)
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Publishing replication events on %s\n", path)
+	eventMu.Lock()
+	eventListener = ln
+	eventMu.Unlock()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			queue := make(chan []byte, eventQueueSize)
+			eventMu.Lock()
+			eventSubscribers[conn] = queue
+			eventMu.Unlock()
+			go writeEvents(conn, queue)
+		}
+	}()
+	return nil
+}
+
+// Stop accepting subscribers and disconnect them, removing the socket file
+func closeEventSocket() {
+	eventMu.Lock()
+	defer eventMu.Unlock()
+	if eventListener != nil {
+		eventListener.Close()
+	}
+	for conn, queue := range eventSubscribers {
+		delete(eventSubscribers, conn)
+		close(queue)
+		conn.Close()
+	}
+}
+
+func writeEvents(conn net.Conn, queue chan []byte) {
+	defer dropSubscriber(conn)
+	for line := range queue {
+		if _, err := conn.Write(line); err != nil {
+			return
+		}
+	}
+}
+
+func dropSubscriber(conn net.Conn) {
+	eventMu.Lock()
+	defer eventMu.Unlock()
+	if queue, ok := eventSubscribers[conn]; ok {
+		delete(eventSubscribers, conn)
+		close(queue)
+	}
+	conn.Close()
+}
+
+// Send an event to every subscriber without waiting for any of them
+func publishEvent(e changeEntry) {
+	eventMu.Lock()
+	defer eventMu.Unlock()
+	if len(eventSubscribers) == 0 {
+		return
+	}
+	line, err := json.Marshal(replicationEvent{Op: e.op, Table: e.table, Seq: e.seq, TS: e.at, Source: e.source})
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	for conn, queue := range eventSubscribers {
+		select {
+		case queue <- line:
+		default:
+			fmt.Println("Event subscriber fell behind, disconnecting it")
+			delete(eventSubscribers, conn)
+			close(queue)
+			conn.Close()
+		}
+	}
+}
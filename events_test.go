@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Open the event socket and connect one subscriber to it
+func subscribeToEvents(t *testing.T) net.Conn {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "events.sock")
+	if err := startEventSocket(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		closeEventSocket()
+		eventMu.Lock()
+		eventListener = nil
+		eventMu.Unlock()
+	})
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// Events published before the subscriber is accepted aren't sent to it
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		eventMu.Lock()
+		n := len(eventSubscribers)
+		eventMu.Unlock()
+		if n == 1 {
+			return conn
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("subscriber was never accepted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSubscriberReceivesAppliedInserts(t *testing.T) {
+	f := useFakeDB(t)
+	clearChangelog(t)
+	conn := subscribeToEvents(t)
+
+	s, applied := throughSlave(t, f, func(*slaveConn) {})
+	s.sendLive("replicate_query:1:INSERT INTO orders VALUES (1)\n")
+	s.sendLive("replicate_query:2:INSERT INTO customers VALUES (2)\n")
+	applied(2)
+
+	r := bufio.NewReader(conn)
+	for _, want := range []replicationEvent{
+		{Op: "INSERT", Table: "orders", Seq: 1, Source: "master"},
+		{Op: "INSERT", Table: "customers", Seq: 2, Source: "master"},
+	} {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("no event for seq %d: %v", want.Seq, err)
+		}
+		var got replicationEvent
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("event %q isn't JSON: %v", line, err)
+		}
+		if got.TS.IsZero() {
+			t.Fatalf("event %q has no timestamp", line)
+		}
+		got.TS = time.Time{}
+		if got != want {
+			t.Fatalf("got event %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestSubscriberThatFallsBehindIsDisconnected(t *testing.T) {
+	conn := subscribeToEvents(t)
+
+	// Never read, so the socket buffer and then the queue fill up
+	entry := changeEntry{seq: 1, op: "INSERT", table: "orders", at: time.Now(), source: "master"}
+	captureOutput(t, func() {
+		for i := 0; i < 100000; i++ {
+			publishEvent(entry)
+			eventMu.Lock()
+			n := len(eventSubscribers)
+			eventMu.Unlock()
+			if n == 0 {
+				return
+			}
+		}
+		t.Error("subscriber is still connected after 100000 unread events")
+	})
+
+	// What was queued may still arrive, then the connection ends
+	if _, err := io.Copy(io.Discard, conn); err != nil {
+		t.Fatalf("subscriber's connection wasn't closed: %v", err)
+	}
+}
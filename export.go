@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// SELECT results written to a file on the slave. The master sends the
+// header and each row as a JSON array of strings (null for NULL) and the
+// slave writes them out as they arrive, so memory use doesn't grow with the
+// size of the result.
+
+func encodeExportRow(fields []string) string {
+	data, _ := json.Marshal(fields)
+	return string(data)
+}
+
+// Binary columns (see binaryColumns) are written as plain base64, which is
+// also how they end up in the export file.
+func encodeExportValues(values []interface{}, binary []bool) string {
+	data, _ := json.Marshal(exportFields(values, binary))
+	return string(data)
+}
+
+// Text form of scanned values, nil for NULL and base64 for binary columns
+func exportFields(values []interface{}, binary []bool) []*string {
+	fields := make([]*string, len(values))
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+		var strValue string
+		switch v := v.(type) {
+		case []byte:
+			if i < len(binary) && binary[i] {
+				strValue = base64.StdEncoding.EncodeToString(v)
+			} else {
+				strValue = string(v)
+			}
+		default:
+			strValue = fmt.Sprintf("%v", v)
+		}
+		fields[i] = &strValue
+	}
+	return fields
+}
+
+// Decode a row sent by encodeExportValues, nil entries are NULL
+func decodeExportRow(line string) ([]*string, error) {
+	var fields []*string
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return nil, fmt.Errorf("invalid export row: %v", err)
+	}
+	return fields, nil
+}
+
+type exportFile struct {
+	path    string
+	format  string // csv or json
+	f       *os.File
+	w       *bufio.Writer
+	csv     *csv.Writer
+	columns []string
+	rows    int
+}
+
+var (
+	exportMu      sync.Mutex
+	pendingExport *exportFile
+)
+
+// Create the output file and mark it as the target of the next SELECT result
+func startExport(path, format string) (*exportFile, error) {
+	format = strings.ToLower(format)
+	if format != "csv" && format != "json" {
+		return nil, fmt.Errorf("unknown export format %q, use csv or json", format)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	e := &exportFile{path: path, format: format, f: f, w: bufio.NewWriter(f)}
+	if format == "csv" {
+		e.csv = csv.NewWriter(e.w)
+	}
+
+	exportMu.Lock()
+	if pendingExport != nil {
+		pendingExport.abandon()
+	}
+	pendingExport = e
+	exportMu.Unlock()
+	return e, nil
+}
+
+// The export waiting for a result, if any. It is cleared on return.
+func takeExport() *exportFile {
+	exportMu.Lock()
+	defer exportMu.Unlock()
+	e := pendingExport
+	pendingExport = nil
+	return e
+}
+
+func (e *exportFile) writeHeader(columns []string) error {
+	e.columns = columns
+	if e.format == "csv" {
+		return e.csv.Write(columns)
+	}
+	_, err := e.w.WriteString("[\n")
+	return err
+}
+
+func (e *exportFile) writeRow(fields []*string) error {
+	if len(fields) != len(e.columns) {
+		return fmt.Errorf("row has %d values, expected %d", len(fields), len(e.columns))
+	}
+	e.rows++
+
+	if e.format == "csv" {
+		// NULL is written as an empty field
+		record := make([]string, len(fields))
+		for i, v := range fields {
+			if v != nil {
+				record[i] = *v
+			}
+		}
+		return e.csv.Write(record)
+	}
+
+	var b strings.Builder
+	if e.rows > 1 {
+		b.WriteString(",\n")
+	}
+	b.WriteString("  {")
+	for i, v := range fields {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		key, _ := json.Marshal(e.columns[i])
+		value, _ := json.Marshal(v)
+		b.Write(key)
+		b.WriteString(": ")
+		b.Write(value)
+	}
+	b.WriteString("}")
+	_, err := e.w.WriteString(b.String())
+	return err
+}
+
+func (e *exportFile) finish() error {
+	if e.format == "csv" {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			e.f.Close()
+			return err
+		}
+	} else {
+		closing := "]\n"
+		if e.rows > 0 {
+			closing = "\n]\n"
+		}
+		if _, err := e.w.WriteString(closing); err != nil {
+			e.f.Close()
+			return err
+		}
+	}
+	if err := e.w.Flush(); err != nil {
+		e.f.Close()
+		return err
+	}
+	return e.f.Close()
+}
+
+// Close and remove a file whose result never arrived or failed part way
+func (e *exportFile) abandon() {
+	e.f.Close()
+	os.Remove(e.path)
+}
+
+// Write a select_export result from the master to the export file. The
+// success header has already been read.
+func receiveExport(e *exportFile, scanner *messageScanner) {
+	if !scanner.Scan() {
+		fmt.Println("Failed to read column names")
+		e.abandon()
+		return
+	}
+	columns, err := decodeExportRow(scanner.Text())
+	if err == nil {
+		names := make([]string, len(columns))
+		for i, c := range columns {
+			if c != nil {
+				names[i] = *c
+			}
+		}
+		err = e.writeHeader(names)
+	}
+
+	// Keep reading to END (or the ERROR line of a failed result) even after a
+	// failure so the stream stays in step.
+	// Binary columns already arrive as base64 text, so the ENCODING line
+	// needs no handling here.
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "END" {
+			break
+		}
+		if msg, ok := strings.CutPrefix(line, "ERROR:"); ok {
+			if err == nil {
+				err = fmt.Errorf("master failed after %d row(s): %s", e.rows, msg)
+			}
+			break
+		}
+		if err != nil || strings.HasPrefix(line, "ENCODING:") {
+			continue
+		}
+		var fields []*string
+		fields, err = decodeExportRow(line)
+		if err == nil {
+			err = e.writeRow(fields)
+		}
+	}
+
+	if err == nil {
+		err = e.finish()
+	}
+	if err != nil {
+		fmt.Printf("Export to %s failed: %v\n", e.path, err)
+		e.abandon()
+		return
+	}
+	fmt.Printf("Exported %d row(s) to %s\n", e.rows, e.path)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A select_export result as the master streams it, after its header
+func exportStream(columns []string, rows ...[]interface{}) string {
+	lines := []string{encodeExportRow(columns)}
+	for _, r := range rows {
+		lines = append(lines, encodeExportValues(r, nil))
+	}
+	return strings.Join(append(lines, "END"), "\n") + "\n"
+}
+
+func TestExportToCSVQuotesAwkwardValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.csv")
+	if _, err := startExport(path, "csv"); err != nil {
+		t.Fatal(err)
+	}
+	stream := exportStream([]string{"id", "note"},
+		[]interface{}{int64(1), []byte("plain")},
+		[]interface{}{int64(2), []byte("a, b")},
+		[]interface{}{int64(3), []byte(`say "hi"`)},
+		[]interface{}{int64(4), []byte("two\nlines")},
+		[]interface{}{int64(5), nil})
+	receiveExport(takeExport(), newMessageScanner(strings.NewReader(stream), nil))
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "id,note\n1,plain\n2,\"a, b\"\n3,\"say \"\"hi\"\"\"\n4,\"two\nlines\"\n5,\n"
+	if string(data) != want {
+		t.Fatalf("file holds:\n%s\nwant:\n%s", data, want)
+	}
+	records, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records[4][1] != "two\nlines" || records[5][1] != "" {
+		t.Fatalf("read back %q", records)
+	}
+}
+
+func TestExportToJSONKeepsNULLs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.json")
+	if _, err := startExport(path, "json"); err != nil {
+		t.Fatal(err)
+	}
+	stream := exportStream([]string{"id", "note"},
+		[]interface{}{int64(1), []byte("a, \"b\"\n")},
+		[]interface{}{int64(2), nil})
+	receiveExport(takeExport(), newMessageScanner(strings.NewReader(stream), nil))
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]*string
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("not JSON: %v\n%s", err, data)
+	}
+	one, two, note := "1", "2", "a, \"b\"\n"
+	want := []map[string]*string{{"id": &one, "note": &note}, {"id": &two, "note": nil}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("file holds:\n%s", data)
+	}
+}
+
+func TestFailedExportLeavesNoFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.csv")
+	if _, err := startExport(path, "csv"); err != nil {
+		t.Fatal(err)
+	}
+	stream := encodeExportRow([]string{"id"}) + "\n" + encodeExportRow([]string{"1"}) + "\nERROR:connection lost\n"
+	receiveExport(takeExport(), newMessageScanner(strings.NewReader(stream), nil))
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("half-written export kept: %v", err)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// A scripted database/sql driver for the tests. Statements are answered by
+// handlers matched on the query text, most recently added first, and every
+// statement is recorded. An Exec nothing matches succeeds, a Query nothing
+// matches fails.
+
+type fakeResult struct {
+	cols     []string
+	types    []string // DatabaseTypeName of each column, if set
+	rows     [][]driver.Value
+	affected int64
+	err      error
+	rowsErr  error // reading stops with it after the rows, as if cut off
+}
+
+type fakeHandler struct {
+	re *regexp.Regexp
+	fn func(args []driver.Value) fakeResult
+}
+
+type fakeDB struct {
+	mu       sync.Mutex
+	handlers []fakeHandler
+	log      []string
+	logConns []int64 // connection each logged statement ran on
+	nextConn int64
+}
+
+var (
+	fakeDBsMu sync.Mutex
+	fakeDBs   = make(map[string]*fakeDB)
+)
+
+func init() {
+	sql.Register("fakedb", fakeDriver{})
+}
+
+// Install a fresh fake as the global db for the length of the test
+func useFakeDB(t *testing.T) *fakeDB {
+	t.Helper()
+	f, conn := openFakeDB(t)
+	old := db
+	db = conn
+	t.Cleanup(func() { db = old })
+	return f
+}
+
+func openFakeDB(t *testing.T) (*fakeDB, *sql.DB) {
+	t.Helper()
+	f := &fakeDB{}
+	fakeDBsMu.Lock()
+	name := strconv.Itoa(len(fakeDBs))
+	fakeDBs[name] = f
+	fakeDBsMu.Unlock()
+	conn, err := sql.Open("fakedb", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return f, conn
+}
+
+// Answer statements matching pattern with fn
+func (f *fakeDB) on(pattern string, fn func(args []driver.Value) fakeResult) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers = append(f.handlers, fakeHandler{regexp.MustCompile(pattern), fn})
+}
+
+// Answer statements matching pattern with fixed rows
+func (f *fakeDB) rows(pattern string, cols []string, rows ...[]driver.Value) {
+	f.on(pattern, func([]driver.Value) fakeResult { return fakeResult{cols: cols, rows: rows} })
+}
+
+// Fail statements matching pattern
+func (f *fakeDB) fail(pattern string, err error) {
+	f.on(pattern, func([]driver.Value) fakeResult { return fakeResult{err: err} })
+}
+
+// Statements run so far
+func (f *fakeDB) statements() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.log...)
+}
+
+// Connections the statements matching pattern ran on
+func (f *fakeDB) connsOf(pattern string) []int64 {
+	re := regexp.MustCompile(pattern)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []int64
+	for i, stmt := range f.log {
+		if re.MatchString(stmt) {
+			out = append(out, f.logConns[i])
+		}
+	}
+	return out
+}
+
+// Statements run so far matching pattern
+func (f *fakeDB) matching(pattern string) []string {
+	re := regexp.MustCompile(pattern)
+	var out []string
+	for _, stmt := range f.statements() {
+		if re.MatchString(stmt) {
+			out = append(out, stmt)
+		}
+	}
+	return out
+}
+
+func (f *fakeDB) run(conn int64, query string, args []driver.NamedValue) (fakeResult, bool) {
+	values := make([]driver.Value, len(args))
+	for i, a := range args {
+		values[i] = a.Value
+	}
+	f.mu.Lock()
+	f.log = append(f.log, query)
+	f.logConns = append(f.logConns, conn)
+	var fn func([]driver.Value) fakeResult
+	for i := len(f.handlers) - 1; i >= 0; i-- {
+		if f.handlers[i].re.MatchString(query) {
+			fn = f.handlers[i].fn
+			break
+		}
+	}
+	f.mu.Unlock()
+	if fn == nil {
+		return fakeResult{}, false
+	}
+	return fn(values), true
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeDBsMu.Lock()
+	f, ok := fakeDBs[name]
+	fakeDBsMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("fakedb: unknown database %q", name)
+	}
+	f.mu.Lock()
+	f.nextConn++
+	id := f.nextConn
+	f.mu.Unlock()
+	return &fakeConn{f: f, id: id}, nil
+}
+
+type fakeConn struct {
+	f  *fakeDB
+	id int64
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{c: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if _, err := c.ExecContext(ctx, "BEGIN", nil); err != nil {
+		return nil, err
+	}
+	return &fakeTx{c: c}, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res, _ := c.f.run(c.id, query, args)
+	if res.err != nil {
+		return nil, res.err
+	}
+	return driver.RowsAffected(res.affected), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if query == "SELECT CONNECTION_ID()" {
+		c.f.run(c.id, query, args)
+		return &fakeRows{cols: []string{"CONNECTION_ID()"}, rows: [][]driver.Value{{c.id}}}, nil
+	}
+	res, ok := c.f.run(c.id, query, args)
+	if !ok {
+		return nil, fmt.Errorf("fakedb: unexpected query %q", query)
+	}
+	if res.err != nil {
+		return nil, res.err
+	}
+	return &fakeRows{cols: res.cols, types: res.types, rows: res.rows, err: res.rowsErr}, nil
+}
+
+type fakeStmt struct {
+	c     *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.c.ExecContext(context.Background(), s.query, named(args))
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.c.QueryContext(context.Background(), s.query, named(args))
+}
+
+func named(args []driver.Value) []driver.NamedValue {
+	out := make([]driver.NamedValue, len(args))
+	for i, a := range args {
+		out[i] = driver.NamedValue{Ordinal: i + 1, Value: a}
+	}
+	return out
+}
+
+type fakeTx struct{ c *fakeConn }
+
+func (tx *fakeTx) Commit() error {
+	_, err := tx.c.ExecContext(context.Background(), "COMMIT", nil)
+	return err
+}
+
+func (tx *fakeTx) Rollback() error {
+	_, err := tx.c.ExecContext(context.Background(), "ROLLBACK", nil)
+	return err
+}
+
+type fakeRows struct {
+	cols  []string
+	types []string
+	rows  [][]driver.Value
+	next  int
+	err   error
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) ColumnTypeDatabaseTypeName(i int) string {
+	if i < len(r.types) {
+		return r.types[i]
+	}
+	return ""
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows) {
+		if r.err != nil {
+			return r.err
+		}
+		return io.EOF
+	}
+	copy(dest, r.rows[r.next])
+	r.next++
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+// Initial sync order for tables with foreign keys. A child table can only
+// be created, and its rows loaded, once the table it references exists, so
+// parents are sent first. SHOW TABLES order is kept otherwise.
+
+// Tables each table references through a foreign key, from the snapshot.
+// References to the table itself are left out.
+func foreignKeyParents(ctx context.Context, q queryer) (map[string][]string, error) {
+	rows, err := q.QueryContext(ctx, `SELECT DISTINCT TABLE_NAME, REFERENCED_TABLE_NAME
+		FROM information_schema.KEY_COLUMN_USAGE
+		WHERE TABLE_SCHEMA = DATABASE() AND REFERENCED_TABLE_SCHEMA = DATABASE()
+		AND REFERENCED_TABLE_NAME IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	parents := make(map[string][]string)
+	for rows.Next() {
+		var child, parent string
+		if err := rows.Scan(&child, &parent); err != nil {
+			return nil, err
+		}
+		if child != parent {
+			parents[child] = append(parents[child], parent)
+		}
+	}
+	return parents, rows.Err()
+}
+
+// Order tables so every table comes after the tables it references. Tables
+// in a reference cycle can't be ordered and are returned as cycle, in their
+// original order after everything else.
+func orderByForeignKeys(names []string, parents map[string][]string) (ordered, cycle []string) {
+	present := make(map[string]bool, len(names))
+	for _, name := range names {
+		present[name] = true
+	}
+
+	placed := make(map[string]bool, len(names))
+	for len(ordered)+len(cycle) < len(names) {
+		progress := false
+		for _, name := range names {
+			if placed[name] {
+				continue
+			}
+			ready := true
+			for _, p := range parents[name] {
+				if present[p] && !placed[p] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				ordered = append(ordered, name)
+				placed[name] = true
+				progress = true
+			}
+		}
+		if !progress {
+			for _, name := range names {
+				if !placed[name] {
+					cycle = append(cycle, name)
+				}
+			}
+		}
+	}
+	return ordered, cycle
+}
+
+// Sync order for the snapshot's tables, falling back to the given order if
+// the foreign keys can't be read
+func syncOrder(ctx context.Context, q queryer, names []string) []string {
+	parents, err := foreignKeyParents(ctx, q)
+	if err != nil {
+		fmt.Printf("Could not read foreign keys, syncing tables in name order: %v\n", err)
+		return names
+	}
+	if len(parents) == 0 {
+		return names
+	}
+
+	for _, child := range names {
+		for _, parent := range parents[child] {
+			fmt.Printf("Foreign key: %s references %s\n", child, parent)
+		}
+	}
+	ordered, cycle := orderByForeignKeys(names, parents)
+	if len(cycle) > 0 {
+		fmt.Printf("Warning: tables %v reference each other, slaves load them with foreign key checks off\n", cycle)
+	}
+	return append(ordered, cycle...)
+}
+
+// Slave side: the order alone can't satisfy tables that reference each
+// other, or rows referencing later rows of their own table, so what
+// arrives between init_replication and replication_complete runs on one
+// session with foreign key checks off. Everything else keeps them on.
+var (
+	syncSessionMu sync.Mutex
+	syncSession   *sql.Conn
+)
+
+func beginSyncSession() error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 0"); err != nil {
+		conn.Close()
+		return err
+	}
+	syncSessionMu.Lock()
+	syncSession = conn
+	syncSessionMu.Unlock()
+	return nil
+}
+
+func endSyncSession() {
+	syncSessionMu.Lock()
+	conn := syncSession
+	syncSession = nil
+	syncSessionMu.Unlock()
+	if conn == nil {
+		return
+	}
+	ctx := context.Background()
+	conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 1")
+	conn.Close()
+}
+
+// Run a local statement, on the sync session while there is one
+func localExec(query string) (sql.Result, error) {
+	syncSessionMu.Lock()
+	conn := syncSession
+	syncSessionMu.Unlock()
+	if conn != nil {
+		return conn.ExecContext(context.Background(), query)
+	}
+	return db.Exec(query)
+}
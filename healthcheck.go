@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Liveness/readiness probe for container orchestrators. "ddb healthcheck"
+// connects to a master with subscribe:healthcheck, which the master answers
+// with health:ok:<version> without registering a slave or starting a sync.
+// Optionally it also asks for the replication position. Exits 0 if the
+// master is healthy and 1 otherwise.
+
+// Master side of a health check connection: only get_position is answered
+func serveHealthcheck(s *slaveConn, scanner *messageScanner) {
+	s.reply("health:ok:%s\n", version)
+	for scanner.Scan() {
+		operation, _, ok := parseMessage(scanner.Text())
+		if ok && operation == "get_position" {
+			s.reply("position:%d\n", currentSeq())
+			continue
+		}
+		s.reply("error:unsupported operation %s\n", operation)
+	}
+}
+
+// Entry point for "ddb healthcheck", returns the exit status
+func healthcheckMain(args []string) int {
+	fs := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	target := fs.String("target", "localhost:9999", "master address, or unix:/path")
+	position := fs.Bool("position", false, "also ask the master for its replication position")
+	timeout := fs.Duration("timeout", 3*time.Second, "give up after this long")
+	parseFlags(fs, args)
+
+	status, err := runHealthcheck(*target, *position, *timeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unhealthy: %v\n", err)
+		return 1
+	}
+	fmt.Println("healthy:", status)
+	return 0
+}
+
+func runHealthcheck(target string, withPosition bool, timeout time.Duration) (string, error) {
+	conn, err := dialMasterTimeout(target, timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	fmt.Fprintf(conn, "subscribe:healthcheck\n")
+	r := bufio.NewReader(conn)
+	reply, err := readReply(r)
+	if err != nil {
+		return "", err
+	}
+	v, ok := strings.CutPrefix(reply, "health:ok:")
+	if !ok {
+		return "", fmt.Errorf("unexpected reply %q", reply)
+	}
+	status := "master version " + v
+
+	if withPosition {
+		fmt.Fprintf(conn, "get_position:\n")
+		reply, err := readReply(r)
+		if err != nil {
+			return "", err
+		}
+		pos, ok := strings.CutPrefix(reply, "position:")
+		if !ok {
+			return "", fmt.Errorf("unexpected reply %q", reply)
+		}
+		status += ", position " + pos
+	}
+	return status, nil
+}
+
+func readReply(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("no reply from master: %v", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Serve one connection the way the master does, on a real TCP port
+func healthcheckMaster(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		handleSlaveConnection(conn)
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		<-done
+	})
+	return ln.Addr().String()
+}
+
+func TestHealthcheckExitStatus(t *testing.T) {
+	seqMu.Lock()
+	oldSeq := replicationSeq
+	replicationSeq = 42
+	seqMu.Unlock()
+	t.Cleanup(func() {
+		seqMu.Lock()
+		replicationSeq = oldSeq
+		seqMu.Unlock()
+	})
+
+	t.Run("healthy", func(t *testing.T) {
+		addr := healthcheckMaster(t)
+		var code int
+		out := captureOutput(t, func() {
+			code = healthcheckMain([]string{"-target", addr, "-position"})
+		})
+		want := "healthy: master version " + version + ", position 42"
+		if code != 0 || strings.TrimSpace(out) != want {
+			t.Fatalf("exit %d, printed %q; want exit 0 and %q", code, out, want)
+		}
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := ln.Addr().String()
+		ln.Close()
+		if code := healthcheckMain([]string{"-target", addr}); code != 1 {
+			t.Fatalf("exit %d against a closed port, want 1", code)
+		}
+	})
+
+	t.Run("not answering", func(t *testing.T) {
+		// Accepts the connection but never replies to the handshake
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		held := make(chan net.Conn, 1)
+		go func() {
+			if conn, err := ln.Accept(); err == nil {
+				held <- conn
+			}
+		}()
+
+		start := time.Now()
+		code := healthcheckMain([]string{"-target", ln.Addr().String(), "-timeout", "200ms"})
+		if code != 1 {
+			t.Fatalf("exit %d against a master that never answers, want 1", code)
+		}
+		if took := time.Since(start); took > 2*time.Second {
+			t.Fatalf("took %v, want the -timeout to cut it short", took)
+		}
+		(<-held).Close()
+	})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// End-to-end replication latency, from a write on the master to it being
+// applied on a slave. A slave that announces slave_info:origin_time:1 gets
+// live statements with the master's time attached to the position:
+//
+//	replicate_query:<seq>@<unix ns>:<query>
+//
+// Once it has applied the statement the slave translates that time to its
+// own clock with the offset the master estimated (see clock.go) and reports
+// how long ago it was in the ack, replicate_ack:ok:<ns>. The master keeps
+// the last latencySamples of them per slave and shows min, average, max
+// and 99th percentile in the slave list. Statements from a sync or a
+// resume carry no time, and neither do live ones held back during a sync or
+// a table copy, so only live replication is measured.
+
+const latencySamples = 1000
+
+// Master side: the latest latencies reported by one slave
+type latencyWindow struct {
+	samples []time.Duration
+	next    int
+}
+
+func (w *latencyWindow) add(d time.Duration) {
+	if len(w.samples) < latencySamples {
+		w.samples = append(w.samples, d)
+		return
+	}
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % latencySamples
+}
+
+// Summary for the slave list, "" before the first sample
+func (w *latencyWindow) String() string {
+	if len(w.samples) == 0 {
+		return ""
+	}
+	sorted := slices.Clone(w.samples)
+	slices.Sort(sorted)
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	p99 := sorted[(len(sorted)*99+99)/100-1]
+	return fmt.Sprintf("(latency min %v avg %v max %v p99 %v over %d)",
+		roundLatency(sorted[0]), roundLatency(total/time.Duration(len(sorted))),
+		roundLatency(sorted[len(sorted)-1]), roundLatency(p99), len(sorted))
+}
+
+func roundLatency(d time.Duration) time.Duration {
+	return d.Round(10 * time.Microsecond)
+}
+
+// Master side: a replicate_ack:ok may carry the statement's latency
+func (s *slaveConn) recordLatencyLocked(msg string) {
+	if n, err := strconv.ParseInt(msg, 10, 64); err == nil && n >= 0 {
+		s.latency.add(time.Duration(n))
+	}
+}
+
+func (s *slaveConn) latencyNote() string {
+	s.smu.Lock()
+	defer s.smu.Unlock()
+	return s.latency.String()
+}
+
+// Master side: the replicate_query frame for a live statement written at
+// origin (master clock, Unix ns)
+func (s *slaveConn) replicateFrame(seq uint64, query string, origin int64) string {
+	query = s.tailorStatement(query)
+	s.smu.Lock()
+	timed := s.originTime
+	s.smu.Unlock()
+	if timed {
+		return fmt.Sprintf("replicate_query:%d@%d:%s\n", seq, origin, s.payload(query))
+	}
+	return fmt.Sprintf("replicate_query:%d:%s\n", seq, s.payload(query))
+}
+
+// Master side: a frame without the master's time, for one that is held back
+// and would count the hold as latency
+func untimedFrame(msg string) string {
+	rest, ok := strings.CutPrefix(msg, "replicate_query:")
+	if !ok {
+		return msg
+	}
+	pos, query, _ := strings.Cut(rest, ":")
+	seq, _, timed := strings.Cut(pos, "@")
+	if !timed {
+		return msg
+	}
+	return "replicate_query:" + seq + ":" + query
+}
+
+// Slave side: the position of a replicate_query and the master's time, if
+// it was sent one (0 if not)
+func parseReplicatePosition(s string) (seq uint64, origin int64, err error) {
+	seqStr, originStr, timed := strings.Cut(s, "@")
+	if seq, err = strconv.ParseUint(seqStr, 10, 64); err != nil {
+		return 0, 0, err
+	}
+	if timed {
+		if origin, err = strconv.ParseInt(originStr, 10, 64); err != nil {
+			return 0, 0, err
+		}
+	}
+	return seq, origin, nil
+}
+
+// Slave side: master times of the statements not acked yet, by position
+var (
+	originMu    sync.Mutex
+	originTimes = make(map[uint64]int64)
+)
+
+func noteOrigin(seq uint64, origin int64) {
+	if origin == 0 {
+		return
+	}
+	originMu.Lock()
+	originTimes[seq] = origin
+	originMu.Unlock()
+}
+
+// How long ago, corrected for clock skew, the master wrote a statement.
+// False if it didn't say.
+func takeLatency(seq uint64) (time.Duration, bool) {
+	originMu.Lock()
+	origin, ok := originTimes[seq]
+	delete(originTimes, seq)
+	originMu.Unlock()
+	if !ok {
+		return 0, false
+	}
+	// The offset is only an estimate, it can make a fast apply look
+	// like it happened before the write
+	return max(0, clock().Sub(fromMasterTime(time.Unix(0, origin)))), true
+}
+
+// A new sync starts over, nothing sent before it will be acked
+func forgetOrigins() {
+	originMu.Lock()
+	clear(originTimes)
+	originMu.Unlock()
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Surviving a restart of the slave's own MySQL server. When a replicated
+// statement fails because the local server went away, the slave stays
+// connected to the master and keeps receiving: that statement and every
+// one after it are buffered with the pending operations, under
+// pendingLocalDB, instead of being applied (or acked as failed, which would
+// trip the master's circuit breaker). A master transaction cut off by the
+// failure is buffered whole, unless it was cut off during its commit: that
+// may have landed, and replaying it could apply it twice, so its tables are
+// listed under pendingUnverified instead and compared with the master once
+// the buffer is replayed. A background loop pings the server, waiting
+// longer after each failed try, and once it answers replays the buffer in
+// order; new statements are buffered behind it until it is empty. The
+// connection pool dials again by itself, so the database handle is kept.
+
+const (
+	pendingLocalDB    = "awaiting local database"
+	pendingUnverified = "to compare with the master"
+)
+
+var (
+	localDBRetry    = time.Second
+	localDBRetryMax = 30 * time.Second
+)
+
+// Whether statements are being buffered, guarded by pendingMu so checking
+// it and buffering are one step
+var localDBDown bool
+
+// Held while the buffer is replayed, so other messages from the master wait
+// for it (see waitLocalDBReplay)
+var replayMu sync.Mutex
+
+// Wait for a replay of buffered changes that is under way. While the
+// database is still down there's nothing to wait for.
+func waitLocalDBReplay() {
+	replayMu.Lock()
+	replayMu.Unlock()
+}
+
+func isLocalDBDown() bool {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	return localDBDown
+}
+
+// Start buffering if err says the local database went away. True if it is
+// down.
+func noteLocalDBLost(err error) bool {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	return noteLocalDBLostLocked(err)
+}
+
+func noteLocalDBLostLocked(err error) bool {
+	if !localDBDown && isConnectionLost(err) {
+		localDBDown = true
+		fmt.Printf("Lost the local database (%v), buffering replicated changes until it is back\n", err)
+		go recoverLocalDB()
+	}
+	return localDBDown
+}
+
+// Buffer op instead of applying it if the local database is down or apply
+// is paused (see pause.go). Returns true if it was buffered, then the caller
+// must leave op alone.
+func deferApply(op pendingOp) bool {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	if localDBDown {
+		op.received = time.Now()
+		pendingOps[pendingLocalDB] = append(pendingOps[pendingLocalDB], op)
+		return true
+	}
+	return bufferForPauseLocked(op)
+}
+
+// Buffer op if applying it failed because the local database just went
+// down. Returns true if it was, then the caller must leave op alone.
+func bufferForLocalDB(op pendingOp, err error) bool {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	if !noteLocalDBLostLocked(err) {
+		return false
+	}
+	op.received = time.Now()
+	pendingOps[pendingLocalDB] = append(pendingOps[pendingLocalDB], op)
+	return true
+}
+
+// Drop what was buffered, when a resync from the master supersedes it
+func discardLocalDBBacklog() {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	if n := len(pendingOps[pendingLocalDB]) + len(pendingOps[pendingPaused]); n > 0 {
+		fmt.Printf("%d buffered change(s) are superseded by the resync\n", n)
+	}
+	pendingOps[pendingLocalDB] = nil
+	pendingOps[pendingPaused] = nil
+	pendingOps[pendingUnverified] = nil
+}
+
+// List the tables of a transaction whose commit was cut off by the loss of
+// the local database, to be compared with the master once it is back
+func flagCutOffCommit(id string, stmts []pendingOp) {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	flagged := make(map[string]bool)
+	for _, op := range pendingOps[pendingUnverified] {
+		flagged[op.table] = true
+	}
+	for _, stmt := range stmts {
+		if stmt.table == "" || flagged[stmt.table] {
+			continue
+		}
+		flagged[stmt.table] = true
+		pendingOps[pendingUnverified] = append(pendingOps[pendingUnverified], pendingOp{
+			table:    stmt.table,
+			query:    fmt.Sprintf("commit of transaction %s was cut off", id),
+			received: time.Now(),
+		})
+	}
+}
+
+// Compare the tables of cut off commits with the master, fetching what
+// differs
+func verifyCutOffCommits() {
+	pendingMu.Lock()
+	ops := pendingOps[pendingUnverified]
+	pendingOps[pendingUnverified] = nil
+	pendingMu.Unlock()
+	for _, op := range ops {
+		fmt.Printf("A commit to table '%s' was cut off, comparing it with the master\n", op.table)
+		repairTable(op.table)
+	}
+}
+
+// Wait for the local server to answer, then replay the buffer
+func recoverLocalDB() {
+	wait := localDBRetry
+	for {
+		time.Sleep(wait)
+		if db == nil || db.Ping() != nil {
+			wait = min(wait*2, localDBRetryMax)
+			continue
+		}
+		wait = localDBRetry
+
+		fmt.Println("Local database is back, applying the buffered changes")
+		replayMu.Lock()
+		done := replayLocalDBBacklog()
+		if done {
+			verifyCutOffCommits()
+		}
+		replayMu.Unlock()
+		if done {
+			fmt.Println("Buffered changes applied, replication continues")
+			return
+		}
+		fmt.Println("Lost the local database again while applying buffered changes")
+	}
+}
+
+// Apply buffered operations in order until none are left, and stop
+// buffering. False if the connection was lost again, with the failed
+// operation put back first in line.
+func replayLocalDBBacklog() bool {
+	for {
+		pendingMu.Lock()
+		ops := pendingOps[pendingLocalDB]
+		if len(ops) == 0 {
+			localDBDown = false
+			pendingMu.Unlock()
+			return true
+		}
+		op := ops[0]
+		pendingOps[pendingLocalDB] = ops[1:]
+		pendingMu.Unlock()
+
+		if err := replayOp(op); isConnectionLost(err) {
+			pendingMu.Lock()
+			pendingOps[pendingLocalDB] = append([]pendingOp{op}, pendingOps[pendingLocalDB]...)
+			pendingMu.Unlock()
+			return false
+		}
+	}
+}
+
+// Apply one buffered operation and ack it, unless the connection was lost
+// again, which is returned. None of it was acked before: a transaction's
+// statements are only acked at its commit_tx, and a cut off commit isn't
+// buffered.
+func replayOp(op pendingOp) error {
+	if op.seq == 0 && op.tx == nil {
+		// Rows of a table sync, which aren't acked
+		err := executeLocalQuery(op.query)
+		if isConnectionLost(err) {
+			return err
+		} else if err != nil {
+			fmt.Printf("Failed to sync buffered data: %v\n", err)
+		}
+		return nil
+	}
+	if op.tx == nil {
+		affected, err := applyWithRetry(op.query)
+		if isConnectionLost(err) {
+			return err
+		}
+		finishReplicatedQuery(op.seq, op.query, affected, err)
+		return nil
+	}
+
+	results, err := applyBufferedTx(op.tx)
+	if isConnectionLost(err) {
+		return err
+	}
+	for i, stmt := range op.tx {
+		recordReplicated(stmt.seq, results[i], err)
+		if err == nil {
+			recordChange(stmt.seq, stmt.query, "master")
+		}
+		sendAck(stmt.seq, err)
+	}
+	if err != nil {
+		fmt.Printf("Buffered transaction of %d statement(s) failed and was rolled back: %v\n", len(op.tx), err)
+	}
+	return nil
+}
+
+// Run a buffered master transaction, returning the rows each statement
+// changed
+func applyBufferedTx(stmts []pendingOp) ([]int64, error) {
+	results := make([]int64, len(stmts))
+	tx, err := db.Begin()
+	if err != nil {
+		return results, err
+	}
+	for i, stmt := range stmts {
+		result, err := tx.Exec(stmt.query)
+		if err != nil {
+			tx.Rollback()
+			return results, fmt.Errorf("local query execution error: %w", err)
+		}
+		results[i], _ = result.RowsAffected()
+	}
+	return results, tx.Commit()
+}
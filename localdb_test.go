@@ -0,0 +1,133 @@
+package main
+
+import (
+	"database/sql/driver"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Check on the local database every millisecond
+func fastLocalDBRetry(t *testing.T) {
+	t.Helper()
+	old := localDBRetry
+	localDBRetry = time.Millisecond
+	t.Cleanup(func() { localDBRetry = old })
+}
+
+// Wait until the buffered changes have been replayed
+func waitLocalDBRecovered(t *testing.T) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for isLocalDBDown() {
+		if time.Now().After(deadline) {
+			t.Fatal("local database still down")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	waitLocalDBReplay()
+}
+
+// Nothing more sent to the master for a moment
+func noMoreLines(t *testing.T, lines <-chan string) {
+	t.Helper()
+	select {
+	case line := <-lines:
+		t.Fatalf("unexpected %q", line)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestChangesAreBufferedWhileTheLocalDBIsDown(t *testing.T) {
+	f := useFakeDB(t)
+	acks := pipeMaster(t)
+	clearPending(t)
+	fastLocalDBRetry(t)
+	want := []string{"INSERT INTO t (id) VALUES (1)", "INSERT INTO t (id) VALUES (2)", "UPDATE t SET v = 3 WHERE id = 2", "INSERT INTO t (id) VALUES (4)"}
+	var down atomic.Bool
+	down.Store(true)
+	var mu sync.Mutex
+	var applied []string
+	for _, query := range append([]string{"BEGIN"}, want...) {
+		f.on("^"+regexp.QuoteMeta(query)+"$", func([]driver.Value) fakeResult {
+			if down.Load() {
+				return fakeResult{err: driver.ErrBadConn}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if query != "BEGIN" {
+				applied = append(applied, query)
+			}
+			return fakeResult{affected: 1}
+		})
+	}
+
+	applyReplicatedQuery(1, want[0])
+	beginSlaveTx("4")
+	execInSlaveTx(2, want[1])
+	execInSlaveTx(3, want[2])
+	commitSlaveTx("4")
+	applyReplicatedQuery(4, want[3])
+	if n := len(pendingSnapshot()[pendingLocalDB]); n != 3 {
+		t.Fatalf("%d operation(s) buffered, want the statements and the transaction", n)
+	}
+	noMoreLines(t, acks)
+
+	down.Store(false)
+	waitLocalDBRecovered(t)
+
+	mu.Lock()
+	got := strings.Join(applied, "\n")
+	mu.Unlock()
+	if got != strings.Join(want, "\n") {
+		t.Fatalf("applied\n%s\nwant\n%s", got, strings.Join(want, "\n"))
+	}
+	// Every statement acked once
+	for i := 0; i < 4; i++ {
+		if ack := nextAck(t, acks); ack != "replicate_ack:ok:" {
+			t.Fatalf("ack %q", ack)
+		}
+	}
+	noMoreLines(t, acks)
+}
+
+func TestCutOffCommitIsComparedWithTheMasterInsteadOfReplayed(t *testing.T) {
+	f := useFakeDB(t)
+	lines := pipeMaster(t)
+	clearPending(t)
+	fastLocalDBRetry(t)
+	var commits atomic.Int32
+	f.on(`^COMMIT$`, func([]driver.Value) fakeResult {
+		if commits.Add(1) == 1 {
+			return fakeResult{err: driver.ErrBadConn}
+		}
+		return fakeResult{}
+	})
+
+	beginSlaveTx("3")
+	execInSlaveTx(1, "INSERT INTO t (id) VALUES (1)")
+	execInSlaveTx(2, "UPDATE u SET v = 1 WHERE id = 1")
+	commitSlaveTx("3")
+	for i := 0; i < 2; i++ {
+		if ack := nextAck(t, lines); !strings.HasPrefix(ack, "replicate_ack:err:") || !strings.Contains(ack, "during the commit") {
+			t.Fatalf("ack %q, want the cut off commit", ack)
+		}
+	}
+
+	waitLocalDBRecovered(t)
+	if n := len(f.matching(`^INSERT INTO t`)); n != 1 {
+		t.Fatalf("insert ran %d times, want it not replayed", n)
+	}
+	for _, table := range []string{"t", "u"} {
+		if got := nextLine(t, lines); got != "get_table_schema:"+table {
+			t.Fatalf("sent %q, want table %s compared with the master", got, table)
+		}
+	}
+	noMoreLines(t, lines)
+	if n := len(pendingSnapshot()[pendingUnverified]); n != 0 {
+		t.Fatalf("%d table(s) still listed after the comparison", n)
+	}
+}
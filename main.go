@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+const version = "0.1.0"
+
+func printVersion() {
+	fmt.Println("ddb version", version)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: ddb <command> [flags]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	fmt.Fprintln(os.Stderr, "  master       run the master server")
+	fmt.Fprintln(os.Stderr, "  slave        run a slave client")
+	fmt.Fprintln(os.Stderr, "  healthcheck  check that a master is up and exit 0 if so")
+	fmt.Fprintln(os.Stderr, "  version      print version and exit")
+}
+
+// Parse a command's flags. Their usage and errors are written through the
+// redaction copier, so -h and a bad flag exit through exitProgram: an
+// os.Exit from the flag package would lose what they printed.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	err := fs.Parse(args)
+	if err == flag.ErrHelp {
+		exitProgram(0)
+	}
+	if err != nil {
+		exitProgram(2)
+	}
+}
+
+func main() {
+	installRedaction()
+	defer drainOutput()
+
+	if len(os.Args) < 2 {
+		usage()
+		exitProgram(2)
+	}
+
+	switch os.Args[1] {
+	case "master":
+		masterMain(os.Args[2:])
+	case "slave":
+		slaveMain(os.Args[2:])
+	case "healthcheck":
+		exitProgram(healthcheckMain(os.Args[2:]))
+	case "version", "--version", "-version":
+		printVersion()
+	case "help", "--help", "-h":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", os.Args[1])
+		usage()
+		exitProgram(2)
+	}
+}
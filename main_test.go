@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// Run the program itself when the test binary is started by runDDB
+func TestMain(m *testing.M) {
+	if args := os.Getenv("DDB_TEST_ARGS"); args != "" {
+		os.Args = append([]string{"ddb"}, strings.Fields(args)...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// Run ddb with args, returning what it printed and its exit status
+func runDDB(t *testing.T, args string) (string, int) {
+	t.Helper()
+	return runDDBWithInput(t, args, nil)
+}
+
+// Run ddb with args and stdin, nil for no input at all
+func runDDBWithInput(t *testing.T, args string, stdin io.Reader) (string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "DDB_TEST_ARGS="+args, "MYSQL_DATABASE=")
+	cmd.Stdin = stdin
+	out, err := cmd.CombinedOutput()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return string(out), exit.ExitCode()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out), 0
+}
+
+func TestFlagHelpAndErrorsArePrinted(t *testing.T) {
+	for _, tc := range []struct {
+		args string
+		code int
+		want string
+	}{
+		{"healthcheck -h", 0, "-target"},
+		{"master -h", 0, "-select-limit"},
+		{"slave -h", 0, "-schema-only"},
+		{"slave -no-such-flag", 2, "flag provided but not defined: -no-such-flag"},
+		{"healthcheck -timeout soon", 2, "invalid value"},
+	} {
+		out, code := runDDB(t, tc.args)
+		if code != tc.code || !strings.Contains(out, tc.want) {
+			t.Errorf("ddb %s: exit %d, printed %q; want exit %d and %q", tc.args, code, out, tc.code, tc.want)
+		}
+	}
+}
+
+func TestIdleTimeoutExitsWhileWaitingForInput(t *testing.T) {
+	// Stdin stays open but nothing is ever typed
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	defer r.Close()
+
+	out, code := runDDBWithInput(t, "master -idle-timeout 100ms -data-dir "+t.TempDir(), r)
+	if code != 0 || !strings.Contains(out, "No input for 100ms, exiting.") {
+		t.Fatalf("exit %d, printed %q; want exit 0 after the idle timeout", code, out)
+	}
+	if strings.Contains(out, "Database name cannot be empty") {
+		t.Fatalf("printed %q; the prompt gave up with an empty answer instead of exiting", out)
+	}
+}
+
+func TestSubcommandsPrintTheirVersionAndStartUp(t *testing.T) {
+	for _, args := range []string{"version", "--version", "master --version", "slave --version", "slave -version"} {
+		out, code := runDDB(t, args)
+		if code != 0 || out != "ddb version "+version+"\n" {
+			t.Errorf("ddb %s: exit %d, printed %q; want the version", args, code, out)
+		}
+	}
+
+	// Without input the master stops at the first prompt, the slave at
+	// its menu
+	out, code := runDDB(t, "master -data-dir "+t.TempDir())
+	if code != 1 || !strings.Contains(out, "Enter your database name") || !strings.Contains(out, "Database name cannot be empty") {
+		t.Errorf("ddb master: exit %d, printed %q; want the database prompt", code, out)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := ln.Addr().String()
+	ln.Close()
+	out, code = runDDB(t, "slave -data-dir "+t.TempDir()+" -master "+closed)
+	if code != 0 || !strings.Contains(out, "===== SLAVE CLIENT MENU =====") || !strings.Contains(out, "End of input, exiting.") {
+		t.Errorf("ddb slave: exit %d, printed %q; want the menu", code, out)
+	}
+}
@@ -1,66 +1,838 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"net"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
-
-	"github.com/go-sql-driver/mysql"
 )
 
 // Database structures
 type column struct {
-	Name string
-	Type int
+	Name          string
+	Type          int
+	Nullable      bool
+	AutoIncrement bool
+}
+
+// Slots of data_type, the menu of column types. column.Type is one of
+// these, use them rather than bare numbers.
+const (
+	typeInt = iota
+	typeVarchar
+	typeFloat
+	typeText
+	typeJSON
+	typeDate
+	typeDatetime
+	typeBoolean
+	typeDecimal
+)
+
+var data_type = [...]string{
+	typeInt:      "INT",
+	typeVarchar:  "VARCHAR(100)",
+	typeFloat:    "FLOAT",
+	typeText:     "TEXT",
+	typeJSON:     "JSON",
+	typeDate:     "DATE",
+	typeDatetime: "DATETIME",
+	typeBoolean:  "BOOLEAN",
+	typeDecimal:  "DECIMAL",
 }
 
-var data_type = [4]string{"INT", "VARCHAR(100)", "FLOAT", "TEXT"}
+// A DECIMAL value, kept as the digits typed so it isn't rounded through a
+// float. sqlLiteral writes it unquoted.
+type decimalValue string
+
+var decimalRe = regexp.MustCompile(`^[+-]?(\d+(\.\d*)?|\.\d+)$`)
+
+// DATE and DATETIME values as MySQL writes them, and how they are asked for
+var dateLayouts = map[string]struct{ layout, hint string }{
+	"DATE":     {"2006-01-02", "YYYY-MM-DD"},
+	"DATETIME": {"2006-01-02 15:04:05", "YYYY-MM-DD HH:MM:SS"},
+}
 var tables []string
 var currentTable string
 var tableAttributes = make(map[string][]column)
 
+// Primary key columns of each table in key order, none for a table without
+// one. They aren't in tableAttributes.
+var tableKeys = make(map[string][]column)
+
 // Master-Slave communication
-var slaves = make(map[string]net.Conn)
+var slaves = make(map[string]*slaveConn)
 var mu sync.Mutex
-var db *sql.DB
 var dbName string
 
-func readPassword() string {
-	fmt.Print("Enter MySQL password: ")
+// Whether new slave connections are accepted, guarded by mu. Turned off
+// from the menu to keep new slaves (and their initial syncs) out for a
+// while; connected slaves are not affected.
+var acceptSlaves = true
+
+// Most slave connections one IP address may have at once (-max-slaves-per-ip,
+// 0 for no limit), counted in slavesPerIP under mu. Connections over a Unix
+// socket aren't limited.
+var maxSlavesPerIP int
+var slavesPerIP = make(map[string]int)
+
+// Source IP of a TCP slave connection, "" for other connections
+func slaveIP(conn net.Conn) string {
+	if a, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return a.IP.String()
+	}
+	return ""
+}
+
+// In maintenance mode writes forwarded by slaves are rejected, while
+// changes made here are still replicated to them. Guarded by mu.
+var maintenance bool
+
+// Reject a forwarded write while in maintenance mode
+func rejectInMaintenance(s *slaveConn) bool {
+	mu.Lock()
+	on := maintenance
+	mu.Unlock()
+	if on {
+		s.reply("error:master in maintenance\n")
+	}
+	return on
+}
+
+// Row cap applied to forwarded SELECTs without their own LIMIT (0 = no cap)
+var selectLimit = 1000
+
+// With -dry-run, local operations run as usual but everything that would be
+// replicated to slaves is only printed
+var dryRun bool
+
+// Backend the replicated statements are rendered for
+var replicaDialect = dialectMySQL
+
+// The master server's max_allowed_packet, read at startup
+var masterMaxPacket int
+
+// Held for reading by every write that is replicated to slaves (from the
+// local exec through the broadcast), and for writing while an initial sync
+// takes its snapshot. This way each write is either in the snapshot or
+// broadcast after it, never both.
+var snapshotMu sync.RWMutex
+
+// Serializes the writes themselves, whether made from the menu or forwarded
+// by a slave, so e.g. a DROP TABLE can't land in the middle of an INSERT
+// into the same table and its replication.
+var opMu sync.Mutex
+
+// Take the locks a replicated write holds from its local exec through the
+// broadcast. Returns the function releasing them. With the binlog engine
+// the release waits, still holding opMu, for the tailer to replicate the
+// write, so the writes go out in the order they were made; snapshotMu is
+// let go first, since the tailer takes it too (see binlog.go).
+func beginWrite() func() {
+	snapshotMu.RLock()
+	opMu.Lock()
+	return func() {
+		snapshotMu.RUnlock()
+		if binlogEngine() {
+			waitForBinlog()
+		}
+		opMu.Unlock()
+	}
+}
+
+// Like beginWrite, for a write forwarded by slave s, which already has it.
+// Returns the connection to run the write on. With the binlog engine it is
+// registered by its MySQL connection id until the tailer is past the write,
+// which is how the tailer knows to leave s out.
+func beginWriteFrom(s *slaveConn) (*sql.Conn, func(), error) {
+	release := beginWrite()
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return nil, release, err
+	}
+	if !binlogEngine() {
+		return conn, func() {
+			release()
+			conn.Close()
+		}, nil
+	}
+	var id uint32
+	if err := conn.QueryRowContext(context.Background(), "SELECT CONNECTION_ID()").Scan(&id); err != nil {
+		conn.Close()
+		return nil, release, err
+	}
+	registerBinlogOrigin(id, s)
+	return conn, func() {
+		release()
+		forgetBinlogOrigin(id)
+		conn.Close()
+	}, nil
+}
+
+// Sequence number of the last replicated statement. Assigned and broadcast
+// under seqMu so every slave sees the numbers in the same order.
+var (
+	seqMu          sync.Mutex
+	replicationSeq uint64
+)
+
+// Common subset of *sql.DB and *sql.Conn used for reading sync data
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Queue sizes for the per-slave writer. The bulk queue is kept small so an
+// initial sync can't get far ahead of what the slave is actually receiving.
+const liveQueueSize = 1024
+const bulkQueueSize = 64
+
+// A connected slave. All writes to the connection go through writeLoop,
+// which always sends queued live replication frames before bulk-sync frames.
+//
+// While the initial sync is running, live frames are held back instead and
+// only released once the snapshot has been fully sent.
+type slaveConn struct {
+	addr      string
+	conn      net.Conn
+	live      chan string
+	bulk      chan string
+	done      chan struct{}
+	wmu       sync.Mutex // serializes writes to conn
+	framed    bool       // messages are sent as frames, guarded by wmu (see protocol.go)
+	closeOnce sync.Once
+
+	smu     sync.Mutex // guards the fields below
+	syncing bool
+	held    []string
+	syncPos uint64 // replication sequence at the sync snapshot
+
+	// Set once the slave confirms with sync_ack that it applied the whole
+	// initial sync. Until then it isn't counted as fully synced.
+	syncAcked bool
+
+	// Bulk sends outside the initial sync that hold live frames back,
+	// see holdLive
+	holding int
+
+	// Slave's max_allowed_packet as reported by slave_info (0 = unknown)
+	maxPacket int
+
+	// Position of the last write this slave forwarded. It isn't sent the
+	// write back, so get_position tells it instead.
+	ownSeq uint64
+
+	// Slave's MySQL server version from slave_info, "" if not reported
+	mysqlVersion string
+
+	// Id the slave process keeps across reconnects, from slave_info,
+	// "" for slaves that don't send one
+	slaveID string
+
+	// Slave clock minus master clock and the round trip it was measured
+	// with, once known (see clock.go)
+	clockOffset time.Duration
+	rtt         time.Duration
+	clockKnown  bool
+
+	// Whether the slave wants the master's time with live statements, and
+	// the latencies it reported (see latency.go)
+	originTime bool
+	latency    latencyWindow
+
+	// The slave reads length-prefixed frames, from slave_info:framing
+	wantsFraming bool
+
+	// Verify Replication sends table checksums, from
+	// slave_info:table_checksums (see verifychecksum.go)
+	tableChecksums bool
+
+	// Statements are sent to the slave base64 encoded, from
+	// slave_info:payload_encoding (see encodePayload)
+	base64Payloads bool
+
+	// How often the slave wants cluster stats, 0 for never (see stats.go)
+	statsInterval time.Duration
+
+	// Free space on the slave's data directory from slave_info, -1 if not
+	// reported (see diskspace.go)
+	diskFree int64
+
+	// Database and position of the snapshot the slave bootstrapped from,
+	// from slave_info:resume_position (see snapshot.go)
+	resumeDB  string
+	resumePos uint64
+
+	// Set from the subscribe handshake before the slave is registered:
+	// only DDL is replicated, no rows
+	schemaOnly bool
+
+	// A health check connection (subscribe:healthcheck), never registered
+	// as a slave, see healthcheck.go. verifyOnce marks a probe that only
+	// asks for one verification (subscribe:verify, see verifyonce.go).
+	probe      bool
+	verifyOnce bool
+
+	// Circuit breaker state, see breaker.go
+	broken      bool
+	errStreak   int
+	streakStart time.Time
+	dropped     int
+
+	// Live frames queued and not yet written, see flushLive
+	unsent atomic.Int64
+}
+
+// Unix socket peers have no address, they are numbered instead
+var unixPeerCount int64
+
+func newSlaveConn(conn net.Conn) *slaveConn {
+	addr := conn.RemoteAddr().String()
+	if _, ok := conn.LocalAddr().(*net.UnixAddr); ok && (addr == "" || addr == "@") {
+		addr = fmt.Sprintf("unix#%d", atomic.AddInt64(&unixPeerCount, 1))
+	}
+	s := &slaveConn{
+		addr:     addr,
+		conn:     conn,
+		live:     make(chan string, liveQueueSize),
+		bulk:     make(chan string, bulkQueueSize),
+		done:     make(chan struct{}),
+		syncing:  true,
+		diskFree: -1,
+	}
+	go s.writeLoop()
+	return s
+}
+
+func (s *slaveConn) writeLoop() {
+	for {
+		var msg string
+		// Check the live queue on its own first so it always wins over bulk
+		live := true
+		select {
+		case msg = <-s.live:
+		default:
+			select {
+			case msg = <-s.live:
+			case msg = <-s.bulk:
+				live = false
+			case <-s.done:
+				return
+			}
+		}
+
+		s.wmu.Lock()
+		err := s.writeLocked(msg)
+		s.wmu.Unlock()
+		if live {
+			s.unsent.Add(-1)
+		}
+		if err != nil {
+			fmt.Printf("Failed to write to slave %s: %v\n", s.addr, err)
+			s.close()
+			return
+		}
+	}
+}
+
+// Largest statement this slave can take: the smaller of both servers'
+// max_allowed_packet and the protocol message limit
+func (s *slaveConn) packetLimit() int {
+	limit := maxMessageSize
+	if masterMaxPacket > 0 && masterMaxPacket < limit {
+		limit = masterMaxPacket
+	}
+	s.smu.Lock()
+	defer s.smu.Unlock()
+	if s.maxPacket > 0 && s.maxPacket < limit {
+		limit = s.maxPacket
+	}
+	return limit
+}
+
+// Report whether a frame is small enough to send, logging it if not
+func (s *slaveConn) fits(msg string) bool {
+	limit := s.packetLimit()
+	if len(msg) <= limit {
+		return true
+	}
+	fmt.Printf("Not sending %d byte statement to slave %s: over the %d byte limit. "+
+		"Raise max_allowed_packet (and --max-message-size) on both sides to at least %d.\n",
+		len(msg), s.addr, limit, len(msg))
+	return false
+}
+
+func (s *slaveConn) encodesPayloads() bool {
+	s.smu.Lock()
+	defer s.smu.Unlock()
+	return s.base64Payloads
+}
+
+// A statement as it is sent to this slave in sync_data and replicate_query
+func (s *slaveConn) payload(query string) string {
+	if s.encodesPayloads() {
+		return encodePayload(query)
+	}
+	return query
+}
+
+// Handle a slave_info message ("key:value")
+func (s *slaveConn) recordInfo(content string) {
+	key, value, ok := parseMessage(content)
+	if !ok {
+		return
+	}
+	if key == "acked_position" {
+		// Kept under mu, see breaker.go
+		s.recordAckedPosition(value)
+		return
+	}
+	s.smu.Lock()
+	defer s.smu.Unlock()
+	switch key {
+	case "max_allowed_packet":
+		fmt.Sscanf(value, "%d", &s.maxPacket)
+	case "mysql_version":
+		s.mysqlVersion = value
+	case "slave_id":
+		s.slaveID = value
+	case "stats_interval":
+		s.statsInterval = parseStatsInterval(value)
+	case "origin_time":
+		s.originTime = value == "1"
+	case "framing":
+		s.wantsFraming = value == "length"
+	case "table_checksums":
+		s.tableChecksums = value == "1"
+	case "payload_encoding":
+		s.base64Payloads = value == "base64"
+	case "disk_free":
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			s.diskFree = n
+		}
+	case "resume_position":
+		name, pos, ok := parseMessage(value)
+		if n, err := strconv.ParseUint(pos, 10, 64); ok && err == nil {
+			s.resumeDB, s.resumePos = name, n
+		}
+	}
+}
+
+// Slave's MySQL server version, "" if it didn't report one
+func (s *slaveConn) version() string {
+	s.smu.Lock()
+	defer s.smu.Unlock()
+	return s.mysqlVersion
+}
+
+var versionRe = regexp.MustCompile(`^(\d+)\.(\d+)`)
+
+// Whether a server knows the MySQL 8.0 utf8mb4_0900_* collations. MariaDB
+// and MySQL before 8.0 don't. An unknown version is assumed to.
+func supportsUCA0900(version string) bool {
+	if version == "" {
+		return true
+	}
+	if strings.Contains(strings.ToLower(version), "mariadb") {
+		return false
+	}
+	m := versionRe.FindStringSubmatch(version)
+	if m == nil {
+		return true
+	}
+	major, _ := strconv.Atoi(m[1])
+	return major >= 8
+}
+
+var collation0900Re = regexp.MustCompile(`utf8mb4_0900_\w+`)
+
+// Adapt a table definition or other DDL to what the slave's server supports
+func (s *slaveConn) tailorDDL(def string) string {
+	if !supportsUCA0900(s.version()) {
+		def = collation0900Re.ReplaceAllString(def, "utf8mb4_unicode_ci")
+	}
+	return def
+}
+
+// A replicated statement as the slave's server can run it. Only DDL is
+// adapted, rows are sent as they are.
+func (s *slaveConn) tailorStatement(query string) string {
+	if isSchemaStatement(query) {
+		return s.tailorDDL(query)
+	}
+	return query
+}
+
+// Send a table definition to every slave, adapted to each one's server
+func broadcastTableDef(def string) {
+	if dryRun {
+		logDryRun("all slaves", fmt.Sprintf("create_table:%s\n", def))
+		return
+	}
+	for _, s := range slaveTargets(nil) {
+		s.sendLive("create_table:%s\n", s.tailorDDL(def))
+	}
+	resetReplayLog()
+}
+
+// Queue a live replication frame (replicated queries, DDL, notifications)
+func (s *slaveConn) sendLive(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if !s.fits(msg) {
+		return
+	}
+
+	s.smu.Lock()
+	if s.broken {
+		s.dropped++
+		s.smu.Unlock()
+		return
+	}
+	if s.syncing || s.holding > 0 {
+		s.held = append(s.held, untimedFrame(msg))
+		s.smu.Unlock()
+		return
+	}
+	s.smu.Unlock()
+
+	s.unsent.Add(1)
+	select {
+	case s.live <- msg:
+	case <-s.done:
+	}
+}
+
+// Print a frame instead of sending it in dry-run mode
+func logDryRun(target, msg string) {
+	fmt.Printf("[dry-run] to %s: %s", target, msg)
+	if !strings.HasSuffix(msg, "\n") {
+		fmt.Println()
+	}
+}
+
+// Queue a bulk-sync frame. Blocks while the bulk queue is full.
+func (s *slaveConn) sendBulk(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if dryRun {
+		logDryRun(s.addr, msg)
+		return
+	}
+	if !s.fits(msg) {
+		return
+	}
+	select {
+	case s.bulk <- msg:
+	case <-s.done:
+	}
+}
+
+// Write a reply to the slave's own request directly
+func (s *slaveConn) reply(format string, args ...interface{}) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	return s.writeLocked(fmt.Sprintf(format, args...))
+}
+
+// Write one message, as a frame if the slave reads them. wmu must be held.
+func (s *slaveConn) writeLocked(msg string) error {
+	if s.framed {
+		msg = encodeFrame(msg)
+	}
+	_, err := io.WriteString(s.conn, msg)
+	return err
+}
+
+// Switch to frames if the slave asked for them in its handshake. Called
+// before anything else is sent to it.
+func (s *slaveConn) startFraming() {
+	s.smu.Lock()
+	wants := s.wantsFraming
+	s.smu.Unlock()
+	if !wants {
+		return
+	}
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if _, err := fmt.Fprintf(s.conn, "%s\n", lengthFraming); err == nil {
+		s.framed = true
+	}
+}
+
+// Wait until pending live frames have been picked up by the writer
+func (s *slaveConn) yieldToLive() {
+	for len(s.live) > 0 {
+		select {
+		case <-s.done:
+			return
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// Hold live frames again for a sync on an already connected slave
+func (s *slaveConn) startResync() {
+	s.smu.Lock()
+	s.syncing = true
+	s.syncAcked = false
+	s.held = nil
+	s.smu.Unlock()
+}
+
+// Handle sync_ack:<position>, sent by the slave once everything up to
+// replication_complete has been applied locally
+func (s *slaveConn) recordSyncAck(content string) {
+	s.smu.Lock()
+	defer s.smu.Unlock()
+	var pos uint64
+	fmt.Sscanf(content, "%d", &pos)
+	if pos < s.syncPos {
+		fmt.Printf("Slave %s acknowledged sync at position %d, expected %d\n", s.addr, pos, s.syncPos)
+		return
+	}
+	s.syncAcked = true
+	fmt.Printf("Slave %s is fully synced\n", s.addr)
+}
+
+// Sync state for the topology view
+func (s *slaveConn) syncState() string {
+	s.smu.Lock()
+	defer s.smu.Unlock()
+	switch {
+	case s.syncing:
+		return "syncing"
+	case !s.syncAcked:
+		return "waiting for sync ack"
+	}
+	return "synced"
+}
+
+// Called once the sync snapshot is taken. Anything held so far is already
+// part of the snapshot, so it is dropped, and so is what the binlog tailer
+// hasn't replicated yet of what went into it.
+func (s *slaveConn) snapshotTaken() {
+	cutBinlog(s)
+	pos := currentSeq()
+	s.smu.Lock()
+	s.held = nil
+	s.syncPos = pos
+	s.smu.Unlock()
+}
+
+// Called after the last bulk frame of the initial sync is queued. Waits for
+// the bulk queue to drain and then releases the held live frames in order.
+func (s *slaveConn) syncFinished() {
+	s.waitForBulk()
+
+	s.smu.Lock()
+	defer s.smu.Unlock()
+	s.syncing = false
+	if s.holding == 0 {
+		s.releaseHeldLocked()
+	}
+}
 
-	// In a production environment, you would use a package like "golang.org/x/term"
-	// to read passwords securely without displaying them on screen
-	// Example:
-	// bytePassword, _ := term.ReadPassword(int(syscall.Stdin))
-	// return string(bytePassword)
+// Hold live frames back while bulk frames go out outside the initial sync,
+// like a table copy for get_table_schema. Taken under snapshotMu.Lock along
+// with the read the bulk frames come from, so writes made after the read
+// can't overtake its rows. Each holdLive is ended by one releaseLive.
+func (s *slaveConn) holdLive() {
+	s.smu.Lock()
+	s.holding++
+	s.smu.Unlock()
+}
 
-	// For simplicity, we'll just read it directly here
-	var password string
-	fmt.Scanln(&password)
-	return password
+// Called after the last bulk frame of a holdLive is queued. Waits for the
+// bulk queue to drain and then releases the held live frames, unless a sync
+// or another hold still needs them.
+func (s *slaveConn) releaseLive() {
+	s.waitForBulk()
+
+	s.smu.Lock()
+	defer s.smu.Unlock()
+	s.holding--
+	if s.holding == 0 && !s.syncing {
+		s.releaseHeldLocked()
+	}
+}
+
+// Wait until the queued bulk frames have been picked up by the writer
+func (s *slaveConn) waitForBulk() {
+	for len(s.bulk) > 0 {
+		select {
+		case <-s.done:
+			return
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// Queue the held live frames in order. smu must be held.
+func (s *slaveConn) releaseHeldLocked() {
+	for _, msg := range s.held {
+		s.unsent.Add(1)
+		select {
+		case s.live <- msg:
+		case <-s.done:
+			return
+		}
+	}
+	s.held = nil
+}
+
+// Wait, up to timeout, until the queued live frames have been written.
+// False if some are left.
+func (s *slaveConn) flushLive(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for s.unsent.Load() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		select {
+		case <-s.done:
+			return false
+		case <-time.After(time.Millisecond):
+		}
+	}
+	return true
+}
+
+func (s *slaveConn) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.conn.Close()
+	})
+}
+
+// Send a replicated statement to every slave except skip (the slave the
+// statement came from, which may be nil), numbered with the next
+// replication sequence. Schema-only slaves only get the DDL.
+func replicate(skip *slaveConn, query string) uint64 {
+	seqMu.Lock()
+	defer seqMu.Unlock()
+	return replicateLocked(skip, query)
+}
+
+// replicate with seqMu already held
+func replicateLocked(skip *slaveConn, query string) uint64 {
+	return replicateToLocked(skip, nil, query)
+}
+
+// replicateLocked leaving out the slaves keep (if not nil) rejects
+func replicateToLocked(skip *slaveConn, keep func(*slaveConn) bool, query string) uint64 {
+	replicationSeq++
+	if dryRun {
+		logDryRun("all slaves", fmt.Sprintf("replicate_query:%d:%s\n", replicationSeq, query))
+		return replicationSeq
+	}
+	schema := isSchemaStatement(query)
+	logReplay(replicationSeq, query)
+	origin := clock().UnixNano()
+	for _, s := range keptTargets(skip, keep) {
+		if s.schemaOnly && !schema {
+			// Keeps the slave's position in step without sending the rows
+			s.sendLive("applied_position:%d\n", replicationSeq)
+			continue
+		}
+		s.sendLive("%s", s.replicateFrame(replicationSeq, query, origin))
+	}
+
+	source := "master"
+	if skip != nil {
+		source = skip.addr
+		skip.smu.Lock()
+		skip.ownSeq = replicationSeq
+		skip.smu.Unlock()
+	}
+	recordChange(replicationSeq, query, source)
+	return replicationSeq
+}
+
+// Sequence number of the last replicated statement
+func currentSeq() uint64 {
+	seqMu.Lock()
+	defer seqMu.Unlock()
+	return replicationSeq
+}
+
+// Every connected slave except skip (which may be nil)
+func slaveTargets(skip *slaveConn) []*slaveConn {
+	mu.Lock()
+	defer mu.Unlock()
+	targets := make([]*slaveConn, 0, len(slaves))
+	for _, s := range slaves {
+		if s != skip {
+			targets = append(targets, s)
+		}
+	}
+	return targets
+}
+
+// slaveTargets narrowed to the slaves keep accepts, if keep isn't nil
+func keptTargets(skip *slaveConn, keep func(*slaveConn) bool) []*slaveConn {
+	targets := slaveTargets(skip)
+	if keep == nil {
+		return targets
+	}
+	kept := targets[:0]
+	for _, s := range targets {
+		if keep(s) {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// Send a live frame to every connected slave except skip (which may be nil)
+func broadcast(skip *slaveConn, format string, args ...interface{}) {
+	if dryRun {
+		logDryRun("all slaves", fmt.Sprintf(format, args...))
+		return
+	}
+	for _, s := range slaveTargets(skip) {
+		s.sendLive(format, args...)
+	}
+}
+
+// Statements a schema-only slave receives
+func isSchemaStatement(query string) bool {
+	op, _ := statementInfo(query)
+	switch op {
+	case "CREATE", "ALTER", "DROP", "RENAME", "TRUNCATE":
+		return true
+	}
+	return false
 }
 
 // Database connection setup
 func dbConn(dbn string) {
-	cfg := mysql.NewConfig()
-	fmt.Print("Enter MySQL username: ")
-	fmt.Scanln(&cfg.User)
-
-	cfg.Passwd = readPassword()
+	user := mysqlUser("Enter MySQL username: ")
+	cfg := newMySQLConfig(user, mysqlPassword())
 
 	if cfg.User == "" {
 		fmt.Println("Warning: Using empty username for database connection")
 	}
+	masterConfig = cfg.Clone()
 
 	var err error
 	// First connect without specifying a database
-	db, err = sql.Open("mysql", cfg.FormatDSN())
+	db, err = openMySQL(cfg)
 	if err != nil {
 		log.Fatalf("Connection error: %v", err)
 	}
@@ -70,11 +842,8 @@ func dbConn(dbn string) {
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// Database doesn't exist, ask to create it
-			fmt.Printf("Database '%s' doesn't exist. Create it? (y/n): ", dbn)
-			var create string
-			fmt.Scanln(&create)
-			if strings.ToLower(create) == "y" {
-				_, err = db.Exec("CREATE DATABASE " + dbn)
+			if confirm(fmt.Sprintf("Database '%s' doesn't exist. Create it? (y/n): ", dbn)) {
+				_, err = db.Exec("CREATE DATABASE " + dbn + " CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci")
 				if err != nil {
 					log.Fatalf("Error creating database: %v", err)
 				}
@@ -88,8 +857,9 @@ func dbConn(dbn string) {
 	}
 
 	// Now connect to the specific database
+	db.Close()
 	cfg.DBName = dbn
-	db, err = sql.Open("mysql", cfg.FormatDSN())
+	db, err = openMySQL(cfg)
 	if err != nil {
 		log.Fatalf("Connection error: %v", err)
 	}
@@ -101,244 +871,518 @@ func dbConn(dbn string) {
 	}
 
 	fmt.Printf("Successfully connected to database '%s'\n", dbn)
+
+	masterMaxPacket = queryMaxPacket(db)
+}
+
+// Start a read-only REPEATABLE READ transaction on a dedicated connection.
+// The snapshot is taken while holding snapshotMu so it lines up exactly
+// with what has and hasn't been broadcast yet. at runs under the same lock,
+// also when the snapshot can't be taken and the caller falls back to plain
+// reads.
+func consistentSnapshot(ctx context.Context, at func()) (*sql.Conn, error) {
+	snap, err := db.Conn(ctx)
+	if err == nil {
+		_, err = snap.ExecContext(ctx, "SET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ")
+	}
+
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+	at()
+	if err == nil {
+		_, err = snap.ExecContext(ctx, "START TRANSACTION WITH CONSISTENT SNAPSHOT, READ ONLY")
+	}
+	if err != nil {
+		if snap != nil {
+			snap.Close()
+		}
+		return nil, err
+	}
+	return snap, nil
+}
+
+// End a transaction started by consistentSnapshot
+func closeSnapshot(ctx context.Context, snap *sql.Conn) {
+	snap.ExecContext(ctx, "COMMIT")
+	snap.Close()
+}
+
+// The snapshot for an initial sync. The table list is copied at the same
+// point, so a table created during the sync is either in the list or
+// arrives as a held create_table, never both or neither.
+func startSyncSnapshot(ctx context.Context, s *slaveConn) (*sql.Conn, []string, error) {
+	var names []string
+	snap, err := consistentSnapshot(ctx, func() {
+		names = append([]string(nil), tables...)
+		s.snapshotTaken()
+	})
+	return snap, names, err
 }
 
 // Send database schema to slave for replication
-func sendSchemaToSlave(conn net.Conn) {
+func sendSchemaToSlave(s *slaveConn) {
+	defer useDB()()
+	ctx := context.Background()
+
+	// Read everything from one consistent snapshot so concurrent writes
+	// can't shift the LIMIT/OFFSET batches. Writes committed after the
+	// snapshot are held and sent once the sync is done.
+	defer s.syncFinished()
+
+	var q queryer = db
+	snap, syncTables, err := startSyncSnapshot(ctx, s)
+	if err != nil {
+		fmt.Printf("Error starting sync snapshot, falling back to plain reads: %v\n", err)
+	} else {
+		q = snap
+		defer closeSnapshot(ctx, snap)
+	}
+
+	// Referenced tables go before the tables referencing them
+	syncTables = syncOrder(ctx, q, syncTables)
+
+	// Auto-increment partitioning applies to the connections the slave
+	// opens for init_replication
+	if autoIncIncrement > 0 {
+		s.sendBulk("auto_increment:%d:%d\n", autoIncIncrement, autoIncOffset)
+	}
+
 	// First send the database name
-	fmt.Fprintf(conn, "init_replication:%s\n", dbName)
+	s.sendBulk("init_replication:%s\n", dbName)
 
-	// Send CREATE DATABASE statement
-	fmt.Fprintf(conn, "create_db:%s\n", dbName)
+	// Send CREATE DATABASE statement, with the database's default character
+	// set and collation so text compares the same way on the slave
+	var charset, collation string
+	err = q.QueryRowContext(ctx, `SELECT DEFAULT_CHARACTER_SET_NAME, DEFAULT_COLLATION_NAME
+		FROM information_schema.SCHEMATA WHERE SCHEMA_NAME = DATABASE()`).Scan(&charset, &collation)
+	if err != nil {
+		fmt.Printf("Could not read the database character set: %v\n", err)
+		s.sendBulk("create_db:%s\n", dbName)
+	} else {
+		s.sendBulk("create_db:%s:%s:%s\n", dbName, charset, s.tailorDDL(collation))
+	}
 
-	// For each table, send its schema
-	for _, tableName := range tables {
+	// For each table, send its schema. With no tables yet the slave just
+	// gets replication_complete and picks up tables from live create_table.
+	var summary []tableSyncStatus
+	for _, tableName := range syncTables {
 		// Get CREATE TABLE statement
 		var tableDefinition string
-		err := db.QueryRow("SHOW CREATE TABLE "+tableName).Scan(&tableName, &tableDefinition)
+		err := q.QueryRowContext(ctx, "SHOW CREATE TABLE "+tableName).Scan(&tableName, &tableDefinition)
 		if err != nil {
 			fmt.Printf("Error getting CREATE TABLE for %s: %v\n", tableName, err)
+			summary = append(summary, tableSyncStatus{Table: tableName, Status: syncSkipped,
+				Detail: fmt.Sprintf("schema: %v", err)})
 			continue
 		}
 
 		// Log the full CREATE TABLE statement for debugging
 		fmt.Printf("Sending CREATE TABLE statement to slave: %s\n", tableDefinition)
 
-		// Send the CREATE TABLE statement to the slave
-		// Make sure to encode any newlines or special characters
-		encodedDef := strings.ReplaceAll(tableDefinition, "\n", " ")
-		fmt.Fprintf(conn, "create_table:%s\n", encodedDef)
-
-		// Now dump all data from this table
-		// First check if the table has data
-		var rowCount int
-		err = db.QueryRow("SELECT COUNT(*) FROM " + tableName).Scan(&rowCount)
-		if err != nil {
-			fmt.Printf("Error counting rows in %s: %v\n", tableName, err)
-			continue
-		}
-
-		if rowCount == 0 {
-			fmt.Printf("Table %s is empty, skipping data sync\n", tableName)
-			continue
+		// Send the table and then all data from it
+		if problems := sendTableContents(ctx, q, tableName, tableDefinition, s); len(problems) > 0 {
+			summary = append(summary, tableSyncStatus{Table: tableName, Status: syncPartial,
+				Detail: strings.Join(problems, "; ")})
+		} else {
+			summary = append(summary, tableSyncStatus{Table: tableName, Status: syncOK})
 		}
+	}
 
-		fmt.Printf("Syncing %d rows from table %s\n", rowCount, tableName)
-
-		// Use batched processing for large tables
-		const batchSize = 100
-		for offset := 0; offset < rowCount; offset += batchSize {
-			rows, err := db.Query(fmt.Sprintf("SELECT * FROM %s LIMIT %d OFFSET %d",
-				tableName, batchSize, offset))
-			if err != nil {
-				fmt.Printf("Error selecting data from %s: %v\n", tableName, err)
-				continue
-			}
-
-			columns, err := rows.Columns()
-			if err != nil {
-				rows.Close()
-				fmt.Printf("Error getting columns for %s: %v\n", tableName, err)
-				continue
-			}
-
-			values := make([]interface{}, len(columns))
-			scanArgs := make([]interface{}, len(columns))
-			for i := range values {
-				scanArgs[i] = &values[i]
-			}
-
-			// For each row in the batch
-			rowNum := 0
-			for rows.Next() {
-				rowNum++
-				err = rows.Scan(scanArgs...)
-				if err != nil {
-					fmt.Printf("Error scanning row: %v\n", err)
-					continue
-				}
-
-				// Construct INSERT statement
-				insertQuery := fmt.Sprintf("INSERT INTO %s (", tableName)
-				valueStrings := make([]string, len(columns))
-
-				// Add column names
-				for i, colName := range columns {
-					if i > 0 {
-						insertQuery += ", "
-					}
-					insertQuery += colName
-				}
-				insertQuery += ") VALUES ("
-
-				// Convert scan values to strings
-				for i, val := range values {
-					var strVal string
-					if val == nil {
-						strVal = "NULL"
-					} else {
-						switch v := val.(type) {
-						case []byte:
-							strVal = "'" + strings.ReplaceAll(string(v), "'", "''") + "'"
-						case string:
-							strVal = "'" + strings.ReplaceAll(v, "'", "''") + "'"
-						default:
-							strVal = fmt.Sprintf("%v", v)
-						}
-					}
-					valueStrings[i] = strVal
-				}
-
-				// Add value strings
-				insertQuery += strings.Join(valueStrings, ", ") + ")"
-
-				// Send the INSERT statement to the slave
-				fmt.Fprintf(conn, "sync_data:%s\n", insertQuery)
-			}
-			rows.Close()
+	// Triggers and routines go last so triggers don't fire on synced rows
+	sendRoutinesToSlave(ctx, q, s)
 
-			fmt.Printf("Sent batch of %d rows from table %s (offset %d)\n",
-				rowNum, tableName, offset)
+	// Tell the slave which tables didn't make it, before it reports done
+	s.sendBulk("sync_summary:%s\n", encodeSyncSummary(summary))
+	for _, t := range summary {
+		if t.Status != syncOK {
+			fmt.Printf("Sync to %s: table %s %s (%s)\n", s.addr, t.Table, t.Status, t.Detail)
 		}
 	}
 
+	// The slave is now at the position the snapshot was taken at
+	s.smu.Lock()
+	pos := s.syncPos
+	s.smu.Unlock()
+	s.sendBulk("applied_position:%d\n", pos)
+
 	// Signal end of schema replication
-	fmt.Fprintf(conn, "replication_complete:done\n")
-	fmt.Printf("Schema and data sent to slave: %s\n", conn.RemoteAddr().String())
+	s.sendBulk("replication_complete:done\n")
+	fmt.Printf("Schema and data sent to slave: %s\n", s.addr)
 }
 
 // Slave connection handler
 func handleSlaveConnection(conn net.Conn) {
-	addr := conn.RemoteAddr().String()
+	s := newSlaveConn(conn)
+	addr := s.addr
+	defer func() {
+		s.close()
+		if !s.probe {
+			fmt.Println("Slave disconnected:", addr)
+		}
+	}()
+
+	scanner := newMessageScanner(conn, func(size int) {
+		fmt.Printf("Rejected %d byte message from slave %s (limit %d)\n", size, addr, maxMessageSize)
+		s.reply("error:message too large (%d bytes, limit %d)\n", size, maxMessageSize)
+	})
+
+	// Read the subscription before the slave is visible to broadcasts
+	first, ok := readSubscription(s, scanner)
+	if !ok {
+		return
+	}
+	s.startFraming()
+	if s.verifyOnce {
+		serveVerifyOnce(s)
+		return
+	}
+	if s.probe {
+		serveHealthcheck(s, scanner)
+		return
+	}
+
 	mu.Lock()
-	slaves[addr] = conn
+	accepting := acceptSlaves
 	mu.Unlock()
-	fmt.Println("Slave connected:", addr)
-
-	// Send schema to new slave for replication
-	sendSchemaToSlave(conn)
+	if !accepting {
+		fmt.Println("Rejected new slave (not accepting new slaves):", addr)
+		s.reply("error:master is not accepting new slaves right now, try again later\n")
+		return
+	}
 
+	// A slave that reconnects before its old connection was noticed as
+	// dead must not get the broadcasts twice, or keep the old state
+	mu.Lock()
+	var stale *slaveConn
+	for _, old := range slaves {
+		if s.slaveID != "" && old.slaveID == s.slaveID {
+			stale = old
+		}
+	}
+	// The connection being replaced doesn't count against the limit
+	ip := slaveIP(conn)
+	count := slavesPerIP[ip]
+	if stale != nil && slaveIP(stale.conn) == ip {
+		count--
+	}
+	if ip != "" && maxSlavesPerIP > 0 && count >= maxSlavesPerIP {
+		mu.Unlock()
+		fmt.Printf("Rejected new slave %s (%s already has %d connection(s))\n", addr, ip, count)
+		s.reply("error:too many slave connections from %s (limit %d)\n", ip, maxSlavesPerIP)
+		return
+	}
+	if ip != "" {
+		slavesPerIP[ip]++
+	}
+	if stale != nil {
+		delete(slaves, stale.addr)
+	}
+	slaves[addr] = s
+	mu.Unlock()
+	if stale != nil {
+		fmt.Printf("Slave %s reconnected as %s, closing its old connection\n", stale.addr, addr)
+		stale.close()
+	}
+	fmt.Println("Slave connected:", addr)
 	defer func() {
 		mu.Lock()
-		delete(slaves, addr)
+		if slaves[addr] == s {
+			delete(slaves, addr)
+		}
+		if ip != "" {
+			if slavesPerIP[ip]--; slavesPerIP[ip] <= 0 {
+				delete(slavesPerIP, ip)
+			}
+		}
 		mu.Unlock()
-		conn.Close()
-		fmt.Println("Slave disconnected:", addr)
 	}()
 
-	scanner := bufio.NewScanner(conn)
+	// Send schema to new slave for replication, unless it bootstrapped
+	// from a snapshot and can be caught up from there
+	if !resumeSlave(s) {
+		if !checkSlaveSpace(s) {
+			return
+		}
+		if !syncSlave(s) {
+			return
+		}
+	}
+
+	s.smu.Lock()
+	interval := s.statsInterval
+	s.smu.Unlock()
+	if interval > 0 {
+		go pushStats(s, interval)
+	}
+
+	if first != "" {
+		handleSlaveMessage(s, first)
+	}
 	for scanner.Scan() {
-		request := scanner.Text()
-		parts := strings.SplitN(request, ":", 2)
-		if len(parts) != 2 {
-			fmt.Fprintf(conn, "error:invalid request format\n")
+		handleSlaveMessage(s, scanner.Text())
+	}
+}
+
+// How long a new slave has to send its subscribe message. Slaves that
+// don't send one get a full subscription.
+const handshakeTimeout = 5 * time.Second
+
+// Read the slave's subscribe:<mode> handshake. Returns a first message that
+// turned out to be something else, to be handled after the sync, and false
+// if the connection went away.
+func readSubscription(s *slaveConn, scanner *messageScanner) (string, bool) {
+	s.conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	defer s.conn.SetReadDeadline(time.Time{})
+
+	var operation, mode string
+	for {
+		if !scanner.Scan() {
+			var ne net.Error
+			if errors.As(scanner.Err(), &ne) && ne.Timeout() {
+				fmt.Printf("Slave %s sent no subscription, replicating everything\n", s.addr)
+				return "", true
+			}
+			return "", false
+		}
+
+		var ok bool
+		operation, mode, ok = parseMessage(scanner.Text())
+		if ok && operation == "slave_info" {
+			// Sent ahead of the subscription, e.g. the server version
+			s.recordInfo(mode)
 			continue
 		}
+		if !ok || operation != "subscribe" {
+			return scanner.Text(), true
+		}
+		break
+	}
+	if v := s.version(); v != "" {
+		fmt.Printf("Slave %s runs MySQL %s\n", s.addr, v)
+	}
+	switch mode {
+	case "schema_only":
+		s.schemaOnly = true
+		fmt.Printf("Slave %s subscribed to schema changes only\n", s.addr)
+	case "healthcheck":
+		s.probe = true
+	case "verify":
+		s.probe, s.verifyOnce = true, true
+	case "full":
+	default:
+		fmt.Printf("Slave %s asked for unknown subscription %q, replicating everything\n", s.addr, mode)
+	}
+	return "", true
+}
 
-		operation := parts[0]
-		query := parts[1]
+// Handle one request or control message from a slave
+func handleSlaveMessage(s *slaveConn, line string) {
+	defer useDB()()
 
-		// Handle operations
+	operation, query, ok := parseMessage(line)
+	if !ok {
+		s.reply("error:invalid request format\n")
+		return
+	}
+
+	// Handle operations
+	switch operation {
+	case "insert", "update", "delete":
+		if rejectInMaintenance(s) || rejectInTx(s) || rejectForwarded(s, operation, query) {
+			return
+		}
+		if operation == "delete" {
+			query = softDeleteQuery(query)
+		}
+		executeQuery(query, s)
+	case "select", "select_all", "select_export":
+		if rejectForwarded(s, operation, query) {
+			return
+		}
 		switch operation {
-		case "insert":
-			executeQuery(query, conn)
-		case "update":
-			executeQuery(query, conn)
-		case "delete":
-			executeQuery(query, conn)
 		case "select":
-			executeSelect(query, conn)
-		case "verify_replication":
-			handleVerifyReplication(conn)
-		case "get_table_schema":
-			sendTableSchema(query, conn)
+			executeSelect(query, s, selectCapped)
+		case "select_all":
+			executeSelect(query, s, selectAll)
 		default:
-			fmt.Fprintf(conn, "error:unsupported operation\n")
+			executeSelect(query, s, selectExport)
 		}
+	case "verify_replication":
+		handleVerifyReplication(s, query == "schema")
+	case "get_block_checksums":
+		sendBlockChecksums(s, query)
+	case "get_block_rows":
+		sendBlockRows(s, query)
+	case "verify_row":
+		handleVerifyRow(s, query)
+	case "get_position":
+		sendPosition(s)
+	case "get_table_schema":
+		sendTableSchema(query, s)
+	case "bench_ack":
+		benchAcked(query, s.addr)
+	case "replicate_ack":
+		s.recordAck(query)
+	case "affected_count":
+		bulkReported(query, s.addr)
+	case "slave_info":
+		s.recordInfo(query)
+	case "sync_ack":
+		s.recordSyncAck(query)
+		// The slave is idle now, so its answer isn't delayed by the sync
+		sendClockProbe(s)
+	case "time_reply":
+		s.recordClock(query)
+	case "subscribe":
+		// Only read during the handshake, the mode can't change later
+		fmt.Printf("Ignoring subscribe from slave %s after the handshake\n", s.addr)
+	case "pong", "auth":
+		// Control messages reserved for newer slaves. They never get a
+		// reply, so they are accepted and ignored rather than answered
+		// with an error the slave would show as a failed request.
+	default:
+		fmt.Printf("Unsupported operation %q from slave %s\n", operation, s.addr)
+		s.reply("error:unsupported operation %s\n", operation)
 	}
 }
 
-// Handle replication verification requests
-func handleVerifyReplication(conn net.Conn) {
-	fmt.Println("Received replication verification request from:", conn.RemoteAddr())
+// Handle replication verification requests, with each table's definition
+// if withSchema (see verifyschema.go)
+func handleVerifyReplication(s *slaveConn, withSchema bool) {
+	fmt.Println("Received replication verification request from:", s.addr)
 
 	// Get table information
 	rows, err := db.Query("SHOW TABLES")
 	if err != nil {
-		fmt.Fprintf(conn, "error:Failed to get tables: %v\n", err)
+		s.reply("error:Failed to get tables: %v\n", err)
 		return
 	}
 	defer rows.Close()
 
-	// Start verification response
-	fmt.Fprintf(conn, "verification_data:begin\n")
+	s.smu.Lock()
+	withChecksums := s.tableChecksums
+	s.smu.Unlock()
+
+	// Build the report before taking the write lock, the queries can take
+	// a while on big tables and live frames shouldn't wait for them
+	report := []string{"verification_data:begin\n"}
 
-	// Send info for each table
+	// Add info for each table
 	var tableName string
 	for rows.Next() {
 		rows.Scan(&tableName)
 
-		// Count rows in this table
-		var rowCount int
-		err := db.QueryRow("SELECT COUNT(*) FROM " + tableName).Scan(&rowCount)
+		// Count rows in this table, with the checksum if the slave wants it
+		rowCount, sum, summed, err := verifyCounts(tableName, withChecksums)
 		if err != nil {
 			fmt.Printf("Error counting rows in %s: %v\n", tableName, err)
 			continue
 		}
 
-		// Send table info
-		fmt.Fprintf(conn, "table:%s:%d\n", tableName, rowCount)
+		// Add table info
+		if summed {
+			report = append(report, fmt.Sprintf("table:%s:%d:%d\n", tableName, rowCount, sum))
+		} else {
+			report = append(report, fmt.Sprintf("table:%s:%d\n", tableName, rowCount))
+		}
+		if withSchema {
+			var name, def string
+			if err := db.QueryRow("SHOW CREATE TABLE "+tableName).Scan(&name, &def); err != nil {
+				fmt.Printf("Error getting CREATE TABLE for %s: %v\n", tableName, err)
+				continue
+			}
+			report = append(report, fmt.Sprintf("table_schema:%s:%s\n", tableName,
+				base64.StdEncoding.EncodeToString([]byte(s.tailorDDL(def)))))
+		}
 	}
 
 	// End verification response
-	fmt.Fprintf(conn, "verification_data:end\n")
+	report = append(report, "verification_data:end\n")
+
+	// Hold the write lock so broadcasts can't interleave with the report
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	for _, line := range report {
+		if err := s.writeLocked(line); err != nil {
+			return
+		}
+	}
+}
+
+// Answer get_position. A slave that forwarded writes is told first that
+// they count as applied, or it would show itself behind by them.
+func sendPosition(s *slaveConn) {
+	s.smu.Lock()
+	own := s.ownSeq
+	s.smu.Unlock()
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if own > 0 && s.writeLocked(fmt.Sprintf("applied_position:%d\n", own)) != nil {
+		return
+	}
+	s.writeLocked(fmt.Sprintf("position:%d\n", currentSeq()))
 }
 
 // Execute query and return result to slave
-func executeQuery(query string, conn net.Conn) {
-	_, err := db.Exec(query)
+func executeQuery(query string, s *slaveConn) {
+	conn, release, err := beginWriteFrom(s)
+	defer release()
+
+	if err == nil {
+		_, err = conn.ExecContext(context.Background(), query)
+	}
 	if err != nil {
-		fmt.Fprintf(conn, "error:%v\n", err)
+		s.reply("error:%v\n", err)
 		return
 	}
-	fmt.Fprintf(conn, "success:query executed\n")
+	s.reply("success:query executed\n")
 	fmt.Println("Query Executed Succesfuly")
 
+	// A forwarded ALTER may have added, moved or dropped columns
+	if op, table := statementInfo(query); op == "ALTER" && table != "" {
+		markColumnsStale(table)
+	}
+
 	// Propagate the change to all slaves except the one that sent the query
-	mu.Lock()
-	for _, slaveConn := range slaves {
-		if slaveConn != conn { // Skip the slave that sent the query
-			fmt.Fprintf(slaveConn, "replicate_query:%s\n", query)
-		}
+	if !binlogEngine() {
+		replicate(s, conflictQuery(query))
 	}
-	mu.Unlock()
 }
 
+// How a SELECT result is sent back to the slave
+type selectMode int
+
+const (
+	// Limited to selectLimit rows unless the query has its own LIMIT
+	selectCapped selectMode = iota
+	// Every row, for display
+	selectAll
+	// Every row, with the header and each row as a JSON array of strings
+	// so values containing commas or newlines survive the trip to a file
+	selectExport
+)
+
 // Execute SELECT query and return results to slave
-func executeSelect(query string, conn net.Conn) {
-	rows, err := db.Query(query)
+//
+// In selectCapped mode a query without its own LIMIT is limited to
+// selectLimit rows and a TRUNCATED line is sent before END if there were more.
+// If reading the result fails part way, an ERROR:<message> line is sent
+// instead of END so the rows already sent aren't taken for the whole result.
+func executeSelect(query string, s *slaveConn, mode selectMode) {
+	// Cancelled if the slave goes away mid-stream so the scan stops too
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rowCap := 0
+	if mode == selectCapped && selectLimit > 0 && !hasLimitClause(query) {
+		rowCap = selectLimit
+		// Fetch one extra row to find out whether the cap cut anything off
+		query = addLimitClause(query, rowCap+1)
+	}
+
+	rows, err := db.QueryContext(ctx, query)
 	if err != nil {
-		fmt.Fprintf(conn, "error:%v\n", err)
+		s.reply("error:%v\n", err)
 		return
 	}
 	defer rows.Close()
@@ -346,9 +1390,20 @@ func executeSelect(query string, conn net.Conn) {
 	// Get column names
 	columns, err := rows.Columns()
 	if err != nil {
-		fmt.Fprintf(conn, "error:%v\n", err)
+		s.reply("error:%v\n", err)
 		return
 	}
+	if len(columns) == 0 {
+		s.reply("error:the statement returned no columns\n")
+		return
+	}
+
+	// Hold the write lock for the whole result so broadcasts can't interleave
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	send := func(format string, args ...interface{}) error {
+		return s.writeLocked(fmt.Sprintf(format, args...))
+	}
 
 	// Prepare result holders
 	values := make([]interface{}, len(columns))
@@ -357,42 +1412,107 @@ func executeSelect(query string, conn net.Conn) {
 		scanArgs[i] = &values[i]
 	}
 
+	// Stop streaming, cancel the query and drop the slave on a failed write
+	abort := func(err error) {
+		fmt.Printf("Slave %s went away during SELECT, aborting query: %v\n", s.addr, err)
+		cancel()
+		s.close()
+	}
+
 	// Start with success header
-	fmt.Fprintf(conn, "success:%d\n", len(columns))
+	if err := send("success:%d\n", len(columns)); err != nil {
+		abort(err)
+		return
+	}
 
-	// Send column names
-	colNames := strings.Join(columns, ",")
-	fmt.Fprintf(conn, "%s\n", colNames)
+	// Send column names, made unique in case of a JOIN
+	colNames := strings.Join(dedupeColumnNames(columns), ",")
+	if mode == selectExport {
+		colNames = encodeExportRow(dedupeColumnNames(columns))
+	}
+	if err := send("%s\n", colNames); err != nil {
+		abort(err)
+		return
+	}
+
+	// Binary columns are sent base64 encoded, announced by an ENCODING line
+	binary := binaryColumns(rows)
+	if line := encodingLine(binary); line != "" {
+		if err := send("%s\n", line); err != nil {
+			abort(err)
+			return
+		}
+	}
 
 	// Send data rows
 	rowCount := 0
 	for rows.Next() {
+		if rowCap > 0 && rowCount == rowCap {
+			if err := send("TRUNCATED:%d\n", rowCap); err != nil {
+				abort(err)
+				return
+			}
+			break
+		}
+
+		select {
+		case <-s.done:
+			abort(fmt.Errorf("connection closed"))
+			return
+		default:
+		}
+
 		rowCount++
 		err = rows.Scan(scanArgs...)
 		if err != nil {
 			continue
 		}
 
+		if mode == selectExport {
+			if err := send("%s\n", encodeExportValues(values, binary)); err != nil {
+				abort(err)
+				return
+			}
+			continue
+		}
+
 		var rowData []string
-		for _, v := range values {
+		for i, v := range values {
 			var strValue string
 			if v == nil {
 				strValue = "NULL"
 			} else {
 				switch v := v.(type) {
 				case []byte:
-					strValue = string(v)
+					if binary[i] {
+						strValue = encodeBinaryValue(v)
+					} else {
+						strValue = string(v)
+					}
 				default:
 					strValue = fmt.Sprintf("%v", v)
 				}
 			}
 			rowData = append(rowData, strValue)
 		}
-		fmt.Fprintf(conn, "%s\n", strings.Join(rowData, ","))
+		if err := send("%s\n", strings.Join(rowData, ",")); err != nil {
+			abort(err)
+			return
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		fmt.Printf("Error reading SELECT result for slave %s: %v\n", s.addr, err)
+		if err := send("ERROR:%s\n", strings.ReplaceAll(err.Error(), "\n", " ")); err != nil {
+			abort(err)
+		}
+		return
 	}
 
 	// End marker
-	fmt.Fprintf(conn, "END\n")
+	if err := send("END\n"); err != nil {
+		abort(err)
+	}
 }
 
 // Load existing tables from database
@@ -414,70 +1534,209 @@ func loadExistingTables() {
 }
 
 func GetColumnInfo(table string) {
-	attrs := []column{}
-	rows, err := db.Query("DESCRIBE " + table)
+	keys, attrs, err := liveColumns(table)
 	if err != nil {
 		log.Fatalf("Describe error: %v", err)
 	}
+	tableKeys[table] = keys
+	tableAttributes[table] = attrs
+}
+
+// Tables altered by slaves, whose cached columns the menu reads again
+// before its next use of them. The caches belong to the menu goroutine.
+var (
+	staleColumnsMu sync.Mutex
+	staleColumns   = make(map[string]bool)
+)
+
+func markColumnsStale(table string) {
+	staleColumnsMu.Lock()
+	defer staleColumnsMu.Unlock()
+	staleColumns[table] = true
+}
+
+// Whether the table's cached columns were marked stale, clearing the mark
+func takeColumnsStale(table string) bool {
+	staleColumnsMu.Lock()
+	defer staleColumnsMu.Unlock()
+	stale := staleColumns[table]
+	delete(staleColumns, table)
+	return stale
+}
+
+// Columns of a table as the server has them now: the primary key, and
+// every other column
+func liveColumns(table string) (keys, attrs []column, err error) {
+	keyNames, err := primaryKeyColumns(table)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, err := db.Query("DESCRIBE " + table)
+	if err != nil {
+		return nil, nil, err
+	}
 	defer rows.Close()
 
-	var field, colType, nul, key, defVal, extra string
+	attrs = []column{}
+	keyByName := make(map[string]column, len(keyNames))
+	var field, colType, nul, key, extra string
+	var defVal sql.NullString
 	for rows.Next() {
 		rows.Scan(&field, &colType, &nul, &key, &defVal, &extra)
-		if field == "id" {
+		c := column{
+			Name:          field,
+			Type:          columnTypeIndex(colType),
+			Nullable:      nul == "YES",
+			AutoIncrement: strings.Contains(strings.ToLower(extra), "auto_increment"),
+		}
+		if slices.Contains(keyNames, field) {
+			keyByName[field] = c
 			continue
 		}
-		idx := 0
-		for i, t := range data_type {
-			if strings.Contains(colType, strings.ToLower(t)) {
-				idx = i
-				break
-			}
+		attrs = append(attrs, c)
+	}
+	for _, name := range keyNames {
+		keys = append(keys, keyByName[name])
+	}
+	return keys, attrs, rows.Err()
+}
+
+// Differences between the cached columns and the live ones
+func columnDrift(cached, live []column) []string {
+	var diffs []string
+	liveByName := make(map[string]column, len(live))
+	for _, c := range live {
+		liveByName[c.Name] = c
+	}
+	cachedNames := make(map[string]bool, len(cached))
+	for _, c := range cached {
+		cachedNames[c.Name] = true
+		l, ok := liveByName[c.Name]
+		switch {
+		case !ok:
+			diffs = append(diffs, fmt.Sprintf("column %s no longer exists", c.Name))
+		case l.Type != c.Type:
+			diffs = append(diffs, fmt.Sprintf("column %s is now %s", c.Name, data_type[l.Type]))
+		case l.Nullable != c.Nullable:
+			diffs = append(diffs, fmt.Sprintf("column %s changed nullability", c.Name))
 		}
-		attrs = append(attrs, column{Name: field, Type: idx})
 	}
-	tableAttributes[table] = attrs
+	for _, c := range live {
+		if !cachedNames[c.Name] {
+			diffs = append(diffs, fmt.Sprintf("column %s was added", c.Name))
+		}
+	}
+	return diffs
 }
 
+// Check the cached columns of the current table against the server before
+// building a statement from them. If the schema changed behind our back
+// the operator can refresh the cache and carry on; false means give up.
+func checkColumnCache() bool {
+	keys, live, err := liveColumns(currentTable)
+	if err != nil {
+		fmt.Printf("Error reading columns of %s: %v\n", currentTable, err)
+		return false
+	}
+	if takeColumnsStale(currentTable) {
+		// Changed through this program by a slave, not behind its back
+		tableKeys[currentTable] = keys
+		tableAttributes[currentTable] = live
+		return true
+	}
+	diffs := columnDrift(tableAttributes[currentTable], live)
+	diffs = append(diffs, columnDrift(tableKeys[currentTable], keys)...)
+	if len(diffs) == 0 {
+		return true
+	}
+
+	fmt.Printf("The cached columns of '%s' don't match the database:\n", currentTable)
+	for _, d := range diffs {
+		fmt.Println("  -", d)
+	}
+	if !confirm("Refresh the cached columns and continue? (y/n): ") {
+		fmt.Println("Cancelled, the table was changed outside this program.")
+		return false
+	}
+	tableKeys[currentTable] = keys
+	tableAttributes[currentTable] = live
+	return true
+}
+
+// The name is bound, not matched with LIKE, so _ and % in it are literal
 func TableExists(tableName string) bool {
-	query := fmt.Sprintf("SHOW TABLES LIKE '%s'", tableName)
-	row := db.QueryRow(query)
+	row := db.QueryRow(`SELECT TABLE_NAME FROM information_schema.TABLES
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?`, tableName)
 	var existingTable string
 	err := row.Scan(&existingTable)
 	return err == nil
 }
 
+// Ask for the precision and scale of a DECIMAL column
+func readDecimalType() string {
+	fmt.Print("Enter precision (total digits, 1-65): ")
+	p := readChoice()
+	for p < 1 || p > 65 {
+		fmt.Print("Precision must be between 1 and 65: ")
+		p = readChoice()
+	}
+	fmt.Printf("Enter scale (digits after the point, 0-%d): ", min(p, 30))
+	s := readChoice()
+	for s < 0 || s > min(p, 30) {
+		fmt.Printf("Scale must be between 0 and %d: ", min(p, 30))
+		s = readChoice()
+	}
+	return fmt.Sprintf("DECIMAL(%d,%d)", p, s)
+}
+
 func CreateTable(name string) {
-	var num int
 	fmt.Print("\nEnter number of attributes: ")
-	fmt.Scanln(&num)
+	num := readChoice()
+	for num < 0 {
+		fmt.Print("Number of attributes can't be negative, enter again: ")
+		num = readChoice()
+	}
 
 	attrs := make([]column, num)
 
 	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id INT PRIMARY KEY AUTO_INCREMENT", name)
 	for i := 0; i < num; i++ {
 		fmt.Printf("\nEnter name for column %d: ", i+1)
-		fmt.Scanln(&attrs[i].Name)
+		attrs[i].Name = strings.TrimSpace(readLine())
 
 		fmt.Println("Choose data type:")
 		for j, dt := range data_type {
 			fmt.Printf("%d: %s\n", (j + 1), dt)
 		}
 		fmt.Print("Enter choice: ")
-		var x int
-		fmt.Scanln(&x)
+		x := readChoice()
+		for x < 1 || x > len(data_type) {
+			fmt.Printf("Choose a type between 1 and %d: ", len(data_type))
+			x = readChoice()
+		}
 		attrs[i].Type = x - 1
+		attrs[i].Nullable = true // columns are created without NOT NULL
 
-		query += fmt.Sprintf(", %s %s", attrs[i].Name, data_type[attrs[i].Type])
+		colType := data_type[attrs[i].Type]
+		if colType == "DECIMAL" {
+			colType = readDecimalType()
+		}
+		query += fmt.Sprintf(", %s %s", attrs[i].Name, colType)
 	}
 	query += ")"
 
+	defer beginWrite()()
+
 	_, err := db.Exec(query)
 	if err != nil {
 		log.Fatalf("Error creating table: %v", err)
 	}
 	fmt.Println("Table created successfully.")
+	tableKeys[name] = []column{{Name: "id", Type: typeInt, AutoIncrement: true}}
 	tableAttributes[name] = attrs
+	if !containsTable(name) {
+		tables = append(tables, name)
+	}
 
 	// Notify slaves about the new table
 	notifySlaves("Table created: " + name)
@@ -503,31 +1762,30 @@ func CreateTable(name string) {
 	encodedDef = strings.ReplaceAll(encodedDef, "\r", " ")
 
 	// Send create table query to all slaves for replication
-	mu.Lock()
-	for _, conn := range slaves {
-		fmt.Fprintf(conn, "create_table:%s\n", encodedDef)
-	}
-	mu.Unlock()
+	broadcastTableDef(encodedDef)
+	recordChange(0, tableDefinition, "master")
 }
 
-func notifySlaves(message string) {
-	mu.Lock()
-	defer mu.Unlock()
-	for addr, conn := range slaves {
-		_, err := fmt.Fprintf(conn, "notification:%s\n", message)
-		if err != nil {
-			fmt.Printf("Failed to notify slave %s: %v\n", addr, err)
+func containsTable(name string) bool {
+	for _, table := range tables {
+		if table == name {
+			return true
 		}
 	}
+	return false
 }
 
-func DropTable() {
-	fmt.Printf("Are you sure you want to drop table '%s'? (y/n): ", currentTable)
-	var confirm string
-	fmt.Scanln(&confirm)
+func notifySlaves(message string) {
+	broadcast(nil, "notification:%s\n", message)
+}
 
-	if strings.ToLower(confirm) == "y" {
+func DropTable() {
+	if txOpen() {
+		return
+	}
+	if confirm(fmt.Sprintf("Are you sure you want to drop table '%s'? (y/n): ", currentTable)) {
 		dropQuery := "DROP TABLE " + currentTable
+		defer beginWrite()()
 		_, err := db.Exec(dropQuery)
 		if err != nil {
 			fmt.Printf("Error dropping table: %v\n", err)
@@ -541,154 +1799,252 @@ func DropTable() {
 				}
 			}
 			delete(tableAttributes, currentTable)
+			delete(tableKeys, currentTable)
+			softDeleteMu.Lock()
+			delete(softDeleteTables, currentTable)
+			softDeleteMu.Unlock()
+			setConflictPolicy(currentTable, conflictError)
 
 			// Notify slaves about the dropped table
 			notifySlaves("Table dropped: " + currentTable)
 
-			// Send drop table query to all slaves for replication
-			mu.Lock()
-			for _, conn := range slaves {
-				fmt.Fprintf(conn, "replicate_query:%s\n", dropQuery)
-			}
-			mu.Unlock()
+			// Send drop table query to all slaves for replication
+			replicate(nil, dropQuery)
+		}
+	} else {
+		fmt.Println("Table drop cancelled.")
+	}
+}
+
+// The drop only goes ahead if the operator typed the exact database name
+func confirmDropName(input, name string) bool {
+	return strings.TrimSpace(input) == name
+}
+
+// How long DropDatabase waits for each slave to be sent the drop
+const dropFlushTimeout = 5 * time.Second
+
+func DropDatabase() {
+	if txOpen() {
+		return
+	}
+	fmt.Printf("This drops database '%s' here AND on every connected slave.\n", dbName)
+	fmt.Print("Type the database name to confirm: ")
+	typed := readLine()
+
+	if !confirmDropName(typed, dbName) {
+		fmt.Println("Name did not match. Database drop cancelled.")
+		return
+	}
+
+	if confirm("Export a SQL dump first? (y/n): ") {
+		path := fmt.Sprintf("%s-%s.sql", dbName, time.Now().Format("20060102-150405"))
+		if err := exportSQLDump(path); err != nil {
+			fmt.Printf("Error exporting dump, database drop cancelled: %v\n", err)
+			return
 		}
-	} else {
-		fmt.Println("Table drop cancelled.")
+		fmt.Printf("Dump written to %s\n", path)
+		auditLog("export_dump", path)
 	}
-}
 
-func DropDatabase() {
-	fmt.Printf("Are you sure you want to drop database '%s'? (y/n): ", dbName)
-	var confirm string
-	fmt.Scanln(&confirm)
+	mu.Lock()
+	numSlaves := len(slaves)
+	mu.Unlock()
+	auditLog("drop_database", fmt.Sprintf("%s (%d slaves connected)", dbName, numSlaves))
 
-	if strings.ToLower(confirm) == "y" {
-		dropQuery := "DROP DATABASE " + dbName
-		_, err := db.Exec(dropQuery)
-		if err != nil {
-			fmt.Printf("Error dropping database: %v\n", err)
-		} else {
-			fmt.Println("Database dropped successfully.")
+	// No sync or forwarded write runs between the drop and its notification
+	defer beginWrite()()
 
-			// Notify slaves to drop their copies of the database
-			mu.Lock()
-			for _, conn := range slaves {
-				fmt.Fprintf(conn, "drop_database:%s\n", dbName)
-			}
-			mu.Unlock()
+	dropQuery := "DROP DATABASE " + dbName
+	_, err := db.Exec(dropQuery)
+	if err != nil {
+		fmt.Printf("Error dropping database: %v\n", err)
+		return
+	}
+	fmt.Println("Database dropped successfully.")
 
-			// Close all slave connections
-			mu.Lock()
-			for addr, conn := range slaves {
-				conn.Close()
-				fmt.Printf("Closed connection to slave: %s\n", addr)
-			}
-			slaves = make(map[string]net.Conn)
-			mu.Unlock()
+	// Notify slaves to drop their copies of the database, behind the live
+	// frames already queued for them
+	broadcast(nil, "drop_database:%s\n", dbName)
 
-			os.Exit(0)
+	// Close all slave connections once they have it
+	mu.Lock()
+	for addr, s := range slaves {
+		if !s.flushLive(dropFlushTimeout) {
+			fmt.Printf("Slave %s may not have received the drop\n", addr)
 		}
-	} else {
-		fmt.Println("Database drop cancelled.")
+		s.close()
+		fmt.Printf("Closed connection to slave: %s\n", addr)
 	}
+	slaves = make(map[string]*slaveConn)
+	mu.Unlock()
+
+	closeListener()
+	exitProgram(0)
 }
 
 func InsertRecord() {
-	attrs := tableAttributes[currentTable]
-	query := fmt.Sprintf("INSERT INTO %s (", currentTable)
-	values := make([]interface{}, len(attrs))
-
-	for i, attr := range attrs {
-		query += attr.Name
-		if i != len(attrs)-1 {
-			query += ", "
-		} else {
-			query += ") VALUES ("
-		}
+	if !checkColumnCache() {
+		return
 	}
-
-	for i := range attrs {
-		query += "?"
-		if i != len(attrs)-1 {
-			query += ", "
-		} else {
-			query += ")"
+	// Key columns MySQL doesn't generate are asked for first
+	fields := []column{}
+	for _, key := range tableKeys[currentTable] {
+		if !key.AutoIncrement {
+			fields = append(fields, key)
 		}
 	}
+	fields = append(fields, tableAttributes[currentTable]...)
 
-	for i, attr := range attrs {
-		fmt.Printf("Enter value for %s: ", attr.Name)
-		switch data_type[attr.Type] {
-		case "INT":
-			var v int
-			fmt.Scanln(&v)
-			values[i] = v
-		case "FLOAT":
-			var v float64
-			fmt.Scanln(&v)
-			values[i] = v
-		default:
-			var v string
-			fmt.Scanln(&v)
-			values[i] = v
+	// Only columns given a value are listed, the rest get their defaults
+	columns := []string{}
+	values := []interface{}{}
+	placeholders := []string{}
+	for _, attr := range fields {
+		v, ok := readInsertValue(attr)
+		if !ok {
+			continue
 		}
+		columns = append(columns, attr.Name)
+		values = append(values, v)
+		placeholders = append(placeholders, "?")
 	}
 
-	_, err := db.Exec(query, values...)
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		currentTable, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+
+	defer beginWrite()()
+
+	_, err := execWrite(query, values...)
 	if err != nil {
 		fmt.Printf("Insert error: %v\n", err)
 	} else {
 		fmt.Println("Record inserted successfully.")
 
-		// Prepare query with actual values for slaves
-		replicaQuery := fmt.Sprintf("INSERT INTO %s (", currentTable)
-		valuesList := make([]string, len(attrs))
+		// Prepare query with actual values for slaves, listing the same
+		// columns so the slaves fill in the same defaults
+		replicaQuery := conflictQuery(buildInsert(replicaDialect, currentTable, columns, values))
 
-		for i, attr := range attrs {
-			replicaQuery += attr.Name
-			if i != len(attrs)-1 {
-				replicaQuery += ", "
-			} else {
-				replicaQuery += ") VALUES ("
-			}
+		// Send insert query to all slaves for replication
+		replicateWrite(replicaQuery)
+	}
+}
 
-			// Format the value
-			var strVal string
-			if values[i] == nil {
-				strVal = "NULL"
-			} else {
-				switch v := values[i].(type) {
-				case string:
-					strVal = "'" + strings.ReplaceAll(v, "'", "''") + "'"
-				default:
-					strVal = fmt.Sprintf("%v", v)
-				}
-			}
-			valuesList[i] = strVal
+// Prompt for a column value. A blank line leaves the column out of the
+// INSERT (ok is false) so it gets its default, and NULL inserts an actual
+// SQL NULL into nullable columns.
+func readInsertValue(attr column) (value interface{}, ok bool) {
+	label := attr.Name
+	if d, isDate := dateLayouts[data_type[attr.Type]]; isDate {
+		label += " as " + d.hint
+	}
+	for {
+		if attr.Nullable {
+			fmt.Printf("Enter value for %s (blank for default, NULL for NULL): ", label)
+		} else {
+			fmt.Printf("Enter value for %s (blank for default): ", label)
 		}
+		// The whole line, as values like "John Doe" or a datetime contain spaces
+		input := strings.TrimSpace(readLine())
 
-		replicaQuery += strings.Join(valuesList, ", ") + ")"
+		if input == "" {
+			return nil, false
+		}
 
-		// Send insert query to all slaves for replication
-		mu.Lock()
-		for _, conn := range slaves {
-			fmt.Fprintf(conn, "replicate_query:%s\n", replicaQuery)
+		if strings.EqualFold(input, "NULL") {
+			if !attr.Nullable {
+				fmt.Printf("Column %s does not allow NULL\n", attr.Name)
+				continue
+			}
+			return nil, true
 		}
-		mu.Unlock()
+
+		v, ok, problem := parseColumnValue(attr, input)
+		if !ok {
+			fmt.Printf("Value for %s %s\n", attr.Name, problem)
+			continue
+		}
+		return v, true
+	}
+}
+
+// The value typed for a column, parsed for its type. False with what is
+// wrong with it if it isn't one.
+func parseColumnValue(attr column, input string) (interface{}, bool, string) {
+	switch data_type[attr.Type] {
+	case "INT":
+		v, ok := parseIntValue(input)
+		return v, ok, "is not a whole number"
+	case "FLOAT":
+		v, ok := parseFloatValue(input)
+		return v, ok, "is not a number"
+	case "JSON":
+		// Bind the text exactly as entered
+		return input, json.Valid([]byte(input)), "is not valid JSON"
+	case "BOOLEAN":
+		v, ok := parseBoolValue(input)
+		return v, ok, "must be true, false, 1 or 0"
+	case "DECIMAL":
+		return decimalValue(input), decimalRe.MatchString(input), "is not a decimal number"
+	case "DATE", "DATETIME":
+		v, ok := parseDateValue(data_type[attr.Type], input)
+		return v, ok, "is not a valid " + dateLayouts[data_type[attr.Type]].hint
+	}
+	return input, true, ""
+}
+
+// A DATE or DATETIME value as MySQL writes it, false if it isn't one. A
+// date without a time is midnight.
+func parseDateValue(typ, input string) (string, bool) {
+	layout := dateLayouts[typ].layout
+	t, err := time.Parse(layout, input)
+	if err != nil && typ == "DATETIME" {
+		t, err = time.Parse(dateLayouts["DATE"].layout, input)
+	}
+	if err != nil {
+		return "", false
+	}
+	return t.Format(layout), true
+}
+
+// An INT value, false if the input isn't a whole number
+func parseIntValue(input string) (int, bool) {
+	v, err := strconv.Atoi(input)
+	return v, err == nil
+}
+
+// A FLOAT value, false if the input isn't a finite number
+func parseFloatValue(input string) (float64, bool) {
+	v, err := strconv.ParseFloat(input, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, false
 	}
+	return v, true
+}
+
+// A BOOLEAN value as the 1 or 0 MySQL stores, false if it isn't one
+func parseBoolValue(input string) (int, bool) {
+	switch strings.ToLower(input) {
+	case "true", "1":
+		return 1, true
+	case "false", "0":
+		return 0, true
+	}
+	return 0, false
 }
 
 func UpdateRecord() {
+	if !checkColumnCache() {
+		return
+	}
 	attrs := tableAttributes[currentTable]
-	fmt.Print("Enter ID to update: ")
-	var id int
-	fmt.Scanln(&id)
-
-	// First verify the record exists
-	row := db.QueryRow(fmt.Sprintf("SELECT id FROM %s WHERE id = ?", currentTable), id)
-	var foundID int
-	if err := row.Scan(&foundID); err != nil {
-		fmt.Printf("Record with ID %d not found\n", id)
+	keys := tableKeys[currentTable]
+	if len(keys) == 0 {
+		fmt.Printf("Table %s has no primary key, its records can't be picked to update\n", currentTable)
+		return
+	}
+	keyValues, ok := readKeyValues(keys, "update")
+	if !ok {
 		return
 	}
 
@@ -698,13 +2054,19 @@ func UpdateRecord() {
 
 	for _, attr := range attrs {
 		fmt.Printf("Enter new value for %s (leave empty to keep current): ", attr.Name)
-		var input string
-		fmt.Scanln(&input)
+		// Read like readInsertValue, an empty line keeps the field
+		input := strings.TrimSpace(readLine())
 
 		if input == "" {
 			continue // skip updating this field
 		}
 
+		v, ok, problem := parseColumnValue(attr, input)
+		if !ok {
+			fmt.Printf("Value for %s %s, keeping current value\n", attr.Name, problem)
+			continue
+		}
+
 		if setClause != "" {
 			setClause += ", "
 		}
@@ -712,18 +2074,7 @@ func UpdateRecord() {
 		setClause += fmt.Sprintf("%s = ?", attr.Name)
 		updateFields = append(updateFields, attr.Name)
 
-		switch data_type[attr.Type] {
-		case "INT":
-			var v int
-			fmt.Sscanf(input, "%d", &v)
-			values = append(values, v)
-		case "FLOAT":
-			var v float64
-			fmt.Sscanf(input, "%f", &v)
-			values = append(values, v)
-		default:
-			values = append(values, input)
-		}
+		values = append(values, v)
 	}
 
 	if setClause == "" {
@@ -731,91 +2082,111 @@ func UpdateRecord() {
 		return
 	}
 
-	query := fmt.Sprintf("UPDATE %s SET %s WHERE id = ?", currentTable, setClause)
-	values = append(values, id)
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s", currentTable, setClause, keyCondition(keys))
+	values = append(values, keyValues...)
 
-	_, err := db.Exec(query, values...)
+	defer beginWrite()()
+
+	result, err := execWrite(query, values...)
 	if err != nil {
 		fmt.Printf("Update error: %v\n", err)
+		return
+	}
+
+	// Only replicate if the UPDATE itself changed a row. Checking for the key
+	// beforehand would race with a concurrent delete.
+	affected, err := result.RowsAffected()
+	if err != nil {
+		fmt.Printf("Error getting affected rows: %v\n", err)
+	} else if affected == 0 {
+		fmt.Printf("No record with %s was changed (not found or values unchanged)\n", describeKey(keys, keyValues))
 	} else {
 		fmt.Println("Record updated successfully.")
 
 		// Prepare the replica query with actual values
-		replicaSetClause := ""
-
-		for i, fieldName := range updateFields {
-			if i > 0 {
-				replicaSetClause += ", "
-			}
-
-			// Format the value
-			var strVal string
-			v := values[i]
-			if v == nil {
-				strVal = "NULL"
-			} else {
-				switch v := v.(type) {
-				case string:
-					strVal = "'" + strings.ReplaceAll(v, "'", "''") + "'"
-				default:
-					strVal = fmt.Sprintf("%v", v)
-				}
-			}
-
-			replicaSetClause += fmt.Sprintf("%s = %s", fieldName, strVal)
-		}
-
-		replicaQuery := fmt.Sprintf("UPDATE %s SET %s WHERE id = %d", currentTable, replicaSetClause, id)
+		replicaQuery := buildUpdate(replicaDialect, currentTable, updateFields, values[:len(updateFields)],
+			columnNames(keys), keyValues)
 
 		// Send update query to all slaves for replication
-		mu.Lock()
-		for _, conn := range slaves {
-			fmt.Fprintf(conn, "replicate_query:%s\n", replicaQuery)
-		}
-		mu.Unlock()
+		replicateWrite(replicaQuery)
 	}
 }
 
 func DeleteRecord() {
-	fmt.Print("Enter ID to delete: ")
-	var id int
-	fmt.Scanln(&id)
+	keys := tableKeys[currentTable]
+	if len(keys) == 0 {
+		fmt.Printf("Table %s has no primary key, its records can't be picked to delete\n", currentTable)
+		return
+	}
+	keyValues, ok := readKeyValues(keys, "delete")
+	if !ok {
+		return
+	}
 
-	query := fmt.Sprintf("DELETE FROM %s WHERE id = ?", currentTable)
-	_, err := db.Exec(query, id)
+	defer beginWrite()()
+
+	if isSoftDelete(currentTable) {
+		deletedAt := softDeleteTimestamp()
+		query := fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s AND %s IS NULL",
+			currentTable, softDeleteColumn, keyCondition(keys), softDeleteColumn)
+		result, err := execWrite(query, append([]interface{}{deletedAt}, keyValues...)...)
+		if err != nil {
+			fmt.Printf("Delete error: %v\n", err)
+			return
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			fmt.Println("No record with that key, or it is already deleted.")
+			return
+		}
+		fmt.Println("Record marked as deleted.")
+
+		replicaQuery := buildUpdate(replicaDialect, currentTable,
+			[]string{softDeleteColumn}, []interface{}{deletedAt}, columnNames(keys), keyValues)
+		replicateWrite(replicaQuery)
+		return
+	}
+
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s", currentTable, keyCondition(keys))
+	_, err := execWrite(query, keyValues...)
 	if err != nil {
 		fmt.Printf("Delete error: %v\n", err)
 	} else {
 		fmt.Println("Record deleted successfully.")
 
 		// Send delete query to all slaves for replication
-		replicaQuery := fmt.Sprintf("DELETE FROM %s WHERE id = %d", currentTable, id)
+		replicaQuery := buildDelete(replicaDialect, currentTable, columnNames(keys), keyValues)
 
-		mu.Lock()
-		for _, conn := range slaves {
-			fmt.Fprintf(conn, "replicate_query:%s\n", replicaQuery)
-		}
-		mu.Unlock()
+		replicateWrite(replicaQuery)
 	}
 }
 
 func DisplayRecords() {
-	attrs := tableAttributes[currentTable]
-	rows, err := db.Query("SELECT * FROM " + currentTable)
+	// Soft-deleted rows are kept in the table but not listed
+	query := "SELECT * FROM " + currentTable
+	if isSoftDelete(currentTable) {
+		query += " WHERE " + softDeleteColumn + " IS NULL"
+	}
+	rows, err := db.Query(query + displayOrder(currentTable))
 	if err != nil {
 		fmt.Printf("Error retrieving records: %v\n", err)
 		return
 	}
 	defer rows.Close()
 
-	fmt.Print("id\t")
-	for _, attr := range attrs {
-		fmt.Printf("%s\t", attr.Name)
+	// Headers come from the result itself, so they line up with the data
+	// even when the cached attributes are stale
+	columns, err := rows.Columns()
+	if err != nil {
+		fmt.Printf("Error getting columns: %v\n", err)
+		return
+	}
+	for _, col := range columns {
+		fmt.Printf("%s\t", col)
 	}
 	fmt.Println("\n-----------------------------------------------------------")
 
-	cols := make([]interface{}, len(attrs)+1)
-	colPtrs := make([]interface{}, len(attrs)+1)
+	cols := make([]interface{}, len(columns))
+	colPtrs := make([]interface{}, len(columns))
 
 	for i := range cols {
 		colPtrs[i] = &cols[i]
@@ -832,6 +2203,8 @@ func DisplayRecords() {
 			switch val := col.(type) {
 			case []byte:
 				fmt.Printf("%s\t", string(val))
+			case nil:
+				fmt.Printf("NULL\t")
 			default:
 				fmt.Printf("%v\t", val)
 			}
@@ -841,9 +2214,11 @@ func DisplayRecords() {
 }
 
 func createNewTable() {
+	if txOpen() {
+		return
+	}
 	fmt.Print("\nEnter new table name: ")
-	var tableName string
-	fmt.Scanln(&tableName)
+	tableName := strings.TrimSpace(readLine())
 	if tableName == "" {
 		fmt.Println("Table name cannot be empty")
 		return
@@ -855,7 +2230,6 @@ func createNewTable() {
 	}
 
 	CreateTable(tableName)
-	tables = append(tables, tableName)
 	currentTable = tableName
 	tableMenu()
 }
@@ -867,8 +2241,7 @@ func selectTable() {
 	}
 	fmt.Print("Select table (number): ")
 
-	var choice int
-	fmt.Scanln(&choice)
+	choice := readChoice()
 
 	if choice < 1 || choice > len(tables) {
 		fmt.Println("Invalid table selection")
@@ -887,11 +2260,15 @@ func tableMenu() {
 		fmt.Println("3. Delete Record")
 		fmt.Println("4. Display Records")
 		fmt.Println("5. Drop Table")
-		fmt.Println("6. Back to Main Menu")
+		fmt.Println("6. Toggle Soft Delete")
+		fmt.Println("7. Modify Column Type")
+		fmt.Println("8. Set Insert Conflict Policy")
+		fmt.Println("9. Bulk Update or Delete by Filter")
+		fmt.Println("10. Add Column")
+		fmt.Println("11. Back to Main Menu")
 		fmt.Print("Enter choice: ")
 
-		var choice int
-		fmt.Scanln(&choice)
+		choice := readChoice()
 
 		switch choice {
 		case 1:
@@ -906,6 +2283,16 @@ func tableMenu() {
 			DropTable()
 			return
 		case 6:
+			ToggleSoftDelete()
+		case 7:
+			ModifyColumn()
+		case 8:
+			SetConflictPolicy()
+		case 9:
+			BulkOperation()
+		case 10:
+			AddColumn()
+		case 11:
 			return
 		default:
 			fmt.Println("Invalid choice")
@@ -913,26 +2300,217 @@ func tableMenu() {
 	}
 }
 
+// Backoff limits for the accept loop and for recreating a dead listener
+const maxAcceptBackoff = time.Second
+const listenRetries = 5
+
+// Listen on this Unix domain socket instead of the TCP port (-listen-unix)
+var listenUnix string
+
+// TCP port for slaves (-listen-port)
+var listenPort = 9999
+
+var (
+	listenerMu     sync.Mutex
+	activeListener net.Listener
+	shuttingDown   bool
+)
+
+// Open the slave listener and remember it for closeListener
+func listen() (net.Listener, string, error) {
+	network, addr, desc := "tcp", fmt.Sprintf(":%d", listenPort), fmt.Sprintf("port %d", listenPort)
+	if listenUnix != "" {
+		network, addr, desc = "unix", listenUnix, "unix socket "+listenUnix
+		removeStaleSocket(listenUnix)
+	}
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, desc, err
+	}
+	listenerMu.Lock()
+	activeListener = ln
+	listenerMu.Unlock()
+	return ln, desc, nil
+}
+
+// A socket file left behind by a master that didn't shut down cleanly would
+// make Listen fail. It is only removed if nothing answers on it.
+func removeStaleSocket(path string) {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return
+	}
+	fmt.Printf("Removing stale socket %s\n", path)
+	os.Remove(path)
+}
+
+// Stop listening on shutdown. Closing a Unix listener also removes its
+// socket file.
+func closeListener() {
+	listenerMu.Lock()
+	defer listenerMu.Unlock()
+	shuttingDown = true
+	if activeListener != nil {
+		activeListener.Close()
+	}
+}
+
 func startServer() {
-	ln, err := net.Listen("tcp", ":9999")
+	ln, desc, err := listen()
 	if err != nil {
 		fmt.Println("Error starting server:", err)
 		return
 	}
-	fmt.Println("Master server started on port 9999")
+	fmt.Printf("Master server started on %s\n", desc)
+
+	for {
+		err := acceptLoop(ln)
+		ln.Close()
+		listenerMu.Lock()
+		stopping := shuttingDown
+		listenerMu.Unlock()
+		if stopping {
+			return
+		}
+		fmt.Printf("Listener failed, recreating it: %v\n", err)
+
+		// The listener is gone, try to get a new one a few times
+		ln = nil
+		backoff := time.Second
+		for attempt := 1; attempt <= listenRetries; attempt++ {
+			time.Sleep(backoff)
+			ln, _, err = listen()
+			if err == nil {
+				break
+			}
+			fmt.Printf("Listen attempt %d/%d failed: %v\n", attempt, listenRetries, err)
+			backoff *= 2
+		}
+		if ln == nil {
+			log.Fatalf("Could not recreate the slave listener on %s after %d attempts: %v", desc, listenRetries, err)
+		}
+		fmt.Printf("Master server listening on %s again\n", desc)
+	}
+}
 
+// Accept slaves until the listener fails for good. Temporary errors (out of
+// file descriptors, aborted handshakes, timeouts) are retried with a growing
+// delay instead of spinning.
+func acceptLoop(ln net.Listener) error {
+	var backoff time.Duration
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
+			if !isTemporaryAcceptError(err) {
+				return err
+			}
+			if backoff == 0 {
+				backoff = 5 * time.Millisecond
+			} else if backoff *= 2; backoff > maxAcceptBackoff {
+				backoff = maxAcceptBackoff
+			}
+			fmt.Printf("Accept error, retrying in %v: %v\n", backoff, err)
+			time.Sleep(backoff)
 			continue
 		}
+		backoff = 0
 		go handleSlaveConnection(conn)
 	}
 }
 
-func main() {
-	fmt.Print("\nEnter your database name: ")
-	fmt.Scanln(&dbName)
+func isTemporaryAcceptError(err error) bool {
+	if errors.Is(err, net.ErrClosed) {
+		return false
+	}
+	if errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ENOBUFS) || errors.Is(err, syscall.ENOMEM) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// Entry point for "ddb master"
+func masterMain(args []string) {
+	fs := flag.NewFlagSet("master", flag.ContinueOnError)
+	showVersion := fs.Bool("version", false, "print version and exit")
+	fs.IntVar(&selectLimit, "select-limit", selectLimit, "default row limit for forwarded SELECTs without a LIMIT (0 for none)")
+	fs.IntVar(&maxMessageSize, "max-message-size", MaxMessageSize, "largest protocol message accepted, in bytes")
+	fs.IntVar(&breakerThreshold, "breaker-threshold", breakerThreshold, "consecutive slave apply errors before replication to it stops (0 to disable)")
+	fs.DurationVar(&breakerWindow, "breaker-window", breakerWindow, "time window for counting consecutive slave apply errors")
+	fs.BoolVar(&dryRun, "dry-run", false, "run local operations but only print what would be replicated to slaves")
+	fs.DurationVar(&idleTimeout, "idle-timeout", 0, "exit when there is no input for this long (0 to wait forever)")
+	fs.BoolVar(&deferIndexes, "defer-indexes", false, "during initial sync, create secondary indexes on slaves after the rows are loaded")
+	fs.BoolVar(&ignoreSlaveSpace, "ignore-slave-space", false, "sync slaves that report too little free disk space anyway, with a warning")
+	fs.IntVar(&maxConcurrentSync, "max-concurrent-sync", 0, "most initial syncs run at once, other new slaves wait for one to finish (0 for no limit)")
+	fs.IntVar(&maxSlavesPerIP, "max-slaves-per-ip", 0, "most slave connections accepted from one IP address at once (0 for no limit)")
+	fs.IntVar(&listenPort, "listen-port", listenPort, "TCP port to listen for slaves on")
+	fs.StringVar(&listenUnix, "listen-unix", "", "listen for slaves on this Unix domain socket instead of the TCP port")
+	fs.IntVar(&autoIncIncrement, "auto-increment-increment", 0, "auto_increment_increment for this master and its slaves (0 for the server default)")
+	fs.IntVar(&autoIncOffset, "auto-increment-offset", 0, "auto_increment_offset for this master and its slaves")
+	fs.StringVar(&replicationEngine, "replication-engine", engineStatement, "how row changes are replicated: statement, or binlog to tail MySQL's binary log")
+	fs.Var(&replicaDialect, "replica-dialect", "how replicated statements quote names and values: mysql, or ansi/sqlite for slaves with standard SQL quoting")
+	fs.UintVar(&binlogServerID, "binlog-server-id", 4201, "server id the binlog engine connects to MySQL with, unique among its replicas")
+	forwardAllow := fs.String("forward-allow", "", "statement types slaves may forward per operation, e.g. insert=INSERT+REPLACE,select=SELECT+WITH")
+	dumpSchema := fs.String("dump-schema", "", "write the database's CREATE statements to this file and exit, without serving slaves")
+	autoIncPeers := fs.String("auto-increment-peers", "", "comma separated auto-increment offsets of the other masters, checked for clashes")
+	fs.StringVar(&dataDir, "data-dir", "", "directory for the state kept between runs, such as the audit log (default: a directory per listen port under "+defaultDataDir()+")")
+	printConfigFlag := fs.Bool("print-config", false, "print the effective configuration and exit")
+	configFile := addConfigFlag(fs)
+	addMySQLFlags(fs, true)
+	parseFlags(fs, args)
+	configFlags = fs
+	if *configFile != "" {
+		if err := applyConfigFile(fs, *configFile); err != nil {
+			log.Fatalf("Error reading config file: %v", err)
+		}
+	}
+	if listenUnix != "" {
+		useInstanceDataDir("master-" + filepath.Base(listenUnix))
+	} else {
+		useInstanceDataDir(fmt.Sprintf("master-%d", listenPort))
+	}
+	if *showVersion {
+		printVersion()
+		return
+	}
+	if *printConfigFlag {
+		printConfig(os.Stdout)
+		return
+	}
+	if err := openDataDir(); err != nil {
+		log.Fatal(err)
+	}
+	migrateLegacyAuditLog()
+	if err := validateAutoIncrement(*autoIncPeers); err != nil {
+		log.Fatal(err)
+	}
+	if err := parseForwardPolicy(*forwardAllow); err != nil {
+		log.Fatal(err)
+	}
+	if replicationEngine != engineStatement && replicationEngine != engineBinlog {
+		log.Fatalf("Unknown replication engine %q, use %s or %s", replicationEngine, engineStatement, engineBinlog)
+	}
+	if dryRun {
+		fmt.Println("DRY RUN: changes are made locally but nothing is sent to slaves")
+	}
+	setupSyncSlots()
+	idleCleanup = func() {
+		closeListener()
+		for _, s := range slaveTargets(nil) {
+			s.close()
+		}
+		if db != nil {
+			db.Close()
+		}
+	}
+
+	dbName = mysqlDatabase()
 	if dbName == "" {
 		log.Fatal("Database name cannot be empty")
 	}
@@ -940,6 +2518,21 @@ func main() {
 
 	// Load existing tables
 	loadExistingTables()
+	loadConflictPolicies()
+
+	if *dumpSchema != "" {
+		if err := exportSchemaDump(*dumpSchema); err != nil {
+			log.Fatalf("Error exporting schema: %v", err)
+		}
+		fmt.Printf("Schema of '%s' (%d tables) written to %s\n", dbName, len(tables), *dumpSchema)
+		return
+	}
+
+	if binlogEngine() {
+		if err := startBinlogTailer(); err != nil {
+			log.Fatalf("Can't start the binlog engine: %v", err)
+		}
+	}
 
 	// Start server in a goroutine
 	go startServer()
@@ -951,11 +2544,37 @@ mainMenu:
 		fmt.Println("2. Select Existing Table")
 		fmt.Println("3. List Connected Slaves")
 		fmt.Println("4. Drop Database")
-		fmt.Println("5. Exit Program")
+		fmt.Println("5. Run Replication Benchmark")
+		fmt.Println("6. Reset Slave Circuit Breaker")
+		fmt.Println("7. Create Trigger or Procedure")
+		fmt.Println("8. Show Replication Changelog")
+		fmt.Println("9. Switch Database")
+		mu.Lock()
+		if acceptSlaves {
+			fmt.Println("10. Accept New Slaves: on")
+		} else {
+			fmt.Println("10. Accept New Slaves: off")
+		}
+		mu.Unlock()
+		fmt.Println("11. Export Sync Snapshot")
+		mu.Lock()
+		if maintenance {
+			fmt.Println("12. Maintenance Mode: on")
+		} else {
+			fmt.Println("12. Maintenance Mode: off")
+		}
+		mu.Unlock()
+		if masterTx != nil {
+			fmt.Printf("13. Commit or Roll Back Transaction (%d pending change(s))\n", len(txStatements))
+		} else {
+			fmt.Println("13. Begin Transaction")
+		}
+		fmt.Println("14. Export Schema as SQL")
+		fmt.Println("15. Show Effective Configuration")
+		fmt.Println("16. Exit Program")
 		fmt.Print("Enter choice: ")
 
-		var choice int
-		fmt.Scanln(&choice)
+		choice := readChoice()
 
 		switch choice {
 		case 1:
@@ -972,15 +2591,70 @@ mainMenu:
 			if len(slaves) == 0 {
 				fmt.Println("No slaves connected")
 			} else {
-				for addr := range slaves {
-					fmt.Println("-", addr)
+				for addr, s := range slaves {
+					version := s.version()
+					if version == "" {
+						version = "unknown"
+					}
+					info := []interface{}{"-", addr, "MySQL", version, s.syncState()}
+					if note := s.clockNote(); note != "" {
+						info = append(info, note)
+					}
+					if note := s.latencyNote(); note != "" {
+						info = append(info, note)
+					}
+					info = append(info, s.ackNoteLocked())
+					if s.isBroken() {
+						info = append(info, "(circuit breaker tripped)")
+					}
+					fmt.Println(info...)
 				}
 			}
 			mu.Unlock()
 		case 4:
 			DropDatabase()
 		case 5:
+			RunBenchmark()
+		case 6:
+			ResetSlaveBreaker()
+		case 7:
+			CreateRoutine()
+		case 8:
+			showChangelog()
+		case 9:
+			SwitchDatabase()
+		case 10:
+			mu.Lock()
+			acceptSlaves = !acceptSlaves
+			if acceptSlaves {
+				fmt.Println("New slaves are accepted again")
+			} else {
+				fmt.Println("New slaves are rejected until this is turned back on, connected slaves keep replicating")
+			}
+			mu.Unlock()
+		case 11:
+			ExportSnapshot()
+		case 12:
+			mu.Lock()
+			maintenance = !maintenance
+			if maintenance {
+				fmt.Println("Maintenance mode on: writes from slaves are rejected, they still receive replication")
+			} else {
+				fmt.Println("Maintenance mode off: slaves can write again")
+			}
+			mu.Unlock()
+		case 13:
+			TransactionMenu()
+		case 14:
+			ExportSchema()
+		case 15:
+			printConfig(os.Stdout)
+		case 16:
+			if masterTx != nil {
+				rollbackTx()
+			}
 			fmt.Println("Exiting program...")
+			closeListener()
 			break mainMenu
 		default:
 			fmt.Println("Invalid choice")
@@ -989,60 +2663,90 @@ mainMenu:
 }
 
 // Send a specific table's schema to a slave
-func sendTableSchema(tableName string, conn net.Conn) {
+func sendTableSchema(tableName string, s *slaveConn) {
 	fmt.Printf("Slave requested schema for table '%s'\n", tableName)
 
-	// Check if table exists
-	if !TableExists(tableName) {
-		fmt.Fprintf(conn, "error:table '%s' does not exist on master\n", tableName)
+	// Slaves missing the same table share one read of it
+	r, joined := sharedTableRead(tableName, s)
+	if r.started {
+		// Live frames are held from the read until the copy is out
+		defer s.releaseLive()
+	}
+	if joined {
+		fmt.Printf("Slave %s gets the copy of '%s' already being read for another slave\n", s.addr, tableName)
+	}
+	if r.missing {
+		s.reply("error:table '%s' does not exist on master\n", tableName)
 		return
 	}
-
-	// Get CREATE TABLE statement
-	var tableDefinition string
-	err := db.QueryRow("SHOW CREATE TABLE "+tableName).Scan(&tableName, &tableDefinition)
-	if err != nil {
-		fmt.Printf("Error getting CREATE TABLE for %s: %v\n", tableName, err)
-		fmt.Fprintf(conn, "error:Failed to get table schema: %v\n", err)
+	if r.err != nil {
+		fmt.Printf("Error getting CREATE TABLE for %s: %v\n", tableName, r.err)
+		s.reply("error:Failed to get schema of table '%s': %v\n", tableName, r.err)
 		return
 	}
 
-	// Log the full CREATE TABLE statement for debugging
-	fmt.Printf("Sending CREATE TABLE statement to slave: %s\n", tableDefinition)
+	for _, problem := range r.problems[s] {
+		fmt.Printf("Copy of '%s' for slave %s: %s\n", tableName, s.addr, problem)
+	}
+	fmt.Printf("Sent schema and data for table '%s' to slave\n", tableName)
+}
 
-	// Send the CREATE TABLE statement to the slave - ensure any newlines are encoded
-	encodedDef := strings.ReplaceAll(tableDefinition, "\n", " ")
-	fmt.Fprintf(conn, "create_table:%s\n", encodedDef)
-	fmt.Printf("Sent schema for table '%s' to slave\n", tableName)
+// Send one batch of rows to a slave as sync_data INSERTs. Returns how many
+// rows were too large to send.
+func sendRowBatch(s *slaveConn, tableName string, columns []string, batch [][]interface{}) int {
+	// Pack the rows into as few INSERTs as fit in the packet limit
+	limit := s.packetLimit() - len("sync_data:\n")
+	if s.encodesPayloads() {
+		// Room for the statements once encoded
+		limit = base64.StdEncoding.DecodedLen(limit - len(binaryValuePrefix))
+	}
+	stmts, tooLarge := buildInsertBatches(replicaDialect, tableName, columns, batch, limit)
+	for _, r := range tooLarge {
+		fmt.Printf("Skipping %d byte row in table %s for slave %s: over the %d byte limit. "+
+			"Raise max_allowed_packet (and --max-message-size) on both sides to at least %d.\n",
+			r.size, tableName, s.addr, limit, r.size)
+	}
 
-	// Now send all data for this table
-	sendTableData(tableName, conn)
+	// Send the INSERT statements to the slave
+	for _, insertQuery := range stmts {
+		s.sendBulk("sync_data:%s\n", s.payload(insertQuery))
+	}
+	return len(tooLarge)
 }
 
 // Send all data from a table to a slave
-func sendTableData(tableName string, conn net.Conn) {
+// Returns what went wrong, nil if every row was sent.
+func sendTableData(ctx context.Context, q queryer, tableName string, s *slaveConn) []string {
+	if s.schemaOnly {
+		return nil
+	}
+
 	// First check if the table has data
 	var rowCount int
-	err := db.QueryRow("SELECT COUNT(*) FROM " + tableName).Scan(&rowCount)
+	err := q.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+tableName).Scan(&rowCount)
 	if err != nil {
 		fmt.Printf("Error counting rows in %s: %v\n", tableName, err)
-		return
+		return []string{fmt.Sprintf("counting rows: %v", err)}
 	}
 
 	if rowCount == 0 {
 		fmt.Printf("Table %s is empty, skipping data sync\n", tableName)
-		return
+		return nil
 	}
 
+	var problems []string
+	scanErrors, oversized := 0, 0
+
 	fmt.Printf("Syncing %d rows from table %s\n", rowCount, tableName)
 
 	// Use batched processing for large tables
 	const batchSize = 100
 	for offset := 0; offset < rowCount; offset += batchSize {
-		rows, err := db.Query(fmt.Sprintf("SELECT * FROM %s LIMIT %d OFFSET %d",
+		rows, err := q.QueryContext(ctx, fmt.Sprintf("SELECT * FROM %s LIMIT %d OFFSET %d",
 			tableName, batchSize, offset))
 		if err != nil {
 			fmt.Printf("Error selecting data from %s: %v\n", tableName, err)
+			problems = append(problems, fmt.Sprintf("rows %d-%d not sent: %v", offset+1, offset+batchSize, err))
 			continue
 		}
 
@@ -1050,65 +2754,42 @@ func sendTableData(tableName string, conn net.Conn) {
 		if err != nil {
 			rows.Close()
 			fmt.Printf("Error getting columns for %s: %v\n", tableName, err)
+			problems = append(problems, fmt.Sprintf("rows %d-%d not sent: %v", offset+1, offset+batchSize, err))
 			continue
 		}
 
-		values := make([]interface{}, len(columns))
-		scanArgs := make([]interface{}, len(columns))
-		for i := range values {
-			scanArgs[i] = &values[i]
-		}
-
 		// For each row in the batch
-		rowNum := 0
+		var batch [][]interface{}
 		for rows.Next() {
-			rowNum++
+			values := make([]interface{}, len(columns))
+			scanArgs := make([]interface{}, len(columns))
+			for i := range values {
+				scanArgs[i] = &values[i]
+			}
+
 			err = rows.Scan(scanArgs...)
 			if err != nil {
 				fmt.Printf("Error scanning row: %v\n", err)
+				scanErrors++
 				continue
 			}
-
-			// Construct INSERT statement
-			insertQuery := fmt.Sprintf("INSERT INTO %s (", tableName)
-			valueStrings := make([]string, len(columns))
-
-			// Add column names
-			for i, colName := range columns {
-				if i > 0 {
-					insertQuery += ", "
-				}
-				insertQuery += colName
-			}
-			insertQuery += ") VALUES ("
-
-			// Convert scan values to strings
-			for i, val := range values {
-				var strVal string
-				if val == nil {
-					strVal = "NULL"
-				} else {
-					switch v := val.(type) {
-					case []byte:
-						strVal = "'" + strings.ReplaceAll(string(v), "'", "''") + "'"
-					case string:
-						strVal = "'" + strings.ReplaceAll(v, "'", "''") + "'"
-					default:
-						strVal = fmt.Sprintf("%v", v)
-					}
-				}
-				valueStrings[i] = strVal
-			}
-
-			// Add value strings
-			insertQuery += strings.Join(valueStrings, ", ") + ")"
-
-			// Send the INSERT statement to the slave
-			fmt.Fprintf(conn, "sync_data:%s\n", insertQuery)
+			batch = append(batch, values)
 		}
 		rows.Close()
 
+		oversized += sendRowBatch(s, tableName, columns, batch)
 		fmt.Printf("Sent batch of %d rows from table %s (offset %d)\n",
-			rowNum, tableName, offset)
+			len(batch), tableName, offset)
+
+		// Give live replication a chance to go out before the next batch
+		s.yieldToLive()
+	}
+
+	if scanErrors > 0 {
+		problems = append(problems, fmt.Sprintf("%d row(s) could not be read", scanErrors))
+	}
+	if oversized > 0 {
+		problems = append(problems, fmt.Sprintf("%d row(s) over the packet limit", oversized))
 	}
+	return problems
 }
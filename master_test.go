@@ -0,0 +1,1619 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// A slaveConn over a pipe, and a scanner reading what it is sent
+func pipeSlave(t *testing.T) (*slaveConn, *messageScanner) {
+	t.Helper()
+	server, client := net.Pipe()
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+	s := newSlaveConn(server)
+	t.Cleanup(func() {
+		s.close()
+		client.Close()
+	})
+	return s, newMessageScanner(client, nil)
+}
+
+// Make s visible to broadcasts for the length of the test
+func registerSlave(t *testing.T, s *slaveConn) {
+	t.Helper()
+	mu.Lock()
+	slaves[s.addr] = s
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(slaves, s.addr)
+		mu.Unlock()
+	})
+}
+
+// The next message sent to the slave
+func nextFrame(t *testing.T, sc *messageScanner) string {
+	t.Helper()
+	if !sc.Scan() {
+		t.Fatalf("no message from the master: %v", sc.Err())
+	}
+	return sc.Text()
+}
+
+func TestHoldLiveKeepsBulkAhead(t *testing.T) {
+	s, sc := pipeSlave(t)
+	s.syncFinished()
+
+	s.holdLive()
+	s.sendLive("replicate_query:1:UPDATE t SET v = 2\n")
+	go func() {
+		s.sendBulk("create_table:CREATE TABLE t (id INT)\n")
+		s.sendBulk("sync_data:INSERT INTO t VALUES (1)\n")
+		s.releaseLive()
+	}()
+
+	want := []string{
+		"create_table:CREATE TABLE t (id INT)",
+		"sync_data:INSERT INTO t VALUES (1)",
+		"replicate_query:1:UPDATE t SET v = 2",
+	}
+	for _, w := range want {
+		if got := nextFrame(t, sc); got != w {
+			t.Fatalf("got %q, want %q", got, w)
+		}
+	}
+}
+
+func TestHoldLiveOutlastsSync(t *testing.T) {
+	s, sc := pipeSlave(t)
+
+	// A table copy that started during the initial sync keeps holding
+	// after the sync is done
+	s.holdLive()
+	s.sendLive("replicate_query:1:DELETE FROM t\n")
+	s.syncFinished()
+
+	s.smu.Lock()
+	held := len(s.held)
+	s.smu.Unlock()
+	if held != 1 {
+		t.Fatalf("%d frame(s) held after the sync, want 1", held)
+	}
+
+	go s.releaseLive()
+	if got := nextFrame(t, sc); got != "replicate_query:1:DELETE FROM t" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestHeldFramesCarryNoOriginTime(t *testing.T) {
+	s, sc := pipeSlave(t)
+	s.originTime = true
+	registerSlave(t, s)
+
+	// Held by the sync, then sent live
+	go func() {
+		replicate(nil, "DELETE FROM t WHERE id = 1")
+		s.syncFinished()
+		replicate(nil, "DELETE FROM t WHERE id = 2")
+	}()
+	if got := nextFrame(t, sc); !strings.HasPrefix(got, "replicate_query:") || strings.Contains(got, "@") {
+		t.Fatalf("held frame %q, want it without the master's time", got)
+	}
+	got := nextFrame(t, sc)
+	pos, _, _ := strings.Cut(strings.TrimPrefix(got, "replicate_query:"), ":")
+	if !strings.Contains(pos, "@") {
+		t.Fatalf("live frame %q, want the master's time", got)
+	}
+}
+
+// Answer TableExists for the given tables
+func existingTables(f *fakeDB, names ...string) {
+	f.on(`^SELECT TABLE_NAME FROM information_schema\.TABLES\s+WHERE TABLE_SCHEMA = DATABASE\(\) AND TABLE_NAME = \?$`,
+		func(args []driver.Value) fakeResult {
+			res := fakeResult{cols: []string{"TABLE_NAME"}}
+			if slices.Contains(names, args[0].(string)) {
+				res.rows = [][]driver.Value{{args[0]}}
+			}
+			return res
+		})
+}
+
+func TestTableExistsTakesTheNameLiterally(t *testing.T) {
+	f := useFakeDB(t)
+	existingTables(f, "axb", "o'brien")
+	for name, want := range map[string]bool{"axb": true, "a_b": false, "a%": false, "o'brien": true, "o'neil": false} {
+		if got := TableExists(name); got != want {
+			t.Errorf("TableExists(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestSendTableSchemaHoldsLaterWrites(t *testing.T) {
+	f := useFakeDB(t)
+	s, sc := pipeSlave(t)
+	registerSlave(t, s)
+	s.syncFinished()
+
+	existingTables(f, "t")
+	f.rows(`^SHOW CREATE TABLE t$`, []string{"Table", "Create Table"},
+		[]driver.Value{"t", "CREATE TABLE `t` (`id` int NOT NULL, `v` int, PRIMARY KEY (`id`))"})
+	f.rows(`^SELECT COUNT\(\*\) FROM t$`, []string{"COUNT(*)"}, []driver.Value{int64(1)})
+
+	// A write lands on the master while the rows are being read
+	f.on(`^SELECT \* FROM t LIMIT`, func([]driver.Value) fakeResult {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			defer beginWrite()()
+			replicate(nil, "UPDATE t SET v = 2 WHERE id = 1")
+		}()
+		<-done
+		return fakeResult{cols: []string{"id", "v"}, rows: [][]driver.Value{{int64(1), int64(1)}}}
+	})
+
+	go sendTableSchema("t", s)
+
+	if got := nextFrame(t, sc); !strings.HasPrefix(got, "create_table:") {
+		t.Fatalf("first message %q, want the create_table", got)
+	}
+	if got := nextFrame(t, sc); !strings.HasPrefix(got, "sync_data:INSERT INTO") {
+		t.Fatalf("second message %q, want the rows", got)
+	}
+	if got := nextFrame(t, sc); !strings.Contains(got, "UPDATE t SET v = 2 WHERE id = 1") {
+		t.Fatalf("third message %q, want the write made during the read", got)
+	}
+	if n := len(f.matching(`^START TRANSACTION WITH CONSISTENT SNAPSHOT`)); n != 1 {
+		t.Fatalf("%d snapshot(s) taken, want 1", n)
+	}
+}
+
+func TestVerifyReportIsWrittenTogether(t *testing.T) {
+	f := useFakeDB(t)
+	s, sc := pipeSlave(t)
+
+	f.rows(`^SHOW TABLES$`, []string{"Tables"}, []driver.Value{"a"}, []driver.Value{"b"})
+	f.rows(`^SELECT COUNT\(\*\) FROM a$`, []string{"COUNT(*)"}, []driver.Value{int64(3)})
+	f.rows(`^SELECT COUNT\(\*\) FROM b$`, []string{"COUNT(*)"}, []driver.Value{int64(0)})
+
+	go handleVerifyReplication(s, false)
+
+	want := []string{"verification_data:begin", "table:a:3", "table:b:0", "verification_data:end"}
+	for _, w := range want {
+		if got := nextFrame(t, sc); got != w {
+			t.Fatalf("got %q, want %q", got, w)
+		}
+	}
+}
+
+// Swap the master's table list and database name for the test
+func withTables(t *testing.T, name string, names ...string) {
+	t.Helper()
+	oldTables, oldDB := tables, dbName
+	tables, dbName = names, name
+	t.Cleanup(func() { tables, dbName = oldTables, oldDB })
+}
+
+func TestInitialSyncConverges(t *testing.T) {
+	f := useFakeDB(t)
+	withTables(t, "shop", "t")
+	s, sc := pipeSlave(t)
+	registerSlave(t, s)
+
+	f.rows(`^SHOW CREATE TABLE t$`, []string{"Table", "Create Table"},
+		[]driver.Value{"t", "CREATE TABLE `t` (`id` int NOT NULL, `v` int, PRIMARY KEY (`id`))"})
+	f.rows(`^SELECT COUNT\(\*\) FROM t$`, []string{"COUNT(*)"}, []driver.Value{int64(1)})
+	f.on(`^SELECT \* FROM t LIMIT`, func([]driver.Value) fakeResult {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			defer beginWrite()()
+			replicate(nil, "UPDATE t SET v = 3 WHERE id = 1")
+		}()
+		<-done
+		return fakeResult{cols: []string{"id", "v"}, rows: [][]driver.Value{{int64(1), int64(2)}}}
+	})
+
+	// Made before the snapshot, so already in the rows the slave gets
+	before := replicate(nil, "UPDATE t SET v = 2 WHERE id = 1")
+
+	go sendSchemaToSlave(s)
+
+	var got []string
+	for {
+		msg := nextFrame(t, sc)
+		got = append(got, msg)
+		if strings.Contains(msg, "SET v = 3") {
+			break
+		}
+	}
+	joined := strings.Join(got, "\n")
+	if strings.Contains(joined, "SET v = 2") {
+		t.Fatalf("write from before the snapshot was sent as well:\n%s", joined)
+	}
+	wantTail := []string{
+		"applied_position:" + strconv.FormatUint(before, 10),
+		"replication_complete:done",
+	}
+	if n := len(got); n < 3 || got[n-3] != wantTail[0] || got[n-2] != wantTail[1] {
+		t.Fatalf("write during the sync not sent right after it:\n%s", joined)
+	}
+	if !strings.Contains(joined, "sync_data:INSERT INTO `t` (`id`, `v`) VALUES (1, 2)") {
+		t.Fatalf("rows from the snapshot missing:\n%s", joined)
+	}
+}
+
+func TestFailedTableCopiesAreFlaggedInTheSummary(t *testing.T) {
+	f := useFakeDB(t)
+	withTables(t, "shop", "a", "b", "c")
+	s, sc := pipeSlave(t)
+	registerSlave(t, s)
+
+	for _, name := range []string{"a", "c"} {
+		f.rows(`^SHOW CREATE TABLE `+name+`$`, []string{"Table", "Create Table"},
+			[]driver.Value{name, "CREATE TABLE `" + name + "` (`id` int NOT NULL, PRIMARY KEY (`id`))"})
+		f.rows(`^SELECT COUNT\(\*\) FROM `+name+`$`, []string{"COUNT(*)"}, []driver.Value{int64(1)})
+	}
+	f.rows(`^SELECT \* FROM a LIMIT`, []string{"id"}, []driver.Value{int64(1)})
+	f.fail(`^SELECT \* FROM c LIMIT`, errors.New("lost connection"))
+	// No definition of b at all
+
+	done := make(chan struct{})
+	go func() {
+		sendSchemaToSlave(s)
+		close(done)
+	}()
+	var frame string
+	for !strings.HasPrefix(frame, "sync_summary:") {
+		frame = nextFrame(t, sc)
+	}
+	for nextFrame(t, sc) != "replication_complete:done" {
+	}
+	<-done
+	summary, err := decodeSyncSummary(strings.TrimPrefix(frame, "sync_summary:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, st := range summary {
+		got[st.Table] = st.Status
+	}
+	if len(summary) != 3 || got["a"] != syncOK || got["b"] != syncSkipped || got["c"] != syncPartial {
+		t.Fatalf("summary %+v", summary)
+	}
+
+	// The slave names the tables that didn't make it
+	server, client := net.Pipe()
+	out := captureOutput(t, func() {
+		go func() {
+			io.WriteString(server, frame+"\n")
+			server.Close()
+		}()
+		readMasterMessages(client)
+	})
+	for _, want := range []string{"WARNING: table 'b' skipped", "WARNING: table 'c' partial",
+		"Replica is incomplete: 2 of 3 table(s) not fully synced (b, c)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("slave printed:\n%s\nwant %q", out, want)
+		}
+	}
+}
+
+func TestSlaveIsSyncedOnlyOnceItAcks(t *testing.T) {
+	useFakeDB(t)
+	withTables(t, "shop")
+	s, sc := pipeSlave(t)
+	registerSlave(t, s)
+	replicate(nil, "DELETE FROM t")
+	t.Cleanup(resetReplicated)
+
+	// The sync, as the slave gets it
+	done := make(chan struct{})
+	go func() {
+		sendSchemaToSlave(s)
+		close(done)
+	}()
+	var frames []string
+	for len(frames) == 0 || frames[len(frames)-1] != "replication_complete:done" {
+		frames = append(frames, nextFrame(t, sc))
+	}
+	<-done
+	pos := strings.TrimPrefix(frames[len(frames)-2], "applied_position:")
+
+	// Everything is sent, but it isn't applied yet
+	s.recordSyncAck("0")
+	if got := s.syncState(); got != "waiting for sync ack" {
+		t.Fatalf("state %q without an ack up to the sync position", got)
+	}
+
+	// The slave acks once replication_complete is applied
+	lines := pipeMaster(t)
+	oldSeq := appliedSeq
+	t.Cleanup(func() { appliedSeq = oldSeq })
+	server, client := net.Pipe()
+	go func() {
+		io.WriteString(server, strings.Join(frames, "\n")+"\n")
+		server.Close()
+	}()
+	readMasterMessages(client)
+	var ack string
+	for !strings.HasPrefix(ack, "sync_ack:") {
+		ack = nextLine(t, lines)
+	}
+	if ack != "sync_ack:"+pos {
+		t.Fatalf("slave sent %q, want it at position %s", ack, pos)
+	}
+	s.recordSyncAck(strings.TrimPrefix(ack, "sync_ack:"))
+	if got := s.syncState(); got != "synced" {
+		t.Fatalf("state %q after the ack", got)
+	}
+}
+
+func TestTableCreatedAfterAnEmptySyncReachesTheSlave(t *testing.T) {
+	f := useFakeDB(t)
+	withTables(t, "shop")
+	lines := pipeMaster(t)
+	clearPending(t)
+	oldSeq := appliedSeq
+	t.Cleanup(func() {
+		appliedSeq = oldSeq
+		delete(tableKeys, "items")
+		delete(tableAttributes, "items")
+	})
+	s, sc := pipeSlave(t)
+	registerSlave(t, s)
+
+	server, client := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		readMasterMessages(client)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		server.Close()
+		<-done
+	})
+	forward := func(until string) {
+		t.Helper()
+		for {
+			frame := nextFrame(t, sc)
+			if strings.HasPrefix(frame, "create_table:") && until != "create_table:" {
+				t.Fatalf("table sent by the sync of an empty database: %s", frame)
+			}
+			// The slave would reopen its database by name, which the fake can't
+			if !strings.HasPrefix(frame, "init_replication:") {
+				io.WriteString(server, frame+"\n")
+			}
+			if strings.HasPrefix(frame, until) {
+				return
+			}
+		}
+	}
+
+	go sendSchemaToSlave(s)
+	forward("replication_complete:")
+	for !strings.HasPrefix(nextLine(t, lines), "sync_ack:") {
+	}
+
+	f.rows(`^SHOW CREATE TABLE items$`, []string{"Table", "Create Table"},
+		[]driver.Value{"items", "CREATE TABLE `items` (\n  `id` int NOT NULL AUTO_INCREMENT,\n  `qty` int DEFAULT NULL,\n  PRIMARY KEY (`id`)\n)"})
+	feedInput(t, "1", "qty", "1")
+	CreateTable("items")
+	forward("create_table:")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(f.matching(`^CREATE TABLE items \(`)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("slave ran %q", f.statements())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// A slave joining from now on gets it with its sync
+	if !containsTable("items") {
+		t.Fatal("new table missing from the tables synced to later slaves")
+	}
+}
+
+func TestCharsetAndCollationAreCarriedThroughTheSync(t *testing.T) {
+	f := useFakeDB(t)
+	withTables(t, "shop", "notes")
+	s, sc := pipeSlave(t)
+	s.recordInfo("mysql_version:8.0.36")
+	registerSlave(t, s)
+	f.rows(`FROM information_schema\.SCHEMATA`, []string{"DEFAULT_CHARACTER_SET_NAME", "DEFAULT_COLLATION_NAME"},
+		[]driver.Value{"utf8mb4", "utf8mb4_0900_ai_ci"})
+	f.rows(`^SHOW CREATE TABLE notes$`, []string{"Table", "Create Table"},
+		[]driver.Value{"notes", "CREATE TABLE `notes` (\n  `id` int NOT NULL,\n" +
+			"  `body` text CHARACTER SET latin1 COLLATE latin1_swedish_ci,\n  PRIMARY KEY (`id`)\n" +
+			") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci"})
+	f.rows(`^SELECT COUNT\(\*\) FROM notes$`, []string{"COUNT(*)"}, []driver.Value{int64(0)})
+	// The slave's server knows them all
+	f.rows(`FROM information_schema\.(CHARACTER_SETS|COLLATIONS)`, []string{"COUNT(*)"}, []driver.Value{int64(1)})
+
+	done := make(chan struct{})
+	go func() {
+		sendSchemaToSlave(s)
+		close(done)
+	}()
+	var frames []string
+	for !slices.ContainsFunc(frames, func(m string) bool { return strings.HasPrefix(m, "create_table:") }) {
+		if frame := nextFrame(t, sc); strings.HasPrefix(frame, "create_db:") || strings.HasPrefix(frame, "create_table:") {
+			frames = append(frames, frame)
+		}
+	}
+	for nextFrame(t, sc) != "replication_complete:done" {
+	}
+	<-done
+	if frames[0] != "create_db:shop:utf8mb4:utf8mb4_0900_ai_ci" {
+		t.Fatalf("sent %q, want the database's charset and collation", frames[0])
+	}
+
+	server, client := net.Pipe()
+	go func() {
+		io.WriteString(server, strings.Join(frames, "\n")+"\n")
+		server.Close()
+	}()
+	readMasterMessages(client)
+	if got := f.matching(`^ALTER DATABASE`); len(got) != 1 || got[0] != "ALTER DATABASE `shop` CHARACTER SET utf8mb4 COLLATE utf8mb4_0900_ai_ci" {
+		t.Fatalf("slave ran %q", got)
+	}
+	created := f.matching(`^CREATE TABLE notes`)
+	if len(created) != 1 || !strings.Contains(created[0], "body text CHARACTER SET latin1 COLLATE latin1_swedish_ci") ||
+		!strings.HasSuffix(created[0], "DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci") {
+		t.Fatalf("slave created %q, want the column and table collations kept", created)
+	}
+}
+
+// Feed lines to the interactive prompts
+func feedInput(t *testing.T, lines ...string) {
+	t.Helper()
+	old := inputSource
+	inputSource = strings.NewReader(strings.Join(lines, "\n") + "\n")
+	inputOnce = sync.Once{}
+	t.Cleanup(func() {
+		inputSource = old
+		inputOnce = sync.Once{}
+	})
+}
+
+// What fn prints
+func captureOutput(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	old := os.Stdout
+	os.Stdout = w
+	func() {
+		defer func() { os.Stdout = old }()
+		fn()
+	}()
+	w.Close()
+	return <-out
+}
+
+// Make table the current one, with the given DESCRIBE rows (field, type,
+// null, key) in the fake database and the column cache
+func useTable(t *testing.T, f *fakeDB, table string, describe ...[]string) {
+	t.Helper()
+	var keyRows, descRows [][]driver.Value
+	for _, d := range describe {
+		if d[3] == "PRI" {
+			keyRows = append(keyRows, []driver.Value{d[0]})
+		}
+		descRows = append(descRows, []driver.Value{d[0], d[1], d[2], d[3], nil, ""})
+	}
+	f.rows(`KEY_COLUMN_USAGE`, []string{"COLUMN_NAME"}, keyRows...)
+	f.rows(`^DESCRIBE `+table+`$`, []string{"Field", "Type", "Null", "Key", "Default", "Extra"}, descRows...)
+
+	oldTable := currentTable
+	currentTable = table
+	keys, attrs, err := liveColumns(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tableKeys[table], tableAttributes[table] = keys, attrs
+	t.Cleanup(func() {
+		currentTable = oldTable
+		delete(tableKeys, table)
+		delete(tableAttributes, table)
+	})
+}
+
+func TestReadInsertValueAsksAgainOnBadNumbers(t *testing.T) {
+	feedInput(t, "12abc", "12", "x", "1e400", "NaN", "2.5")
+	if v, ok := readInsertValue(column{Name: "age", Type: typeInt}); !ok || v != 12 {
+		t.Fatalf("got %v, %v, want 12", v, ok)
+	}
+	if v, ok := readInsertValue(column{Name: "score", Type: typeFloat}); !ok || v != 2.5 {
+		t.Fatalf("got %v, %v, want 2.5", v, ok)
+	}
+}
+
+func TestNestedJSONArraysSurviveInsertAndReplication(t *testing.T) {
+	f := useFakeDB(t)
+	throughSlave(t, f, func(*slaveConn) {})
+	useTable(t, f, "docs",
+		[]string{"id", "int", "NO", "PRI"},
+		[]string{"doc", "json", "YES", ""})
+
+	doc := `{"matrix": [[1, 2], [3, [4, 5]]], "tags": ["a", ["b", "c's"]], "empty": [[]]}`
+	var bound driver.Value
+	f.on(`^INSERT INTO docs \(`, func(args []driver.Value) fakeResult {
+		bound = args[1]
+		return fakeResult{}
+	})
+	// Not JSON, asked again
+	feedInput(t, "1", `[[1, 2]`, doc)
+	InsertRecord()
+
+	if bound != doc {
+		t.Fatalf("master bound %#v, want the document as typed", bound)
+	}
+	got := slaveWrites(t, f, 1)
+	if lits := stringLiterals(got[0]); len(lits) != 1 || lits[0] != doc {
+		t.Fatalf("slave stored %q, want %q", lits, doc)
+	}
+}
+
+func TestBlankValuesAreLeftToTheServerDefault(t *testing.T) {
+	f := useFakeDB(t)
+	throughSlave(t, f, func(*slaveConn) {})
+	useTable(t, f, "people",
+		[]string{"id", "int", "NO", "PRI"},
+		[]string{"name", "varchar(100)", "YES", ""},
+		[]string{"status", "varchar(20)", "NO", ""},
+		[]string{"age", "int", "YES", ""})
+
+	// status and age blank, name given
+	feedInput(t, "1", "Ada", "", "")
+	InsertRecord()
+
+	if got := f.matching(`^INSERT INTO people `); len(got) != 1 || got[0] != "INSERT INTO people (id, name) VALUES (?, ?)" {
+		t.Fatalf("master ran %q, want only the columns given", got)
+	}
+	got := slaveWrites(t, f, 1)
+	if !strings.Contains(got[0], "(`id`, `name`) VALUES (1, 'Ada')") {
+		t.Fatalf("slave ran %q, want the default columns left out", got[0])
+	}
+}
+
+func TestUpdateRecordKeepsFieldOnBadNumber(t *testing.T) {
+	f := useFakeDB(t)
+	useTable(t, f, "people",
+		[]string{"id", "int", "NO", "PRI"},
+		[]string{"age", "int", "YES", ""},
+		[]string{"score", "float", "YES", ""})
+	feedInput(t, "1", "forty", "2.5")
+
+	UpdateRecord()
+
+	got := f.matching(`^UPDATE `)
+	if len(got) != 1 || got[0] != "UPDATE people SET score = ? WHERE id = ?" {
+		t.Fatalf("ran %q", got)
+	}
+}
+
+// The writes the slave ran, those with their values in the text since the
+// master binds them, once there are n
+func slaveWrites(t *testing.T, f *fakeDB, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var got []string
+		for _, stmt := range f.matching(`^(INSERT|UPDATE) `) {
+			if !strings.Contains(stmt, "?") {
+				got = append(got, stmt)
+			}
+		}
+		if len(got) >= n {
+			return got
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("slave ran %q, want %d writes", got, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDatetimeColumnIsCreatedAndItsRowsReplicated(t *testing.T) {
+	f := useFakeDB(t)
+	throughSlave(t, f, func(*slaveConn) {})
+	oldTables := tables
+	t.Cleanup(func() { tables = oldTables })
+
+	feedInput(t, "1", "happened_at", strconv.Itoa(typeDatetime+1))
+	CreateTable("events")
+	want := "CREATE TABLE IF NOT EXISTS events (id INT PRIMARY KEY AUTO_INCREMENT, happened_at DATETIME)"
+	if got := f.matching(`^CREATE TABLE`); len(got) == 0 || got[0] != want {
+		t.Fatalf("ran %q, want %q", got, want)
+	}
+
+	useTable(t, f, "events",
+		[]string{"id", "int", "NO", "PRI"},
+		[]string{"happened_at", "datetime", "YES", ""})
+	feedInput(t, "1", "2026-10-14 09:30:00")
+	InsertRecord()
+
+	got := slaveWrites(t, f, 1)
+	if lits := stringLiterals(got[0]); len(lits) != 1 || lits[0] != "2026-10-14 09:30:00" {
+		t.Fatalf("slave ran %q, want the datetime quoted as typed", got[0])
+	}
+}
+
+func TestBooleanValuesReachTheSlaveAsOneAndZero(t *testing.T) {
+	f := useFakeDB(t)
+	throughSlave(t, f, func(*slaveConn) {})
+	useTable(t, f, "flags",
+		[]string{"id", "int", "NO", "PRI"},
+		[]string{"active", "tinyint(1)", "YES", ""})
+
+	feedInput(t, "1", "true")
+	InsertRecord()
+	feedInput(t, "2", "false")
+	InsertRecord()
+
+	got := slaveWrites(t, f, 2)
+	for i, want := range []string{"(1, 1)", "(2, 0)"} {
+		if !strings.Contains(got[i], want) {
+			t.Errorf("slave ran %q, want the values %s", got[i], want)
+		}
+	}
+}
+
+func TestDecimalValueIsReplicatedExactly(t *testing.T) {
+	f := useFakeDB(t)
+	throughSlave(t, f, func(*slaveConn) {})
+	useTable(t, f, "prices",
+		[]string{"id", "int", "NO", "PRI"},
+		[]string{"amount", "decimal(10,2)", "YES", ""})
+	var bound driver.Value
+	f.on(`^INSERT INTO prices \(`, func(args []driver.Value) fakeResult {
+		bound = args[1]
+		return fakeResult{}
+	})
+
+	feedInput(t, "1", "12345.67")
+	InsertRecord()
+
+	// No float in between on the master either
+	if bound != "12345.67" {
+		t.Fatalf("master bound %#v, want the digits typed", bound)
+	}
+	got := slaveWrites(t, f, 1)
+	if !strings.Contains(got[0], "(1, 12345.67)") {
+		t.Fatalf("slave ran %q, want 12345.67 as typed", got[0])
+	}
+}
+
+func TestValuesWithSpacesAreStoredWhole(t *testing.T) {
+	f := useFakeDB(t)
+	throughSlave(t, f, func(*slaveConn) {})
+	useTable(t, f, "people",
+		[]string{"id", "int", "NO", "PRI"},
+		[]string{"name", "varchar(100)", "YES", ""},
+		[]string{"city", "varchar(100)", "YES", ""})
+
+	f.on(`^UPDATE people SET`, func([]driver.Value) fakeResult { return fakeResult{affected: 1} })
+
+	feedInput(t, "1", "John Doe", "New York")
+	InsertRecord()
+	// An empty line still keeps the name
+	feedInput(t, "1", "", "Los Angeles")
+	UpdateRecord()
+
+	if got := f.matching(`^UPDATE `); len(got) == 0 || got[0] != "UPDATE people SET city = ? WHERE id = ?" {
+		t.Fatalf("ran %q", got)
+	}
+	got := slaveWrites(t, f, 2)
+	for i, want := range [][]string{{"John Doe", "New York"}, {"Los Angeles"}} {
+		if lits := stringLiterals(got[i]); strings.Join(lits, "|") != strings.Join(want, "|") {
+			t.Errorf("slave ran %q, want the values %q", got[i], want)
+		}
+	}
+}
+
+func TestEmojiAndCJKReachTheSlaveByteForByte(t *testing.T) {
+	f := useFakeDB(t)
+	throughSlave(t, f, func(*slaveConn) {})
+	useTable(t, f, "notes",
+		[]string{"id", "int", "NO", "PRI"},
+		[]string{"body", "text", "YES", ""})
+
+	body := "東京 🎉 naïve \U0001F9D1\u200D\U0001F4BB"
+	feedInput(t, "1", body)
+	InsertRecord()
+
+	got := slaveWrites(t, f, 1)
+	if lits := stringLiterals(got[0]); len(lits) != 1 || lits[0] != body {
+		t.Fatalf("slave stored %q, want %q", lits, body)
+	}
+}
+
+func TestUpdateOfAVanishedRowIsNotReplicated(t *testing.T) {
+	f := useFakeDB(t)
+	useTable(t, f, "people",
+		[]string{"id", "int", "NO", "PRI"},
+		[]string{"name", "varchar(100)", "YES", ""})
+	s, sc := pipeSlave(t)
+	registerSlave(t, s)
+	s.syncFinished()
+	// Deleted between the prompt and the UPDATE
+	f.on(`^UPDATE people SET`, func([]driver.Value) fakeResult { return fakeResult{affected: 0} })
+
+	before := currentSeq()
+	feedInput(t, "7", "Grace")
+	out := captureOutput(t, UpdateRecord)
+
+	if !strings.Contains(out, "No record with id 7 was changed") {
+		t.Fatalf("operator was told %q", out)
+	}
+	if currentSeq() != before {
+		t.Fatal("the update was replicated")
+	}
+	s.sendLive("notification:marker\n")
+	if got := nextFrame(t, sc); got != "notification:marker" {
+		t.Fatalf("slave was sent %q", got)
+	}
+}
+
+func TestForwardedWriteKeepsSenderCaughtUp(t *testing.T) {
+	useFakeDB(t)
+	s, sc := pipeSlave(t)
+	registerSlave(t, s)
+	s.syncFinished()
+	other, otherSc := pipeSlave(t)
+	registerSlave(t, other)
+	other.syncFinished()
+
+	go func() {
+		handleSlaveMessage(s, "insert:INSERT INTO t VALUES (1)")
+		handleSlaveMessage(s, "get_position:")
+	}()
+	go otherSc.Scan()
+
+	if got := nextFrame(t, sc); got != "success:query executed" {
+		t.Fatalf("got %q", got)
+	}
+	msg := nextFrame(t, sc)
+	applied, ok := strings.CutPrefix(msg, "applied_position:")
+	if !ok {
+		t.Fatalf("got %q, want the position of the sender's own write", msg)
+	}
+	position, ok := strings.CutPrefix(nextFrame(t, sc), "position:")
+	if !ok {
+		t.Fatal("no position reply")
+	}
+	a, _ := strconv.ParseUint(applied, 10, 64)
+	p, _ := strconv.ParseUint(position, 10, 64)
+	if behind := replicationLag(p, a); behind != 0 {
+		t.Fatalf("sender %d behind after its own write (applied %d, master %d)", behind, a, p)
+	}
+}
+
+// readSubscription on a connection the slave writes lines to
+func handshakeFrom(t *testing.T, lines ...string) (*slaveConn, string, time.Duration) {
+	t.Helper()
+	server, client := net.Pipe()
+	s := newSlaveConn(server)
+	t.Cleanup(func() {
+		s.close()
+		client.Close()
+	})
+	go func() {
+		for _, line := range lines {
+			if _, err := client.Write([]byte(line + "\n")); err != nil {
+				return
+			}
+		}
+	}()
+	start := time.Now()
+	first, ok := readSubscription(s, newMessageScanner(server, nil))
+	if !ok {
+		t.Fatal("connection lost during the handshake")
+	}
+	return s, first, time.Since(start)
+}
+
+func TestHandshakeEndsAtFirstRequest(t *testing.T) {
+	s, first, took := handshakeFrom(t, "slave_info:mysql_version:8.0.36", "get_position:")
+	if first != "get_position:" {
+		t.Fatalf("first message %q, want the request", first)
+	}
+	if took >= handshakeTimeout {
+		t.Fatalf("handshake took %v, waited for a subscribe that wasn't coming", took)
+	}
+	if s.version() != "8.0.36" || s.schemaOnly {
+		t.Fatalf("version %q, schema only %v", s.version(), s.schemaOnly)
+	}
+}
+
+func TestSchemaOnlySubscription(t *testing.T) {
+	s, first, _ := handshakeFrom(t, "slave_info:slave_id:abc", "subscribe:schema_only")
+	if first != "" || !s.schemaOnly {
+		t.Fatalf("first %q, schema only %v", first, s.schemaOnly)
+	}
+}
+
+// Connections counted against the per-IP limit for ip
+func slavesFrom(ip string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return slavesPerIP[ip]
+}
+
+func TestSlavesOverThePerIPLimitAreRefused(t *testing.T) {
+	f := useFakeDB(t)
+	// Hold the accepted slaves in their sync until the end of the test
+	release := make(chan struct{})
+	f.on(".", func([]driver.Value) fakeResult {
+		<-release
+		return fakeResult{}
+	})
+	oldLimit := maxSlavesPerIP
+	maxSlavesPerIP = 2
+	t.Cleanup(func() { maxSlavesPerIP = oldLimit })
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handleSlaveConnection(conn)
+		}
+	}()
+	connect := func() net.Conn {
+		t.Helper()
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		conn.Write([]byte("subscribe:schema_only\n"))
+		return conn
+	}
+	waitFor := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for slavesFrom("127.0.0.1") != n {
+			if time.Now().After(deadline) {
+				t.Fatalf("%d connection(s) counted from 127.0.0.1, want %d", slavesFrom("127.0.0.1"), n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	var accepted []net.Conn
+	for i := 0; i < 2; i++ {
+		accepted = append(accepted, connect())
+		waitFor(i + 1)
+	}
+
+	extra := connect()
+	defer extra.Close()
+	sc := newMessageScanner(extra, nil)
+	if got := nextFrame(t, sc); got != "error:too many slave connections from 127.0.0.1 (limit 2)" {
+		t.Fatalf("got %q, want the connection refused", got)
+	}
+	if sc.Scan() {
+		t.Fatalf("got %q after the refusal, want the connection closed", sc.Text())
+	}
+	waitFor(2)
+
+	// Disconnecting gives the slots back
+	close(release)
+	for _, conn := range accepted {
+		conn.Close()
+	}
+	waitFor(0)
+}
+
+func TestSchemaOnlySlaveGetsDDLButNoRows(t *testing.T) {
+	s, sc := pipeSlave(t)
+	s.schemaOnly = true
+	registerSlave(t, s)
+	s.syncFinished()
+
+	go func() {
+		replicate(nil, "INSERT INTO t VALUES (1)")
+		replicate(nil, "ALTER TABLE t ADD COLUMN v INT")
+	}()
+	if got := nextFrame(t, sc); !strings.HasPrefix(got, "applied_position:") {
+		t.Fatalf("got %q for the INSERT, want only the position", got)
+	}
+	if got := nextFrame(t, sc); !strings.HasPrefix(got, "replicate_query:") || !strings.HasSuffix(got, ":ALTER TABLE t ADD COLUMN v INT") {
+		t.Fatalf("got %q, want the ALTER", got)
+	}
+}
+
+func TestSlaveLeavingMidSelectStopsTheStream(t *testing.T) {
+	f := useFakeDB(t)
+	var many [][]driver.Value
+	for i := int64(1); i <= 1000; i++ {
+		many = append(many, []driver.Value{i, "row"})
+	}
+	f.rows(`^SELECT id, v FROM t`, []string{"id", "v"}, many...)
+
+	baseline := runtime.NumGoroutine()
+	server, client := net.Pipe()
+	s := newSlaveConn(server)
+	s.syncFinished()
+	done := make(chan struct{})
+	go func() {
+		executeSelect("SELECT id, v FROM t", s, selectAll)
+		close(done)
+	}()
+
+	sc := newMessageScanner(client, nil)
+	for i := 0; i < 4; i++ {
+		nextFrame(t, sc)
+	}
+	client.Close()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SELECT still streaming after the slave left")
+	}
+	select {
+	case <-s.done:
+	default:
+		t.Fatal("slave not dropped")
+	}
+	// The writer goes too
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines left, %d before the slave connected", runtime.NumGoroutine(), baseline)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestJoinKeepsBothIDColumns(t *testing.T) {
+	f := useFakeDB(t)
+	f.rows(`^SELECT \* FROM orders JOIN customers`, []string{"id", "customer_id", "id", "name", "id_2"},
+		[]driver.Value{int64(10), int64(3), int64(3), "Ada", "x"})
+	s, sc := pipeSlave(t)
+
+	go handleSlaveMessage(s, "select_all:SELECT * FROM orders JOIN customers ON customers.id = orders.customer_id")
+
+	for _, w := range []string{"success:5", "id,customer_id,id_3,name,id_2", "10,3,3,Ada,x", "END"} {
+		if got := nextFrame(t, sc); got != w {
+			t.Fatalf("got %q, want %q", got, w)
+		}
+	}
+}
+
+func TestSelectFailingMidStreamEndsWithAnError(t *testing.T) {
+	f := useFakeDB(t)
+	f.on(`^SELECT id FROM t`, func([]driver.Value) fakeResult {
+		return fakeResult{cols: []string{"id"}, rows: [][]driver.Value{{int64(1)}, {int64(2)}},
+			rowsErr: errors.New("Lost connection to MySQL server during query")}
+	})
+	s, sc := pipeSlave(t)
+
+	go handleSlaveMessage(s, "select_all:SELECT id FROM t")
+
+	var frames []string
+	for {
+		frame := nextFrame(t, sc)
+		frames = append(frames, frame)
+		if frame == "END" || strings.HasPrefix(frame, "ERROR:") {
+			break
+		}
+	}
+	want := []string{"success:1", "id", "1", "2", "ERROR:Lost connection to MySQL server during query"}
+	if strings.Join(frames, "|") != strings.Join(want, "|") {
+		t.Fatalf("got %q, want %q", frames, want)
+	}
+
+	// The slave reports the failure instead of a short result
+	server, client := net.Pipe()
+	go func() {
+		for _, frame := range frames {
+			io.WriteString(server, frame+"\n")
+		}
+		server.Close()
+	}()
+	out := captureOutput(t, func() { readMasterMessages(client) })
+	if !strings.Contains(out, "the result failed after 2 row(s): Lost connection") || strings.Contains(out, "Total rows") {
+		t.Fatalf("slave printed %q", out)
+	}
+}
+
+func TestSelectSendsExactBinaryBytes(t *testing.T) {
+	f := useFakeDB(t)
+	s, sc := pipeSlave(t)
+
+	small := []byte{0, 1, ',', '\n', '\r', ':', 0xff}
+	large := []byte(strings.Repeat("compressible ", 400))
+	large = append(large, 0, 0xfe)
+	f.on(`^SELECT id, data FROM files`, func([]driver.Value) fakeResult {
+		return fakeResult{
+			cols:  []string{"id", "data"},
+			types: []string{"INT", "BLOB"},
+			rows:  [][]driver.Value{{int64(1), small}, {int64(2), large}, {int64(3), nil}},
+		}
+	})
+	go executeSelect("SELECT id, data FROM files", s, selectAll)
+
+	if got := nextFrame(t, sc); got != "success:2" {
+		t.Fatalf("got %q", got)
+	}
+	nextFrame(t, sc)
+	binary := parseEncodingLine(nextFrame(t, sc))
+	var rows [][]interface{}
+	for {
+		line := nextFrame(t, sc)
+		if line == "END" {
+			break
+		}
+		values, ok := decodeResultRow(line, binary)
+		if !ok {
+			t.Fatalf("row %q doesn't decode", line)
+		}
+		if len(rows) == 1 && !strings.Contains(line, compressedValuePrefix) {
+			t.Fatal("large value sent uncompressed")
+		}
+		rows = append(rows, values)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("%d rows, want 3", len(rows))
+	}
+	if got := rows[0][1].([]byte); string(got) != string(small) {
+		t.Fatalf("got %x, want %x", got, small)
+	}
+	if got := rows[1][1].([]byte); string(got) != string(large) {
+		t.Fatalf("large value came back as %d different bytes", len(got))
+	}
+	if rows[2][1] != nil {
+		t.Fatalf("NULL came back as %v", rows[2][1])
+	}
+}
+
+func TestReplicatedDDLIsTailoredToTheSlave(t *testing.T) {
+	s, sc := pipeSlave(t)
+	s.recordInfo("mysql_version:10.11.6-MariaDB")
+	registerSlave(t, s)
+	s.syncFinished()
+
+	go func() {
+		replicate(nil, "ALTER TABLE t MODIFY name VARCHAR(20) COLLATE utf8mb4_0900_ai_ci")
+		replicate(nil, "INSERT INTO t (name) VALUES ('utf8mb4_0900_ai_ci')")
+	}()
+	if got := nextFrame(t, sc); !strings.HasSuffix(got, ":ALTER TABLE t MODIFY name VARCHAR(20) COLLATE utf8mb4_unicode_ci") {
+		t.Fatalf("got %q", got)
+	}
+	if got := nextFrame(t, sc); !strings.HasSuffix(got, ":INSERT INTO t (name) VALUES ('utf8mb4_0900_ai_ci')") {
+		t.Fatalf("row changed: %q", got)
+	}
+}
+
+func TestMenuOpsAndForwardedWritesDontInterleave(t *testing.T) {
+	f := useFakeDB(t)
+	withTables(t, "shop", "t", "u")
+	oldTable := currentTable
+	currentTable = "t"
+	t.Cleanup(func() { currentTable = oldTable })
+
+	watcher, wsc := pipeSlave(t)
+	watcher.addr = "watcher"
+	registerSlave(t, watcher)
+	watcher.syncFinished()
+	received := make(chan string, 200)
+	go func() {
+		for wsc.Scan() {
+			if rest, ok := strings.CutPrefix(wsc.Text(), "replicate_query:"); ok {
+				_, q, _ := strings.Cut(rest, ":")
+				received <- q
+			}
+		}
+	}()
+
+	const writers, writes = 4, 25
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		s, sc := pipeSlave(t)
+		s.addr = "writer" + strconv.Itoa(w)
+		registerSlave(t, s)
+		s.syncFinished()
+		go func() {
+			for sc.Scan() {
+			}
+		}()
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				handleSlaveMessage(s, "insert:INSERT INTO u VALUES ("+strconv.Itoa(w*writes+i)+")")
+			}
+		}(w)
+	}
+	feedInput(t, "y")
+	DropTable()
+	wg.Wait()
+
+	var executed []string
+	for _, stmt := range f.statements() {
+		if strings.HasPrefix(stmt, "INSERT") || strings.HasPrefix(stmt, "DROP") {
+			executed = append(executed, stmt)
+		}
+	}
+	if len(executed) != writers*writes+1 {
+		t.Fatalf("%d statements ran, want %d", len(executed), writers*writes+1)
+	}
+	for i, want := range executed {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Fatalf("replicated write %d is %q, the master ran %q", i, got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("only %d of %d writes replicated", i, len(executed))
+		}
+	}
+	if containsTable("t") {
+		t.Fatal("dropped table still listed")
+	}
+}
+
+func TestForwardedWriteFailsFastInTransaction(t *testing.T) {
+	f := useFakeDB(t)
+	s, sc := pipeSlave(t)
+	s.syncFinished()
+
+	beginTx()
+	t.Cleanup(func() {
+		if masterTx != nil {
+			rollbackTx()
+		}
+	})
+	go handleSlaveMessage(s, "insert:INSERT INTO t VALUES (1)")
+	if got := nextFrame(t, sc); !strings.HasPrefix(got, "error:master has an open transaction") {
+		t.Fatalf("got %q", got)
+	}
+	if n := len(f.matching(`^INSERT`)); n != 0 {
+		t.Fatal("forwarded write ran inside the operator's transaction")
+	}
+
+	rollbackTx()
+	go handleSlaveMessage(s, "insert:INSERT INTO t VALUES (1)")
+	if got := nextFrame(t, sc); got != "success:query executed" {
+		t.Fatalf("got %q once the transaction ended", got)
+	}
+}
+
+func TestMaintenanceRejectsForwardedWritesButNotSelects(t *testing.T) {
+	f := useFakeDB(t)
+	f.rows(`^SELECT id FROM t`, []string{"id"}, []driver.Value{int64(1)})
+	s, sc := pipeSlave(t)
+	s.syncFinished()
+
+	mu.Lock()
+	maintenance = true
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		maintenance = false
+		mu.Unlock()
+	})
+
+	go handleSlaveMessage(s, "insert:INSERT INTO t VALUES (1)")
+	if got := nextFrame(t, sc); got != "error:master in maintenance" {
+		t.Fatalf("got %q", got)
+	}
+	if n := len(f.matching(`^INSERT`)); n != 0 {
+		t.Fatal("forwarded write ran in maintenance mode")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		handleSlaveMessage(s, "select:SELECT id FROM t")
+		close(done)
+	}()
+	if got := nextFrame(t, sc); got != "success:1" {
+		t.Fatalf("got %q for a SELECT in maintenance mode", got)
+	}
+	for nextFrame(t, sc) != "END" {
+	}
+	<-done
+}
+
+func TestLastAckOutlivesAReconnect(t *testing.T) {
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(slaveAcks, "slave-a")
+		mu.Unlock()
+	})
+	s, _ := pipeSlave(t)
+	s.recordInfo("slave_id:slave-a")
+	s.recordAck("ok:")
+	s.recordInfo("acked_position:12")
+	s.recordAck("err:duplicate entry")
+	s.recordInfo("acked_position:11")
+	s.close()
+
+	// The same slave on a new connection
+	again, _ := pipeSlave(t)
+	again.recordInfo("slave_id:slave-a")
+	mu.Lock()
+	note := again.ackNoteLocked()
+	mu.Unlock()
+	if !strings.Contains(note, "up to position 12") || !strings.Contains(note, "error: duplicate entry") {
+		t.Fatalf("slave list shows %q, want the last ack and its position", note)
+	}
+}
+
+func TestDropNeedsTheExactDatabaseName(t *testing.T) {
+	for _, tc := range []struct {
+		input string
+		ok    bool
+	}{
+		{"shop", true},
+		{"  shop\n", true},
+		{"Shop", false},
+		{"SHOP", false},
+		{"sh op", false},
+		{"sho", false},
+		{"shop2", false},
+		{"shop;", false},
+		{"", false},
+	} {
+		if got := confirmDropName(tc.input, "shop"); got != tc.ok {
+			t.Errorf("confirmDropName(%q, shop) = %v, want %v", tc.input, got, tc.ok)
+		}
+	}
+}
+
+func TestFlushLiveWaitsForQueuedFrames(t *testing.T) {
+	s, sc := pipeSlave(t)
+	s.syncFinished()
+
+	s.sendLive("replicate_query:1:INSERT INTO t VALUES (1)\n")
+	s.sendLive("drop_database:shop\n")
+	if s.flushLive(20 * time.Millisecond) {
+		t.Fatal("flushed with nobody reading")
+	}
+
+	got := make(chan string, 2)
+	go func() {
+		for i := 0; i < 2 && sc.Scan(); i++ {
+			got <- sc.Text()
+		}
+	}()
+	if !s.flushLive(5 * time.Second) {
+		t.Fatal("frames still unsent once read")
+	}
+	if first := <-got; first != "replicate_query:1:INSERT INTO t VALUES (1)" {
+		t.Fatalf("got %q first, want the frame queued before the drop", first)
+	}
+}
+
+func TestDisplayRecordsLinesUpWithAStaleCache(t *testing.T) {
+	f := useFakeDB(t)
+	useTable(t, f, "people", []string{"id", "int", "NO", "PRI"}, []string{"name", "varchar(100)", "YES", ""})
+	// age was added behind the cache's back
+	f.rows(`^SELECT \* FROM people`, []string{"id", "name", "age"},
+		[]driver.Value{int64(1), "Ada", int64(36)}, []driver.Value{int64(2), nil, int64(41)})
+
+	out := captureOutput(t, DisplayRecords)
+	want := "id\tname\tage\t\n" +
+		"-----------------------------------------------------------\n" +
+		"1\tAda\t36\t\n" +
+		"2\tNULL\t41\t\n"
+	if out != want {
+		t.Fatalf("printed:\n%q\nwant:\n%q", out, want)
+	}
+}
+
+func TestChangedTableIsNoticedBeforeItsColumnsAreUsed(t *testing.T) {
+	f := useFakeDB(t)
+	useTable(t, f, "people", []string{"id", "int", "NO", "PRI"}, []string{"name", "varchar(100)", "YES", ""})
+	// Changed outside this program: age added, name now TEXT
+	f.rows(`^DESCRIBE people$`, []string{"Field", "Type", "Null", "Key", "Default", "Extra"},
+		[]driver.Value{"id", "int", "NO", "PRI", nil, ""},
+		[]driver.Value{"name", "text", "YES", "", nil, ""},
+		[]driver.Value{"age", "int", "YES", "", nil, ""})
+
+	feedInput(t, "n")
+	var ok bool
+	out := captureOutput(t, func() { ok = checkColumnCache() })
+	if ok || len(tableAttributes["people"]) != 1 {
+		t.Fatalf("went ahead on the stale cache %+v", tableAttributes["people"])
+	}
+	for _, want := range []string{"The cached columns of 'people' don't match the database:",
+		"- column name is now TEXT", "- column age was added"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("printed:\n%s\nwant %q", out, want)
+		}
+	}
+
+	feedInput(t, "y")
+	captureOutput(t, func() { ok = checkColumnCache() })
+	if attrs := tableAttributes["people"]; !ok || len(attrs) != 2 || attrs[1].Name != "age" {
+		t.Fatalf("cache not refreshed: %+v", attrs)
+	}
+	// Now current, so no question
+	if out := captureOutput(t, func() { ok = checkColumnCache() }); !ok || out != "" {
+		t.Fatalf("asked again about a current cache:\n%s", out)
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestAcceptErrorsAreSortedIntoRetryAndStop(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{&net.OpError{Op: "accept", Err: os.NewSyscallError("accept4", syscall.EMFILE)}, true},
+		{&net.OpError{Op: "accept", Err: os.NewSyscallError("accept4", syscall.ECONNRESET)}, true},
+		{&net.OpError{Op: "accept", Err: timeoutError{}}, true},
+		{&net.OpError{Op: "accept", Err: net.ErrClosed}, false},
+		{errors.New("listener broke"), false},
+	}
+	for _, c := range cases {
+		if got := isTemporaryAcceptError(c.err); got != c.want {
+			t.Errorf("isTemporaryAcceptError(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+// A listener whose Accept fails with each error in turn
+type failingListener struct {
+	errs  []error
+	times []time.Time
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.times = append(l.times, time.Now())
+	err := l.errs[0]
+	l.errs = l.errs[1:]
+	return nil, err
+}
+
+func (l *failingListener) Close() error   { return nil }
+func (l *failingListener) Addr() net.Addr { return &net.TCPAddr{} }
+
+func TestAcceptLoopBacksOffUntilTheListenerCloses(t *testing.T) {
+	emfile := &net.OpError{Op: "accept", Err: os.NewSyscallError("accept4", syscall.EMFILE)}
+	ln := &failingListener{errs: []error{emfile, emfile, emfile, emfile, net.ErrClosed}}
+
+	var err error
+	captureOutput(t, func() { err = acceptLoop(ln) })
+	if !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("loop ended with %v, want the listener closed", err)
+	}
+	if len(ln.times) != 5 {
+		t.Fatalf("%d accepts, want 5", len(ln.times))
+	}
+	// 5ms, then doubling
+	want := 5 * time.Millisecond
+	for i := 1; i < len(ln.times); i++ {
+		if gap := ln.times[i].Sub(ln.times[i-1]); gap < want {
+			t.Errorf("retry %d after %v, want at least %v", i, gap, want)
+		}
+		want *= 2
+	}
+}
+
+func TestNewSlavesAreRefusedWhileConnectedOnesReplicate(t *testing.T) {
+	s, sc := pipeSlave(t)
+	registerSlave(t, s)
+	s.syncFinished()
+	mu.Lock()
+	acceptSlaves = false
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		acceptSlaves = true
+		mu.Unlock()
+	})
+
+	server, client := net.Pipe()
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+	done := make(chan struct{})
+	go func() {
+		handleSlaveConnection(server)
+		close(done)
+	}()
+	io.WriteString(client, "subscribe:full\n")
+	newcomer := newMessageScanner(client, nil)
+	if got := nextFrame(t, newcomer); got != "error:master is not accepting new slaves right now, try again later" {
+		t.Fatalf("new slave got %q", got)
+	}
+	<-done
+
+	replicate(nil, "UPDATE t SET v = 1")
+	if got := nextFrame(t, sc); !strings.HasSuffix(got, ":UPDATE t SET v = 1") {
+		t.Fatalf("connected slave got %q, want the replicated write", got)
+	}
+}
+
+func TestStaleSocketIsReplacedAndLiveOneKept(t *testing.T) {
+	dir := t.TempDir()
+	oldPath, oldListener := listenUnix, activeListener
+	t.Cleanup(func() {
+		listenUnix = oldPath
+		listenerMu.Lock()
+		activeListener = oldListener
+		listenerMu.Unlock()
+	})
+
+	// Left behind by a master that died
+	stalePath := filepath.Join(dir, "stale.sock")
+	dead, err := net.ListenUnix("unix", &net.UnixAddr{Name: stalePath, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead.SetUnlinkOnClose(false)
+	dead.Close()
+	listenUnix = stalePath
+	ln, _, err := listen()
+	if err != nil {
+		t.Fatalf("stale socket not replaced: %v", err)
+	}
+	ln.Close()
+
+	// Another master still serving on it
+	livePath := filepath.Join(dir, "live.sock")
+	live, err := net.Listen("unix", livePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Close()
+	go func() {
+		for {
+			conn, err := live.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	listenUnix = livePath
+	if ln, _, err := listen(); err == nil {
+		ln.Close()
+		t.Fatal("listened over a socket in use")
+	}
+	conn, err := net.Dial("unix", livePath)
+	if err != nil {
+		t.Fatalf("live socket removed: %v", err)
+	}
+	conn.Close()
+}
+
+func TestControlMessagesGetNoErrorReply(t *testing.T) {
+	useFakeDB(t)
+	s, sc := pipeSlave(t)
+	registerSlave(t, s)
+	s.syncFinished()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, msg := range []string{
+			"replicate_ack:ok:",
+			"replicate_ack:err:Duplicate entry",
+			"slave_info:origin_time:1",
+			"sync_ack:0",
+			"time_reply:1:2:3",
+			"affected_count:1",
+			"bench_ack:1",
+			"subscribe:full",
+			"pong:1",
+			"auth:token",
+		} {
+			handleSlaveMessage(s, msg)
+		}
+		// Something that does get an error, to know the others are done
+		handleSlaveMessage(s, "bogus:x")
+	}()
+	for {
+		got := nextFrame(t, sc)
+		if got == "error:unsupported operation bogus" {
+			<-done
+			return
+		}
+		if strings.HasPrefix(got, "error:") {
+			t.Fatalf("control message answered with %q", got)
+		}
+	}
+}
+
+// A slaveConn whose frames are kept as they arrive
+func recordingSlave(t *testing.T) (*slaveConn, func() string) {
+	t.Helper()
+	server, client := net.Pipe()
+	s := newSlaveConn(server)
+	var mu sync.Mutex
+	var written strings.Builder
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := client.Read(buf)
+			mu.Lock()
+			written.Write(buf[:n])
+			mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		s.close()
+		client.Close()
+	})
+	return s, func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return written.String()
+	}
+}
+
+func TestDryRunWritesNothingToSlaves(t *testing.T) {
+	s, written := recordingSlave(t)
+	registerSlave(t, s)
+	s.syncFinished()
+	t.Cleanup(resetReplicated)
+
+	old := dryRun
+	dryRun = true
+	t.Cleanup(func() { dryRun = old })
+	out := captureOutput(t, func() {
+		replicate(nil, "UPDATE t SET v = 1")
+		replicateTxTo(nil, nil, []string{"INSERT INTO t VALUES (1)", "DELETE FROM t WHERE id = 2"})
+		broadcastTableDef("CREATE TABLE u (id INT)")
+		s.sendBulk("sync_data:INSERT INTO t VALUES (3)\n")
+		s.flushLive(time.Second)
+	})
+	if got := written(); got != "" {
+		t.Fatalf("dry run wrote %q to the slave", got)
+	}
+	for _, want := range []string{"[dry-run] to all slaves: replicate_query:", "begin_tx:", "commit_tx:", "create_table:CREATE TABLE u", "sync_data:"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("dry run didn't print %q:\n%s", want, out)
+		}
+	}
+
+	// The same connection does get frames for real
+	dryRun = false
+	replicate(nil, "UPDATE t SET v = 2")
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(written(), "UPDATE t SET v = 2") {
+		if time.Now().After(deadline) {
+			t.Fatalf("slave got %q outside the dry run", written())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
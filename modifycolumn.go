@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Changing a column's type. The ALTER runs in strict mode on the master and
+// on every slave, so a narrowing change that would truncate existing values
+// fails instead of silently changing the data. A slave reports that failure
+// back through its replicate_ack.
+//
+// Adding a column. The ALTER, with its FIRST or AFTER position, is
+// replicated as written so the column lands in the same place on every
+// slave, and the cached columns are read again from the table. Replicated
+// INSERTs name their columns, so rows stay correct either way.
+
+var modifyColumnRe = regexp.MustCompile(`(?i)^\s*ALTER\s+TABLE\s+\S+\s+MODIFY\s+(COLUMN\s+)?`)
+
+// A column's definition as information_schema has it
+type columnDefinition struct {
+	colType    string
+	nullable   bool
+	defVal     sql.NullString
+	extra      string
+	comment    string
+	charset    sql.NullString
+	collation  sql.NullString
+	generation string
+}
+
+func readColumnDefinition(table, name string) (columnDefinition, error) {
+	var c columnDefinition
+	var nullable string
+	err := db.QueryRow(`SELECT COLUMN_TYPE, IS_NULLABLE, COLUMN_DEFAULT, EXTRA, COLUMN_COMMENT,
+		CHARACTER_SET_NAME, COLLATION_NAME, IFNULL(GENERATION_EXPRESSION, '')
+		FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?`, table, name).
+		Scan(&c.colType, &nullable, &c.defVal, &c.extra, &c.comment, &c.charset, &c.collation, &c.generation)
+	c.nullable = nullable == "YES"
+	return c, err
+}
+
+var (
+	// Defaults that are functions rather than values, as MySQL and MariaDB
+	// show them
+	timestampDefaultRe = regexp.MustCompile(`(?i)^(current_timestamp|now|localtime|localtimestamp)(\(\d*\))?$`)
+	onUpdateRe         = regexp.MustCompile(`(?i)\bon update (\S+)`)
+	generatedRe        = regexp.MustCompile(`(?i)\b(VIRTUAL|STORED) GENERATED\b`)
+)
+
+// Character types, the only ones that take a character set
+var charTypeRe = regexp.MustCompile(`(?i)^\s*(national\s+)?(char|varchar|tinytext|text|mediumtext|longtext|enum|set)\b`)
+
+// The definition for MODIFY COLUMN with the type changed to newType and
+// everything else (NOT NULL, default, ON UPDATE, AUTO_INCREMENT, generated
+// expression, comment, character set) as it was
+func (c columnDefinition) definition(newType string) string {
+	def := newType
+	if c.charset.Valid && charTypeRe.MatchString(newType) {
+		def += " CHARACTER SET " + c.charset.String
+		if c.collation.Valid {
+			def += " COLLATE " + c.collation.String
+		}
+	}
+	if m := generatedRe.FindStringSubmatch(c.extra); m != nil && c.generation != "" {
+		def += fmt.Sprintf(" GENERATED ALWAYS AS (%s) %s", c.generation, strings.ToUpper(m[1]))
+	}
+	if !c.nullable {
+		def += " NOT NULL"
+	}
+	if c.defVal.Valid {
+		switch {
+		case timestampDefaultRe.MatchString(c.defVal.String):
+			def += " DEFAULT " + c.defVal.String
+		case strings.Contains(c.extra, "DEFAULT_GENERATED"):
+			def += " DEFAULT (" + c.defVal.String + ")"
+		default:
+			def += " DEFAULT " + quoteString(c.defVal.String)
+		}
+	}
+	if m := onUpdateRe.FindStringSubmatch(c.extra); m != nil {
+		def += " ON UPDATE " + m[1]
+	}
+	if strings.Contains(strings.ToLower(c.extra), "auto_increment") {
+		def += " AUTO_INCREMENT"
+	}
+	if strings.Contains(c.extra, "INVISIBLE") {
+		def += " INVISIBLE"
+	}
+	if c.comment != "" {
+		def += " COMMENT " + quoteString(c.comment)
+	}
+	return def
+}
+
+func isModifyColumn(query string) bool {
+	return modifyColumnRe.MatchString(query)
+}
+
+// Run a statement on its own connection with strict mode on, so values that
+// don't fit are an error rather than a warning
+func execStrict(query string) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SET SESSION sql_mode = IF(@@SESSION.sql_mode = '', 'STRICT_ALL_TABLES',
+		CONCAT(@@SESSION.sql_mode, ',STRICT_ALL_TABLES'))`)
+	if err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, query)
+	return err
+}
+
+// Slot of data_type for a column type as DESCRIBE shows it. Types
+// outside the menu map to their closest entry (BIGINT is an INT, DOUBLE a
+// FLOAT...) so input validation still applies.
+func columnTypeIndex(colType string) int {
+	base := strings.ToLower(colType)
+	// BOOLEAN columns are stored as TINYINT(1)
+	if base == "tinyint(1)" || base == "bool" || base == "boolean" {
+		return typeBoolean
+	}
+	if i := strings.IndexAny(base, "( "); i >= 0 {
+		base = base[:i]
+	}
+	switch base {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint":
+		return typeInt
+	case "float", "double", "real":
+		return typeFloat
+	case "decimal", "dec", "numeric", "fixed":
+		return typeDecimal
+	case "tinytext", "text", "mediumtext", "longtext":
+		return typeText
+	case "json":
+		return typeJSON
+	case "date":
+		return typeDate
+	case "datetime", "timestamp":
+		return typeDatetime
+	}
+	return typeVarchar
+}
+
+// Ask for a column type from the menu or typed in, "" if the choice isn't
+// valid
+func chooseColumnType() string {
+	for j, dt := range data_type {
+		fmt.Printf("%d: %s\n", j+1, dt)
+	}
+	fmt.Printf("%d: Other (type it in, e.g. BIGINT)\n", len(data_type)+1)
+	fmt.Print("Enter choice: ")
+	x := readChoice()
+	var colType string
+	switch {
+	case x >= 1 && x <= len(data_type):
+		colType = data_type[x-1]
+		if colType == "DECIMAL" {
+			colType = readDecimalType()
+		}
+	case x == len(data_type)+1:
+		fmt.Print("Enter SQL type: ")
+		colType = strings.TrimSpace(readLine())
+	}
+	if strings.ContainsAny(colType, ";`") {
+		return ""
+	}
+	return colType
+}
+
+// Menu action: add a column to the current table, at the end or at a
+// chosen position
+func AddColumn() {
+	if txOpen() || !checkColumnCache() {
+		return
+	}
+	fmt.Print("Enter name for the new column: ")
+	name := strings.TrimSpace(readLine())
+	if name == "" || strings.ContainsAny(name, "`;") {
+		fmt.Println("Invalid column name")
+		return
+	}
+	fmt.Println("Choose data type:")
+	colType := chooseColumnType()
+	if colType == "" {
+		fmt.Println("Invalid data type")
+		return
+	}
+
+	// Every column, keys included, in table order
+	var existing []string
+	rows, err := db.Query("SHOW COLUMNS FROM " + quoteIdent(dialectMySQL, currentTable))
+	if err != nil {
+		fmt.Printf("Error reading columns of %s: %v\n", currentTable, err)
+		return
+	}
+	for rows.Next() {
+		var field, colType, nul, key, extra string
+		var defVal sql.NullString
+		if rows.Scan(&field, &colType, &nul, &key, &defVal, &extra) == nil {
+			existing = append(existing, field)
+		}
+	}
+	rows.Close()
+
+	fmt.Println("Where should it go?")
+	fmt.Println("1. After the last column")
+	fmt.Println("2. First")
+	fmt.Println("3. After a chosen column")
+	fmt.Print("Enter choice: ")
+	position := ""
+	switch readChoice() {
+	case 1:
+	case 2:
+		position = " FIRST"
+	case 3:
+		for i, c := range existing {
+			fmt.Printf("%d. %s\n", i+1, c)
+		}
+		fmt.Print("Select column (number): ")
+		choice := readChoice()
+		if choice < 1 || choice > len(existing) {
+			fmt.Println("Invalid column selection")
+			return
+		}
+		position = " AFTER " + quoteIdent(dialectMySQL, existing[choice-1])
+	default:
+		fmt.Println("Invalid choice")
+		return
+	}
+
+	query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s%s",
+		quoteIdent(dialectMySQL, currentTable), quoteIdent(dialectMySQL, name), colType, position)
+
+	defer beginWrite()()
+
+	if _, err := db.Exec(query); err != nil {
+		fmt.Printf("Error adding column: %v\n", err)
+		return
+	}
+	fmt.Printf("Column %s added to %s.\n", name, currentTable)
+	GetColumnInfo(currentTable)
+
+	replicate(nil, query)
+}
+
+// Menu action: change the type of a column in the current table
+func ModifyColumn() {
+	if txOpen() {
+		return
+	}
+	attrs := tableAttributes[currentTable]
+	if len(attrs) == 0 {
+		fmt.Println("Table has no columns besides its primary key")
+		return
+	}
+
+	fmt.Println("Columns:")
+	for i, attr := range attrs {
+		fmt.Printf("%d. %s\n", i+1, attr.Name)
+	}
+	fmt.Print("Select column (number): ")
+	choice := readChoice()
+	if choice < 1 || choice > len(attrs) {
+		fmt.Println("Invalid column selection")
+		return
+	}
+	name := attrs[choice-1].Name
+
+	col, err := readColumnDefinition(currentTable, name)
+	if err != nil {
+		fmt.Printf("Error reading column %s: %v\n", name, err)
+		return
+	}
+	fmt.Printf("Current type of %s: %s\n", name, col.colType)
+
+	fmt.Println("Choose new data type:")
+	newType := chooseColumnType()
+	if newType == "" {
+		fmt.Println("Invalid data type")
+		return
+	}
+
+	// MODIFY replaces the whole definition, everything but the type is kept
+	def := col.definition(newType)
+	query := fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s %s",
+		quoteIdent(dialectMySQL, currentTable), quoteIdent(dialectMySQL, name), def)
+
+	defer beginWrite()()
+
+	if err := execStrict(query); err != nil {
+		fmt.Printf("Error changing column type: %v\n", err)
+		fmt.Println("Existing values may not fit the new type, nothing was changed.")
+		return
+	}
+	fmt.Printf("Column %s is now %s.\n", name, newType)
+	GetColumnInfo(currentTable)
+
+	replicate(nil, query)
+}
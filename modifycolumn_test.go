@@ -0,0 +1,169 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Answer the information_schema lookup for one column, recording what it
+// was asked for
+func fakeColumn(f *fakeDB, asked *[]driver.Value, row ...driver.Value) {
+	f.on(`FROM information_schema.COLUMNS`, func(args []driver.Value) fakeResult {
+		*asked = args
+		return fakeResult{
+			cols: []string{"COLUMN_TYPE", "IS_NULLABLE", "COLUMN_DEFAULT", "EXTRA", "COLUMN_COMMENT",
+				"CHARACTER_SET_NAME", "COLLATION_NAME", "GENERATION_EXPRESSION"},
+			rows: [][]driver.Value{row},
+		}
+	})
+}
+
+func TestModifyColumnKeepsDefinition(t *testing.T) {
+	tests := []struct {
+		name    string
+		row     []driver.Value
+		newType string
+		want    string
+	}{
+		{
+			"timestamp",
+			[]driver.Value{"timestamp", "NO", "CURRENT_TIMESTAMP", "DEFAULT_GENERATED on update CURRENT_TIMESTAMP", "last change", nil, nil, ""},
+			"DATETIME",
+			"DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT 'last change'",
+		},
+		{
+			"mariadb timestamp",
+			[]driver.Value{"timestamp(3)", "YES", "current_timestamp(3)", "on update current_timestamp(3)", "", nil, nil, ""},
+			"DATETIME(3)",
+			"DATETIME(3) DEFAULT current_timestamp(3) ON UPDATE current_timestamp(3)",
+		},
+		{
+			"charset",
+			[]driver.Value{"varchar(20)", "YES", "it's", "", "", "latin1", "latin1_swedish_ci", ""},
+			"VARCHAR(100)",
+			"VARCHAR(100) CHARACTER SET latin1 COLLATE latin1_swedish_ci DEFAULT 'it''s'",
+		},
+		{
+			"charset dropped for a non-character type",
+			[]driver.Value{"varchar(20)", "YES", nil, "", "", "latin1", "latin1_swedish_ci", ""},
+			"INT",
+			"INT",
+		},
+		{
+			"auto increment",
+			[]driver.Value{"int", "NO", nil, "auto_increment", "", nil, nil, ""},
+			"BIGINT",
+			"BIGINT NOT NULL AUTO_INCREMENT",
+		},
+		{
+			"expression default",
+			[]driver.Value{"varchar(36)", "NO", "uuid()", "DEFAULT_GENERATED", "", "utf8mb4", "utf8mb4_0900_ai_ci", ""},
+			"CHAR(36)",
+			"CHAR(36) CHARACTER SET utf8mb4 COLLATE utf8mb4_0900_ai_ci NOT NULL DEFAULT (uuid())",
+		},
+		{
+			"generated",
+			[]driver.Value{"int", "YES", nil, "STORED GENERATED", "", nil, nil, "(`a` + 1)"},
+			"BIGINT",
+			"BIGINT GENERATED ALWAYS AS ((`a` + 1)) STORED",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := useFakeDB(t)
+			var asked []driver.Value
+			fakeColumn(f, &asked, tt.row...)
+			col, err := readColumnDefinition("orders", "created_at")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := col.definition(tt.newType); got != tt.want {
+				t.Fatalf("got  %s\nwant %s", got, tt.want)
+			}
+			// The name is matched exactly, "_" isn't a wildcard
+			if len(asked) != 2 || asked[0] != "orders" || asked[1] != "created_at" {
+				t.Fatalf("looked up %v", asked)
+			}
+		})
+	}
+}
+
+func TestModifyColumnRunsRebuiltDefinition(t *testing.T) {
+	f := useFakeDB(t)
+	useTable(t, f, "orders",
+		[]string{"id", "int", "NO", "PRI"},
+		[]string{"created_at", "timestamp", "NO", ""})
+	var asked []driver.Value
+	fakeColumn(f, &asked, "timestamp", "NO", "CURRENT_TIMESTAMP", "DEFAULT_GENERATED on update CURRENT_TIMESTAMP", "", nil, nil, "")
+	feedInput(t, "1", strconv.Itoa(len(data_type)+1), "DATETIME")
+
+	ModifyColumn()
+
+	got := f.matching(`^ALTER TABLE`)
+	want := "ALTER TABLE `orders` MODIFY COLUMN `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP"
+	if len(got) != 1 || got[0] != want {
+		t.Fatalf("ran %q", got)
+	}
+}
+
+func TestColumnTypesMapToTheirMenuEntry(t *testing.T) {
+	for colType, want := range map[string]string{
+		"int(11)":       "INT",
+		"bigint":        "INT",
+		"varchar(255)":  "VARCHAR(100)",
+		"double":        "FLOAT",
+		"longtext":      "TEXT",
+		"json":          "JSON",
+		"date":          "DATE",
+		"datetime":      "DATETIME",
+		"timestamp":     "DATETIME",
+		"tinyint(1)":    "BOOLEAN",
+		"tinyint(4)":    "INT",
+		"decimal(10,2)": "DECIMAL",
+		"enum('a','b')": "VARCHAR(100)",
+	} {
+		if got := data_type[columnTypeIndex(colType)]; got != want {
+			t.Errorf("%s maps to %s, want %s", colType, got, want)
+		}
+	}
+}
+
+func TestAddedColumnKeepsItsPositionOnTheSlave(t *testing.T) {
+	cases := []struct {
+		name  string
+		input []string
+		want  string
+	}{
+		{"last", []string{"email", "1", "1"}, "ALTER TABLE `people` ADD COLUMN `email` INT"},
+		{"first", []string{"email", "1", "2"}, "ALTER TABLE `people` ADD COLUMN `email` INT FIRST"},
+		{"after", []string{"email", "1", "3", "2"}, "ALTER TABLE `people` ADD COLUMN `email` INT AFTER `name`"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := useFakeDB(t)
+			throughSlave(t, f, func(*slaveConn) {})
+			useTable(t, f, "people",
+				[]string{"id", "int", "NO", "PRI"},
+				[]string{"name", "varchar(100)", "YES", ""},
+				[]string{"age", "int", "YES", ""})
+			f.rows("^SHOW COLUMNS FROM `people`$", []string{"Field", "Type", "Null", "Key", "Default", "Extra"},
+				[]driver.Value{"id", "int", "NO", "PRI", nil, ""},
+				[]driver.Value{"name", "varchar(100)", "YES", "", nil, ""},
+				[]driver.Value{"age", "int", "YES", "", nil, ""})
+			feedInput(t, c.input...)
+
+			AddColumn()
+
+			// Run on the master, then as it is on the slave
+			deadline := time.Now().Add(5 * time.Second)
+			for len(f.matching(`^ALTER TABLE`)) < 2 && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			if got := f.matching(`^ALTER TABLE`); len(got) != 2 || got[0] != c.want || got[1] != c.want {
+				t.Fatalf("ran %q, want %q on the master and the slave", got, c.want)
+			}
+		})
+	}
+}
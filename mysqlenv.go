@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// MySQL connection settings. Each one comes from its command line flag if
+// given, then from the config file (see configfile.go), otherwise from the
+// environment variable the mysql client uses (MYSQL_HOST, MYSQL_PORT,
+// MYSQL_USER, MYSQL_PWD, MYSQL_DATABASE), and only then is asked for. The
+// password has no flag so it stays out of ps output.
+//
+// Credentials only ever go into mysql.Config fields, and the Config goes to
+// the driver as it is (see openMySQL), so characters like @ : / ? # need
+// no escaping.
+
+type mysqlFlags struct {
+	host, port, user, database string
+}
+
+var connFlags mysqlFlags
+
+// Answers given at the prompts, by the setting they stand in for, so the
+// configuration shown at runtime has them (see config.go)
+var askedSettings = make(map[string]string)
+
+// Register the connection flags. withDatabase adds -mysql-database, which
+// only the master uses (a slave replicates whatever database the master has).
+func addMySQLFlags(fs *flag.FlagSet, withDatabase bool) {
+	fs.StringVar(&connFlags.host, "mysql-host", "", "MySQL server host (env MYSQL_HOST, default 127.0.0.1)")
+	fs.StringVar(&connFlags.port, "mysql-port", "", "MySQL server port (env MYSQL_PORT, default 3306)")
+	fs.StringVar(&connFlags.user, "mysql-user", "", "MySQL user (env MYSQL_USER)")
+	if withDatabase {
+		fs.StringVar(&connFlags.database, "mysql-database", "", "database to replicate (env MYSQL_DATABASE)")
+	}
+}
+
+// Pick a setting: the flag value, then the environment, then ask
+func resolveSetting(flagValue, env string, ask func() string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if v, ok := os.LookupEnv(env); ok && v != "" {
+		return v
+	}
+	return ask()
+}
+
+// Host and port of the MySQL server
+func mysqlAddr() string {
+	none := func() string { return "" }
+	host := resolveSetting(connFlags.host, "MYSQL_HOST", none)
+	port := resolveSetting(connFlags.port, "MYSQL_PORT", none)
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	if port == "" {
+		port = "3306"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+func mysqlUser(prompt string) string {
+	return resolveSetting(connFlags.user, "MYSQL_USER", func() string {
+		fmt.Print(prompt)
+		user := strings.TrimSpace(readLine())
+		askedSettings["mysql-user"] = user
+		return user
+	})
+}
+
+// The password may be set to an empty one on purpose, so only its absence
+// falls back to the prompt
+func mysqlPassword() string {
+	if configPassword != nil {
+		return *configPassword
+	}
+	pw, ok := os.LookupEnv("MYSQL_PWD")
+	if !ok {
+		pw = readPassword()
+		askedSettings["mysql-password"] = redactedText
+	}
+	// Never printed, even inside an error message (see redact.go)
+	registerSecret(pw)
+	return pw
+}
+
+func mysqlDatabase() string {
+	return resolveSetting(connFlags.database, "MYSQL_DATABASE", func() string {
+		fmt.Print("\nEnter your database name: ")
+		name := strings.TrimSpace(readLine())
+		askedSettings["mysql-database"] = name
+		return name
+	})
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestCredentialsSurviveTheDSN(t *testing.T) {
+	t.Setenv("MYSQL_USER", "")
+	t.Setenv("MYSQL_HOST", "db.example")
+	t.Setenv("MYSQL_PORT", "3307")
+	old := connFlags
+	connFlags = mysqlFlags{}
+	t.Cleanup(func() { connFlags = old })
+
+	// Read as whole lines, spaces inside the password included
+	feedInput(t, "  app@host/x  ", "p@ss:w/rd?#x y")
+	user := mysqlUser("")
+	password := readPassword()
+
+	cfg, err := mysql.ParseDSN(newMySQLConfig(user, password).FormatDSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.User != "app@host/x" || cfg.Passwd != "p@ss:w/rd?#x y" {
+		t.Fatalf("parsed back user %q, password %q", cfg.User, cfg.Passwd)
+	}
+	if cfg.Addr != "db.example:3307" || cfg.Net != "tcp" {
+		t.Fatalf("parsed back %s(%s)", cfg.Net, cfg.Addr)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net"
+	"regexp"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Server error numbers the code reacts to. Errors are classified by number
+// through *mysql.MySQLError rather than by their text, which changes
+// between server versions and locales.
+const (
+	errBadDB           = 1049 // ER_BAD_DB_ERROR: unknown database
+	errDupKeyName      = 1061 // ER_DUP_KEYNAME: index name already used
+	errDupEntry        = 1062 // ER_DUP_ENTRY: duplicate key value
+	errNoSuchTable     = 1146 // ER_NO_SUCH_TABLE: table doesn't exist
+	errLockWaitTimeout = 1205 // ER_LOCK_WAIT_TIMEOUT: gave up waiting for a row lock
+	errDeadlock        = 1213 // ER_LOCK_DEADLOCK: chosen as a deadlock victim
+	errServerShutdown  = 1053 // ER_SERVER_SHUTDOWN: server is shutting down
+	errConnKilled      = 1927 // ER_CONNECTION_KILLED: connection was killed
+)
+
+// Server error number of err, 0 if it isn't a MySQL server error
+func mysqlErrorNumber(err error) uint16 {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return myErr.Number
+	}
+	return 0
+}
+
+func isMissingTable(err error) bool {
+	return mysqlErrorNumber(err) == errNoSuchTable
+}
+
+func isDuplicateEntry(err error) bool {
+	return mysqlErrorNumber(err) == errDupEntry
+}
+
+func isUnknownDatabase(err error) bool {
+	return mysqlErrorNumber(err) == errBadDB
+}
+
+func isDuplicateIndex(err error) bool {
+	return mysqlErrorNumber(err) == errDupKeyName
+}
+
+// Transient errors, after which the same statement may well succeed
+func isRetryable(err error) bool {
+	switch mysqlErrorNumber(err) {
+	case errLockWaitTimeout, errDeadlock:
+		return true
+	}
+	return false
+}
+
+// The server went away: it can't be reached, or dropped the connection.
+// Says nothing about the statement, which can be run again once it's back.
+func isConnectionLost(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) {
+		return true
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return true
+	}
+	switch mysqlErrorNumber(err) {
+	case errServerShutdown, errConnKilled:
+		return true
+	}
+	return false
+}
+
+var missingTableRe = regexp.MustCompile(`'(?:[^'.]*\.)?([^'.]+)'`)
+
+// Name of the table a missing-table error is about, without the database
+func missingTableName(err error) (string, bool) {
+	var myErr *mysql.MySQLError
+	if !errors.As(err, &myErr) || myErr.Number != errNoSuchTable {
+		return "", false
+	}
+	m := missingTableRe.FindStringSubmatch(myErr.Message)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
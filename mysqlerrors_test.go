@@ -0,0 +1,58 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestMySQLErrorsAreClassifiedByNumber(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		err       error
+		retryable bool
+		lost      bool
+	}{
+		{"lock wait timeout", &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}, true, false},
+		{"deadlock", &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}, true, false},
+		{"wrapped deadlock", fmt.Errorf("local query execution error: %w", &mysql.MySQLError{Number: 1213}), true, false},
+		{"server shutdown", &mysql.MySQLError{Number: 1053, Message: "Server shutdown in progress"}, false, true},
+		{"connection killed", &mysql.MySQLError{Number: 1927, Message: "Connection was killed"}, false, true},
+		{"bad connection", driver.ErrBadConn, false, true},
+		{"invalid connection", mysql.ErrInvalidConn, false, true},
+		{"duplicate entry", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry '1' for key 'PRIMARY'"}, false, false},
+		{"missing table", &mysql.MySQLError{Number: 1146, Message: "Table 'shop.t' doesn't exist"}, false, false},
+		{"syntax error", &mysql.MySQLError{Number: 1064, Message: "You have an error in your SQL syntax"}, false, false},
+		// The text doesn't count, only the number
+		{"deadlock text only", errors.New("Deadlock found when trying to get lock"), false, false},
+		{"nil", nil, false, false},
+	} {
+		if got := isRetryable(tc.err); got != tc.retryable {
+			t.Errorf("%s: isRetryable = %v, want %v", tc.name, got, tc.retryable)
+		}
+		if got := isConnectionLost(tc.err); got != tc.lost {
+			t.Errorf("%s: isConnectionLost = %v, want %v", tc.name, got, tc.lost)
+		}
+	}
+}
+
+func TestMissingTableNameComesFromTheError(t *testing.T) {
+	for _, tc := range []struct {
+		err   error
+		table string
+		ok    bool
+	}{
+		{&mysql.MySQLError{Number: 1146, Message: "Table 'shop.orders' doesn't exist"}, "orders", true},
+		{&mysql.MySQLError{Number: 1146, Message: "Table 'orders' doesn't exist"}, "orders", true},
+		{&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'shop.orders'"}, "", false},
+		{errors.New("Table 'shop.orders' doesn't exist"), "", false},
+	} {
+		table, ok := missingTableName(tc.err)
+		if table != tc.table || ok != tc.ok {
+			t.Errorf("missingTableName(%v) = %q, %v, want %q, %v", tc.err, table, ok, tc.table, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Pausing the apply of replication on the slave, from the menu. The slave
+// stays connected and keeps receiving: replicated statements, whole master
+// transactions and synced rows are buffered with the pending operations,
+// under pendingPaused, and applied in order on resume. New statements
+// queue up behind the buffer until it is empty, the same way as while the
+// local database is down (see localdb.go), which takes precedence.
+
+const pendingPaused = "paused"
+
+// Guarded by pendingMu, like localDBDown
+var applyPaused bool
+
+func isApplyPaused() bool {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	return applyPaused
+}
+
+// Buffer op if apply is paused. pendingMu must be held.
+func bufferForPauseLocked(op pendingOp) bool {
+	if !applyPaused {
+		return false
+	}
+	op.received = time.Now()
+	pendingOps[pendingPaused] = append(pendingOps[pendingPaused], op)
+	return true
+}
+
+func pauseApply() {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	applyPaused = true
+}
+
+// Apply what was buffered while paused, in order, and stop buffering. If
+// the local database goes away meanwhile, the rest waits for it instead.
+func resumeApply() int {
+	replayMu.Lock()
+	defer replayMu.Unlock()
+	applied := 0
+	for {
+		pendingMu.Lock()
+		ops := pendingOps[pendingPaused]
+		if len(ops) == 0 {
+			applyPaused = false
+			pendingMu.Unlock()
+			return applied
+		}
+		op := ops[0]
+		pendingOps[pendingPaused] = ops[1:]
+		pendingMu.Unlock()
+
+		if err := replayOp(op); isConnectionLost(err) {
+			pendingMu.Lock()
+			rest := append([]pendingOp{op}, pendingOps[pendingPaused]...)
+			pendingOps[pendingPaused] = nil
+			applyPaused = false
+			noteLocalDBLostLocked(err)
+			pendingOps[pendingLocalDB] = append(rest, pendingOps[pendingLocalDB]...)
+			pendingMu.Unlock()
+			return applied
+		}
+		applied++
+	}
+}
+
+// Menu action: pause applying replication, or resume it
+func togglePauseApply() {
+	if !isApplyPaused() {
+		pauseApply()
+		fmt.Println("Replication paused. Changes from the master are buffered until you resume.")
+		return
+	}
+	fmt.Println("Resuming replication, applying the buffered changes...")
+	n := resumeApply()
+	fmt.Printf("Applied %d buffered change(s), replication continues\n", n)
+}
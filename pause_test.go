@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestPausedWritesAreListedAndAppliedOnResume(t *testing.T) {
+	f := useFakeDB(t)
+	acks := pipeMaster(t)
+	clearPending(t)
+
+	pauseApply()
+	applyReplicatedQuery(1, "INSERT INTO t (id) VALUES (1)")
+	applyReplicatedQuery(2, "UPDATE t SET v = 2 WHERE id = 1")
+	beginSlaveTx("7")
+	execInSlaveTx(3, "DELETE FROM u WHERE id = 3")
+	commitSlaveTx("7")
+
+	if n := len(f.matching(`^(INSERT|UPDATE|DELETE)`)); n != 0 {
+		t.Fatalf("%d statement(s) applied while paused", n)
+	}
+	paused := pendingSnapshot()[pendingPaused]
+	if len(paused) != 3 {
+		t.Fatalf("pending view lists %d paused operation(s), want 3", len(paused))
+	}
+	if paused[0].table != "t" || paused[2].tx == nil {
+		t.Fatalf("unexpected paused operations %+v", paused)
+	}
+
+	if n := resumeApply(); n != 3 {
+		t.Fatalf("resume applied %d, want 3", n)
+	}
+	want := []string{"INSERT INTO t (id) VALUES (1)", "UPDATE t SET v = 2 WHERE id = 1", "DELETE FROM u WHERE id = 3"}
+	got := f.matching(`^(INSERT|UPDATE|DELETE)`)
+	if len(got) != len(want) {
+		t.Fatalf("applied %q", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("applied %q, want %q", got, want)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if ack := nextAck(t, acks); ack != "replicate_ack:ok:" {
+			t.Fatalf("ack %q", ack)
+		}
+	}
+	if len(pendingSnapshot()) != 0 || isApplyPaused() {
+		t.Fatal("still paused after resume")
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Replication operations the slave has received but not applied (yet),
+// grouped by why they are waiting
+
+const pendingAwaitingSchema = "awaiting schema"
+
+// Statements being run again after a transient error (see retry.go)
+const pendingRetrying = "retrying"
+
+type pendingOp struct {
+	table    string
+	query    string
+	received time.Time
+	seq      uint64      // master position, where it is replayed (localdb.go)
+	tx       []pendingOp // statements of a master transaction, replayed together
+	ref      uint64      // set by trackPending to find the operation again
+}
+
+var pendingMu sync.Mutex
+var pendingOps = make(map[string][]pendingOp) // keyed by category
+var pendingRefs uint64                        // last ref handed out, guarded by pendingMu
+
+func addPending(category, table, query string) {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	pendingOps[category] = append(pendingOps[category], pendingOp{table: table, query: query, received: time.Now()})
+}
+
+// Add an operation for as long as it is in progress. Returns the function
+// removing it again.
+func trackPending(category, table, query string) func() {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	pendingRefs++
+	ref := pendingRefs
+	pendingOps[category] = append(pendingOps[category], pendingOp{table: table, query: query, received: time.Now(), ref: ref})
+	return func() {
+		pendingMu.Lock()
+		defer pendingMu.Unlock()
+		ops := pendingOps[category]
+		for i, op := range ops {
+			if op.ref == ref {
+				pendingOps[category] = append(ops[:i:i], ops[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func hasPending(category, table string) bool {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	for _, op := range pendingOps[category] {
+		if op.table == table {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove and return the pending operations in a category for one table
+func takePending(category, table string) []pendingOp {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	var taken, kept []pendingOp
+	for _, op := range pendingOps[category] {
+		if op.table == table {
+			taken = append(taken, op)
+		} else {
+			kept = append(kept, op)
+		}
+	}
+	pendingOps[category] = kept
+	return taken
+}
+
+// Copy of the pending operations, safe to read without the lock
+func pendingSnapshot() map[string][]pendingOp {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	snap := make(map[string][]pendingOp, len(pendingOps))
+	for category, ops := range pendingOps {
+		if len(ops) > 0 {
+			snap[category] = append([]pendingOp(nil), ops...)
+		}
+	}
+	return snap
+}
+
+func showPendingOperations() {
+	snap := pendingSnapshot()
+
+	fmt.Println("\n===== PENDING REPLICATION OPERATIONS =====")
+	if len(snap) == 0 {
+		fmt.Println("No pending operations")
+		return
+	}
+
+	categories := make([]string, 0, len(snap))
+	for category := range snap {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+
+	now := time.Now()
+	for _, category := range categories {
+		ops := snap[category]
+		fmt.Printf("%s: %d operation(s), oldest %v ago\n",
+			category, len(ops), now.Sub(ops[0].received).Round(time.Second))
+		for _, op := range ops {
+			query := op.query
+			if len(query) > 60 {
+				query = query[:60] + "..."
+			}
+			fmt.Printf("  - [%s] %v ago: %s\n", op.table, now.Sub(op.received).Round(time.Second), query)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestRetryingStatementIsListed(t *testing.T) {
+	f := useFakeDB(t)
+	clearPending(t)
+	oldBackoff := applyRetryBackoff
+	applyRetryBackoff = time.Millisecond
+	defer func() { applyRetryBackoff = oldBackoff }()
+
+	calls := 0
+	var listed []pendingOp
+	f.on(`^UPDATE t`, func([]driver.Value) fakeResult {
+		calls++
+		if calls == 1 {
+			return fakeResult{err: &mysql.MySQLError{Number: errDeadlock, Message: "Deadlock found"}}
+		}
+		listed = pendingSnapshot()[pendingRetrying]
+		return fakeResult{affected: 1}
+	})
+
+	if _, err := applyWithRetry("UPDATE t SET v = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].table != "t" {
+		t.Fatalf("listed as retrying: %+v", listed)
+	}
+	if left := pendingSnapshot()[pendingRetrying]; len(left) != 0 {
+		t.Fatalf("still listed after it went through: %+v", left)
+	}
+}
+
+func TestMissingTableOnMasterExpiresBuffer(t *testing.T) {
+	clearPending(t)
+	requests := pipeMaster(t)
+
+	awaitSchema("gone", "INSERT INTO gone VALUES (1)")
+	if got := nextLine(t, requests); got != "get_table_schema:gone" {
+		t.Fatalf("sent %q", got)
+	}
+	if !schemaRequestFailed("table 'gone' does not exist on master") {
+		t.Fatal("not taken for a schema answer")
+	}
+	if hasPending(pendingAwaitingSchema, "gone") {
+		t.Fatal("operations for a table the master doesn't have are kept")
+	}
+}
+
+func TestFailedSchemaRequestIsRetried(t *testing.T) {
+	clearPending(t)
+	requests := pipeMaster(t)
+	defer resetSchemaRetries("later")
+
+	awaitSchema("later", "INSERT INTO later VALUES (1)")
+	nextLine(t, requests)
+	if !schemaRequestFailed("Failed to get schema of table 'later': connection refused") {
+		t.Fatal("not taken for a schema answer")
+	}
+	if got := nextLine(t, requests); got != "get_table_schema:later" {
+		t.Fatalf("sent %q, want the request again", got)
+	}
+	if !hasPending(pendingAwaitingSchema, "later") {
+		t.Fatal("buffered operation dropped on a retry")
+	}
+
+	schemaRetriesMu.Lock()
+	schemaRetries["later"] = maxSchemaRetries
+	schemaRetriesMu.Unlock()
+	schemaRequestFailed("Failed to get schema of table 'later': connection refused")
+	if hasPending(pendingAwaitingSchema, "later") {
+		t.Fatal("buffered operation kept after the last retry")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Picking a record by its primary key for an update or delete. The key
+// columns come from the table's schema, so tables keyed on something other
+// than id, or on several columns, work the same way. A table without a
+// primary key has no way to name a single record, so the operations refuse.
+
+func columnNames(cols []column) []string {
+	names := make([]string, len(cols))
+	for i, c := range cols {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// Prompt for the key value of each key column. False if one is left
+// blank, which cancels the operation.
+func readKeyValues(keys []column, action string) ([]interface{}, bool) {
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		v, ok := readKeyValue(key, action)
+		if !ok {
+			fmt.Println("No key entered, cancelled")
+			return nil, false
+		}
+		values[i] = v
+	}
+	return values, true
+}
+
+// Prompt for one key value, asking again until it fits the column
+func readKeyValue(key column, action string) (interface{}, bool) {
+	for {
+		fmt.Printf("Enter %s of the record to %s: ", key.Name, action)
+		input := strings.TrimSpace(readLine())
+		if input == "" {
+			return nil, false
+		}
+		v, ok, problem := parseColumnValue(key, input)
+		if !ok {
+			fmt.Printf("Value for %s %s\n", key.Name, problem)
+			continue
+		}
+		return v, true
+	}
+}
+
+// WHERE condition with a placeholder per key column
+func keyCondition(keys []column) string {
+	conds := make([]string, len(keys))
+	for i, key := range keys {
+		conds[i] = key.Name + " = ?"
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// Key values for messages, e.g. "id 5" or "order_id 3, line 2"
+func describeKey(keys []column, values []interface{}) string {
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = fmt.Sprintf("%s %v", key.Name, values[i])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Slave side: prompt for the key of a record in table and return the WHERE
+// condition selecting it. The key is read from the local replica, which has
+// the master's schema; without a local connection id is assumed.
+func promptRecordKey(table, action string) (string, bool) {
+	keys := []string{"id"}
+	if db != nil {
+		var err error
+		keys, err = primaryKeyColumns(table)
+		if err != nil {
+			fmt.Printf("Error reading primary key of %s: %v\n", table, err)
+			return "", false
+		}
+	}
+	if len(keys) == 0 {
+		fmt.Printf("Table %s has no primary key, its records can't be picked to %s\n", table, action)
+		return "", false
+	}
+
+	conds := make([]string, len(keys))
+	for i, key := range keys {
+		fmt.Printf("Enter %s of record to %s: ", key, action)
+		conds[i] = fmt.Sprintf("%s = %s", key, sqlLiteral(strings.TrimSpace(readLine())))
+	}
+	return strings.Join(conds, " AND "), true
+}
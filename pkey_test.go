@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestReadKeyValuesAsksAgainOnBadValues(t *testing.T) {
+	keys := []column{{Name: "id", Type: typeInt}, {Name: "score", Type: typeFloat}, {Name: "code", Type: typeVarchar}}
+	feedInput(t, "12abc", "1.5", " 12 ", "Inf", "2.5x", "2.5", "A-7")
+	values, ok := readKeyValues(keys, "delete")
+	if !ok {
+		t.Fatal("cancelled")
+	}
+	if values[0] != 12 || values[1] != 2.5 || values[2] != "A-7" {
+		t.Fatalf("got %v, want [12 2.5 A-7]", values)
+	}
+}
+
+func TestReadKeyValuesBlankCancels(t *testing.T) {
+	feedInput(t, "3", "")
+	if values, ok := readKeyValues([]column{{Name: "a", Type: typeInt}, {Name: "b", Type: typeInt}}, "update"); ok {
+		t.Fatalf("got %v, want the operation cancelled", values)
+	}
+}
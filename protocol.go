@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxMessageSize is the default limit, in bytes, for a single protocol
+// message on either side of the connection.
+const MaxMessageSize = 1024 * 1024
+
+// Effective limit, set from --max-message-size
+var maxMessageSize = MaxMessageSize
+
+// Line-oriented reader for protocol messages. Works like bufio.Scanner, but
+// a message longer than the limit is skipped and reported through
+// onTooLarge instead of ending the whole stream.
+//
+// Messages are delimited by newlines: ReadSlice keeps reading until the
+// newline arrives, and a message split over several TCP segments is put
+// back together before Scan returns it. A line reading lengthFraming
+// switches the rest of the stream to length-prefixed frames (see
+// encodeFrame), read with io.ReadFull. Text returns the same message
+// either way.
+type messageScanner struct {
+	r          *bufio.Reader
+	max        int
+	line       string
+	err        error
+	onTooLarge func(size int)
+	framed     bool
+}
+
+func newMessageScanner(r io.Reader, onTooLarge func(size int)) *messageScanner {
+	return &messageScanner{
+		r:          bufio.NewReaderSize(r, 64*1024),
+		max:        maxMessageSize,
+		onTooLarge: onTooLarge,
+	}
+}
+
+func (s *messageScanner) Scan() bool {
+	if s.framed {
+		return s.scanFrame()
+	}
+	if !s.scanLine() {
+		return false
+	}
+	if s.line == lengthFraming {
+		s.framed = true
+		return s.scanFrame()
+	}
+	return true
+}
+
+func (s *messageScanner) scanLine() bool {
+	for {
+		var buf []byte
+		size := 0
+		tooLarge := false
+
+		for {
+			chunk, err := s.r.ReadSlice('\n')
+			size += len(chunk)
+			if !tooLarge {
+				buf = append(buf, chunk...)
+				if len(bytes.TrimRight(buf, "\r\n")) > s.max {
+					// Keep reading to the end of the line, but drop the data
+					tooLarge = true
+					buf = nil
+				}
+			}
+
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+			if err != nil {
+				if err != io.EOF {
+					s.err = err
+				}
+				if err == io.EOF && len(buf) > 0 {
+					// Final message without a trailing newline
+					s.line = string(bytes.TrimRight(buf, "\r\n"))
+					return true
+				}
+				if tooLarge && s.onTooLarge != nil {
+					s.onTooLarge(size)
+				}
+				return false
+			}
+			break
+		}
+
+		if tooLarge {
+			if s.onTooLarge != nil {
+				s.onTooLarge(size - 1)
+			}
+			continue
+		}
+
+		s.line = string(bytes.TrimRight(buf, "\r\n"))
+		return true
+	}
+}
+
+func (s *messageScanner) scanFrame() bool {
+	for {
+		header, err := s.r.ReadSlice('\n')
+		if err != nil {
+			if err != io.EOF || len(header) > 0 {
+				s.err = fmt.Errorf("reading frame header: %w", err)
+			}
+			return false
+		}
+		typ, sizeStr, ok := strings.Cut(strings.TrimRight(string(header), "\r\n"), ":")
+		size, err := strconv.Atoi(sizeStr)
+		if !ok || err != nil || size < 0 {
+			s.err = fmt.Errorf("invalid frame header %q", header)
+			return false
+		}
+
+		// The limit is on the whole message, as for lines
+		msgSize := size
+		if typ != "" {
+			msgSize += len(typ) + 1
+		}
+		if msgSize > s.max {
+			if _, err := io.CopyN(io.Discard, s.r, int64(size)); err != nil {
+				s.err = err
+				return false
+			}
+			if s.onTooLarge != nil {
+				s.onTooLarge(msgSize)
+			}
+			continue
+		}
+
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(s.r, payload); err != nil {
+			s.err = err
+			return false
+		}
+		if typ == "" {
+			s.line = string(payload)
+		} else {
+			s.line = typ + ":" + string(payload)
+		}
+		return true
+	}
+}
+
+func (s *messageScanner) Text() string {
+	return s.line
+}
+
+func (s *messageScanner) Err() error {
+	return s.err
+}
+
+// Length-prefixed framing of master to slave messages, so values holding
+// newlines reach the slave intact. A slave that can read frames says so
+// with slave_info:framing:length; the master answers with a lengthFraming
+// line and sends every message after it as a frame:
+//
+//	<type>:<length>\n<payload>
+//
+// where length is the payload's size in bytes. The message is
+// <type>:<payload>, or just the payload for an empty type, used for the
+// untyped lines of a SELECT result. Masters and slaves that don't know
+// about frames keep sending and reading lines.
+const lengthFraming = "framing:length"
+
+var frameTypeRe = regexp.MustCompile(`^\w+$`)
+
+// Frame one message, given with or without its newline
+func encodeFrame(msg string) string {
+	msg = strings.TrimSuffix(msg, "\n")
+	typ, payload, ok := strings.Cut(msg, ":")
+	if !ok || !frameTypeRe.MatchString(typ) {
+		typ, payload = "", msg
+	}
+	return fmt.Sprintf("%s:%d\n%s", typ, len(payload), payload)
+}
+
+// SELECT results are sent as comma separated text lines, which binary
+// values can't survive. Binary columns are listed in an ENCODING line after
+// the column names, e.g. "ENCODING:,base64," for the second of three
+// columns, and their values are sent as "b64:<base64>" (NULL stays NULL).
+// Values of compressThreshold bytes or more are sent zlib compressed as
+// "z64:<base64>" instead, when that comes out shorter.
+const (
+	binaryValuePrefix     = "b64:"
+	compressedValuePrefix = "z64:"
+	compressThreshold     = 1024
+)
+
+// Which result columns hold binary data (BLOB, BINARY, VARBINARY and such)
+func binaryColumns(rows *sql.Rows) []bool {
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil
+	}
+	binary := make([]bool, len(types))
+	for i, t := range types {
+		switch t.DatabaseTypeName() {
+		case "BLOB", "TINYBLOB", "MEDIUMBLOB", "LONGBLOB", "BINARY", "VARBINARY", "BIT", "GEOMETRY":
+			binary[i] = true
+		}
+	}
+	return binary
+}
+
+// ENCODING line for the result, or "" when no column is binary
+func encodingLine(binary []bool) string {
+	encodings := make([]string, len(binary))
+	found := false
+	for i, b := range binary {
+		if b {
+			encodings[i] = "base64"
+			found = true
+		}
+	}
+	if !found {
+		return ""
+	}
+	return "ENCODING:" + strings.Join(encodings, ",")
+}
+
+// Columns marked as base64 in an ENCODING line
+func parseEncodingLine(line string) []bool {
+	encodings := strings.Split(strings.TrimPrefix(line, "ENCODING:"), ",")
+	binary := make([]bool, len(encodings))
+	for i, e := range encodings {
+		binary[i] = e == "base64"
+	}
+	return binary
+}
+
+func encodeBinaryValue(v []byte) string {
+	plain := binaryValuePrefix + base64.StdEncoding.EncodeToString(v)
+	if len(v) < compressThreshold {
+		return plain
+	}
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(v)
+	zw.Close()
+	if compressed := compressedValuePrefix + base64.StdEncoding.EncodeToString(buf.Bytes()); len(compressed) < len(plain) {
+		return compressed
+	}
+	return plain
+}
+
+// Decode a "b64:" or "z64:" value sent by encodeBinaryValue
+func decodeBinaryValue(v string) ([]byte, bool) {
+	encoded, compressed := strings.CutPrefix(v, compressedValuePrefix)
+	if !compressed {
+		var ok bool
+		if encoded, ok = strings.CutPrefix(v, binaryValuePrefix); !ok {
+			return nil, false
+		}
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, false
+	}
+	if !compressed {
+		return data, true
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, false
+	}
+	defer zr.Close()
+	data, err = io.ReadAll(zr)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// Split a result row into its values: nil for NULL, the exact bytes for
+// binary columns and the text for the others. False when the row doesn't
+// split into one field per column, as text values containing commas make
+// the split ambiguous.
+func decodeResultRow(row string, binary []bool) ([]interface{}, bool) {
+	fields := strings.Split(row, ",")
+	if len(fields) != len(binary) {
+		return nil, false
+	}
+	values := make([]interface{}, len(fields))
+	for i, f := range fields {
+		switch {
+		case f == "NULL":
+		case binary[i]:
+			data, ok := decodeBinaryValue(f)
+			if !ok {
+				return nil, false
+			}
+			values[i] = data
+		default:
+			values[i] = f
+		}
+	}
+	return values, true
+}
+
+// Make a result row printable. Binary values are shown as they are when
+// they are printable text, as hex otherwise. Rows decodeResultRow can't
+// split are shown as sent.
+func displayRow(row string, binary []bool) string {
+	if len(binary) == 0 {
+		return row
+	}
+	values, ok := decodeResultRow(row, binary)
+	if !ok {
+		return row
+	}
+	fields := make([]string, len(values))
+	for i, v := range values {
+		switch v := v.(type) {
+		case nil:
+			fields[i] = "NULL"
+		case []byte:
+			if isPrintable(v) {
+				fields[i] = string(v)
+			} else {
+				fields[i] = "0x" + hex.EncodeToString(v)
+			}
+		default:
+			fields[i] = v.(string)
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+// Valid UTF-8 without control characters
+func isPrintable(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// A slave that asks with slave_info:payload_encoding:base64 gets the
+// statements of sync_data and replicate_query base64 encoded, with the same
+// "b64:" mark as binary values, so whatever the statement holds can't be
+// mistaken for the end of the message. Unmarked statements, e.g. from
+// older masters or snapshots, are used as they are.
+func encodePayload(query string) string {
+	return binaryValuePrefix + base64.StdEncoding.EncodeToString([]byte(query))
+}
+
+func decodePayload(payload string) (string, error) {
+	encoded, ok := strings.CutPrefix(payload, binaryValuePrefix)
+	if !ok {
+		return payload, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	return string(data), err
+}
+
+// Per-table outcome of an initial sync, sent as sync_summary:<json list>
+// just before replication_complete
+type tableSyncStatus struct {
+	Table  string `json:"table"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+const (
+	syncOK      = "ok"
+	syncPartial = "partial" // schema sent, some rows missing
+	syncSkipped = "skipped" // not sent at all
+)
+
+func encodeSyncSummary(summary []tableSyncStatus) string {
+	if summary == nil {
+		summary = []tableSyncStatus{}
+	}
+	data, _ := json.Marshal(summary)
+	return string(data)
+}
+
+func decodeSyncSummary(content string) ([]tableSyncStatus, error) {
+	var summary []tableSyncStatus
+	err := json.Unmarshal([]byte(content), &summary)
+	return summary, err
+}
+
+// Cluster-wide statistics pushed as stats:<json> to slaves that asked for
+// them with slave_info:stats_interval:<seconds>
+type clusterStats struct {
+	Time         time.Time `json:"time"`
+	Slaves       int       `json:"slaves"`
+	SyncedSlaves int       `json:"synced_slaves"`
+	Position     uint64    `json:"position"`
+	Tables       int       `json:"tables"`
+	Rows         int64     `json:"rows"` // InnoDB's estimate
+}
+
+func encodeStats(st clusterStats) string {
+	data, _ := json.Marshal(st)
+	return string(data)
+}
+
+func decodeStats(content string) (clusterStats, error) {
+	var st clusterStats
+	err := json.Unmarshal([]byte(content), &st)
+	return st, err
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessageScannerLimit(t *testing.T) {
+	const limit = 64
+	at := "a:" + strings.Repeat("x", limit-2)
+	over := "b:" + strings.Repeat("y", limit-1)
+
+	for _, framed := range []bool{false, true} {
+		var stream strings.Builder
+		for _, msg := range []string{at, over, "c:after"} {
+			if framed {
+				stream.WriteString(encodeFrame(msg))
+			} else {
+				stream.WriteString(msg + "\n")
+			}
+		}
+		input := stream.String()
+		if framed {
+			input = lengthFraming + "\n" + input
+		}
+
+		var rejected []int
+		sc := newMessageScanner(strings.NewReader(input), func(size int) { rejected = append(rejected, size) })
+		sc.max = limit
+		var got []string
+		for sc.Scan() {
+			got = append(got, sc.Text())
+		}
+		if sc.Err() != nil {
+			t.Fatalf("framed=%v: %v", framed, sc.Err())
+		}
+		if len(got) != 2 || got[0] != at || got[1] != "c:after" {
+			t.Fatalf("framed=%v: got %q", framed, got)
+		}
+		if len(rejected) != 1 || rejected[0] != len(over) {
+			t.Fatalf("framed=%v: rejected %v, want one of %d bytes", framed, rejected, len(over))
+		}
+	}
+}
+
+func TestDisplayRowShowsBinaryValues(t *testing.T) {
+	binary := []bool{false, true, true}
+	row := "1," + encodeBinaryValue([]byte("héllo")) + "," + encodeBinaryValue([]byte{0, 0xff})
+	if got := displayRow(row, binary); got != "1,héllo,0x00ff" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestFrameSplitOverManyWrites(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	msg := "sync_data:INSERT INTO t VALUES ('a\nb:c\r\n" + strings.Repeat("z", 5000) + "')"
+	stream := lengthFraming + "\n" + encodeFrame(msg) + encodeFrame("replication_complete:done")
+	go func() {
+		// A few bytes at a time, splitting the length prefix, the header's
+		// newline and the payload
+		for i := 0; i < len(stream); {
+			n := min(1+i%7, len(stream)-i)
+			if _, err := client.Write([]byte(stream[i : i+n])); err != nil {
+				return
+			}
+			i += n
+			if i < 64 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+
+	server.SetReadDeadline(time.Now().Add(10 * time.Second))
+	sc := newMessageScanner(server, nil)
+	for _, want := range []string{msg, "replication_complete:done"} {
+		if !sc.Scan() {
+			t.Fatalf("stream ended: %v", sc.Err())
+		}
+		if sc.Text() != want {
+			t.Fatalf("got %d bytes, want the %d sent", len(sc.Text()), len(want))
+		}
+	}
+}
+
+// A master connection, set up by setup, whose frames go through the
+// slave's message loop, both ends on the fake database f. Returns the
+// connection and the INSERTs run once there are n of them.
+func throughSlave(t *testing.T, f *fakeDB, setup func(s *slaveConn)) (*slaveConn, func(n int) []string) {
+	t.Helper()
+	pipeMaster(t)
+	clearPending(t)
+	oldSeq := appliedSeq
+	server, client := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		readMasterMessages(client)
+		close(done)
+	}()
+	s := newSlaveConn(server)
+	setup(s)
+	s.startFraming()
+	s.syncFinished()
+	registerSlave(t, s)
+	t.Cleanup(func() {
+		s.close()
+		client.Close()
+		<-done
+		appliedSeq = oldSeq
+		resetReplicated()
+	})
+	return s, func(n int) []string {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if got := f.matching(`^INSERT`); len(got) >= n {
+				return got
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("slave ran %q, want %d INSERTs", f.matching(`^INSERT`), n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+// The string literals of a statement, read back the way MySQL reads what
+// quoteString writes
+func stringLiterals(stmt string) []string {
+	var out []string
+	for i := 0; i < len(stmt); i++ {
+		if stmt[i] != '\'' {
+			continue
+		}
+		var b strings.Builder
+		for i++; i < len(stmt); i++ {
+			c := stmt[i]
+			if c == '\\' && i+1 < len(stmt) {
+				i++
+				b.WriteByte(stmt[i])
+				continue
+			}
+			if c == '\'' {
+				if i+1 < len(stmt) && stmt[i+1] == '\'' {
+					b.WriteByte('\'')
+					i++
+					continue
+				}
+				break
+			}
+			b.WriteByte(c)
+		}
+		out = append(out, b.String())
+	}
+	return out
+}
+
+func TestFramedRowsKeepNewlinesCarriageReturnsAndColons(t *testing.T) {
+	value := "first\nsecond\r\nkey: value\r:\n"
+	s, inserts := throughSlave(t, useFakeDB(t), func(s *slaveConn) { s.wantsFraming = true })
+
+	sendRowBatch(s, "notes", []string{"id", "body"}, [][]interface{}{{int64(1), value}})
+	replicate(nil, "INSERT INTO notes (id, body) VALUES (2, "+sqlLiteral(value)+")")
+
+	got := inserts(2)
+	for i, stmt := range got {
+		lits := stringLiterals(stmt)
+		if len(lits) != 1 || lits[0] != value {
+			t.Errorf("insert %d stored %q, want %q", i, lits, value)
+		}
+	}
+}
+
+func TestBase64PayloadsKeepColonsAndNewlines(t *testing.T) {
+	value := "a:b\nc"
+	s, inserts := throughSlave(t, useFakeDB(t), func(s *slaveConn) { s.base64Payloads = true })
+
+	sendRowBatch(s, "notes", []string{"id", "body"}, [][]interface{}{{int64(1), value}})
+	replicate(nil, "INSERT INTO notes (id, body) VALUES (2, "+sqlLiteral(value)+")")
+
+	got := inserts(2)
+	for i, stmt := range got {
+		lits := stringLiterals(stmt)
+		if len(lits) != 1 || lits[0] != value {
+			t.Errorf("insert %d stored %q, want %q", i, lits, value)
+		}
+	}
+}
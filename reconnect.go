@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Slave reconnection. Every connect attempt, from the menu or from the
+// background loop, runs under connectMu, and at most one background loop
+// runs at a time. It stops as soon as a connection is up, whoever made it.
+
+var reconnectInterval = 5 * time.Second
+
+// Set while the background loop runs, guarded by connectMu
+var retrying bool
+
+// Connect to the master, dropping the current connection first. If that
+// fails the background loop keeps trying.
+func reconnectToMaster(addr string) bool {
+	connectMu.Lock()
+	defer connectMu.Unlock()
+
+	if connected {
+		master.Close()
+		connected = false
+	}
+	if connectToMaster(addr) {
+		return true
+	}
+	if !retrying {
+		retrying = true
+		go retryConnect(addr)
+	}
+	return false
+}
+
+func retryConnect(addr string) {
+	for {
+		time.Sleep(reconnectInterval)
+
+		connectMu.Lock()
+		if !connected {
+			fmt.Println("Attempting to reconnect to master...")
+			connectToMaster(addr)
+		}
+		if connected {
+			retrying = false
+			connectMu.Unlock()
+			return
+		}
+		connectMu.Unlock()
+	}
+}
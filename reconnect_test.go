@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A connection the slave made to listenAsMaster, with the lines it sends
+type masterSide struct {
+	conn  net.Conn
+	lines chan string
+}
+
+// Read the slave's handshake up to its subscribe line, which is returned
+func (m masterSide) handshake(t *testing.T) []string {
+	t.Helper()
+	var got []string
+	for {
+		line := nextLine(t, m.lines)
+		got = append(got, line)
+		if strings.HasPrefix(line, "subscribe:") {
+			return got
+		}
+	}
+}
+
+// A master on loopback for the slave's connection code. Every connection
+// arrives on the returned channel. The slave's connection state is put
+// back afterwards.
+func listenAsMaster(t *testing.T) (string, <-chan masterSide) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make(chan masterSide, 16)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			m := masterSide{conn, make(chan string, 1024)}
+			go func() {
+				sc := bufio.NewScanner(conn)
+				for sc.Scan() {
+					m.lines <- sc.Text()
+				}
+				close(m.lines)
+			}()
+			conns <- m
+		}
+	}()
+
+	oldMaster, oldConnected, oldEver := master, connected, everConnected
+	oldVerify, oldRepair := verifyAfterSync, repairAfterVerify
+	t.Cleanup(func() {
+		ln.Close()
+		connectMu.Lock()
+		if connected {
+			master.Close()
+		}
+		connectMu.Unlock()
+		listeners.Wait()
+		master, connected, everConnected = oldMaster, oldConnected, oldEver
+		verifyAfterSync, repairAfterVerify = oldVerify, oldRepair
+	})
+	return ln.Addr().String(), conns
+}
+
+func nextMasterConn(t *testing.T, conns <-chan masterSide) masterSide {
+	t.Helper()
+	select {
+	case m := <-conns:
+		return m
+	case <-time.After(10 * time.Second):
+		t.Fatal("the slave didn't connect")
+	}
+	return masterSide{}
+}
+
+// Wait for the slave to notice its connection is gone
+func waitDisconnected(t *testing.T) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		connectMu.Lock()
+		up := connected
+		connectMu.Unlock()
+		if !up {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("still connected")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReconnectVerifiesAndRepairs(t *testing.T) {
+	f := useFakeDB(t)
+	f.rows(`^SHOW TABLES$`, []string{"Tables"})
+	everConnected = false
+	addr, conns := listenAsMaster(t)
+
+	// The first connection's sync is trusted
+	if !connectToMaster(addr) {
+		t.Fatal("not connected")
+	}
+	first := nextMasterConn(t, conns)
+	first.handshake(t)
+	fmt.Fprint(first.conn, "replication_complete:\n")
+	if got := nextLine(t, first.lines); !strings.HasPrefix(got, "sync_ack:") {
+		t.Fatalf("got %q, want the sync ack", got)
+	}
+	noMoreLines(t, first.lines)
+
+	first.conn.Close()
+	waitDisconnected(t)
+
+	// After a reconnect the replica is checked once the sync is done
+	if !connectToMaster(addr) {
+		t.Fatal("not reconnected")
+	}
+	second := nextMasterConn(t, conns)
+	second.handshake(t)
+	fmt.Fprint(second.conn, "replication_complete:\n")
+	if got := nextLine(t, second.lines); !strings.HasPrefix(got, "sync_ack:") {
+		t.Fatalf("got %q, want the sync ack", got)
+	}
+	if got := nextLine(t, second.lines); got != "verify_replication:schema" {
+		t.Fatalf("got %q, want the verification request", got)
+	}
+
+	// and the tables that differ are repaired
+	fmt.Fprint(second.conn, "verification_data:begin\ntable:orders:3\nverification_data:end\n")
+	if got := nextLine(t, second.lines); got != "get_table_schema:orders" {
+		t.Fatalf("got %q, want the missing table fetched", got)
+	}
+}
+
+// Background reconnect loops running
+func retryLoops() int {
+	buf := make([]byte, 1<<20)
+	return strings.Count(string(buf[:runtime.Stack(buf, true)]), ".retryConnect(")
+}
+
+func TestBurstOfReconnectsStartsOneLoop(t *testing.T) {
+	useFakeDB(t)
+	addr, conns := listenAsMaster(t)
+	old := reconnectInterval
+	reconnectInterval = 10 * time.Millisecond
+	t.Cleanup(func() { reconnectInterval = old })
+
+	// Nothing listens at the master's address for now
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := ln.Addr().String()
+	ln.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reconnectToMaster(down)
+		}()
+	}
+	wg.Wait()
+	if n := retryLoops(); n != 1 {
+		t.Fatalf("%d reconnect loops running, want 1", n)
+	}
+
+	// Connected by hand meanwhile, the loop stops
+	connectMu.Lock()
+	connectToMaster(addr)
+	connectMu.Unlock()
+	nextMasterConn(t, conns)
+	deadline := time.Now().Add(5 * time.Second)
+	for retryLoops() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("reconnect loop still running once connected")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	connectMu.Lock()
+	defer connectMu.Unlock()
+	if retrying {
+		t.Fatal("still marked as retrying")
+	}
+}
+
+func TestReconnectSubscribesAgainWithTheSameState(t *testing.T) {
+	useFakeDB(t)
+	addr, conns := listenAsMaster(t)
+	oldSchemaOnly := schemaOnly
+	schemaOnly = true
+	t.Cleanup(func() { schemaOnly = oldSchemaOnly })
+
+	if !reconnectToMaster(addr) {
+		t.Fatal("not connected")
+	}
+	first := nextMasterConn(t, conns)
+	before := first.handshake(t)
+
+	if !reconnectToMaster(addr) {
+		t.Fatal("not reconnected")
+	}
+	second := nextMasterConn(t, conns)
+	after := second.handshake(t)
+
+	if got := after[len(after)-1]; got != "subscribe:schema_only" {
+		t.Fatalf("reconnect subscribed with %q, want the schema-only subscription again", got)
+	}
+	if after[0] != before[0] || after[0] != "slave_info:slave_id:"+slaveID {
+		t.Fatalf("reconnect introduced itself as %q, first as %q", after[0], before[0])
+	}
+	// The stale connection is dropped
+	select {
+	case _, open := <-first.lines:
+		if open {
+			t.Fatal("more sent on the old connection")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("old connection still open")
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Credential redaction for everything the program prints. Stdout and
+// stderr are replaced with pipes at startup and a copier scrubs the text
+// before it reaches the real terminal, so an error that quotes a DSN or a
+// password can't leak it no matter which Printf wrote it:
+//
+//   - every secret passed to registerSecret (the MySQL password) is
+//     replaced with ****. Secrets shorter than minSecretLen aren't: masking
+//     every "a" or "12" would garble ordinary output, so a password that
+//     short is only masked where it appears in a DSN.
+//   - so is the password part of anything shaped like a DSN,
+//     user:password@tcp(...) or user:password@unix(...)
+//
+// The copier works line by line so a secret can't be split across two
+// writes. A partial line (a prompt) is flushed after flushDelay. Because
+// output is copied asynchronously, the program exits through exitProgram,
+// which waits for it to be written first.
+
+const redactedText = "****"
+
+const flushDelay = 20 * time.Millisecond
+
+const minSecretLen = 4
+
+var (
+	secretsMu sync.Mutex
+	secrets   []string
+)
+
+var dsnPasswordRe = regexp.MustCompile(`([^\s:/@'"]*):[^\s'"]+?@(tcp|unix)\(`)
+
+// Keep a value out of all output from now on
+func registerSecret(secret string) {
+	if len(secret) < minSecretLen {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, s := range secrets {
+		if s == secret {
+			return
+		}
+	}
+	secrets = append(secrets, secret)
+}
+
+// Text with every known secret and DSN password masked
+func redact(text string) string {
+	text = dsnPasswordRe.ReplaceAllString(text, "${1}:"+redactedText+"@${2}(")
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, s := range secrets {
+		text = strings.ReplaceAll(text, s, redactedText)
+	}
+	return text
+}
+
+// One redirected output stream
+type redactedStream struct {
+	real *os.File
+	pipe *os.File // write end, installed as os.Stdout or os.Stderr
+	done chan struct{}
+}
+
+var (
+	streams    []*redactedStream
+	drainOnce  sync.Once
+	realStderr = os.Stderr
+)
+
+// Route stdout, stderr and the log package through the redaction copier.
+// Output is left alone if the pipes can't be created.
+func installRedaction() {
+	stdout, err := redirectStream(os.Stdout)
+	if err != nil {
+		return
+	}
+	stderr, err := redirectStream(os.Stderr)
+	if err != nil {
+		stdout.pipe.Close()
+		<-stdout.done
+		return
+	}
+	streams = []*redactedStream{stdout, stderr}
+	os.Stdout, os.Stderr = stdout.pipe, stderr.pipe
+	// log is only used for fatal errors, which exit right after writing,
+	// so it writes directly once everything before it is out
+	log.SetOutput(fatalLogWriter{})
+}
+
+func redirectStream(real *os.File) (*redactedStream, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	s := &redactedStream{real: real, pipe: w, done: make(chan struct{})}
+	go s.copy(r)
+	return s, nil
+}
+
+func (s *redactedStream) copy(r *os.File) {
+	defer close(s.done)
+	chunks := make(chan string)
+	go func() {
+		defer close(chunks)
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				chunks <- string(buf[:n])
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	var pending string
+	var flush <-chan time.Time
+	for {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				io.WriteString(s.real, redact(pending))
+				return
+			}
+			pending += chunk
+			if i := strings.LastIndexByte(pending, '\n'); i >= 0 {
+				io.WriteString(s.real, redact(pending[:i+1]))
+				pending = pending[i+1:]
+			}
+			flush = nil
+			if pending != "" {
+				flush = time.After(flushDelay)
+			}
+		case <-flush:
+			io.WriteString(s.real, redact(pending))
+			pending, flush = "", nil
+		}
+	}
+}
+
+// Write out everything still in the pipes and put the real stdout and
+// stderr back. Anything printed afterwards isn't redacted, so this is only
+// called on the way out.
+func drainOutput() {
+	drainOnce.Do(func() {
+		for _, s := range streams {
+			s.pipe.Close()
+			<-s.done
+		}
+		if len(streams) == 2 {
+			os.Stdout, os.Stderr = streams[0].real, streams[1].real
+		}
+	})
+}
+
+// Use instead of os.Exit so no output is lost
+func exitProgram(code int) {
+	drainOutput()
+	os.Exit(code)
+}
+
+type fatalLogWriter struct{}
+
+func (fatalLogWriter) Write(p []byte) (int, error) {
+	drainOutput()
+	if _, err := io.WriteString(realStderr, redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
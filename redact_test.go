@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestShortSecretsOnlyMaskedInDSNs(t *testing.T) {
+	secretsMu.Lock()
+	old := secrets
+	secretsMu.Unlock()
+	t.Cleanup(func() {
+		secretsMu.Lock()
+		secrets = old
+		secretsMu.Unlock()
+	})
+
+	registerSecret("ab")
+	registerSecret("hunter2")
+	got := redact("table about: abc, login hunter2, dsn root:ab@tcp(db:3306)/x")
+	want := "table about: abc, login ****, dsn root:****@tcp(db:3306)/x"
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// Full Resync: throw the local copy away and replicate everything again.
+// The slave disconnects, drops every local table and view, and connects
+// again, which makes the master send a full sync. That sync waits for a
+// slot like any other (see synclimit.go), and since it is a reconnect the
+// copy is verified, and repaired if needed, once it completes.
+func fullResync(addr string) {
+	if db == nil {
+		fmt.Println("Local database not set up yet")
+		return
+	}
+	if !connected {
+		fmt.Println("Not connected to master server")
+		return
+	}
+
+	tables, views, err := localTablesAndViews()
+	if err != nil {
+		fmt.Printf("Error getting local tables: %v\n", err)
+		return
+	}
+	fmt.Printf("This drops all %d table(s) and %d view(s) of '%s' and copies them again from the master.\n",
+		len(tables), len(views), localDbName)
+	if !confirm("Continue with the full resync? (y/n): ") {
+		fmt.Println("Full resync cancelled.")
+		return
+	}
+
+	// Nothing may be applied while the tables go
+	connectMu.Lock()
+	if connected {
+		master.Close()
+		connected = false
+	}
+	connectMu.Unlock()
+	listeners.Wait()
+	settleApplyPool()
+
+	if err := dropLocalObjects(tables, views); err != nil {
+		fmt.Printf("Full resync stopped, local database only partly cleared: %v\n", err)
+		fmt.Println("Use Reconnect to Master to sync what is left")
+		return
+	}
+	for _, table := range tables {
+		takePending(pendingAwaitingSchema, table)
+	}
+	fmt.Printf("Dropped %d table(s) and %d view(s), requesting a full sync\n", len(tables), len(views))
+
+	if reconnectToMaster(addr) {
+		fmt.Println("The master sends the sync when it has a free slot, it is verified once complete")
+	}
+}
+
+// Names of the local base tables and views
+func localTablesAndViews() (tables, views []string, err error) {
+	rows, err := db.Query("SHOW FULL TABLES")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, kind string
+		if err := rows.Scan(&name, &kind); err != nil {
+			return nil, nil, err
+		}
+		if kind == "VIEW" {
+			views = append(views, name)
+		} else {
+			tables = append(tables, name)
+		}
+	}
+	return tables, views, rows.Err()
+}
+
+// Drop the views, then the tables in any order, with foreign key checks
+// off for the session doing it
+func dropLocalObjects(tables, views []string) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 0"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 1")
+
+	for _, view := range views {
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("DROP VIEW IF EXISTS `%s`", view)); err != nil {
+			return fmt.Errorf("dropping view %s: %w", view, err)
+		}
+	}
+	for _, table := range tables {
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS `%s`", table)); err != nil {
+			return fmt.Errorf("dropping table %s: %w", table, err)
+		}
+	}
+	return nil
+}
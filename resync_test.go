@@ -0,0 +1,43 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+func TestFullResyncDropsEverythingAndSyncsAgain(t *testing.T) {
+	f := useFakeDB(t)
+	f.rows(`^SHOW FULL TABLES$`, []string{"Tables", "Table_type"},
+		[]driver.Value{"orders", "BASE TABLE"}, []driver.Value{"totals", "VIEW"})
+	addr, conns := listenAsMaster(t)
+	if !connectToMaster(addr) {
+		t.Fatal("not connected")
+	}
+	first := nextMasterConn(t, conns)
+	first.handshake(t)
+
+	// Cancelled, nothing happens
+	feedInput(t, "n")
+	fullResync(addr)
+	if ran := f.matching(`^DROP`); len(ran) != 0 {
+		t.Fatalf("ran %q after cancelling", ran)
+	}
+	noMoreLines(t, first.lines)
+
+	feedInput(t, "y")
+	fullResync(addr)
+
+	want := []string{"SET FOREIGN_KEY_CHECKS = 0", "DROP VIEW IF EXISTS `totals`", "DROP TABLE IF EXISTS `orders`", "SET FOREIGN_KEY_CHECKS = 1"}
+	if got := f.matching(`^(SET FOREIGN_KEY_CHECKS|DROP)`); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("ran %q, want %q", got, want)
+	}
+	// A new connection asks for the full sync, verified once it's done
+	second := nextMasterConn(t, conns)
+	if hs := second.handshake(t); hs[len(hs)-1] != "subscribe:full" {
+		t.Fatalf("resync subscribed with %q", hs[len(hs)-1])
+	}
+	if !verifyAfterSync {
+		t.Fatal("the resynced copy won't be verified")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Retrying replicated statements that failed on a transient server error.
+// A deadlock or lock wait timeout says nothing about the statement itself,
+// so it is run again after a pause that doubles each time, up to
+// -apply-retries times. If it still fails it is dead-lettered: kept with
+// the pending operations, where the operator can see what wasn't applied,
+// and acked to the master as failed. Other errors are not retried.
+// Dead-lettered statements are also appended to dead-letter.jsonl in the
+// data directory and shown again after a restart; delete the file once
+// they have been dealt with.
+
+var (
+	applyRetries      = 3
+	applyRetryBackoff = 100 * time.Millisecond
+)
+
+const maxApplyRetryBackoff = 5 * time.Second
+
+const pendingDeadLetter = "dead-lettered after retries"
+
+const deadLetterFile = "dead-letter.jsonl"
+
+type deadLetter struct {
+	Table    string    `json:"table"`
+	Query    string    `json:"query"`
+	Received time.Time `json:"received"`
+}
+
+// Keep a statement that couldn't be applied, in memory and on disk
+func addDeadLetter(table, query string) {
+	addPending(pendingDeadLetter, table, query)
+	line, err := json.Marshal(deadLetter{Table: table, Query: query, Received: time.Now()})
+	if err != nil {
+		return
+	}
+	path := statePath(deadLetterFile)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		fmt.Printf("Could not save dead-lettered statement to %s: %v\n", path, err)
+		return
+	}
+	defer f.Close()
+	f.Write(append(line, '\n'))
+}
+
+// Put the statements dead-lettered in earlier runs back with the pending
+// operations. Returns how many there were.
+func loadDeadLetters() (int, error) {
+	f, err := os.Open(statePath(deadLetterFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var ops []pendingOp
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			var d deadLetter
+			if jerr := json.Unmarshal(line, &d); jerr != nil {
+				return 0, fmt.Errorf("unreadable entry in %s: %v", deadLetterFile, jerr)
+			}
+			ops = append(ops, pendingOp{table: d.Table, query: d.Query, received: d.Received})
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+	}
+
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	pendingOps[pendingDeadLetter] = append(ops, pendingOps[pendingDeadLetter]...)
+	return len(ops), nil
+}
+
+// applyLocalQuery, run again on retryable errors. While it is being
+// retried the statement is listed with the pending operations.
+func applyWithRetry(query string) (int64, error) {
+	backoff := applyRetryBackoff
+	_, table := statementInfo(query)
+	for attempt := 0; ; attempt++ {
+		affected, err := applyLocalQuery(query)
+		if err == nil || !isRetryable(err) {
+			return affected, err
+		}
+		if attempt >= applyRetries {
+			addDeadLetter(table, query)
+			return 0, fmt.Errorf("gave up after %d retries: %w", applyRetries, err)
+		}
+		if attempt == 0 {
+			defer trackPending(pendingRetrying, table, query)()
+		}
+		fmt.Printf("Transient error applying replicated query, retrying in %v: %v\n", backoff, err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxApplyRetryBackoff)
+	}
+}
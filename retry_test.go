@@ -0,0 +1,70 @@
+package main
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Retry fast, keeping the dead letters in a directory of the test's own
+func fastRetries(t *testing.T) {
+	t.Helper()
+	oldBackoff, oldDir := applyRetryBackoff, dataDir
+	applyRetryBackoff, dataDir = time.Millisecond, t.TempDir()
+	t.Cleanup(func() { applyRetryBackoff, dataDir = oldBackoff, oldDir })
+}
+
+func TestDeadlockedStatementIsAppliedOnRetry(t *testing.T) {
+	f := useFakeDB(t)
+	acks := pipeMaster(t)
+	clearPending(t)
+	fastRetries(t)
+
+	calls := 0
+	f.on(`^UPDATE t`, func([]driver.Value) fakeResult {
+		calls++
+		if calls == 1 {
+			return fakeResult{err: &mysql.MySQLError{Number: errDeadlock, Message: "Deadlock found"}}
+		}
+		return fakeResult{affected: 2}
+	})
+
+	applyReplicatedQuery(9, "UPDATE t SET v = 1 WHERE v = 0")
+	if calls != 2 {
+		t.Fatalf("statement ran %d time(s), want once more after the deadlock", calls)
+	}
+	if ack := nextAck(t, acks); ack != "replicate_ack:ok:" {
+		t.Fatalf("ack %q, want the retry's success", ack)
+	}
+	lastReplicated.Lock()
+	seq, affected, err := lastReplicated.seq, lastReplicated.affected, lastReplicated.err
+	lastReplicated.Unlock()
+	if seq != 9 || affected != 2 || err != nil {
+		t.Fatalf("recorded seq %d, %d row(s), %v; want the retry's outcome", seq, affected, err)
+	}
+	if hasPending(pendingDeadLetter, "t") || hasPending(pendingRetrying, "t") {
+		t.Fatal("statement still listed after the retry went through")
+	}
+}
+
+func TestStatementIsDeadLetteredAfterTheLastRetry(t *testing.T) {
+	f := useFakeDB(t)
+	clearPending(t)
+	fastRetries(t)
+	f.fail(`^UPDATE t`, &mysql.MySQLError{Number: errLockWaitTimeout, Message: "Lock wait timeout exceeded"})
+
+	if _, err := applyWithRetry("UPDATE t SET v = 1"); err == nil {
+		t.Fatal("no error after every retry failed")
+	}
+	if n := len(f.matching(`^UPDATE t`)); n != applyRetries+1 {
+		t.Fatalf("statement ran %d time(s), want %d", n, applyRetries+1)
+	}
+	if !hasPending(pendingDeadLetter, "t") {
+		t.Fatal("statement not dead-lettered")
+	}
+	if n, err := loadDeadLetters(); err != nil || n != 1 {
+		t.Fatalf("dead-letter file has %d entries (%v), want 1", n, err)
+	}
+}
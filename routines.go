@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Triggers and stored routines aren't covered by SHOW TABLES, so they are
+// discovered separately and sent as create_routine:<kind>:<base64 definition>
+// (base64 because routine bodies span many lines).
+
+type routine struct {
+	kind       string // trigger, procedure or function
+	name       string
+	definition string
+}
+
+var definerRe = regexp.MustCompile("(?i)\\s+DEFINER\\s*=\\s*(`[^`]*`|'[^']*'|[^\\s@]+)@(`[^`]*`|'[^']*'|\\S+)")
+var routineKindRe = regexp.MustCompile(`(?i)^\s*CREATE\s+(?:DEFINER\s*=\s*\S+\s+)?(TRIGGER|PROCEDURE|FUNCTION)\b`)
+var delimiterRe = regexp.MustCompile(`(?i)^\s*DELIMITER\s+(\S+)\s*$`)
+
+// Drop the DEFINER clause, the definer account may not exist on the slave
+func stripDefiner(definition string) string {
+	return definerRe.ReplaceAllString(definition, "")
+}
+
+// Kind of routine a CREATE statement defines, or "" if it isn't one
+func routineKind(definition string) string {
+	matches := routineKindRe.FindStringSubmatch(definition)
+	if len(matches) < 2 {
+		return ""
+	}
+	return strings.ToLower(matches[1])
+}
+
+// Remove mysql client DELIMITER lines and the custom delimiter they set, so
+// the statement can be sent to the server as is
+func stripDelimiters(definition string) string {
+	delim := ";"
+	var out []string
+	for _, line := range strings.Split(definition, "\n") {
+		if m := delimiterRe.FindStringSubmatch(line); m != nil {
+			delim = m[1]
+			continue
+		}
+		if delim != ";" {
+			trimmed := strings.TrimRight(line, " \t\r")
+			line = strings.TrimSuffix(trimmed, delim)
+		}
+		out = append(out, line)
+	}
+	return strings.TrimRight(strings.TrimSpace(strings.Join(out, "\n")), ";")
+}
+
+func encodeRoutine(r routine) string {
+	return fmt.Sprintf("create_routine:%s:%s\n", r.kind, base64.StdEncoding.EncodeToString([]byte(r.definition)))
+}
+
+// Read one column by name from a SHOW CREATE result
+func scanNamedColumn(rows *sql.Rows, name string) (string, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	values := make([]sql.NullString, len(columns))
+	scanArgs := make([]interface{}, len(columns))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+	if err := rows.Scan(scanArgs...); err != nil {
+		return "", err
+	}
+	for i, c := range columns {
+		if c == name {
+			return values[i].String, nil
+		}
+	}
+	return "", fmt.Errorf("no %s column in result", name)
+}
+
+func showCreate(ctx context.Context, q queryer, what, name, column string) (string, error) {
+	rows, err := q.QueryContext(ctx, fmt.Sprintf("SHOW CREATE %s `%s`", what, name))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return "", fmt.Errorf("no definition returned for %s %s", strings.ToLower(what), name)
+	}
+	return scanNamedColumn(rows, column)
+}
+
+// All triggers, procedures and functions in the master database
+func listRoutines(ctx context.Context, q queryer) ([]routine, error) {
+	var names []routine
+
+	for _, kind := range []string{"PROCEDURE", "FUNCTION"} {
+		rows, err := q.QueryContext(ctx, fmt.Sprintf("SHOW %s STATUS WHERE Db = ?", kind), dbName)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			name, err := scanNamedColumn(rows, "Name")
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			names = append(names, routine{kind: strings.ToLower(kind), name: name})
+		}
+		rows.Close()
+	}
+
+	rows, err := q.QueryContext(ctx, "SHOW TRIGGERS")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		name, err := scanNamedColumn(rows, "Trigger")
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		names = append(names, routine{kind: "trigger", name: name})
+	}
+	rows.Close()
+
+	var result []routine
+	for _, r := range names {
+		var def string
+		var err error
+		switch r.kind {
+		case "procedure":
+			def, err = showCreate(ctx, q, "PROCEDURE", r.name, "Create Procedure")
+		case "function":
+			def, err = showCreate(ctx, q, "FUNCTION", r.name, "Create Function")
+		case "trigger":
+			def, err = showCreate(ctx, q, "TRIGGER", r.name, "SQL Original Statement")
+		}
+		if err != nil {
+			fmt.Printf("Error getting definition of %s %s: %v\n", r.kind, r.name, err)
+			continue
+		}
+		r.definition = stripDefiner(def)
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+// Send every routine to a syncing slave. Called after the table data so
+// triggers don't fire on the synced rows.
+func sendRoutinesToSlave(ctx context.Context, q queryer, s *slaveConn) {
+	routines, err := listRoutines(ctx, q)
+	if err != nil {
+		fmt.Printf("Error listing triggers and routines: %v\n", err)
+		return
+	}
+	for _, r := range routines {
+		fmt.Printf("Sending %s %s to slave\n", r.kind, r.name)
+		s.sendBulk("%s", encodeRoutine(r))
+	}
+}
+
+// Menu action: create a trigger, procedure or function and replicate it
+func CreateRoutine() {
+	if txOpen() {
+		return
+	}
+	fmt.Println("Enter the CREATE TRIGGER/PROCEDURE/FUNCTION statement.")
+	fmt.Println("DELIMITER lines are allowed. Finish with an empty line.")
+
+	var lines []string
+	for {
+		fmt.Print("> ")
+		line := readLine()
+		if strings.TrimSpace(line) == "" {
+			break
+		}
+		lines = append(lines, line)
+	}
+
+	definition := stripDelimiters(strings.Join(lines, "\n"))
+	kind := routineKind(definition)
+	if kind == "" {
+		fmt.Println("Statement must be a CREATE TRIGGER, PROCEDURE or FUNCTION")
+		return
+	}
+
+	defer beginWrite()()
+
+	if _, err := db.Exec(definition); err != nil {
+		fmt.Printf("Error creating %s: %v\n", kind, err)
+		return
+	}
+	fmt.Printf("The %s was created successfully.\n", kind)
+
+	broadcast(nil, "%s", encodeRoutine(routine{kind: kind, definition: stripDefiner(definition)}))
+	resetReplayLog()
+}
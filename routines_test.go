@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreatedTriggerIsReplicatedAheadOfLaterWrites(t *testing.T) {
+	f := useFakeDB(t)
+	throughSlave(t, f, func(*slaveConn) {})
+	feedInput(t,
+		"DELIMITER //",
+		"CREATE DEFINER=`admin`@`%` TRIGGER orders_audit AFTER INSERT ON orders",
+		"FOR EACH ROW BEGIN",
+		"  INSERT INTO audit (order_id) VALUES (NEW.id);",
+		"END //",
+		"DELIMITER ;",
+		"")
+
+	CreateRoutine()
+	replicate(nil, "INSERT INTO orders (id) VALUES (1)")
+
+	body := " TRIGGER orders_audit AFTER INSERT ON orders\nFOR EACH ROW BEGIN\n" +
+		"  INSERT INTO audit (order_id) VALUES (NEW.id);\nEND"
+	deadline := time.Now().Add(5 * time.Second)
+	for len(f.matching(`^INSERT INTO orders`)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	// Master first, then the slave without the definer, which has the
+	// trigger before the row it is meant to fire on
+	var order []string
+	for _, stmt := range f.statements() {
+		if strings.HasPrefix(stmt, "CREATE DEFINER") || strings.HasPrefix(stmt, "CREATE TRIGGER") || strings.HasPrefix(stmt, "INSERT INTO orders") {
+			order = append(order, stmt)
+		}
+	}
+	want := []string{"CREATE DEFINER=`admin`@`%`" + body, "CREATE" + body, "INSERT INTO orders (id) VALUES (1)"}
+	if strings.Join(order, "\n--\n") != strings.Join(want, "\n--\n") {
+		t.Fatalf("ran:\n%s\nwant:\n%s", strings.Join(order, "\n--\n"), strings.Join(want, "\n--\n"))
+	}
+}
@@ -1,35 +1,80 @@
 package main
 
 import (
-	"bufio"
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
 	"net"
 	"os"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/go-sql-driver/mysql"
 	_ "github.com/go-sql-driver/mysql"
 )
 
 var master net.Conn
 var connected bool
-var db *sql.DB
+
+// Held while connecting and when a connection ends, see reconnect.go
+var connectMu sync.Mutex
 var localDbName string
 var replicationInProgress bool
+
+// Set when connected at least once, so later connections count as reconnects
+var everConnected bool
+
+// Identifies this slave process to the master, the same on every connection
+var slaveID = newSlaveID()
+
+// Verify (and repair) automatically once the reconnect sync completes
+var verifyAfterSync bool
+
+// Repair out-of-sync tables when the pending verification result arrives
+var repairAfterVerify bool
 var dbUser, dbPassword string
 
+// Local server's max_allowed_packet, read in setupLocalDB
+var localMaxPacket int
+
+// Local server's version, reported to the master at handshake
+var localVersion string
+
+// Subscribe to schema changes only (-schema-only). Tables are created and
+// altered like on any slave but the master's rows are never sent, so local
+// row counts aren't compared when verifying.
+var schemaOnly bool
+
+// Sequence number of the last replicated statement applied locally. Reset
+// on init_replication since the sync brings its own position.
+var appliedSeq uint64
+
+// Database and position of the snapshot this slave bootstrapped from
+// (-snapshot), sent with the next handshake so the master can resume
+// from there
+var resumeDB string
+var resumePos uint64
+
 func setupLocalDB(dbName string) error {
 	// Configure connection
-	cfg := mysql.NewConfig()
-	cfg.User = dbUser
-	cfg.Passwd = dbPassword
+	cfg := newMySQLConfig(dbUser, dbPassword)
+
+	// A new init_replication (reconnect, or the master switched databases)
+	// replaces the current connection
+	if db != nil {
+		db.Close()
+	}
 
 	// First connect without specifying a database
 	var err error
-	db, err = sql.Open("mysql", cfg.FormatDSN())
+	db, err = openMySQL(cfg)
 	if err != nil {
 		return fmt.Errorf("connection error: %v", err)
 	}
@@ -49,7 +94,7 @@ func setupLocalDB(dbName string) error {
 	// Now connect to the specific database
 	db.Close()
 	cfg.DBName = dbName
-	db, err = sql.Open("mysql", cfg.FormatDSN())
+	db, err = openMySQL(cfg)
 	if err != nil {
 		return fmt.Errorf("connection error: %v", err)
 	}
@@ -61,12 +106,92 @@ func setupLocalDB(dbName string) error {
 	}
 
 	localDbName = dbName
+	localMaxPacket = queryMaxPacket(db)
 	return nil
 }
 
+// Give the local database the master's default character set and
+// collation. A collation this server doesn't have is left out, so the
+// character set's own default collation is used instead.
+func applyDatabaseCharset(name, charset, collation string) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM information_schema.CHARACTER_SETS WHERE CHARACTER_SET_NAME = ?",
+		charset).Scan(&n)
+	if err != nil || n == 0 {
+		fmt.Printf("WARNING: local server doesn't support character set %s, keeping the database default\n", charset)
+		return
+	}
+
+	stmt := fmt.Sprintf("ALTER DATABASE `%s` CHARACTER SET %s", name, charset)
+	if collation != "" {
+		err = db.QueryRow("SELECT COUNT(*) FROM information_schema.COLLATIONS WHERE COLLATION_NAME = ? AND CHARACTER_SET_NAME = ?",
+			collation, charset).Scan(&n)
+		if err == nil && n > 0 {
+			stmt += " COLLATE " + collation
+		} else {
+			fmt.Printf("WARNING: local server doesn't support collation %s, using the default collation of %s\n",
+				collation, charset)
+		}
+	}
+	if _, err := db.Exec(stmt); err != nil {
+		fmt.Printf("Failed to set database character set: %v\n", err)
+		return
+	}
+	fmt.Printf("Database '%s' uses the master's character set %s\n", name, charset)
+}
+
+// Local address outgoing TCP connections to the master are made from
+// (-local-addr), nil to let the system choose
+var localAddr *net.TCPAddr
+
+// Parse and check a -local-addr value: an IP, optionally with a port, that
+// is assigned to one of this host's interfaces
+func parseLocalAddr(value string) (*net.TCPAddr, error) {
+	hostPort := value
+	if _, _, err := net.SplitHostPort(value); err != nil {
+		hostPort = net.JoinHostPort(value, "0")
+	}
+	addr, err := net.ResolveTCPAddr("tcp", hostPort)
+	if err != nil {
+		return nil, fmt.Errorf("invalid local address %q: %v", value, err)
+	}
+	if addr.IP == nil || addr.IP.IsUnspecified() {
+		return nil, fmt.Errorf("local address %q has no IP", value)
+	}
+
+	ifaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("listing local addresses: %v", err)
+	}
+	for _, a := range ifaceAddrs {
+		if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(addr.IP) {
+			return addr, nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not an address of this host", addr.IP)
+}
+
+// Master addresses are host:port, or unix:/path for a master started with
+// -listen-unix on the same host
+func dialMaster(addr string) (net.Conn, error) {
+	return dialMasterTimeout(addr, 0)
+}
+
+// dialMaster giving up after timeout (0 for the system's default)
+func dialMasterTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: timeout}
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return dialer.Dial("unix", path)
+	}
+	if localAddr != nil {
+		dialer.LocalAddr = localAddr
+	}
+	return dialer.Dial("tcp", addr)
+}
+
 func connectToMaster(addr string) bool {
 	var err error
-	master, err = net.Dial("tcp", addr)
+	master, err = dialMaster(addr)
 	if err != nil {
 		fmt.Printf("Failed to connect to master at %s: %v\n", addr, err)
 		return false
@@ -74,15 +199,116 @@ func connectToMaster(addr string) bool {
 
 	fmt.Println("Connected to master server!")
 	connected = true
+	for _, line := range handshake() {
+		fmt.Fprint(master, line)
+	}
+
+	// Writes made on the master while we were away may have been missed
+	if everConnected {
+		verifyAfterSync = true
+	}
+	everConnected = true
 
 	// Listen for messages from master in a goroutine
-	go listenToMaster()
+	listeners.Add(1)
+	go listenToMaster(master)
 	return true
 }
 
+// The slave_info and subscribe lines opening every connection. The master
+// keeps nothing from an earlier connection, so a reconnect has to
+// advertise all of it again or it would get the defaults.
+func handshake() []string {
+	// Who this slave is and its server version, then what it wants replicated
+	lines := []string{fmt.Sprintf("slave_info:slave_id:%s\n", slaveID)}
+	if v := localServerVersion(); v != "" {
+		lines = append(lines, fmt.Sprintf("slave_info:mysql_version:%s\n", v))
+	}
+	// Known before the initial sync, so its batches already fit
+	if n := localPacketLimit(); n > 0 {
+		lines = append(lines, fmt.Sprintf("slave_info:max_allowed_packet:%d\n", n))
+	}
+	if free, err := localFreeSpace(); err != nil {
+		fmt.Printf("Could not read free disk space, the master can't check the sync will fit: %v\n", err)
+	} else {
+		lines = append(lines, fmt.Sprintf("slave_info:disk_free:%d\n", free))
+	}
+	subscription := "full"
+	if schemaOnly {
+		subscription = "schema_only"
+	}
+	// Have live statements timed to measure the replication latency, every
+	// message framed and the statements encoded so values holding newlines
+	// or colons arrive intact
+	lines = append(lines, "slave_info:origin_time:1\n", "slave_info:framing:length\n",
+		"slave_info:payload_encoding:base64\n")
+	if !schemaOnly {
+		// Verify Replication compares the rows, not just how many there are
+		lines = append(lines, "slave_info:table_checksums:1\n")
+	}
+	if statsInterval > 0 {
+		lines = append(lines, fmt.Sprintf("slave_info:stats_interval:%d\n", max(1, int(statsInterval.Seconds()))))
+	}
+	if resumeDB != "" {
+		// Bootstrapped from a snapshot, only what came after it is needed
+		lines = append(lines, fmt.Sprintf("slave_info:resume_position:%s:%d\n", resumeDB, resumePos))
+		resumeDB = ""
+	}
+	return append(lines, fmt.Sprintf("subscribe:%s\n", subscription))
+}
+
+func newSlaveID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Run fn on the local MySQL server, over a short-lived connection if the
+// local database isn't set up yet
+func onLocalServer(fn func(conn *sql.DB)) {
+	conn := db
+	if conn == nil {
+		var err error
+		conn, err = openMySQL(newMySQLConfig(dbUser, dbPassword))
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+	}
+	fn(conn)
+}
+
+// Local MySQL server version. Cached after the first successful lookup.
+func localServerVersion() string {
+	if localVersion != "" {
+		return localVersion
+	}
+	onLocalServer(func(conn *sql.DB) {
+		if err := conn.QueryRow("SELECT VERSION()").Scan(&localVersion); err != nil {
+			fmt.Printf("Could not read local MySQL version: %v\n", err)
+		}
+	})
+	return localVersion
+}
+
+// Local max_allowed_packet, 0 if unknown. Read again with the local
+// database once it is set up.
+func localPacketLimit() int {
+	if localMaxPacket == 0 {
+		onLocalServer(func(conn *sql.DB) { localMaxPacket = queryMaxPacket(conn) })
+	}
+	return localMaxPacket
+}
+
 func executeLocalQuery(query string) error {
+	_, err := applyLocalQuery(query)
+	return err
+}
+
+// executeLocalQuery, also returning the number of rows the statement changed
+func applyLocalQuery(query string) (int64, error) {
 	if db == nil {
-		return fmt.Errorf("local database connection not established")
+		return 0, fmt.Errorf("local database connection not established")
 	}
 
 	// For debugging
@@ -90,44 +316,74 @@ func executeLocalQuery(query string) error {
 		fmt.Printf("Executing CREATE TABLE query: %s\n", query)
 	}
 
-	_, err := db.Exec(query)
+	if localMaxPacket > 0 && len(query) > localMaxPacket {
+		return 0, fmt.Errorf("statement is %d bytes, over the local max_allowed_packet of %d; raise max_allowed_packet on this server",
+			len(query), localMaxPacket)
+	}
+
+	var err error
+	var result sql.Result
+	if isModifyColumn(query) {
+		// A type change that would truncate local data must fail, not warn
+		err = execStrict(query)
+	} else {
+		result, err = localExec(query)
+	}
 	if err != nil {
-		return fmt.Errorf("local query execution error: %v", err)
+		return 0, fmt.Errorf("local query execution error: %w", err)
 	}
-	return nil
+	var affected int64
+	if result != nil {
+		affected, _ = result.RowsAffected()
+	}
+	return affected, nil
 }
 
+// The table a create_table is for is already here, as the master defines it
+var errSameTable = errors.New("table already exists with the same definition")
+
 // Handle a CREATE TABLE statement with special error handling
 func executeCreateTable(query string) error {
 	if db == nil {
 		return fmt.Errorf("local database connection not established")
 	}
 
-	fmt.Printf("Executing CREATE TABLE query: %s\n", query)
-
 	// Basic validation
 	if !strings.HasPrefix(strings.ToUpper(query), "CREATE TABLE") {
 		return fmt.Errorf("invalid CREATE TABLE statement: %s", query)
 	}
 
+	// A table can be sent twice, e.g. when a sync and a get_table_schema
+	// for it cross. Only a different definition is a problem.
+	if tableName := createTableName(query); tableName != "" && TableExists(tableName) {
+		var name, localDef string
+		if err := db.QueryRow("SHOW CREATE TABLE "+tableName).Scan(&name, &localDef); err != nil {
+			return fmt.Errorf("table %s already exists and its definition can't be read: %w", tableName, err)
+		}
+		if !sameTableDefinition(query, localDef) {
+			return fmt.Errorf("table %s already exists with a different definition, Verify Replication can repair it", tableName)
+		}
+		return errSameTable
+	}
+
+	fmt.Printf("Executing CREATE TABLE query: %s\n", query)
+
 	// Clean up any potential issues in the query
 	// Remove any backticks that might cause problems
 	cleanQuery := strings.ReplaceAll(query, "`", "")
 
 	// Execute the CREATE TABLE statement
-	_, err := db.Exec(cleanQuery)
+	_, err := localExec(cleanQuery)
 	if err != nil {
 		// If there's an error, try to get more specific error details
 		fmt.Printf("Error details for CREATE TABLE: %v\n", err)
-		return fmt.Errorf("local query execution error: %v", err)
+		return fmt.Errorf("local query execution error: %w", err)
 	}
 
 	// Verify the table was created
 	tableName := ""
 	// Extract table name from CREATE TABLE statement
-	matches := regexp.MustCompile(`(?i)CREATE\s+TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?(\w+)`).FindStringSubmatch(query)
-	if len(matches) >= 2 {
-		tableName = matches[1]
+	if tableName = createTableName(query); tableName != "" {
 		fmt.Printf("Extracted table name: %s\n", tableName)
 
 		// Check if table exists
@@ -146,36 +402,209 @@ func executeCreateTable(query string) error {
 	return nil
 }
 
-func listenToMaster() {
+var createTableRe = regexp.MustCompile(`(?i)CREATE\s+TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?` + "`?" + `(\w+)`)
+
+// Extract the table name from a CREATE TABLE statement
+func createTableName(query string) string {
+	matches := createTableRe.FindStringSubmatch(query)
+	if len(matches) < 2 {
+		return ""
+	}
+	return matches[1]
+}
+
+// A replicated DROP of a table or database this slave doesn't have, say
+// because it missed the CREATE, leaves things as the master has them, so
+// it's run with IF EXISTS unless -strict-drop asks for the error
+var strictDrop bool
+
+var dropWithoutIfExistsRe = regexp.MustCompile(`(?is)^(\s*DROP\s+(?:TEMPORARY\s+)?(?:TABLE|DATABASE|SCHEMA)\s+)(.*)$`)
+var ifExistsRe = regexp.MustCompile(`(?i)^IF\s+EXISTS\b`)
+
+func tolerantDrop(query string) string {
+	m := dropWithoutIfExistsRe.FindStringSubmatch(query)
+	if strictDrop || m == nil || ifExistsRe.MatchString(m[2]) {
+		return query
+	}
+	return m[1] + "IF EXISTS " + m[2]
+}
+
+// Apply a replicated statement outside a transaction and ack it. Called by
+// the listener or an apply worker (see applypool.go).
+func applyReplicatedQuery(seq uint64, query string) {
+	if err := checkReplicatedText(query); err != nil {
+		fmt.Printf("Rejected replicated query: %v\n", err)
+		recordReplicated(seq, 0, err)
+		sendAck(seq, err)
+		return
+	}
+
+	_, table := statementInfo(query)
+	op := pendingOp{table: table, query: query, seq: seq}
+	if deferApply(op) {
+		return
+	}
+
+	verbosef("Applying replicated query to local database\n")
+	affected, err := applyWithRetry(query)
+	if bufferForLocalDB(op, err) {
+		return
+	}
+	finishReplicatedQuery(seq, query, affected, err)
+}
+
+// Record and ack the outcome of a replicated statement
+func finishReplicatedQuery(seq uint64, query string, affected int64, err error) {
+	recordReplicated(seq, affected, err)
+	if err != nil {
+		fmt.Printf("Failed to execute replicated query: %v\n", err)
+		fmt.Printf("Query was: %s\n", query)
+
+		// Special handling for missing table errors
+		if tableName, ok := missingTableName(err); ok {
+			fmt.Printf("Table '%s' doesn't exist.\n", tableName)
+
+			// Request table schema from master
+			awaitSchema(tableName, query)
+		} else if isDuplicateEntry(err) {
+			fmt.Println("A row with this key already exists locally.")
+		} else if isUnknownDatabase(err) {
+			fmt.Println("The local database is gone. Reconnect to the master to resync it.")
+		}
+		sendAck(seq, err)
+		return
+	}
+	noteApplied()
+	recordChange(seq, query, "master")
+	sendAck(seq, nil)
+}
+
+// Buffer a statement for a table we don't have yet and ask the master for
+// the table, unless a request for it is already outstanding
+func awaitSchema(tableName, query string) {
+	first := !hasPending(pendingAwaitingSchema, tableName)
+	addPending(pendingAwaitingSchema, tableName, query)
+	if first {
+		fmt.Printf("Requesting schema for table '%s'\n", tableName)
+		fmt.Fprintf(master, "get_table_schema:%s\n", tableName)
+	}
+}
+
+// A get_table_schema the master couldn't answer is asked again, waiting
+// twice as long each time, up to maxSchemaRetries times. After that, or
+// right away if the master doesn't have the table, what was buffered for
+// the table is dropped.
+const (
+	schemaRetryDelay = time.Second
+	maxSchemaRetries = 5
+)
+
+var (
+	schemaRetriesMu sync.Mutex
+	schemaRetries   = make(map[string]int)
+)
+
+var schemaErrorRe = regexp.MustCompile(`^(?:table '(\w+)' does not exist on master|Failed to get schema of table '(\w+)':)`)
+
+// Handle an error from the master if it answers a get_table_schema.
+// Returns false for other errors.
+func schemaRequestFailed(content string) bool {
+	m := schemaErrorRe.FindStringSubmatch(content)
+	if m == nil {
+		return false
+	}
+	if m[1] != "" {
+		expireAwaitingSchema(m[1], "the master doesn't have it")
+		return true
+	}
+
+	tableName := m[2]
+	schemaRetriesMu.Lock()
+	schemaRetries[tableName]++
+	tries := schemaRetries[tableName]
+	schemaRetriesMu.Unlock()
+	if tries > maxSchemaRetries {
+		expireAwaitingSchema(tableName, fmt.Sprintf("the master failed to send it %d times", tries))
+		return true
+	}
+	delay := schemaRetryDelay << (tries - 1)
+	fmt.Printf("Asking for table '%s' again in %v\n", tableName, delay)
+	time.AfterFunc(delay, func() {
+		// A reconnect asks for every missing table anyway
+		if connected && hasPending(pendingAwaitingSchema, tableName) {
+			fmt.Fprintf(master, "get_table_schema:%s\n", tableName)
+		}
+	})
+	return true
+}
+
+// Called once a table arrived or was given up on
+func resetSchemaRetries(tableName string) {
+	schemaRetriesMu.Lock()
+	defer schemaRetriesMu.Unlock()
+	delete(schemaRetries, tableName)
+}
+
+// Drop the statements buffered for a table that isn't coming
+func expireAwaitingSchema(tableName, why string) {
+	resetSchemaRetries(tableName)
+	ops := takePending(pendingAwaitingSchema, tableName)
+	fmt.Printf("Giving up on table '%s', %s: dropped %d buffered operation(s)\n", tableName, why, len(ops))
+}
+
+func listenToMaster(conn net.Conn) {
+	defer listeners.Done()
 	defer func() {
-		master.Close()
-		connected = false
+		conn.Close()
+		// A reconnect may already have replaced this connection
+		connectMu.Lock()
+		if master == conn {
+			connected = false
+		}
+		connectMu.Unlock()
 		fmt.Println("Disconnected from master server.")
 	}()
 
-	scanner := bufio.NewScanner(master)
-	// Increase scanner buffer size to handle larger CREATE TABLE statements
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 1024*1024)
+	readMasterMessages(conn)
+}
 
-	for scanner.Scan() {
-		message := scanner.Text()
-		parts := strings.SplitN(message, ":", 2)
-		if len(parts) != 2 {
+// Handle messages from the master (or a snapshot replay) until the
+// connection ends
+func readMasterMessages(conn net.Conn) {
+	// A sync cut short doesn't leave foreign key checks off
+	defer endSyncSession()
+	scanner := newMessageScanner(conn, func(size int) {
+		fmt.Printf("Rejected %d byte message from master (limit %d)\n", size, maxMessageSize)
+	})
+
+	for nextMessage(scanner) {
+		msgType, content, ok := parseMessage(scanner.Text())
+		if !ok {
 			fmt.Println("Received malformed message from master")
 			continue
 		}
-
-		msgType := parts[0]
-		content := parts[1]
+		// Only replicated statements can be applied in the background,
+		// every other message sees them all applied first
+		if msgType != "replicate_query" {
+			settleApplyPool()
+			waitLocalDBReplay()
+		}
 
 		switch msgType {
 		case "init_replication":
 			fmt.Printf("\nInitializing replication for database: %s\n", content)
 			replicationInProgress = true
+			appliedSeq = 0
+			resetReplicated()
+			forgetOrigins()
+			discardLocalDBBacklog()
 
 			// Setup local database for replication
+			endSyncSession()
 			err := setupLocalDB(content)
+			if err == nil {
+				err = beginSyncSession()
+			}
 			if err != nil {
 				fmt.Printf("Failed to setup local database: %v\n", err)
 				replicationInProgress = false
@@ -183,8 +612,31 @@ func listenToMaster() {
 				fmt.Printf("Local database '%s' ready for replication\n", content)
 			}
 
+		case "auto_increment":
+			recordAutoIncrement(content)
+
+		case "stats":
+			recordStats(content)
+
+		case "time_probe":
+			answerClockProbe(content)
+
+		case "clock_offset":
+			recordClockOffset(content)
+
+		case "sync_estimate":
+			if n, err := strconv.ParseInt(content, 10, 64); err == nil {
+				fmt.Printf("Master expects about %s of data for the initial sync\n", formatBytes(n))
+			}
+
 		case "create_db":
-			fmt.Printf("Creating database: %s\n", content)
+			// Format: <name>[:<charset>:<collation>]
+			name, charset, collation := content, "", ""
+			if n, rest, ok := parseMessage(content); ok {
+				name = n
+				charset, collation, _ = parseMessage(rest)
+			}
+			fmt.Printf("Creating database: %s\n", name)
 			if !replicationInProgress && db == nil {
 				fmt.Println("Replication not in progress and no local database, ignoring create_db command")
 				continue
@@ -192,11 +644,15 @@ func listenToMaster() {
 
 			// Database already created in setupLocalDB or we try to create it now
 			if db == nil {
-				err := setupLocalDB(content)
+				err := setupLocalDB(name)
 				if err != nil {
 					fmt.Printf("Failed to create database: %v\n", err)
+					continue
 				}
 			}
+			if charset != "" {
+				applyDatabaseCharset(name, charset, collation)
+			}
 
 		case "create_table":
 			fmt.Println("Creating table from master schema")
@@ -209,21 +665,57 @@ func listenToMaster() {
 
 			// Use specialized function for CREATE TABLE
 			err := executeCreateTable(content)
-			if err != nil {
+			switch {
+			case errors.Is(err, errSameTable):
+				fmt.Printf("Table '%s' already exists with the same definition, skipped\n", createTableName(content))
+			case err != nil:
 				fmt.Printf("Failed to create table: %v\n", err)
 				fmt.Printf("SQL statement was: %s\n", content)
 				continue
+			default:
+				fmt.Println("Table created successfully in local database")
+				recordChange(0, content, "master")
+			}
+
+			// The fresh copy of the table that follows already contains
+			// whatever was buffered while it was missing
+			if tableName := createTableName(content); tableName != "" {
+				resetSchemaRetries(tableName)
+				if ops := takePending(pendingAwaitingSchema, tableName); len(ops) > 0 {
+					fmt.Printf("Table '%s' arrived, %d buffered operation(s) superseded by its data sync\n",
+						tableName, len(ops))
+				}
 			}
-			fmt.Println("Table created successfully in local database")
 
 		case "sync_data":
 			// Always process data sync commands, even if not in replication mode
 			// This allows for adding data to tables that were created after initial replication
-			err := executeLocalQuery(content)
+			content, err := decodePayload(content)
+			if err != nil {
+				fmt.Println("Invalid sync_data message from master")
+				continue
+			}
+			if err := checkReplicatedText(content); err != nil {
+				fmt.Printf("Rejected data sync from master: %v\n", err)
+				continue
+			}
+			// Synced rows carry no position and aren't acked
+			_, table := statementInfo(content)
+			op := pendingOp{table: table, query: content}
+			if deferApply(op) {
+				continue
+			}
+			err = executeLocalQuery(content)
+			if bufferForLocalDB(op, err) {
+				continue
+			}
 			if err != nil {
 				fmt.Printf("Failed to sync data: %v\n", err)
+				if isDuplicateEntry(err) {
+					fmt.Println("The row already exists locally, keeping the local copy.")
+				}
 				// Check for specific errors like missing tables
-				if strings.Contains(err.Error(), "Error 1146") {
+				if isMissingTable(err) {
 					fmt.Println("Table doesn't exist for this data. Request schema from master.")
 					// Try to extract table name from INSERT statement
 					if strings.HasPrefix(strings.ToUpper(content), "INSERT INTO") {
@@ -232,47 +724,141 @@ func listenToMaster() {
 							tableName := strings.TrimSpace(parts[2])
 							// Remove any trailing characters like ( or spaces
 							tableName = strings.Split(tableName, "(")[0]
-							fmt.Printf("Requesting schema for table '%s'\n", tableName)
-							fmt.Fprintf(master, "get_table_schema:%s\n", tableName)
+							tableName = strings.Trim(tableName, "`\"")
+							awaitSchema(tableName, content)
 						}
 					}
 				}
 				continue
 			}
 
+		case "create_routine":
+			// Format: <kind>:<base64 definition>
+			kind, encoded, ok := parseMessage(content)
+			if !ok {
+				fmt.Println("Invalid create_routine message from master")
+				continue
+			}
+			definition, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				fmt.Printf("Invalid %s definition from master: %v\n", kind, err)
+				continue
+			}
+			err = executeLocalQuery(string(definition))
+			if err != nil {
+				fmt.Printf("Failed to create %s: %v\n", kind, err)
+				continue
+			}
+			fmt.Printf("Created %s from master definition\n", kind)
+
+		case "create_index":
+			// Format: <table>:<ALTER TABLE ... ADD statement>
+			tableName, statement, ok := parseMessage(content)
+			if !ok {
+				fmt.Println("Invalid create_index message from master")
+				continue
+			}
+			applyDeferredIndex(tableName, statement)
+
+		case "sync_summary":
+			summary, err := decodeSyncSummary(content)
+			if err != nil {
+				fmt.Printf("Invalid sync summary from master: %v\n", err)
+				continue
+			}
+			var incomplete []string
+			for _, t := range summary {
+				if t.Status != syncOK {
+					fmt.Printf("WARNING: table '%s' %s during sync: %s\n", t.Table, t.Status, t.Detail)
+					incomplete = append(incomplete, t.Table)
+				}
+			}
+			if len(incomplete) > 0 {
+				fmt.Printf("Replica is incomplete: %d of %d table(s) not fully synced (%s).\n",
+					len(incomplete), len(summary), strings.Join(incomplete, ", "))
+				fmt.Println("Run Verify Replication Status to check them once the master problem is fixed.")
+			} else {
+				fmt.Printf("All %d table(s) synced\n", len(summary))
+			}
+
 		case "replication_complete":
 			replicationInProgress = false
+			endSyncSession()
 			fmt.Println("Initial replication completed successfully!")
 
-		case "replicate_query":
-			fmt.Println("Applying replicated query to local database")
-			err := executeLocalQuery(content)
+			// Everything before this point has been applied (statements
+			// are run one at a time, autocommit), so confirm the sync
+			fmt.Fprintf(master, "sync_ack:%d\n", appliedSeq)
+
+			if verifyAfterSync {
+				verifyAfterSync = false
+				repairAfterVerify = true
+				fmt.Println("Reconnected to master, verifying replication...")
+				fmt.Fprintf(master, "verify_replication:schema\n")
+			}
+
+		case "applied_position":
+			if seq, err := strconv.ParseUint(content, 10, 64); err == nil && seq > appliedSeq {
+				appliedSeq = seq
+			}
+
+		case "position":
+			masterSeq, err := strconv.ParseUint(content, 10, 64)
 			if err != nil {
-				fmt.Printf("Failed to execute replicated query: %v\n", err)
-				fmt.Printf("Query was: %s\n", content)
-
-				// Special handling for missing table errors
-				if strings.Contains(err.Error(), "Error 1146") && strings.Contains(err.Error(), "doesn't exist") {
-					// Extract table name from error
-					errParts := strings.Split(err.Error(), "'")
-					if len(errParts) >= 4 {
-						tableName := strings.Split(errParts[3], ".")[1]
-						fmt.Printf("Table '%s' doesn't exist. Requesting schema from master...\n", tableName)
-
-						// Request table schema from master
-						fmt.Fprintf(master, "get_table_schema:%s\n", tableName)
-					}
-				}
+				fmt.Printf("Invalid position from master: %s\n", content)
 				continue
 			}
-			fmt.Println("Query applied successfully to local database")
+			fmt.Printf("Master position: %d, applied: %d\n", masterSeq, appliedSeq)
+			if behind := replicationLag(masterSeq, appliedSeq); behind == 0 {
+				fmt.Println("Caught up with master")
+			} else {
+				fmt.Printf("%d statement(s) behind master\n", behind)
+			}
+
+		case "replicate_query":
+			// Format: <seq>:<query>
+			seqStr, query, ok := parseMessage(content)
+			seq, origin, err := parseReplicatePosition(seqStr)
+			if err == nil {
+				query, err = decodePayload(query)
+			}
+			if !ok || err != nil {
+				fmt.Println("Invalid replicate_query message from master")
+				continue
+			}
+			noteOrigin(seq, origin)
+			content = tolerantDrop(query)
+			if inSlaveTx() {
+				// Acked and counted as applied at commit_tx, the whole
+				// group at once
+				execInSlaveTx(seq, content)
+				continue
+			}
+			// Counted as applied even if it fails, the failure is acked
+			if seq > appliedSeq {
+				appliedSeq = seq
+			}
+
+			if !dispatchApply(seq, content) {
+				settleApplyPool()
+				applyReplicatedQuery(seq, content)
+			}
+
+		case "begin_tx":
+			beginSlaveTx(content)
+
+		case "commit_tx":
+			commitSlaveTx(content)
+
+		case "affected_check":
+			answerAffectedCheck(content)
 
 		case "verification_data":
 			if content == "begin" {
 				fmt.Println("\nReceiving verification data from master:")
 
 				// Process table info
-				masterTables := make(map[string]int)
+				data := newVerificationData()
 
 				// Read table information
 				for scanner.Scan() {
@@ -283,29 +869,49 @@ func listenToMaster() {
 						break
 					}
 
-					// Parse table info (format: "table:name:count")
-					infoParts := strings.Split(tableInfo, ":")
-					if len(infoParts) != 3 || infoParts[0] != "table" {
+					if !data.add(tableInfo) {
 						fmt.Printf("Invalid table info format: %s\n", tableInfo)
 						continue
 					}
-
-					tableName := infoParts[1]
-					tableCount := 0
-					fmt.Sscanf(infoParts[2], "%d", &tableCount)
-
-					masterTables[tableName] = tableCount
-					fmt.Printf("  - Master table: %s: %d rows\n", tableName, tableCount)
+					if tableName, tableCount, ok := parseTableInfo(tableInfo); ok {
+						fmt.Printf("  - Master table: %s: %d rows\n", tableName, tableCount)
+					}
 				}
 
 				// Compare with local tables
-				compareReplication(masterTables)
+				outOfSync := compareReplication(data)
+				if repairAfterVerify {
+					repairAfterVerify = false
+					repairTables(outOfSync)
+				}
+			}
+
+		case "block_checksums":
+			compareBlocks(content)
+
+		case "block_begin":
+			beginBlock(content)
+
+		case "block_data":
+			if err := executeLocalQuery(content); err != nil {
+				fmt.Printf("Failed to apply caught-up rows: %v\n", err)
+			}
+
+		case "block_end":
+			// Format: <table>:<lo>:<hi>:<rows>
+			parts := strings.Split(content, ":")
+			if len(parts) == 4 {
+				fmt.Printf("Table '%s': block %s..%s replaced with %s row(s) from master\n",
+					parts[0], parts[1], parts[2], parts[3])
 			}
 
+		case "row_data":
+			compareRow(content)
+
 		case "drop_database":
 			fmt.Printf("Dropping local database '%s'\n", content)
 			if db != nil {
-				_, err := db.Exec("DROP DATABASE IF EXISTS " + content)
+				_, err := db.Exec(tolerantDrop("DROP DATABASE " + content))
 				if err != nil {
 					fmt.Printf("Error dropping database: %v\n", err)
 				} else {
@@ -319,6 +925,10 @@ func listenToMaster() {
 		case "notification":
 			fmt.Printf("\n--- Master notification: %s ---\n", content)
 
+		case "bench_done":
+			// Everything before this marker has been applied by now
+			fmt.Fprintf(master, "bench_ack:%s\n", content)
+
 		case "success":
 			if content == "query executed" {
 				fmt.Println("Query executed successfully on master")
@@ -331,108 +941,287 @@ func listenToMaster() {
 					continue
 				}
 
+				if e := takeExport(); e != nil {
+					receiveExport(e, scanner)
+					continue
+				}
+
 				// Get column names
 				if !scanner.Scan() {
 					fmt.Println("Failed to read column names")
 					break
 				}
-				columns := scanner.Text()
+				columns := strings.Join(dedupeColumnNames(strings.Split(scanner.Text(), ",")), ",")
 				fmt.Printf("\n%s\n", columns)
 				fmt.Println(strings.Repeat("-", len(columns)*2))
 
 				// Display rows
 				rowCount := 0
+				truncated, failed := "", ""
+				var binary []bool
 				for scanner.Scan() {
 					row := scanner.Text()
 					if row == "END" {
 						break
 					}
+					if msg, ok := strings.CutPrefix(row, "ERROR:"); ok {
+						failed = msg
+						break
+					}
+					if strings.HasPrefix(row, "TRUNCATED:") {
+						truncated = strings.TrimPrefix(row, "TRUNCATED:")
+						continue
+					}
+					if rowCount == 0 && binary == nil && strings.HasPrefix(row, "ENCODING:") {
+						binary = parseEncodingLine(row)
+						continue
+					}
 					rowCount++
-					fmt.Println(row)
+					fmt.Println(displayRow(row, binary))
+				}
+				if failed != "" {
+					fmt.Printf("Error from master: the result failed after %d row(s): %s\n", rowCount, failed)
+					continue
 				}
 				fmt.Printf("Total rows: %d\n", rowCount)
+				if truncated != "" {
+					fmt.Printf("WARNING: results were capped at %s rows by the master. Add a LIMIT or fetch all rows to see more.\n", truncated)
+				}
 			}
 
 		case "error":
 			fmt.Printf("Error from master: %s\n", content)
+			if schemaRequestFailed(content) {
+				continue
+			}
+			if e := takeExport(); e != nil {
+				fmt.Printf("Export to %s cancelled\n", e.path)
+				e.abandon()
+			}
 		}
 	}
+	settleApplyPool()
+	abandonSlaveTx()
 
 	if err := scanner.Err(); err != nil {
 		fmt.Printf("Scanner error: %v\n", err)
 	}
 }
 
-// Compare local replication with master tables
-func compareReplication(masterTables map[string]int) {
+// Parse a "table:<name>:<rows>" line of verification_data
+func parseTableInfo(line string) (string, int, bool) {
+	infoParts := strings.Split(line, ":")
+	// A fourth part is the checksum, see parseTableChecksum
+	if (len(infoParts) != 3 && len(infoParts) != 4) || infoParts[0] != "table" {
+		return "", 0, false
+	}
+	tableCount := 0
+	fmt.Sscanf(infoParts[2], "%d", &tableCount)
+	return infoParts[1], tableCount, true
+}
+
+// Number of statements the slave is behind the master position
+func replicationLag(masterSeq, applied uint64) uint64 {
+	if applied >= masterSeq {
+		return 0
+	}
+	return masterSeq - applied
+}
+
+// Show the last cluster stats and ask the master for its current position,
+// the reply is shown by the listener
+func showReplicationPosition() {
+	showClusterStats()
+	if !connected {
+		fmt.Println("Not connected to master server")
+		return
+	}
+	fmt.Fprintf(master, "get_position:\n")
+}
+
+// Report the outcome of a replicated query back to the master, with how
+// long it took to get here if the master sent its time (see latency.go) and
+// its position
+func sendAck(seq uint64, err error) {
+	latency, timed := takeLatency(seq)
+	// The position goes in a slave_info line of its own, which masters
+	// from before it ignore, in the same write as the ack
+	pos := fmt.Sprintf("slave_info:acked_position:%d\n", seq)
+	if err != nil {
+		msg := strings.ReplaceAll(err.Error(), "\n", " ")
+		fmt.Fprintf(master, "replicate_ack:err:%s\n%s", msg, pos)
+	} else if timed {
+		fmt.Fprintf(master, "replicate_ack:ok:%d\n%s", int64(latency), pos)
+	} else {
+		fmt.Fprintf(master, "replicate_ack:ok:\n%s", pos)
+	}
+}
+
+// Compare local replication with master tables. Returns the master tables
+// that are missing or differ locally.
+func compareReplication(data *verificationData) []tableVerification {
 	if db == nil {
 		fmt.Println("Local database not available")
-		return
+		return nil
 	}
 
-	// Get local tables and counts
-	localTables := make(map[string]int)
-	rows, err := db.Query("SHOW TABLES")
+	localTables, failed, err := localTableCounts()
 	if err != nil {
 		fmt.Printf("Error getting local tables: %v\n", err)
-		return
+		return nil
+	}
+	for tableName, err := range failed {
+		fmt.Printf("Error counting rows in %s: %v\n", tableName, err)
+	}
+
+	// Compare tables
+	fmt.Println("\n=== REPLICATION VERIFICATION RESULTS ===")
+
+	run := compareTables(data.tables, localTables)
+	compareSchemas(&run, data.schemas)
+	compareChecksums(&run, data.checksums)
+	var outOfSync []tableVerification
+	for _, t := range run.tables {
+		switch {
+		case t.status == "MISSING":
+			fmt.Printf("MISSING: Table '%s' exists on master but not locally\n", t.table)
+			outOfSync = append(outOfSync, t)
+		case t.contentDiffers:
+			fmt.Printf("MISMATCH (content differs): Table '%s' has %d rows on both, but not the same ones\n",
+				t.table, t.local)
+			outOfSync = append(outOfSync, t)
+		case t.status == "MISMATCH":
+			fmt.Printf("MISMATCH: Table '%s' has %d rows locally but %d rows on master\n",
+				t.table, t.local, t.master)
+			outOfSync = append(outOfSync, t)
+		case t.status == "SCHEMA":
+			fmt.Printf("SCHEMA: Table '%s' has %d rows on both, but its structure differs from the master's\n",
+				t.table, t.local)
+			outOfSync = append(outOfSync, t)
+		case t.status == "EXTRA":
+			fmt.Printf("EXTRA: Table '%s' exists locally but not on master\n", t.table)
+		default:
+			if schemaOnly {
+				fmt.Printf("MATCH: Table '%s' exists (schema-only, %d local rows not compared)\n",
+					t.table, t.local)
+			} else {
+				fmt.Printf("MATCH: Table '%s' has %d rows on both master and locally\n",
+					t.table, t.local)
+			}
+		}
+		for _, d := range t.schema {
+			fmt.Println("    -", d)
+		}
+		for _, n := range t.notes {
+			fmt.Println("    note:", n)
+		}
+	}
+	if len(data.schemas) == 0 && len(data.tables) > 0 {
+		fmt.Println("(The master sent no table definitions, structure was not compared)")
+	}
+	recordVerification(run)
+
+	if run.inSync {
+		fmt.Println("\nReplication status: SYNCHRONIZED ✓")
+	} else {
+		fmt.Println("\nReplication status: OUT OF SYNC ✗")
 	}
+	return outOfSync
+}
 
+// Row count of every local table, and the tables that couldn't be counted
+func localTableCounts() (map[string]int, map[string]error, error) {
+	rows, err := db.Query("SHOW TABLES")
+	if err != nil {
+		return nil, nil, err
+	}
+	var names []string
 	var tableName string
 	for rows.Next() {
 		rows.Scan(&tableName)
+		names = append(names, tableName)
+	}
+	rows.Close()
 
-		// Count rows in this table
+	localTables := make(map[string]int, len(names))
+	failed := make(map[string]error)
+	for _, tableName := range names {
 		var rowCount int
 		err := db.QueryRow("SELECT COUNT(*) FROM " + tableName).Scan(&rowCount)
 		if err != nil {
-			fmt.Printf("Error counting rows in %s: %v\n", tableName, err)
+			failed[tableName] = err
 			continue
 		}
-
 		localTables[tableName] = rowCount
 	}
-	rows.Close()
-
-	// Compare tables
-	fmt.Println("\n=== REPLICATION VERIFICATION RESULTS ===")
-
-	allMatch := true
+	return localTables, failed, nil
+}
 
-	// Check for master tables that should be in local
+// Table by table comparison of the master's row counts with the local
+// ones, sorted by table name. Schema-only slaves only compare which tables
+// exist.
+func compareTables(masterTables, localTables map[string]int) verificationRun {
+	run := verificationRun{at: time.Now(), inSync: true}
 	for masterTable, masterCount := range masterTables {
 		localCount, exists := localTables[masterTable]
-
-		if !exists {
-			fmt.Printf("MISSING: Table '%s' exists on master but not locally\n", masterTable)
-			allMatch = false
-			continue
+		status := "MATCH"
+		switch {
+		case !exists:
+			status = "MISSING"
+		case !schemaOnly && localCount != masterCount:
+			status = "MISMATCH"
 		}
+		if status != "MATCH" {
+			run.inSync = false
+		}
+		run.tables = append(run.tables, tableVerification{table: masterTable, status: status, local: localCount, master: masterCount})
+	}
+	for localTable, localCount := range localTables {
+		if _, exists := masterTables[localTable]; !exists {
+			run.inSync = false
+			run.tables = append(run.tables, tableVerification{table: localTable, status: "EXTRA", local: localCount})
+		}
+	}
+	sort.Slice(run.tables, func(i, j int) bool { return run.tables[i].table < run.tables[j].table })
+	return run
+}
 
-		if localCount != masterCount {
-			fmt.Printf("MISMATCH: Table '%s' has %d rows locally but %d rows on master\n",
-				masterTable, localCount, masterCount)
-			allMatch = false
+// Re-copy the given tables from the master: drop the local copy and request
+// its schema and data again
+func repairTables(outOfSync []tableVerification) {
+	if len(outOfSync) == 0 {
+		fmt.Println("Reconnect check: replica is fully synced with master")
+		return
+	}
+
+	fmt.Printf("Reconnect check: %d table(s) out of sync, repairing\n", len(outOfSync))
+	for _, t := range outOfSync {
+		// A table of the wrong shape is always copied again
+		if len(t.schema) > 0 {
+			reloadTable(t.table)
 		} else {
-			fmt.Printf("MATCH: Table '%s' has %d rows on both master and locally\n",
-				masterTable, localCount)
+			repairTable(t.table)
 		}
 	}
+}
 
-	// Check for local tables not in master
-	for localTable := range localTables {
-		_, exists := masterTables[localTable]
-		if !exists {
-			fmt.Printf("EXTRA: Table '%s' exists locally but not on master\n", localTable)
-			allMatch = false
-		}
+// Bring a table in line with the master, fetching only the differing rows
+// where possible and copying it again otherwise
+func repairTable(table string) {
+	if schemaOnly || !TableExists(table) || !catchUpTable(table) {
+		reloadTable(table)
 	}
+}
 
-	if allMatch {
-		fmt.Println("\nReplication status: SYNCHRONIZED ✓")
-	} else {
-		fmt.Println("\nReplication status: OUT OF SYNC ✗")
+// Drop a local table and ask the master for a fresh copy
+func reloadTable(tableName string) {
+	_, err := db.Exec("DROP TABLE IF EXISTS " + tableName)
+	if err != nil {
+		fmt.Printf("Error dropping local table %s for repair: %v\n", tableName, err)
+		return
 	}
+	fmt.Printf("Requesting fresh copy of table '%s'\n", tableName)
+	fmt.Fprintf(master, "get_table_schema:%s\n", tableName)
 }
 
 func sendQuery(operation, query string) {
@@ -451,9 +1240,8 @@ func sendQuery(operation, query string) {
 }
 
 func insertRecord() {
-	var tableName string
 	fmt.Print("Enter table name: ")
-	fmt.Scanln(&tableName)
+	tableName := strings.TrimSpace(readLine())
 
 	fmt.Println("Enter column names and values separated by equals sign (name=value), one per line")
 	fmt.Println("Enter empty line when done")
@@ -461,10 +1249,9 @@ func insertRecord() {
 	columns := []string{}
 	values := []string{}
 
-	reader := bufio.NewReader(os.Stdin)
 	for {
 		fmt.Print("> ")
-		line, _ := reader.ReadString('\n')
+		line := readLine()
 		line = strings.TrimSpace(line)
 
 		if line == "" {
@@ -478,7 +1265,7 @@ func insertRecord() {
 		}
 
 		columns = append(columns, parts[0])
-		values = append(values, "'"+parts[1]+"'") // Note: simple quoting, not safe for all values
+		values = append(values, sqlLiteral(parts[1]))
 	}
 
 	if len(columns) == 0 {
@@ -495,23 +1282,22 @@ func insertRecord() {
 }
 
 func updateRecord() {
-	var tableName string
 	fmt.Print("Enter table name: ")
-	fmt.Scanln(&tableName)
+	tableName := strings.TrimSpace(readLine())
 
-	var id string
-	fmt.Print("Enter ID of record to update: ")
-	fmt.Scanln(&id)
+	key, ok := promptRecordKey(tableName, "update")
+	if !ok {
+		return
+	}
 
 	fmt.Println("Enter column names and values to update separated by equals sign (name=value), one per line")
 	fmt.Println("Enter empty line when done")
 
 	updates := []string{}
 
-	reader := bufio.NewReader(os.Stdin)
 	for {
 		fmt.Print("> ")
-		line, _ := reader.ReadString('\n')
+		line := readLine()
 		line = strings.TrimSpace(line)
 
 		if line == "" {
@@ -524,7 +1310,7 @@ func updateRecord() {
 			continue
 		}
 
-		updates = append(updates, fmt.Sprintf("%s = '%s'", parts[0], parts[1]))
+		updates = append(updates, fmt.Sprintf("%s = %s", parts[0], sqlLiteral(parts[1])))
 	}
 
 	if len(updates) == 0 {
@@ -532,34 +1318,33 @@ func updateRecord() {
 		return
 	}
 
-	query := fmt.Sprintf("UPDATE %s SET %s WHERE id = %s",
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s",
 		tableName,
 		strings.Join(updates, ", "),
-		id)
+		key)
 
 	sendQuery("update", query)
 }
 
 func deleteRecord() {
-	var tableName string
 	fmt.Print("Enter table name: ")
-	fmt.Scanln(&tableName)
+	tableName := strings.TrimSpace(readLine())
 
-	var id string
-	fmt.Print("Enter ID of record to delete: ")
-	fmt.Scanln(&id)
+	key, ok := promptRecordKey(tableName, "delete")
+	if !ok {
+		return
+	}
 
-	query := fmt.Sprintf("DELETE FROM %s WHERE id = %s", tableName, id)
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s", tableName, key)
 	sendQuery("delete", query)
 }
 
 func selectRecords() {
 	var query string
-	reader := bufio.NewReader(os.Stdin)
 
 	fmt.Println("Enter SELECT query:")
 	fmt.Print("> ")
-	query, _ = reader.ReadString('\n')
+	query = readLine()
 	query = strings.TrimSpace(query)
 
 	if !strings.HasPrefix(strings.ToUpper(query), "SELECT") {
@@ -567,6 +1352,37 @@ func selectRecords() {
 		return
 	}
 
+	if confirm("Write results to a file instead of the screen? (y/n): ") {
+		fmt.Print("Format (csv/json): ")
+		format := strings.TrimSpace(readLine())
+		fmt.Print("Output path: ")
+		path := readLine()
+		path = strings.TrimSpace(path)
+		if path == "" {
+			fmt.Println("Output path cannot be empty")
+			return
+		}
+		if !connected {
+			fmt.Println("Not connected to master server")
+			return
+		}
+		if _, err := startExport(path, format); err != nil {
+			fmt.Printf("Cannot export: %v\n", err)
+			return
+		}
+		// Exports always fetch every row
+		sendQuery("select_export", query)
+		return
+	}
+
+	// The master caps queries without a LIMIT unless asked not to
+	if !hasLimitClause(query) {
+		if confirm("Query has no LIMIT and may be capped by the master. Fetch all rows? (y/n): ") {
+			sendQuery("select_all", query)
+			return
+		}
+	}
+
 	sendQuery("select", query)
 }
 
@@ -602,8 +1418,7 @@ func viewLocalDatabase() {
 
 	// Ask which table to view
 	fmt.Print("\nEnter table number to view data (0 to cancel): ")
-	var choice int
-	fmt.Scanln(&choice)
+	choice := readChoice()
 
 	if choice <= 0 || choice > tableCount {
 		return
@@ -619,7 +1434,7 @@ func viewLocalDatabase() {
 	rows.Close()
 
 	// Display records from selected table
-	rows, err = db.Query("SELECT * FROM " + selectedTable)
+	rows, err = db.Query("SELECT * FROM " + selectedTable + displayOrder(selectedTable))
 	if err != nil {
 		fmt.Printf("Error querying table: %v\n", err)
 		return
@@ -684,56 +1499,118 @@ func verifyReplication() {
 	fmt.Println("Requesting verification data from master...")
 
 	// Request table list and row counts from master
-	fmt.Fprintf(master, "verify_replication:request\n")
+	fmt.Fprintf(master, "verify_replication:schema\n")
 
 	// The actual verification is handled in listenToMaster when the master responds
 }
 
-func readPassword() string {
-	fmt.Print("Enter MySQL password: ")
-
-	// In a production environment, you would use a package like "golang.org/x/term"
-	// to read passwords securely without displaying them on screen
-	// Example:
-	// bytePassword, _ := term.ReadPassword(int(syscall.Stdin))
-	// return string(bytePassword)
-
-	// For simplicity, we'll just read it directly here
-	var password string
-	fmt.Scanln(&password)
-	return password
-}
+// Entry point for "ddb slave"
+func slaveMain(args []string) {
+	fs := flag.NewFlagSet("slave", flag.ContinueOnError)
+	showVersion := fs.Bool("version", false, "print version and exit")
+	fs.IntVar(&maxMessageSize, "max-message-size", MaxMessageSize, "largest protocol message accepted, in bytes")
+	fs.BoolVar(&schemaOnly, "schema-only", false, "replicate only the schema (CREATE/ALTER/DROP), not the master's rows")
+	fs.DurationVar(&statsInterval, "stats-interval", 0, "have the master push cluster stats this often, shown with the replication position (0 for none)")
+	fs.IntVar(&applyWorkers, "apply-workers", applyWorkers, "apply replicated statements for different tables on this many workers at once (1 to apply them one by one)")
+	fs.IntVar(&applyRetries, "apply-retries", applyRetries, "times a replicated statement is retried after a deadlock or lock wait timeout")
+	fs.DurationVar(&applyRetryBackoff, "apply-retry-backoff", applyRetryBackoff, "pause before the first retry, doubled for each one after it")
+	fs.DurationVar(&drainTimeout, "drain-timeout", drainTimeout, "on exit, how long to keep applying what the master already sent (0 to exit at once)")
+	fs.DurationVar(&idleTimeout, "idle-timeout", 0, "exit when there is no input for this long (0 to wait forever)")
+	snapshotFlag := fs.String("snapshot", "", "bootstrap from a snapshot file (path or http(s) URL) exported by the master")
+	localAddrFlag := fs.String("local-addr", "", "local IP (or IP:port) to connect to the master from")
+	masterFlag := fs.String("master", "", "master address, or unix:/path (prompted for if not given)")
+	verifyOnceFlag := fs.Bool("verify-once", false, "compare the local replica with the master once, print the result as JSON and exit (0 if in sync)")
+	fs.StringVar(&dataDir, "data-dir", "", "directory for the state kept between runs, such as dead-lettered statements (default: a directory per local MySQL server under "+defaultDataDir()+")")
+	fs.BoolVar(&strictDrop, "strict-drop", false, "report a replicated DROP of a table or database this slave doesn't have as an error")
+	fs.BoolVar(&verbose, "verbose", false, "print a line for every replicated statement applied, not just failures and a summary each second")
+	fs.StringVar(&eventSocket, "event-socket", "", "publish applied replication events as JSON lines on this Unix domain socket")
+	printConfigFlag := fs.Bool("print-config", false, "print the effective configuration and exit")
+	configFile := addConfigFlag(fs)
+	addMySQLFlags(fs, false)
+	parseFlags(fs, args)
+	configFlags = fs
+	if *configFile != "" {
+		if err := applyConfigFile(fs, *configFile); err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading config file:", err)
+			exitProgram(2)
+		}
+	}
+	useInstanceDataDir("slave-" + mysqlAddr())
+	if *showVersion {
+		printVersion()
+		return
+	}
+	if *printConfigFlag {
+		printConfig(os.Stdout)
+		return
+	}
+	if err := openDataDir(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		exitProgram(2)
+	}
+	if *localAddrFlag != "" {
+		addr, err := parseLocalAddr(*localAddrFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			exitProgram(2)
+		}
+		localAddr = addr
+	}
+	idleCleanup = shutdownSlave
 
-func main() {
 	// Get MySQL credentials for local database
-	fmt.Print("Enter MySQL username for local replication: ")
-	fmt.Scanln(&dbUser)
-
-	dbPassword = readPassword()
+	dbUser = mysqlUser("Enter MySQL username for local replication: ")
+	dbPassword = mysqlPassword()
 
 	if dbUser == "" {
 		fmt.Println("Warning: Using empty username for database connection")
 	}
 
-	var masterAddr string
-	fmt.Print("Enter master server address (default: localhost:9999): ")
-	fmt.Scanln(&masterAddr)
+	masterAddr := *masterFlag
+	if *verifyOnceFlag {
+		if masterAddr == "" {
+			masterAddr = "localhost:9999"
+		}
+		exitProgram(verifyOnce(masterAddr, os.Stdout))
+	}
+
+	if n, err := loadDeadLetters(); err != nil {
+		fmt.Println("Warning: can't read earlier dead-lettered statements:", err)
+	} else if n > 0 {
+		fmt.Printf("%d statement(s) dead-lettered in earlier runs, see Show Pending Operations\n", n)
+	}
+
+	if applyWorkers < 1 {
+		fmt.Fprintln(os.Stderr, "Error: -apply-workers must be at least 1")
+		exitProgram(2)
+	}
+	startApplyPool()
+	startApplySummary()
+	if eventSocket != "" {
+		if err := startEventSocket(eventSocket); err != nil {
+			fmt.Fprintln(os.Stderr, "Error opening event socket:", err)
+			exitProgram(2)
+		}
+	}
+
+	if *snapshotFlag != "" {
+		if err := replaySnapshot(*snapshotFlag); err != nil {
+			fmt.Fprintln(os.Stderr, "Error bootstrapping from snapshot:", err)
+			exitProgram(1)
+		}
+	}
 
+	if masterAddr == "" {
+		fmt.Print("Enter master server address, or unix:/path for a socket (default: localhost:9999): ")
+		masterAddr = strings.TrimSpace(readLine())
+	}
 	if masterAddr == "" {
 		masterAddr = "localhost:9999"
 	}
 
-	// Try to connect to master
-	if !connectToMaster(masterAddr) {
+	// Try to connect to master, retrying in the background on failure
+	if !reconnectToMaster(masterAddr) {
 		fmt.Println("Initial connection failed. Will retry in background.")
-		// Retry connection in background
-		go func() {
-			for !connected {
-				time.Sleep(5 * time.Second)
-				fmt.Println("Attempting to reconnect to master...")
-				connectToMaster(masterAddr)
-			}
-		}()
 	}
 
 	// Start the command loop
@@ -746,15 +1623,27 @@ func main() {
 		fmt.Println("5. View Local Database")
 		fmt.Println("6. Verify Replication Status")
 		fmt.Println("7. Reconnect to Master")
-		fmt.Println("8. Exit Program")
+		fmt.Println("8. Show Pending Operations")
+		fmt.Println("9. Show Replication Position")
+		fmt.Println("10. Show Replication Changelog")
+		fmt.Println("11. Verify Single Row")
+		fmt.Println("12. Catch Up Tables With Master")
+		fmt.Println("13. Show Verification History")
+		fmt.Println("14. Show Effective Configuration")
+		fmt.Println("15. Full Resync")
+		if isApplyPaused() {
+			fmt.Println("16. Resume Replication (paused)")
+		} else {
+			fmt.Println("16. Pause Replication")
+		}
+		fmt.Println("17. Exit Program")
 
 		if !connected {
 			fmt.Println("WARNING: Not connected to master server!")
 		}
 
 		fmt.Print("Enter choice: ")
-		var choice int
-		fmt.Scanln(&choice)
+		choice := readChoice()
 
 		switch choice {
 		case 1:
@@ -770,19 +1659,28 @@ func main() {
 		case 6:
 			verifyReplication()
 		case 7:
-			if connected {
-				master.Close()
-				connected = false
-			}
-			connectToMaster(masterAddr)
+			reconnectToMaster(masterAddr)
 		case 8:
+			showPendingOperations()
+		case 9:
+			showReplicationPosition()
+		case 10:
+			showChangelog()
+		case 11:
+			verifyRow()
+		case 12:
+			catchUpAll()
+		case 13:
+			showVerificationHistory()
+		case 14:
+			printConfig(os.Stdout)
+		case 15:
+			fullResync(masterAddr)
+		case 16:
+			togglePauseApply()
+		case 17:
 			fmt.Println("Exiting program...")
-			if connected {
-				master.Close()
-			}
-			if db != nil {
-				db.Close()
-			}
+			shutdownSlave()
 			return
 		default:
 			fmt.Println("Invalid choice")
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Point the slave's connection to the master at a pipe. The lines the
+// slave sends arrive on the returned channel.
+func pipeMaster(t *testing.T) <-chan string {
+	t.Helper()
+	client, server := net.Pipe()
+	old, oldConnected := master, connected
+	master, connected = client, true
+	lines := make(chan string, 1024)
+	go func() {
+		sc := bufio.NewScanner(server)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+		close(lines)
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+		master, connected = old, oldConnected
+	})
+	return lines
+}
+
+// The next line the slave sent to the master
+func nextLine(t *testing.T, lines <-chan string) string {
+	t.Helper()
+	select {
+	case line, ok := <-lines:
+		if !ok {
+			t.Fatal("connection to the master closed")
+		}
+		return line
+	case <-time.After(10 * time.Second):
+		t.Fatal("nothing sent to the master")
+	}
+	return ""
+}
+
+// The next ack the slave sent to the master, and the position sent with it
+func nextAck(t *testing.T, lines <-chan string) string {
+	t.Helper()
+	ack := nextLine(t, lines)
+	if !strings.HasPrefix(ack, "replicate_ack:") {
+		t.Fatalf("sent %q, want an ack", ack)
+	}
+	if pos := nextLine(t, lines); !strings.HasPrefix(pos, "slave_info:acked_position:") {
+		t.Fatalf("sent %q after the ack, want its position", pos)
+	}
+	return ack
+}
+
+// Start the test with no pending operations, and leave none behind
+func clearPending(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		pendingMu.Lock()
+		pendingOps = make(map[string][]pendingOp)
+		localDBDown, applyPaused = false, false
+		pendingMu.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestHandshakeReportsPacketLimit(t *testing.T) {
+	f := useFakeDB(t)
+	oldVersion, oldPacket := localVersion, localMaxPacket
+	localVersion, localMaxPacket = "", 0
+	t.Cleanup(func() { localVersion, localMaxPacket = oldVersion, oldPacket })
+	f.rows(`^SELECT VERSION\(\)$`, []string{"VERSION()"}, []driver.Value{"8.0.36"})
+	f.rows(`^SELECT @@max_allowed_packet$`, []string{"@@max_allowed_packet"}, []driver.Value{int64(1 << 20)})
+
+	// The master knows the limit before it sends the first sync batch
+	s, _ := pipeSlave(t)
+	var sawLimit bool
+	for _, line := range handshake() {
+		line = strings.TrimSuffix(line, "\n")
+		if strings.HasPrefix(line, "subscribe:") {
+			if !sawLimit {
+				t.Fatal("max_allowed_packet not sent ahead of the subscription")
+			}
+			break
+		}
+		if line == "slave_info:max_allowed_packet:1048576" {
+			sawLimit = true
+		}
+		if rest, ok := strings.CutPrefix(line, "slave_info:"); ok {
+			s.recordInfo(rest)
+		}
+	}
+	if got := s.packetLimit(); got > 1<<20 {
+		t.Fatalf("packet limit %d, want at most the slave's 1048576", got)
+	}
+}
+
+func TestSyncRunsWithForeignKeyChecksOff(t *testing.T) {
+	f := useFakeDB(t)
+	if err := beginSyncSession(); err != nil {
+		t.Fatal(err)
+	}
+	// Child rows ahead of the parent's, as with tables referencing each other
+	for _, q := range []string{
+		"CREATE TABLE child (id INT, parent_id INT, FOREIGN KEY (parent_id) REFERENCES parent (id))",
+		"INSERT INTO child VALUES (1, 1)",
+		"INSERT INTO parent VALUES (1)",
+	} {
+		if err := executeLocalQuery(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	endSyncSession()
+	if err := executeLocalQuery("INSERT INTO child VALUES (2, 1)"); err != nil {
+		t.Fatal(err)
+	}
+
+	off := f.connsOf(`^SET FOREIGN_KEY_CHECKS = 0$`)
+	if len(off) != 1 {
+		t.Fatalf("checks turned off %d time(s)", len(off))
+	}
+	for _, pattern := range []string{`^CREATE TABLE child`, `VALUES \(1, 1\)`, `^INSERT INTO parent`, `^SET FOREIGN_KEY_CHECKS = 1$`} {
+		if conns := f.connsOf(pattern); len(conns) != 1 || conns[0] != off[0] {
+			t.Fatalf("%s ran on connection(s) %v, the sync session is %d", pattern, conns, off[0])
+		}
+	}
+	stmts := f.statements()
+	if !strings.Contains(strings.Join(stmts, "\n"), "SET FOREIGN_KEY_CHECKS = 1\nINSERT INTO child VALUES (2, 1)") {
+		t.Fatalf("checks not back on before the next statement:\n%s", strings.Join(stmts, "\n"))
+	}
+}
+
+func TestTransactionIsAckedAtCommit(t *testing.T) {
+	f := useFakeDB(t)
+	acks := pipeMaster(t)
+	clearPending(t)
+	old := appliedSeq
+	appliedSeq = 4
+	t.Cleanup(func() { appliedSeq = old })
+
+	beginSlaveTx("1")
+	execInSlaveTx(5, "INSERT INTO t (id) VALUES (5)")
+	execInSlaveTx(6, "INSERT INTO t (id) VALUES (6)")
+	select {
+	case ack := <-acks:
+		t.Fatalf("acked %q before the transaction committed", ack)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if appliedSeq != 4 {
+		t.Fatalf("applied position %d before the commit", appliedSeq)
+	}
+
+	commitSlaveTx("1")
+	if n := len(f.matching(`^COMMIT$`)); n != 1 {
+		t.Fatalf("%d commits", n)
+	}
+	for i := 0; i < 2; i++ {
+		if ack := nextAck(t, acks); ack != "replicate_ack:ok:" {
+			t.Fatalf("ack %q", ack)
+		}
+	}
+	if appliedSeq != 6 {
+		t.Fatalf("applied position %d after the commit, want 6", appliedSeq)
+	}
+}
+
+func TestFailedTransactionAcksEveryStatementAsFailed(t *testing.T) {
+	f := useFakeDB(t)
+	acks := pipeMaster(t)
+	clearPending(t)
+	f.fail(`^INSERT INTO t \(id\) VALUES \(2\)`, errors.New("duplicate entry"))
+
+	beginSlaveTx("1")
+	execInSlaveTx(1, "INSERT INTO t (id) VALUES (1)")
+	execInSlaveTx(2, "INSERT INTO t (id) VALUES (2)")
+	execInSlaveTx(3, "INSERT INTO t (id) VALUES (3)")
+	commitSlaveTx("1")
+
+	if n := len(f.matching(`^INSERT INTO t \(id\) VALUES \(3\)`)); n != 0 {
+		t.Fatal("statement ran after the transaction was rolled back")
+	}
+	if n := len(f.matching(`^COMMIT$`)); n != 0 {
+		t.Fatal("rolled back transaction committed")
+	}
+	for i := 0; i < 3; i++ {
+		ack := nextAck(t, acks)
+		if !strings.HasPrefix(ack, "replicate_ack:err:") || !strings.Contains(ack, "duplicate entry") {
+			t.Fatalf("ack %d is %q, want the failure", i, ack)
+		}
+	}
+}
+
+func TestAckCarriesItsPosition(t *testing.T) {
+	lines := pipeMaster(t)
+
+	sendAck(7, nil)
+	sendAck(8, errors.New("duplicate entry"))
+
+	for _, want := range []string{"replicate_ack:ok:", "slave_info:acked_position:7",
+		"replicate_ack:err:duplicate entry", "slave_info:acked_position:8"} {
+		if got := nextLine(t, lines); got != want {
+			t.Fatalf("sent %q, want %q", got, want)
+		}
+	}
+}
+
+func TestCreateTableSentTwice(t *testing.T) {
+	local := "CREATE TABLE `orders` (\n  `id` int NOT NULL,\n  `item` varchar(100) DEFAULT NULL,\n  PRIMARY KEY (`id`)\n) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"
+	for _, tc := range []struct {
+		name, def string
+		same      bool
+	}{
+		{"same definition", strings.ReplaceAll(local, "\n", " "), true},
+		{"different definition", "CREATE TABLE `orders` (  `id` int NOT NULL,  `item` varchar(200) DEFAULT NULL,  PRIMARY KEY (`id`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := useFakeDB(t)
+			existingTables(f, "orders")
+			f.rows(`^SHOW CREATE TABLE orders$`, []string{"Table", "Create Table"}, []driver.Value{"orders", local})
+
+			err := executeCreateTable(tc.def)
+			if tc.same && !errors.Is(err, errSameTable) {
+				t.Fatalf("got %v, want the table skipped as the same", err)
+			}
+			if !tc.same && (err == nil || errors.Is(err, errSameTable) || !strings.Contains(err.Error(), "different definition")) {
+				t.Fatalf("got %v, want the different definition reported", err)
+			}
+			if ran := f.matching(`^CREATE`); len(ran) != 0 {
+				t.Fatalf("ran %q over the existing table", ran)
+			}
+		})
+	}
+}
+
+func TestDropOfATableTheSlaveNeverHadIsANoOp(t *testing.T) {
+	old := strictDrop
+	t.Cleanup(func() { strictDrop = old })
+
+	for _, strict := range []bool{false, true} {
+		strictDrop = strict
+		f := useFakeDB(t)
+		throughSlave(t, f, func(*slaveConn) {})
+		acks := pipeMaster(t)
+		f.fail("^DROP TABLE gone$", &mysql.MySQLError{Number: 1051, Message: "Unknown table 'shop.gone'"})
+
+		replicate(nil, "DROP TABLE gone")
+		ack := nextAck(t, acks)
+		ran := f.matching(`^DROP TABLE`)
+		switch {
+		case !strict && (ack != "replicate_ack:ok:" || len(ran) != 1 || ran[0] != "DROP TABLE IF EXISTS gone"):
+			t.Fatalf("ran %q and acked %q, want the drop run with IF EXISTS and acked ok", ran, ack)
+		case strict && !strings.HasPrefix(ack, "replicate_ack:err:"):
+			t.Fatalf("-strict-drop acked %q, want the error", ack)
+		}
+	}
+}
+
+func TestLocalAddressIsCheckedAndDialedFrom(t *testing.T) {
+	for _, bad := range []string{"0.0.0.0", "192.0.2.1"} {
+		if _, err := parseLocalAddr(bad); err == nil {
+			t.Errorf("-local-addr %s accepted", bad)
+		}
+	}
+
+	// A port known to be free, to see it on the master's side
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := probe.Addr().(*net.TCPAddr).Port
+	probe.Close()
+
+	addr, err := parseLocalAddr(net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := localAddr
+	localAddr = addr
+	t.Cleanup(func() { localAddr = old })
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	from := make(chan net.Addr, 1)
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			from <- conn.RemoteAddr()
+			conn.Close()
+		}
+	}()
+	conn, err := dialMaster(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if got := (<-from).(*net.TCPAddr); got.Port != port || !got.IP.Equal(addr.IP) {
+		t.Fatalf("master saw the slave at %v, want %v", got, addr)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Reusable sync snapshots for provisioning many slaves. The master writes
+// the initial sync stream it would send a new slave to a file once, with
+// the statements encoded as for slaves asking for payload_encoding:base64. A slave
+// started with -snapshot replays that file locally, then connects and asks
+// to resume from the snapshot's position (slave_info:resume_position:
+// <db>:<seq>) instead of getting a full sync. The master sends the
+// statements replicated since from its replay log, or falls back to a full
+// sync if the log no longer covers that position.
+//
+// Only replicate() statements are numbered, so changes that go out as
+// their own frames (create_table, create_routine, a database switch) reset
+// the replay log: everything before them can only be caught up by a full
+// sync.
+
+const replayLogSize = 10000
+
+type replayEntry struct {
+	seq   uint64
+	query string
+}
+
+// Guarded by seqMu. replayFloor is the oldest position a slave can resume
+// from.
+var (
+	replayLog   []replayEntry
+	replayFloor uint64
+)
+
+// Sent as the last frame of an exported snapshot so the writer knows the
+// file is complete. Slaves ignore it.
+const snapshotEndFrame = "snapshot_end:\n"
+
+// Called by replicate with seqMu held
+func logReplay(seq uint64, query string) {
+	if len(replayLog) == replayLogSize {
+		replayFloor = replayLog[0].seq
+		replayLog = append(replayLog[:0], replayLog[1:]...)
+	}
+	replayLog = append(replayLog, replayEntry{seq, query})
+}
+
+// Forget the replayable history after a change that isn't numbered
+func resetReplayLog() {
+	seqMu.Lock()
+	defer seqMu.Unlock()
+	replayLog = nil
+	replayFloor = replicationSeq
+}
+
+// Statements replicated after pos, false if some are no longer in the log
+func replaySince(pos uint64) ([]replayEntry, bool) {
+	seqMu.Lock()
+	defer seqMu.Unlock()
+	if pos < replayFloor || pos > replicationSeq {
+		return nil, false
+	}
+	var entries []replayEntry
+	for _, e := range replayLog {
+		if e.seq > pos {
+			entries = append(entries, e)
+		}
+	}
+	return entries, true
+}
+
+// Master side: catch a slave bootstrapped from a snapshot up from its
+// position. Returns false if it needs a full sync instead.
+func resumeSlave(s *slaveConn) bool {
+	defer useDB()()
+	s.smu.Lock()
+	name, pos := s.resumeDB, s.resumePos
+	s.smu.Unlock()
+	if name == "" {
+		return false
+	}
+	if name != dbName {
+		fmt.Printf("Slave %s has a snapshot of '%s', not '%s', sending a full sync\n", s.addr, name, dbName)
+		return false
+	}
+
+	// Like a sync snapshot: no write may land between reading the log and
+	// holding live frames for the slave
+	snapshotMu.Lock()
+	entries, ok := replaySince(pos)
+	if ok {
+		s.snapshotTaken()
+	}
+	snapshotMu.Unlock()
+	if !ok {
+		fmt.Printf("Slave %s is at position %d, which the replay log no longer covers, sending a full sync\n", s.addr, pos)
+		return false
+	}
+	defer s.syncFinished()
+
+	fmt.Printf("Resuming slave %s from position %d (%d statement(s) to catch up)\n", s.addr, pos, len(entries))
+	for _, e := range entries {
+		if s.schemaOnly && !isSchemaStatement(e.query) {
+			s.sendBulk("applied_position:%d\n", e.seq)
+			continue
+		}
+		s.sendBulk("replicate_query:%d:%s\n", e.seq, s.payload(s.tailorStatement(e.query)))
+	}
+	s.smu.Lock()
+	syncPos := s.syncPos
+	s.smu.Unlock()
+	s.sendBulk("applied_position:%d\n", syncPos)
+	s.sendBulk("replication_complete:done\n")
+	return true
+}
+
+// Master side: write the initial sync stream to a file. The stream is
+// produced by sendSchemaToSlave for a slave connection that isn't
+// registered, so it gets no live frames. Returns the snapshot's position.
+func exportSnapshot(path string) (uint64, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+
+	conn, peer := net.Pipe()
+	s := newSlaveConn(conn)
+	s.addr = "snapshot " + path
+	// The file is read line by line, statements holding newlines must
+	// stay on theirs
+	s.base64Payloads = true
+
+	copied := make(chan error, 1)
+	go func() {
+		r := bufio.NewReader(peer)
+		w := bufio.NewWriter(f)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				copied <- err
+				return
+			}
+			if line == snapshotEndFrame {
+				copied <- w.Flush()
+				return
+			}
+			if _, err := w.WriteString(line); err != nil {
+				copied <- err
+				return
+			}
+		}
+	}()
+
+	sendSchemaToSlave(s)
+	s.sendBulk(snapshotEndFrame)
+	err = <-copied
+	s.close()
+	peer.Close()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return 0, err
+	}
+
+	s.smu.Lock()
+	defer s.smu.Unlock()
+	return s.syncPos, nil
+}
+
+// Menu action
+func ExportSnapshot() {
+	if dryRun {
+		fmt.Println("Snapshots can't be exported with -dry-run, nothing would be written")
+		return
+	}
+	defaultPath := fmt.Sprintf("%s-%s.snapshot", dbName, time.Now().Format("20060102-150405"))
+	fmt.Printf("Enter snapshot file path (default: %s): ", defaultPath)
+	path := strings.TrimSpace(readLine())
+	if path == "" {
+		path = defaultPath
+	}
+
+	pos, err := exportSnapshot(path)
+	if err != nil {
+		fmt.Printf("Error exporting snapshot: %v\n", err)
+		return
+	}
+	auditLog("export_snapshot", path)
+	fmt.Printf("Snapshot of '%s' at position %d written to %s\n", dbName, pos, path)
+	fmt.Println("Start new slaves with -snapshot to bootstrap from it.")
+}
+
+// Open a snapshot from a local path or an http(s) URL
+func openSnapshot(source string) (io.ReadCloser, error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		resp, err := http.Get(source)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("fetching %s: %s", source, resp.Status)
+		}
+		return resp.Body, nil
+	}
+	return os.Open(source)
+}
+
+// Slave side: apply a snapshot before connecting to the master. The frames
+// are handled exactly as if the master sent them; what the handlers send
+// back is discarded.
+func replaySnapshot(source string) error {
+	in, err := openSnapshot(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	conn, peer := net.Pipe()
+	master = conn
+	defer func() { master = nil }()
+
+	go io.Copy(io.Discard, peer)
+	go func() {
+		io.Copy(peer, in)
+		peer.Close()
+	}()
+
+	fmt.Printf("Bootstrapping from snapshot %s\n", source)
+	readMasterMessages(conn)
+	conn.Close()
+
+	if localDbName == "" {
+		return fmt.Errorf("snapshot didn't set up a database")
+	}
+	resumeDB, resumePos = localDbName, appliedSeq
+	fmt.Printf("Snapshot of '%s' applied, at position %d\n", localDbName, appliedSeq)
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bufio"
+	"database/sql/driver"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotKeepsStatementsOnOneLine(t *testing.T) {
+	f := useFakeDB(t)
+	withTables(t, "shop", "notes")
+	f.rows(`^SHOW CREATE TABLE notes$`, []string{"Table", "Create Table"},
+		[]driver.Value{"notes", "CREATE TABLE `notes` (\n  `id` int NOT NULL,\n  `body` text,\n  PRIMARY KEY (`id`)\n)"})
+	f.rows(`^SELECT COUNT\(\*\) FROM notes$`, []string{"COUNT(*)"}, []driver.Value{int64(1)})
+	f.rows(`^SELECT \* FROM notes LIMIT`, []string{"id", "body"}, []driver.Value{int64(1), "first line\nsecond: line\r\n"})
+
+	path := filepath.Join(t.TempDir(), "shop.snapshot")
+	if _, err := exportSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var rows []string
+	sc := bufio.NewScanner(file)
+	for sc.Scan() {
+		if payload, ok := strings.CutPrefix(sc.Text(), "sync_data:"); ok {
+			stmt, err := decodePayload(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows = append(rows, stmt)
+		}
+	}
+	if len(rows) != 1 || !strings.Contains(rows[0], "'first line\nsecond: line\r\n'") {
+		t.Fatalf("rows in the snapshot: %q", rows)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Per-table soft delete. Deletes on these tables set the marker column
+// instead of removing the row, and the UPDATE is what gets replicated, so
+// the row is kept on the master and every slave. Display Records leaves
+// the marked rows out.
+
+const softDeleteColumn = "deleted_at"
+
+var (
+	softDeleteMu     sync.Mutex
+	softDeleteTables = make(map[string]bool)
+)
+
+var deleteStmtRe = regexp.MustCompile("(?is)^\\s*DELETE\\s+FROM\\s+`?(\\w+)`?\\s+WHERE\\s+(.*?)\\s*;?\\s*$")
+
+func isSoftDelete(table string) bool {
+	softDeleteMu.Lock()
+	defer softDeleteMu.Unlock()
+	return softDeleteTables[table]
+}
+
+// The table must have a DATETIME or TIMESTAMP marker column
+func checkSoftDeleteColumn(table string) error {
+	var dataType string
+	err := db.QueryRow(
+		"SELECT DATA_TYPE FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? AND COLUMN_NAME = ?",
+		dbName, table, softDeleteColumn).Scan(&dataType)
+	if err != nil {
+		return fmt.Errorf("table %s has no %s column", table, softDeleteColumn)
+	}
+	switch strings.ToLower(dataType) {
+	case "datetime", "timestamp":
+		return nil
+	}
+	return fmt.Errorf("%s.%s is %s, it must be DATETIME or TIMESTAMP", table, softDeleteColumn, dataType)
+}
+
+// Marker value, fixed on the master so every replica stores the same time
+func softDeleteTimestamp() string {
+	return time.Now().UTC().Format("2006-01-02 15:04:05")
+}
+
+// Rewrite a DELETE forwarded by a slave into the marker UPDATE when its
+// table is in soft-delete mode. Anything else is returned unchanged.
+func softDeleteQuery(query string) string {
+	m := deleteStmtRe.FindStringSubmatch(query)
+	if m == nil || !isSoftDelete(m[1]) {
+		return query
+	}
+	// Run on the master as well, so always in its dialect
+	marker := quoteIdent(dialectMySQL, softDeleteColumn)
+	return fmt.Sprintf("UPDATE %s SET %s = %s WHERE (%s) AND %s IS NULL",
+		quoteIdent(dialectMySQL, m[1]), marker,
+		sqlLiteral(softDeleteTimestamp()), m[2], marker)
+}
+
+// Menu action: turn soft delete on or off for the current table
+func ToggleSoftDelete() {
+	if isSoftDelete(currentTable) {
+		softDeleteMu.Lock()
+		delete(softDeleteTables, currentTable)
+		softDeleteMu.Unlock()
+		fmt.Printf("Soft delete disabled for '%s', deletes remove rows again.\n", currentTable)
+		return
+	}
+
+	if err := checkSoftDeleteColumn(currentTable); err != nil {
+		fmt.Printf("Cannot enable soft delete: %v\n", err)
+		fmt.Printf("Add it with: ALTER TABLE %s ADD COLUMN %s DATETIME NULL\n", currentTable, softDeleteColumn)
+		return
+	}
+	softDeleteMu.Lock()
+	softDeleteTables[currentTable] = true
+	softDeleteMu.Unlock()
+	fmt.Printf("Soft delete enabled for '%s', deletes now set %s.\n", currentTable, softDeleteColumn)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+func TestSoftDeleteMarksTheRowEverywhereAndHidesIt(t *testing.T) {
+	f := useFakeDB(t)
+	throughSlave(t, f, func(*slaveConn) {})
+	useTable(t, f, "people",
+		[]string{"id", "int", "NO", "PRI"},
+		[]string{"name", "varchar(100)", "YES", ""},
+		[]string{"deleted_at", "datetime", "YES", ""})
+	f.rows(`^SELECT DATA_TYPE FROM information_schema\.COLUMNS`, []string{"DATA_TYPE"}, []driver.Value{"datetime"})
+	f.on(`^UPDATE people SET deleted_at = \?`, func([]driver.Value) fakeResult { return fakeResult{affected: 1} })
+
+	ToggleSoftDelete()
+	t.Cleanup(func() {
+		softDeleteMu.Lock()
+		delete(softDeleteTables, "people")
+		softDeleteMu.Unlock()
+	})
+	if !isSoftDelete("people") {
+		t.Fatal("soft delete not enabled")
+	}
+
+	feedInput(t, "3")
+	DeleteRecord()
+	if got := f.matching(`^DELETE`); len(got) != 0 {
+		t.Fatalf("row removed with %q", got)
+	}
+	if got := f.matching(`^UPDATE people `); len(got) != 1 || got[0] != "UPDATE people SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL" {
+		t.Fatalf("master ran %q, want the marker set", got)
+	}
+	got := slaveWrites(t, f, 1)
+	if !strings.HasPrefix(got[0], "UPDATE `people` SET `deleted_at` = '") || !strings.HasSuffix(got[0], "' WHERE `id` = 3") {
+		t.Fatalf("slave ran %q, want the same marker set", got[0])
+	}
+
+	f.rows(`^SELECT \* FROM people`, []string{"id", "name", "deleted_at"}, []driver.Value{int64(1), "Ada", nil})
+	captureOutput(t, DisplayRecords)
+	if got := f.matching(`^SELECT \* FROM people`); len(got) != 1 || got[0] != "SELECT * FROM people WHERE deleted_at IS NULL ORDER BY `id`" {
+		t.Fatalf("listed the rows with %q, want the marked ones left out", got)
+	}
+}
+
+func TestSoftDeleteNeedsADateMarkerColumn(t *testing.T) {
+	f := useFakeDB(t)
+	useTable(t, f, "people", []string{"id", "int", "NO", "PRI"}, []string{"deleted_at", "int", "YES", ""})
+	f.rows(`^SELECT DATA_TYPE FROM information_schema\.COLUMNS`, []string{"DATA_TYPE"}, []driver.Value{"int"})
+
+	out := captureOutput(t, ToggleSoftDelete)
+	if isSoftDelete("people") || !strings.Contains(out, "people.deleted_at is int, it must be DATETIME or TIMESTAMP") {
+		t.Fatalf("enabled on an INT marker, printed:\n%s", out)
+	}
+}
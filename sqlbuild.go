@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Builders for the statements the master replicates to slaves. Identifier
+// quoting and literal formatting depend on the target backend, so the same
+// logical statement can be rendered for MySQL or an ANSI-quoting backend
+// such as SQLite, chosen with -replica-dialect. Statements the master runs
+// itself are always rendered for MySQL.
+
+type sqlDialect int
+
+const (
+	dialectMySQL sqlDialect = iota // `backtick` identifiers, backslash escapes
+	dialectANSI                    // "double-quoted" identifiers, no backslash escapes
+)
+
+// Name of the dialect, as given to -replica-dialect
+func (d sqlDialect) String() string {
+	if d == dialectANSI {
+		return "ansi"
+	}
+	return "mysql"
+}
+
+// Set the dialect from its -replica-dialect name. sqlite is ANSI quoting
+// with INSERT OR IGNORE/REPLACE.
+func (d *sqlDialect) Set(name string) error {
+	switch strings.ToLower(name) {
+	case "mysql", "mariadb":
+		*d = dialectMySQL
+	case "ansi", "sqlite":
+		*d = dialectANSI
+	default:
+		return fmt.Errorf("unknown dialect %q, use mysql, ansi or sqlite", name)
+	}
+	return nil
+}
+
+// Quote a table or column name for the given backend
+func quoteIdent(d sqlDialect, name string) string {
+	switch d {
+	case dialectANSI:
+		return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+	default:
+		return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+	}
+}
+
+// Format a value as a literal for the given backend
+func sqlLiteralFor(d sqlDialect, val interface{}) string {
+	if d == dialectMySQL {
+		return sqlLiteral(val)
+	}
+	switch v := val.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		if !utf8.Valid(v) {
+			return "X'" + hex.EncodeToString(v) + "'"
+		}
+		return "'" + strings.ReplaceAll(string(v), "'", "''") + "'"
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	default:
+		return sqlLiteral(v)
+	}
+}
+
+func buildInsert(d sqlDialect, table string, columns []string, values []interface{}) string {
+	cols := make([]string, len(columns))
+	for i, c := range columns {
+		cols[i] = quoteIdent(d, c)
+	}
+	vals := make([]string, len(values))
+	for i, v := range values {
+		vals[i] = sqlLiteralFor(d, v)
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		quoteIdent(d, table), strings.Join(cols, ", "), strings.Join(vals, ", "))
+}
+
+// A row buildInsertBatches left out: its index and the size its single-row
+// statement would have had
+type skippedRow struct {
+	row, size int
+}
+
+// Pack rows into multi-row INSERTs of at most maxBytes each (no limit if
+// maxBytes is 0). A row that doesn't fit on its own is left out and
+// returned in tooLarge.
+func buildInsertBatches(d sqlDialect, table string, columns []string, rows [][]interface{}, maxBytes int) (stmts []string, tooLarge []skippedRow) {
+	cols := make([]string, len(columns))
+	for i, c := range columns {
+		cols[i] = quoteIdent(d, c)
+	}
+	prefix := fmt.Sprintf("INSERT INTO %s (%s) VALUES ", quoteIdent(d, table), strings.Join(cols, ", "))
+
+	var cur strings.Builder
+	for r, row := range rows {
+		vals := make([]string, len(row))
+		for i, v := range row {
+			vals[i] = sqlLiteralFor(d, v)
+		}
+		tuple := "(" + strings.Join(vals, ", ") + ")"
+
+		if maxBytes > 0 && len(prefix)+len(tuple) > maxBytes {
+			tooLarge = append(tooLarge, skippedRow{r, len(prefix) + len(tuple)})
+			continue
+		}
+		if cur.Len() > 0 && maxBytes > 0 && cur.Len()+len(", ")+len(tuple) > maxBytes {
+			stmts = append(stmts, cur.String())
+			cur.Reset()
+		}
+		if cur.Len() == 0 {
+			cur.WriteString(prefix)
+		} else {
+			cur.WriteString(", ")
+		}
+		cur.WriteString(tuple)
+	}
+	if cur.Len() > 0 {
+		stmts = append(stmts, cur.String())
+	}
+	return stmts, tooLarge
+}
+
+func buildUpdate(d sqlDialect, table string, columns []string, values []interface{}, keyCols []string, keys []interface{}) string {
+	sets := make([]string, len(columns))
+	for i, c := range columns {
+		sets[i] = fmt.Sprintf("%s = %s", quoteIdent(d, c), sqlLiteralFor(d, values[i]))
+	}
+	return fmt.Sprintf("UPDATE %s SET %s WHERE %s",
+		quoteIdent(d, table), strings.Join(sets, ", "), buildKeyMatch(d, keyCols, keys))
+}
+
+func buildDelete(d sqlDialect, table string, keyCols []string, keys []interface{}) string {
+	return fmt.Sprintf("DELETE FROM %s WHERE %s",
+		quoteIdent(d, table), buildKeyMatch(d, keyCols, keys))
+}
+
+// Condition matching the row with the given primary key values
+func buildKeyMatch(d sqlDialect, keyCols []string, keys []interface{}) string {
+	conds := make([]string, len(keyCols))
+	for i, c := range keyCols {
+		conds[i] = fmt.Sprintf("%s = %s", quoteIdent(d, c), sqlLiteralFor(d, keys[i]))
+	}
+	return strings.Join(conds, " AND ")
+}
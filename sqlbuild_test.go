@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestBuildStatementsPerDialect(t *testing.T) {
+	cols := []string{"id", "na`me", `no"te`}
+	vals := []interface{}{int64(7), `O'Brien \ co`, nil}
+	tests := []struct {
+		d                      sqlDialect
+		insert, update, delete string
+	}{
+		{
+			dialectMySQL,
+			"INSERT INTO `t` (`id`, `na``me`, `no\"te`) VALUES (7, 'O''Brien \\\\ co', NULL)",
+			"UPDATE `t` SET `na``me` = 'O''Brien \\\\ co' WHERE `id` = 7",
+			"DELETE FROM `t` WHERE `id` = 7",
+		},
+		{
+			dialectANSI,
+			`INSERT INTO "t" ("id", "na` + "`" + `me", "no""te") VALUES (7, 'O''Brien \ co', NULL)`,
+			`UPDATE "t" SET "na` + "`" + `me" = 'O''Brien \ co' WHERE "id" = 7`,
+			`DELETE FROM "t" WHERE "id" = 7`,
+		},
+	}
+	for _, tt := range tests {
+		if got := buildInsert(tt.d, "t", cols, vals); got != tt.insert {
+			t.Errorf("%v insert:\n got %s\nwant %s", tt.d, got, tt.insert)
+		}
+		if got := buildUpdate(tt.d, "t", cols[1:2], vals[1:2], cols[:1], vals[:1]); got != tt.update {
+			t.Errorf("%v update:\n got %s\nwant %s", tt.d, got, tt.update)
+		}
+		if got := buildDelete(tt.d, "t", cols[:1], vals[:1]); got != tt.delete {
+			t.Errorf("%v delete:\n got %s\nwant %s", tt.d, got, tt.delete)
+		}
+	}
+}
+
+func TestReplicaDialectFlag(t *testing.T) {
+	old := replicaDialect
+	defer func() { replicaDialect = old }()
+
+	fs := flag.NewFlagSet("master", flag.ContinueOnError)
+	fs.Var(&replicaDialect, "replica-dialect", "")
+	if err := fs.Parse([]string{"-replica-dialect", "sqlite"}); err != nil {
+		t.Fatal(err)
+	}
+	if replicaDialect != dialectANSI {
+		t.Fatalf("sqlite gave %v", replicaDialect)
+	}
+	if got := fs.Lookup("replica-dialect").Value.String(); got != "ansi" {
+		t.Fatalf("shown as %q", got)
+	}
+	if err := fs.Set("replica-dialect", "oracle"); err == nil {
+		t.Fatal("unknown dialect accepted")
+	}
+
+	// SQLite's spelling of the conflict policies
+	conflictMu.Lock()
+	conflictPolicies["t"] = conflictIgnore
+	conflictMu.Unlock()
+	defer setConflictPolicy("t", conflictError)
+	if got := conflictQuery("INSERT INTO t (a) VALUES (1)"); got != "INSERT OR IGNORE INTO t (a) VALUES (1)" {
+		t.Fatalf("got %s", got)
+	}
+
+	// Statements the master runs itself stay in MySQL's dialect
+	softDeleteMu.Lock()
+	softDeleteTables["t"] = true
+	softDeleteMu.Unlock()
+	defer func() {
+		softDeleteMu.Lock()
+		delete(softDeleteTables, "t")
+		softDeleteMu.Unlock()
+	}()
+	if got := softDeleteQuery("DELETE FROM t WHERE id = 1"); got[:12] != "UPDATE `t` S" {
+		t.Fatalf("got %s", got)
+	}
+}
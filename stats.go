@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Cluster statistics for slaves. A slave started with -stats-interval asks
+// for them in the handshake (slave_info:stats_interval:<seconds>) and the
+// master pushes a stats:<json clusterStats> frame at that interval once the
+// slave is synced. Slaves that don't ask get nothing.
+
+// Shortest push interval the master accepts
+const minStatsInterval = time.Second
+
+// Master side: current statistics
+func collectStats() clusterStats {
+	st := clusterStats{Time: clock(), Position: currentSeq()}
+	for _, s := range slaveTargets(nil) {
+		st.Slaves++
+		if s.syncState() == "synced" {
+			st.SyncedSlaves++
+		}
+	}
+	var rows sql.NullInt64
+	defer useDB()()
+	err := db.QueryRow(`SELECT COUNT(*), SUM(TABLE_ROWS) FROM information_schema.TABLES
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_TYPE = 'BASE TABLE'`).Scan(&st.Tables, &rows)
+	if err != nil {
+		fmt.Printf("Could not count tables for slave stats: %v\n", err)
+	}
+	st.Rows = rows.Int64
+	return st
+}
+
+// Master side: push stats to a slave until it disconnects
+func pushStats(s *slaveConn, interval time.Duration) {
+	if interval < minStatsInterval {
+		interval = minStatsInterval
+	}
+	fmt.Printf("Sending cluster stats to slave %s every %v\n", s.addr, interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.sendLive("stats:%s\n", encodeStats(collectStats()))
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Parse the slave_info:stats_interval value, 0 if it isn't valid
+func parseStatsInterval(value string) time.Duration {
+	secs, err := strconv.Atoi(value)
+	if err != nil || secs <= 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// Slave side. How often to ask for stats (0 = never) and the last ones
+// received.
+var (
+	statsInterval time.Duration
+	statsMu       sync.Mutex
+	lastStats     *clusterStats
+)
+
+// Slave side of stats
+func recordStats(content string) {
+	st, err := decodeStats(content)
+	if err != nil {
+		fmt.Printf("Invalid stats from master: %v\n", err)
+		return
+	}
+	statsMu.Lock()
+	lastStats = &st
+	statsMu.Unlock()
+}
+
+// Print the last cluster stats, if any
+func showClusterStats() {
+	statsMu.Lock()
+	st := lastStats
+	statsMu.Unlock()
+	if st == nil {
+		if statsInterval > 0 {
+			fmt.Println("No cluster stats received from the master yet")
+		}
+		return
+	}
+	// Stamped by the master's clock
+	at := fromMasterTime(st.Time)
+	fmt.Printf("Cluster as of %s (%v ago):\n", at.Format("15:04:05"), clock().Sub(at).Round(time.Second))
+	fmt.Printf("  Slaves: %d connected, %d synced\n", st.Slaves, st.SyncedSlaves)
+	fmt.Printf("  Master position: %d\n", st.Position)
+	fmt.Printf("  Tables: %d (about %d rows)\n", st.Tables, st.Rows)
+}
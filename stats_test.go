@@ -0,0 +1,67 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlaveReceivesAndShowsPushedStats(t *testing.T) {
+	f := useFakeDB(t)
+	f.rows(`FROM information_schema\.TABLES`, []string{"COUNT(*)", "SUM(TABLE_ROWS)"}, []driver.Value{int64(2), int64(30)})
+	statsMu.Lock()
+	lastStats = nil
+	statsMu.Unlock()
+	t.Cleanup(func() {
+		statsMu.Lock()
+		lastStats = nil
+		statsMu.Unlock()
+	})
+
+	s, _ := throughSlave(t, f, func(s *slaveConn) {
+		s.recordInfo("stats_interval:60")
+	})
+	s.recordSyncAck("0")
+	if s.statsInterval != time.Minute {
+		t.Fatalf("stats interval %v, want 1m from slave_info", s.statsInterval)
+	}
+	done := make(chan struct{})
+	go func() {
+		pushStats(s, s.statsInterval)
+		close(done)
+	}()
+
+	// The first push goes out right away, not after the interval
+	deadline := time.Now().Add(5 * time.Second)
+	var st *clusterStats
+	for st == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("slave never recorded the stats push")
+		}
+		time.Sleep(time.Millisecond)
+		statsMu.Lock()
+		st = lastStats
+		statsMu.Unlock()
+	}
+	s.close()
+	<-done
+
+	if st.Slaves != 1 || st.SyncedSlaves != 1 || st.Tables != 2 || st.Rows != 30 {
+		t.Fatalf("slave recorded %+v, want 1 synced slave and 2 tables of 30 rows", *st)
+	}
+	out := captureOutput(t, showClusterStats)
+	for _, want := range []string{"Slaves: 1 connected, 1 synced", "Tables: 2 (about 30 rows)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("status view %q doesn't show %q", out, want)
+		}
+	}
+}
+
+func TestStatsIntervalMustBeWholePositiveSeconds(t *testing.T) {
+	for value, want := range map[string]time.Duration{"5": 5 * time.Second, "0": 0, "-1": 0, "soon": 0} {
+		if got := parseStatsInterval(value); got != want {
+			t.Errorf("parseStatsInterval(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
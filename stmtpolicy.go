@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Policy for the SQL slaves forward to the master. Each operation may only
+// carry the statement types allowed for it (an insert must be an INSERT,
+// a select a SELECT), one statement at a time, and without the functions
+// that read or write files on the master's host. Anything else is rejected
+// before it reaches MySQL.
+//
+// The allowed statement types can be changed per operation with
+// -forward-allow, e.g. "insert=INSERT+REPLACE,select=SELECT+WITH".
+
+var forwardPolicy = map[string][]string{
+	"insert": {"INSERT"},
+	"update": {"UPDATE"},
+	"delete": {"DELETE"},
+	"select": {"SELECT"},
+}
+
+// Never allowed in a forwarded statement, whatever its type
+var forbiddenWords = map[string]bool{
+	"LOAD_FILE": true,
+	"OUTFILE":   true,
+	"DUMPFILE":  true,
+}
+
+// Apply a -forward-allow value on top of the defaults
+func parseForwardPolicy(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		op, kinds, ok := strings.Cut(item, "=")
+		op = strings.ToLower(strings.TrimSpace(op))
+		if _, known := forwardPolicy[op]; !ok || !known {
+			return fmt.Errorf("invalid -forward-allow entry %q, want <insert|update|delete|select>=<TYPE>[+<TYPE>...]", item)
+		}
+		var allowed []string
+		for _, k := range strings.Split(kinds, "+") {
+			if k = strings.ToUpper(strings.TrimSpace(k)); k != "" {
+				allowed = append(allowed, k)
+			}
+		}
+		if len(allowed) == 0 {
+			return fmt.Errorf("-forward-allow entry %q allows no statement types", item)
+		}
+		forwardPolicy[op] = allowed
+	}
+	return nil
+}
+
+// Why a slave's statement isn't allowed for the operation, nil if it is
+func checkForwarded(operation, query string) error {
+	if strings.HasPrefix(operation, "select") {
+		operation = "select"
+	}
+	allowed, ok := forwardPolicy[operation]
+	if !ok {
+		return fmt.Errorf("no statements are allowed for %s", operation)
+	}
+
+	words, err := statementWords(query)
+	if err != nil {
+		return err
+	}
+	if len(words) == 0 {
+		return fmt.Errorf("empty statement")
+	}
+	for _, w := range words {
+		if forbiddenWords[w] {
+			return fmt.Errorf("%s is not allowed in forwarded statements", w)
+		}
+	}
+	for _, kind := range allowed {
+		if words[0] == kind {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s statement not allowed for %s (allowed: %s)",
+		words[0], operation, strings.Join(allowed, ", "))
+}
+
+// The bare words of a statement, upper-cased, skipping comments, string
+// literals and quoted identifiers. Fails on more than one statement and on
+// MySQL's executable /*! ... */ comments, whose content would run.
+func statementWords(query string) ([]string, error) {
+	var words []string
+	ended := false
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case strings.HasPrefix(query[i:], "/*!") || strings.HasPrefix(query[i:], "/*+"):
+			return nil, fmt.Errorf("executable comments are not allowed in forwarded statements")
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated comment")
+			}
+			i += end + 4
+			continue
+		case c == '#' || strings.HasPrefix(query[i:], "-- "):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end + 1
+			}
+			continue
+		}
+
+		if ended {
+			return nil, fmt.Errorf("only one statement may be forwarded at a time")
+		}
+		switch {
+		case c == ';':
+			ended = true
+			i++
+		case c == '\'' || c == '"' || c == '`':
+			end := closingQuote(query, i)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			i = end + 1
+		case isWordByte(c):
+			start := i
+			for i < len(query) && isWordByte(query[i]) {
+				i++
+			}
+			words = append(words, strings.ToUpper(query[start:i]))
+		default:
+			i++
+		}
+	}
+	return words, nil
+}
+
+// Index of the quote closing the one at start, -1 if there is none.
+// Doubled quotes and, outside backticks, backslashes escape it.
+func closingQuote(query string, start int) int {
+	q := query[start]
+	for i := start + 1; i < len(query); i++ {
+		switch {
+		case query[i] == '\\' && q != '`':
+			i++
+		case query[i] == q && i+1 < len(query) && query[i+1] == q:
+			i++
+		case query[i] == q:
+			return i
+		}
+	}
+	return -1
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// Reject a forwarded statement the policy doesn't allow, telling the slave
+// why. Returns true if it was rejected.
+func rejectForwarded(s *slaveConn, operation, query string) bool {
+	err := checkForwarded(operation, query)
+	if err == nil {
+		return false
+	}
+	fmt.Printf("Rejected %s from slave %s: %v\n", operation, s.addr, err)
+	s.reply("error:statement rejected: %v\n", err)
+	return true
+}
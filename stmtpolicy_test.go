@@ -0,0 +1,21 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestForwardedDropUnderInsertIsRejected(t *testing.T) {
+	f := useFakeDB(t)
+	s, sc := pipeSlave(t)
+	s.syncFinished()
+
+	go handleSlaveMessage(s, "insert:DROP TABLE orders")
+
+	if got := nextFrame(t, sc); !strings.HasPrefix(got, "error:statement rejected: DROP statement not allowed for insert") {
+		t.Fatalf("got %q, want the rejection", got)
+	}
+	if ran := f.statements(); len(ran) != 0 {
+		t.Fatalf("ran %q, want nothing", ran)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Switching the master to another database at runtime. Replication always
+// covers the active database only, so every connected slave is re-synced
+// from scratch into a local database of the new name.
+
+// Connection settings from dbConn, without a database selected
+var masterConfig *mysql.Config
+
+var dbNameRe = regexp.MustCompile(`^\w+$`)
+
+// Guards db and dbName against the swap. Everything using them outside the
+// menu (slave requests, syncs, stats, the binlog tailer) holds it for
+// reading, so the swap waits for it and the old pool isn't closed under a
+// running query. Held at the outermost call only, a nested RLock can
+// deadlock behind a waiting swap.
+var dbMu sync.RWMutex
+
+// Hold the active database until the returned function is called
+func useDB() func() {
+	dbMu.RLock()
+	return dbMu.RUnlock
+}
+
+// Databases on the server, without the system schemas
+func listDatabases() ([]string, error) {
+	rows, err := db.Query("SHOW DATABASES")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		switch name {
+		case "information_schema", "mysql", "performance_schema", "sys":
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// Menu action: list databases and switch to another one
+func SwitchDatabase() {
+	if txOpen() {
+		return
+	}
+	names, err := listDatabases()
+	if err != nil {
+		fmt.Printf("Error listing databases: %v\n", err)
+		return
+	}
+
+	fmt.Println("\nDatabases:")
+	for i, name := range names {
+		marker := ""
+		if name == dbName {
+			marker = " (active)"
+		}
+		fmt.Printf("%d. %s%s\n", i+1, name, marker)
+	}
+	fmt.Print("Select database (number) or type a new name, blank to cancel: ")
+	input := strings.TrimSpace(readLine())
+	if input == "" {
+		return
+	}
+
+	name := input
+	if n, err := strconv.Atoi(input); err == nil {
+		if n < 1 || n > len(names) {
+			fmt.Println("Invalid database selection")
+			return
+		}
+		name = names[n-1]
+	}
+	if !dbNameRe.MatchString(name) {
+		fmt.Println("Database names may only contain letters, digits and underscores")
+		return
+	}
+	if name == dbName {
+		fmt.Printf("Already using '%s'\n", name)
+		return
+	}
+
+	exists := false
+	for _, n := range names {
+		if n == name {
+			exists = true
+		}
+	}
+	if !exists {
+		if !confirm(fmt.Sprintf("Database '%s' doesn't exist. Create it? (y/n): ", name)) {
+			return
+		}
+		_, err := db.Exec("CREATE DATABASE " + name + " CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci")
+		if err != nil {
+			fmt.Printf("Error creating database: %v\n", err)
+			return
+		}
+		fmt.Println("Database created successfully.")
+	}
+
+	cfg := masterConfig.Clone()
+	cfg.DBName = name
+	newDB, err := openMySQL(cfg)
+	if err == nil {
+		err = newDB.Ping()
+	}
+	if err != nil {
+		fmt.Printf("Failed to connect to database '%s': %v\n", name, err)
+		return
+	}
+
+	targets := swapDatabase(name, newDB)
+	auditLog("switch_database", name)
+	fmt.Printf("Switched to database '%s' (%d tables)\n", name, len(tables))
+
+	for _, s := range targets {
+		s.sendLive("notification:Master switched to database %s\n", name)
+		go syncSlave(s)
+	}
+	if len(targets) > 0 {
+		fmt.Printf("Re-syncing %d slave(s) to '%s'\n", len(targets), name)
+	}
+}
+
+// Make newDB the active database and close the old one. Returns the slaves,
+// marked for a re-sync.
+func swapDatabase(name string, newDB *sql.DB) []*slaveConn {
+	// No request, replicated write or sync snapshot can be in flight
+	// during the swap
+	dbMu.Lock()
+	snapshotMu.Lock()
+	oldDB := db
+	db = newDB
+	dbName = name
+	masterMaxPacket = queryMaxPacket(db)
+	tableAttributes = make(map[string][]column)
+	tableKeys = make(map[string][]column)
+	loadExistingTables()
+	currentTable = ""
+	softDeleteMu.Lock()
+	softDeleteTables = make(map[string]bool)
+	softDeleteMu.Unlock()
+	loadConflictPolicies()
+
+	targets := slaveTargets(nil)
+	for _, s := range targets {
+		s.startResync()
+	}
+	resetReplayLog()
+	snapshotMu.Unlock()
+	oldDB.Close()
+	dbMu.Unlock()
+	return targets
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSwapWaitsForRequestsInFlight(t *testing.T) {
+	old := useFakeDB(t)
+	withTables(t, "shop")
+	next, nextDB := openFakeDB(t)
+	for _, f := range []*fakeDB{old, next} {
+		f.rows(`^SHOW TABLES$`, []string{"Tables"})
+		f.rows(`^SELECT @@max_allowed_packet$`, []string{"@@max_allowed_packet"}, []driver.Value{int64(1 << 20)})
+	}
+
+	// A SELECT is running on the old database when the swap starts
+	started, finish := make(chan struct{}), make(chan struct{})
+	old.on(`^SELECT v FROM t`, func([]driver.Value) fakeResult {
+		close(started)
+		<-finish
+		return fakeResult{cols: []string{"v"}, rows: [][]driver.Value{{int64(1)}}}
+	})
+
+	s, sc := pipeSlave(t)
+	go handleSlaveMessage(s, "select:SELECT v FROM t")
+	<-started
+
+	swapped := make(chan struct{})
+	go func() {
+		defer close(swapped)
+		swapDatabase("other", nextDB)
+	}()
+	select {
+	case <-swapped:
+		t.Fatal("database swapped under a running SELECT")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(finish)
+	var reply []string
+	for {
+		line := nextFrame(t, sc)
+		if line == "END" {
+			break
+		}
+		reply = append(reply, line)
+		if strings.HasPrefix(line, "error:") {
+			break
+		}
+	}
+	<-swapped
+	if joined := strings.Join(reply, "\n"); strings.Contains(joined, "closed") || len(reply) < 3 {
+		t.Fatalf("SELECT cut short by the swap:\n%s", joined)
+	}
+	if db != nextDB || dbName != "other" {
+		t.Fatal("database not swapped")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import "fmt"
+
+// Limit on initial syncs running at once (-max-concurrent-sync, 0 for no
+// limit). Each full sync reads every table of the master, so after a
+// network blip reconnects all slaves they take turns instead of all
+// reading at once. A waiting slave is registered and its live changes are
+// held like during a sync; slaves already synced aren't affected.
+
+var maxConcurrentSync int
+
+// One token per running sync, nil when there's no limit
+var syncSlots chan struct{}
+
+func setupSyncSlots() {
+	if maxConcurrentSync > 0 {
+		syncSlots = make(chan struct{}, maxConcurrentSync)
+	}
+}
+
+// Run a full sync of the slave once a slot is free. False if the slave
+// went away while waiting.
+func syncSlave(s *slaveConn) bool {
+	release, ok := acquireSyncSlot(s)
+	if !ok {
+		return false
+	}
+	defer release()
+	sendSchemaToSlave(s)
+	return true
+}
+
+// Wait for a free sync slot. Returns the function that frees it, or false
+// if the slave went away while waiting.
+func acquireSyncSlot(s *slaveConn) (func(), bool) {
+	if syncSlots == nil {
+		return func() {}, true
+	}
+	select {
+	case syncSlots <- struct{}{}:
+	default:
+		fmt.Printf("Slave %s waits for a sync slot (%d syncs running)\n", s.addr, maxConcurrentSync)
+		s.reply("notification:the master is syncing %d other slave(s), yours starts when one finishes\n", maxConcurrentSync)
+		select {
+		case syncSlots <- struct{}{}:
+		case <-s.done:
+			return nil, false
+		}
+	}
+	return func() { <-syncSlots }, true
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlavesOverTheSyncLimitWaitForASlot(t *testing.T) {
+	oldMax, oldSlots := maxConcurrentSync, syncSlots
+	maxConcurrentSync = 1
+	setupSyncSlots()
+	t.Cleanup(func() { maxConcurrentSync, syncSlots = oldMax, oldSlots })
+
+	first, _ := pipeSlave(t)
+	release, ok := acquireSyncSlot(first)
+	if !ok {
+		t.Fatal("first slave got no slot")
+	}
+
+	// The second one is told to wait, and waits
+	second, sc := pipeSlave(t)
+	acquired := make(chan func())
+	go func() {
+		if r, ok := acquireSyncSlot(second); ok {
+			acquired <- r
+		}
+	}()
+	if got := nextFrame(t, sc); !strings.HasPrefix(got, "notification:the master is syncing 1 other slave(s)") {
+		t.Fatalf("waiting slave got %q", got)
+	}
+	select {
+	case <-acquired:
+		t.Fatal("second sync started while the first was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// A third that leaves while waiting gives up its turn
+	third, thirdSc := pipeSlave(t)
+	gaveUp := make(chan bool)
+	go func() {
+		_, ok := acquireSyncSlot(third)
+		gaveUp <- !ok
+	}()
+	nextFrame(t, thirdSc)
+	third.close()
+	if !<-gaveUp {
+		t.Fatal("slave that left still got a slot")
+	}
+
+	release()
+	select {
+	case next := <-acquired:
+		next()
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiting slave didn't get the freed slot")
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Coalesced on-demand table reads. When several slaves miss the same table
+// they tend to ask for it at the same moment (get_table_schema:<table>).
+// The first request reads the definition and rows; requests for the same
+// table arriving before that read takes its snapshot wait for it and get
+// the same result. Each requester then gets its own copy sent, adapted to
+// its server and packet limit. A request arriving after the snapshot reads
+// the table again, so nobody is sent stale data.
+//
+// The rows come from one consistent snapshot, and every requester's live
+// frames are held from that snapshot until its copy is out (see holdLive),
+// so writes made after the read reach it after the rows. The read sends
+// each batch to every requester as it goes, so only one batch is in memory
+// at a time; the slowest requester sets the pace for all of them.
+// Schema-only requesters get the definition but no rows.
+
+type tableRead struct {
+	done       chan struct{}
+	requesters []*slaveConn // guarded by tableReadsMu
+	started    bool         // the snapshot is taken and the requesters' live frames held
+	missing    bool         // the table doesn't exist
+	err        error
+	problems   map[*slaveConn][]string // what went wrong with each requester's rows
+}
+
+var (
+	tableReadsMu sync.Mutex
+	tableReads   = make(map[string]*tableRead)
+)
+
+// Read a table for slave s, or join a read of it that hasn't taken its
+// snapshot yet. If the returned read started, s must call releaseLive once
+// its copy is queued.
+func sharedTableRead(tableName string, s *slaveConn) (*tableRead, bool) {
+	tableReadsMu.Lock()
+	if r, ok := tableReads[tableName]; ok && !r.started {
+		r.requesters = append(r.requesters, s)
+		tableReadsMu.Unlock()
+		<-r.done
+		return r, true
+	}
+	r := &tableRead{done: make(chan struct{}), requesters: []*slaveConn{s}}
+	tableReads[tableName] = r
+	tableReadsMu.Unlock()
+
+	r.read(tableName)
+
+	tableReadsMu.Lock()
+	if tableReads[tableName] == r {
+		delete(tableReads, tableName)
+	}
+	tableReadsMu.Unlock()
+	close(r.done)
+	return r, false
+}
+
+func (r *tableRead) read(tableName string) {
+	var def string
+	if !TableExists(tableName) {
+		r.missing = true
+		return
+	}
+	if r.err = db.QueryRow("SHOW CREATE TABLE "+tableName).Scan(&tableName, &def); r.err != nil {
+		return
+	}
+
+	ctx := context.Background()
+	var q queryer = db
+	snap, err := consistentSnapshot(ctx, func() {
+		tableReadsMu.Lock()
+		defer tableReadsMu.Unlock()
+		r.started = true
+		for _, s := range r.requesters {
+			s.holdLive()
+		}
+	})
+	if err != nil {
+		fmt.Printf("Error starting snapshot of %s, falling back to plain reads: %v\n", tableName, err)
+	} else {
+		q = snap
+		defer closeSnapshot(ctx, snap)
+	}
+
+	// Nobody joins once the snapshot is taken
+	tableReadsMu.Lock()
+	targets := append([]*slaveConn(nil), r.requesters...)
+	tableReadsMu.Unlock()
+
+	// Log the full CREATE TABLE statement for debugging
+	fmt.Printf("Sending CREATE TABLE statement to slave: %s\n", def)
+	r.problems = make(map[*slaveConn][]string)
+	indexes := make([][]string, len(targets))
+	oversized := make([]int, len(targets))
+	for i, s := range targets {
+		indexes[i] = beginTableCopy(tableName, def, s)
+	}
+	defer func() {
+		for i, s := range targets {
+			if oversized[i] > 0 {
+				r.problems[s] = append(r.problems[s], fmt.Sprintf("%d row(s) over the packet limit", oversized[i]))
+			}
+			endTableCopy(tableName, s, indexes[i])
+		}
+	}()
+	problem := func(text string) {
+		for _, s := range targets {
+			if !s.schemaOnly {
+				r.problems[s] = append(r.problems[s], text)
+			}
+		}
+	}
+	wantRows := false
+	for _, s := range targets {
+		wantRows = wantRows || !s.schemaOnly
+	}
+	if !wantRows {
+		return
+	}
+
+	var rowCount int
+	if err := q.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+tableName).Scan(&rowCount); err != nil {
+		problem(fmt.Sprintf("counting rows: %v", err))
+		return
+	}
+
+	// Same batches as sendTableData
+	const batchSize = 100
+	var columns []string
+	scanErrors := 0
+	for offset := 0; offset < rowCount; offset += batchSize {
+		rows, err := q.QueryContext(ctx, fmt.Sprintf("SELECT * FROM %s LIMIT %d OFFSET %d", tableName, batchSize, offset))
+		if err != nil {
+			fmt.Printf("Error selecting data from %s: %v\n", tableName, err)
+			problem(fmt.Sprintf("rows %d-%d not sent: %v", offset+1, offset+batchSize, err))
+			continue
+		}
+		if columns == nil {
+			if columns, err = rows.Columns(); err != nil {
+				rows.Close()
+				problem(fmt.Sprintf("rows %d-%d not sent: %v", offset+1, offset+batchSize, err))
+				continue
+			}
+		}
+		var batch [][]interface{}
+		for rows.Next() {
+			values := make([]interface{}, len(columns))
+			scanArgs := make([]interface{}, len(columns))
+			for i := range values {
+				scanArgs[i] = &values[i]
+			}
+			if err := rows.Scan(scanArgs...); err != nil {
+				fmt.Printf("Error scanning row: %v\n", err)
+				scanErrors++
+				continue
+			}
+			batch = append(batch, values)
+		}
+		rows.Close()
+		for i, s := range targets {
+			if s.schemaOnly {
+				continue
+			}
+			oversized[i] += sendRowBatch(s, tableName, columns, batch)
+			s.yieldToLive()
+		}
+	}
+	if scanErrors > 0 {
+		problem(fmt.Sprintf("%d row(s) could not be read", scanErrors))
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Frames sent to a slave, collected in the background
+func collectFrames(sc *messageScanner) <-chan string {
+	frames := make(chan string, 100)
+	go func() {
+		for sc.Scan() {
+			frames <- sc.Text()
+		}
+	}()
+	return frames
+}
+
+// Frames collected until none has come for a while
+func framesSoFar(frames <-chan string) []string {
+	var out []string
+	for {
+		select {
+		case f := <-frames:
+			out = append(out, f)
+		case <-time.After(200 * time.Millisecond):
+			return out
+		}
+	}
+}
+
+func TestSharedTableReadStreamsToEveryRequester(t *testing.T) {
+	f := useFakeDB(t)
+	existingTables(f, "t")
+	joined := make(chan struct{})
+	f.on(`^SHOW CREATE TABLE t$`, func([]driver.Value) fakeResult {
+		<-joined
+		return fakeResult{cols: []string{"Table", "Create Table"},
+			rows: [][]driver.Value{{"t", "CREATE TABLE `t` (`id` int NOT NULL, PRIMARY KEY (`id`))"}}}
+	})
+	f.rows(`^SELECT COUNT\(\*\) FROM t$`, []string{"COUNT(*)"}, []driver.Value{int64(150)})
+	f.on(`^SELECT \* FROM t LIMIT`, func([]driver.Value) fakeResult {
+		return fakeResult{cols: []string{"id"}, rows: [][]driver.Value{{int64(1)}, {int64(2)}}}
+	})
+
+	var slaves []*slaveConn
+	var frames []<-chan string
+	for i := 0; i < 3; i++ {
+		s, sc := pipeSlave(t)
+		s.syncFinished()
+		slaves = append(slaves, s)
+		frames = append(frames, collectFrames(sc))
+	}
+	slaves[2].schemaOnly = true
+
+	var wg sync.WaitGroup
+	request := func(s *slaveConn) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sendTableSchema("t", s)
+		}()
+	}
+	waitForRequesters := func(n int) {
+		for {
+			tableReadsMu.Lock()
+			r := tableReads["t"]
+			got := r != nil && len(r.requesters) == n
+			tableReadsMu.Unlock()
+			if got {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for i, s := range slaves {
+		request(s)
+		waitForRequesters(i + 1)
+	}
+	close(joined)
+	wg.Wait()
+
+	if n := len(f.matching(`^SELECT \* FROM t LIMIT`)); n != 2 {
+		t.Fatalf("%d batch reads, want 2 shared by every requester", n)
+	}
+	for i, want := range []int{2, 2, 0} {
+		got := framesSoFar(frames[i])
+		if len(got) == 0 || !strings.HasPrefix(got[0], "create_table:") {
+			t.Fatalf("slave %d got %q, want the create_table first", i, got)
+		}
+		rows := 0
+		for _, frame := range got[1:] {
+			if strings.HasPrefix(frame, "sync_data:") {
+				rows++
+			}
+		}
+		if rows != want {
+			t.Fatalf("slave %d got %d batch(es) of rows, want %d: %q", i, rows, want, got)
+		}
+	}
+}
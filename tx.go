@@ -0,0 +1,373 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Operator transactions. Between Begin and Commit in the main menu the
+// record operations run in one sql.Tx on the master and their replica
+// statements are held back. On commit they are numbered and sent to every
+// slave as one group:
+//
+//	begin_tx:<id>
+//	replicate_query:<seq>:<query>   (one per statement)
+//	commit_tx:<id>
+//
+// and the slave applies the group in its own transaction. A failed
+// statement rolls the master's transaction back and nothing is sent, so
+// the slaves never see a partial group. The slave acks the group's
+// statements and counts them as applied at commit_tx, once its own
+// transaction has committed or been rolled back; if a statement failed,
+// every statement of the group is acked with that failure.
+//
+// Schema changes can't be rolled back by MySQL, so they are refused while
+// a transaction is open. So are writes forwarded by slaves: they would
+// wait for the transaction's row locks holding the write lock, stalling
+// every other write and the menu until MySQL's lock wait timeout gave up
+// on them. The slave gets an error it can retry once the transaction ends.
+//
+// Only the menu goroutine touches masterTx and txStatements; whether a
+// transaction is open is also kept in txActive for the request handlers.
+
+var (
+	masterTx     *sql.Tx
+	txStatements []string
+	txID         uint64 // guarded by seqMu
+	txActive     bool   // guarded by mu
+)
+
+// Set the open transaction, nil once it has ended
+func setMasterTx(tx *sql.Tx) {
+	masterTx, txStatements = tx, nil
+	mu.Lock()
+	txActive = tx != nil
+	mu.Unlock()
+}
+
+// True, after telling the slave, if a write it forwarded has to wait for
+// the operator's transaction to end
+func rejectInTx(s *slaveConn) bool {
+	mu.Lock()
+	open := txActive
+	mu.Unlock()
+	if open {
+		s.reply("error:master has an open transaction, try again once it ends\n")
+	}
+	return open
+}
+
+// Exec a master write, inside the open transaction if there is one. A
+// failure inside it rolls the whole transaction back.
+func execWrite(query string, args ...interface{}) (sql.Result, error) {
+	if masterTx == nil {
+		return db.Exec(query, args...)
+	}
+	result, err := masterTx.Exec(query, args...)
+	if err != nil {
+		masterTx.Rollback()
+		setMasterTx(nil)
+		fmt.Println("Transaction rolled back, none of its changes were replicated")
+	}
+	return result, err
+}
+
+// Replicate a master write, or hold it for the commit if a transaction is open
+func replicateWrite(query string) {
+	if binlogEngine() {
+		// The tailer replicates it once MySQL has logged it
+		return
+	}
+	if masterTx == nil {
+		replicate(nil, query)
+		return
+	}
+	txStatements = append(txStatements, query)
+}
+
+// True, after telling the operator, if an operation that can't be part
+// of a transaction has to wait for the open one to end
+func txOpen() bool {
+	if masterTx == nil {
+		return false
+	}
+	fmt.Println("Commit or roll back the open transaction first")
+	return true
+}
+
+func beginTx() {
+	tx, err := db.Begin()
+	if err != nil {
+		fmt.Printf("Error starting transaction: %v\n", err)
+		return
+	}
+	setMasterTx(tx)
+	fmt.Println("Transaction started. Record changes are replicated when it is committed.")
+}
+
+func commitTx() {
+	defer beginWrite()()
+
+	stmts := txStatements
+	err := masterTx.Commit()
+	setMasterTx(nil)
+	if err != nil {
+		fmt.Printf("Error committing transaction: %v\n", err)
+		return
+	}
+	if binlogEngine() {
+		fmt.Println("Transaction committed, its changes are replicated from the binary log")
+		return
+	}
+	if len(stmts) == 0 {
+		fmt.Println("Transaction committed, it made no changes")
+		return
+	}
+	id := replicateTx(nil, stmts)
+	fmt.Printf("Transaction %d committed, %d statement(s) replicated\n", id, len(stmts))
+}
+
+func rollbackTx() {
+	err := masterTx.Rollback()
+	n := len(txStatements)
+	setMasterTx(nil)
+	if err != nil {
+		fmt.Printf("Error rolling back transaction: %v\n", err)
+		return
+	}
+	fmt.Printf("Transaction rolled back, %d change(s) discarded\n", n)
+}
+
+// Number a committed transaction's statements and send them to every slave
+// but skip as one group. Returns the transaction's id.
+func replicateTx(skip *slaveConn, stmts []string) uint64 {
+	return replicateTxTo(skip, nil, stmts)
+}
+
+// replicateTx leaving out the slaves keep (if not nil) rejects
+func replicateTxTo(skip *slaveConn, keep func(*slaveConn) bool, stmts []string) uint64 {
+	seqMu.Lock()
+	defer seqMu.Unlock()
+	txID++
+
+	first := replicationSeq + 1
+	for _, query := range stmts {
+		replicationSeq++
+		if !dryRun {
+			logReplay(replicationSeq, query)
+			recordChange(replicationSeq, query, "master")
+		}
+	}
+
+	if dryRun {
+		logDryRun("all slaves", fmt.Sprintf("begin_tx:%d\n", txID))
+		for i, query := range stmts {
+			logDryRun("all slaves", fmt.Sprintf("replicate_query:%d:%s\n", first+uint64(i), query))
+		}
+		logDryRun("all slaves", fmt.Sprintf("commit_tx:%d\n", txID))
+		return txID
+	}
+	origin := clock().UnixNano()
+	for _, s := range keptTargets(skip, keep) {
+		if s.schemaOnly {
+			// Record changes only, so all it needs is the position
+			s.sendLive("applied_position:%d\n", replicationSeq)
+			continue
+		}
+		s.sendLive("begin_tx:%d\n", txID)
+		for i, query := range stmts {
+			s.sendLive("%s", s.replicateFrame(first+uint64(i), query, origin))
+		}
+		s.sendLive("commit_tx:%d\n", txID)
+	}
+	return txID
+}
+
+// Menu action: begin a transaction, or end the open one
+func TransactionMenu() {
+	if masterTx == nil {
+		beginTx()
+		return
+	}
+	fmt.Printf("A transaction with %d pending change(s) is open.\n", len(txStatements))
+	fmt.Println("1. Commit")
+	fmt.Println("2. Roll Back")
+	fmt.Println("3. Keep It Open")
+	fmt.Print("Enter choice: ")
+	switch readChoice() {
+	case 1:
+		commitTx()
+	case 2:
+		rollbackTx()
+	case 3:
+	default:
+		fmt.Println("Invalid choice")
+	}
+}
+
+// Slave side. The transaction the master's current group is applied in,
+// its statements so far with the rows each changed, and the failure that
+// rolled it back if one did. Only the listener touches them. If the local
+// database goes away during the group, or apply is paused when it starts
+// (slaveTxLost), its statements so far and the rest of it are buffered
+// whole at commit_tx and replayed once they can be applied (see localdb.go
+// and pause.go). If it goes away during the commit, the transaction is
+// acked as failed and its tables are compared with the master instead.
+var (
+	slaveTx         *sql.Tx
+	slaveTxErr      error
+	slaveTxLost     bool
+	slaveTxStmts    []pendingOp
+	slaveTxAffected []int64
+)
+
+// Slave side of begin_tx
+func beginSlaveTx(id string) {
+	if slaveTx != nil {
+		fmt.Println("Master started a transaction while one was open, rolling the old one back")
+		slaveTx.Rollback()
+	}
+	slaveTx, slaveTxErr, slaveTxLost, slaveTxStmts, slaveTxAffected = nil, nil, false, nil, nil
+	if isLocalDBDown() || isApplyPaused() {
+		slaveTxLost = true
+		fmt.Printf("Buffering transaction %s from master until it can be applied\n", id)
+		return
+	}
+	if db == nil {
+		slaveTxErr = fmt.Errorf("local database connection not established")
+		fmt.Printf("Can't apply transaction %s: %v\n", id, slaveTxErr)
+		return
+	}
+	tx, err := db.Begin()
+	if noteLocalDBLost(err) {
+		slaveTxLost = true
+		return
+	}
+	if err != nil {
+		slaveTxErr = err
+		fmt.Printf("Can't apply transaction %s: %v\n", id, err)
+		return
+	}
+	slaveTx = tx
+	fmt.Printf("Applying transaction %s from master\n", id)
+}
+
+// True if replicated statements are currently part of a master transaction
+func inSlaveTx() bool {
+	return slaveTx != nil || slaveTxErr != nil || slaveTxLost
+}
+
+// Apply one statement of the current transaction. Nothing is acked until
+// commit_tx. Once one fails the transaction is rolled back and the rest of
+// it is only kept to be acked.
+func execInSlaveTx(seq uint64, query string) {
+	_, table := statementInfo(query)
+	slaveTxStmts = append(slaveTxStmts, pendingOp{table: table, query: query, seq: seq})
+	slaveTxAffected = append(slaveTxAffected, 0)
+	if slaveTxLost || slaveTxErr != nil {
+		return
+	}
+	fail := func(err error) {
+		slaveTx.Rollback()
+		slaveTx, slaveTxErr = nil, err
+		fmt.Printf("Failed to apply statement of transaction: %v\n", err)
+	}
+	if localMaxPacket > 0 && len(query) > localMaxPacket {
+		fail(fmt.Errorf("statement is %d bytes, over the local max_allowed_packet of %d; raise max_allowed_packet on this server",
+			len(query), localMaxPacket))
+		return
+	}
+	if err := checkReplicatedText(query); err != nil {
+		fail(err)
+		return
+	}
+	result, err := slaveTx.Exec(query)
+	if noteLocalDBLost(err) {
+		slaveTx.Rollback()
+		slaveTx, slaveTxLost = nil, true
+		return
+	}
+	if err != nil {
+		fail(fmt.Errorf("local query execution error: %w", err))
+		return
+	}
+	slaveTxAffected[len(slaveTxAffected)-1], _ = result.RowsAffected()
+}
+
+// Slave side of commit_tx
+func commitSlaveTx(id string) {
+	tx, txErr, lost, stmts, affected := slaveTx, slaveTxErr, slaveTxLost, slaveTxStmts, slaveTxAffected
+	slaveTx, slaveTxErr, slaveTxLost, slaveTxStmts, slaveTxAffected = nil, nil, false, nil, nil
+	// Counted as applied even if it fails, the failure is acked
+	for _, stmt := range stmts {
+		if stmt.seq > appliedSeq {
+			appliedSeq = stmt.seq
+		}
+	}
+	switch {
+	case lost:
+		bufferSlaveTx(id, stmts)
+		return
+	case txErr != nil:
+		fmt.Printf("Transaction %s was rolled back, none of it was applied\n", id)
+	case tx == nil:
+		fmt.Printf("Got commit for transaction %s, which was never started\n", id)
+		return
+	default:
+		txErr = tx.Commit()
+		if noteLocalDBLost(txErr) {
+			// Whether the commit made it is unknown and replaying it could
+			// apply it twice, so its tables are compared with the master
+			// once the database is back
+			flagCutOffCommit(id, stmts)
+			fmt.Printf("Lost the local database while committing transaction %s, its tables are compared with the master once it is back\n", id)
+			txErr = fmt.Errorf("transaction %s: the local database went away during the commit, its tables are compared with the master", id)
+			for _, stmt := range stmts {
+				recordReplicated(stmt.seq, 0, txErr)
+				sendAck(stmt.seq, txErr)
+			}
+			return
+		}
+		if txErr != nil {
+			fmt.Printf("Error committing transaction %s: %v\n", id, txErr)
+		} else {
+			fmt.Printf("Transaction %s applied\n", id)
+		}
+	}
+	if txErr != nil {
+		txErr = fmt.Errorf("transaction %s was rolled back: %w", id, txErr)
+	}
+	for i, stmt := range stmts {
+		recordReplicated(stmt.seq, affected[i], txErr)
+		if txErr == nil {
+			noteApplied()
+			recordChange(stmt.seq, stmt.query, "master")
+		}
+		sendAck(stmt.seq, txErr)
+	}
+}
+
+// Buffer a transaction cut off by the loss of the local database. If it is
+// back already, with nothing left buffered, the transaction is applied now.
+func bufferSlaveTx(id string, stmts []pendingOp) {
+	op := pendingOp{query: fmt.Sprintf("transaction %s (%d statements)", id, len(stmts)), tx: stmts}
+	if len(stmts) > 0 {
+		op.table = stmts[0].table
+	}
+	if deferApply(op) {
+		fmt.Printf("Transaction %s buffered until it can be applied\n", id)
+		return
+	}
+	if err := replayOp(op); err != nil {
+		bufferForLocalDB(op, err)
+	}
+}
+
+// Throw away a transaction the master never finished sending
+func abandonSlaveTx() {
+	if slaveTx != nil {
+		slaveTx.Rollback()
+		fmt.Println("Connection lost in the middle of a transaction, rolled it back")
+	}
+	slaveTx, slaveTxErr, slaveTxLost, slaveTxStmts, slaveTxAffected = nil, nil, false, nil, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// Replicated statements (sync_data and replicate_query) are text. The
+// master writes binary values that aren't valid UTF-8 as hex literals (see
+// sqlLiteral), so invalid UTF-8 in a statement means its text was mangled
+// on the way, and applying it would store garbage in a text column. Such
+// statements are rejected. Statements for tables with binary columns are
+// still applied: masters from before the hex literals sent those values
+// raw, and they can't be told apart from the text.
+
+// Check a replicated statement before applying it
+func checkReplicatedText(query string) error {
+	if utf8.ValidString(query) {
+		return nil
+	}
+	_, table := statementInfo(query)
+	if table != "" && tableHasBinaryColumns(table) {
+		return nil
+	}
+	return fmt.Errorf("statement holds invalid UTF-8 at byte %d, not applied", invalidUTF8At(query))
+}
+
+// Offset of the first byte that isn't valid UTF-8
+func invalidUTF8At(s string) int {
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			return i
+		}
+		i += size
+	}
+	return -1
+}
+
+// Whether a local table has a column that holds bytes rather than text.
+// True when it can't be told, so the statement isn't rejected for nothing.
+func tableHasBinaryColumns(table string) bool {
+	if db == nil {
+		return true
+	}
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
+		AND DATA_TYPE IN ('binary', 'varbinary', 'tinyblob', 'blob', 'mediumblob', 'longblob', 'bit', 'geometry')`,
+		table).Scan(&n)
+	return err != nil || n > 0
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+func TestReplicatedTextMustBeValidUTF8(t *testing.T) {
+	f := useFakeDB(t)
+	f.on(`DATA_TYPE IN \('binary'`, func(args []driver.Value) fakeResult {
+		n := int64(0)
+		if args[0] == "files" {
+			n = 1
+		}
+		return fakeResult{cols: []string{"COUNT(*)"}, rows: [][]driver.Value{{n}}}
+	})
+	for _, tc := range []struct {
+		name, query string
+		rejectedAt  string // "" if applied
+	}{
+		{"ascii", "INSERT INTO notes (body) VALUES ('plain')", ""},
+		{"accents", "INSERT INTO notes (body) VALUES ('café crème')", ""},
+		{"cjk", "INSERT INTO notes (body) VALUES ('東京の天気')", ""},
+		{"emoji", "INSERT INTO notes (body) VALUES ('ok 😀👍')", ""},
+		{"stray byte", "INSERT INTO notes (body) VALUES ('caf\xe9')", "byte 37"},
+		{"truncated emoji", "INSERT INTO notes (body) VALUES ('\xf0\x9f\x98')", "byte 34"},
+		{"overlong encoding", "UPDATE notes SET body = '\xc0\xaf'", "byte 25"},
+		// Raw bytes from an older master for a binary column
+		{"binary table", "INSERT INTO files (data) VALUES ('\xff\xfe')", ""},
+	} {
+		err := checkReplicatedText(tc.query)
+		switch {
+		case tc.rejectedAt == "" && err != nil:
+			t.Errorf("%s: rejected: %v", tc.name, err)
+		case tc.rejectedAt != "" && (err == nil || !strings.Contains(err.Error(), tc.rejectedAt)):
+			t.Errorf("%s: got %v, want it rejected at %s", tc.name, err, tc.rejectedAt)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"testing"
+)
+
+// Columns of orders, for rowChecksumExpr
+func checksumColumns(f *fakeDB) {
+	f.rows(`information_schema\.COLUMNS`, []string{"COLUMN_NAME"}, []driver.Value{"id"}, []driver.Value{"name"})
+}
+
+func TestVerifyReportCountsAndChecksumsInOneQueryOutsideTheWriteLock(t *testing.T) {
+	f := useFakeDB(t)
+	f.rows(`^SHOW TABLES$`, []string{"Tables"}, []driver.Value{"orders"})
+	checksumColumns(f)
+	// A separate count would see the row written since
+	f.rows(`^SELECT COUNT\(\*\) FROM orders$`, []string{"COUNT(*)"}, []driver.Value{int64(4)})
+	release := make(chan struct{})
+	f.on(`^SELECT COUNT\(\*\), COALESCE\(SUM\(`, func([]driver.Value) fakeResult {
+		<-release
+		return fakeResult{cols: []string{"COUNT(*)", "SUM"}, rows: [][]driver.Value{{int64(3), int64(999)}}}
+	})
+
+	s, sc := pipeSlave(t)
+	s.syncFinished()
+	s.tableChecksums = true
+	done := make(chan struct{})
+	go func() {
+		handleVerifyReplication(s, false)
+		close(done)
+	}()
+
+	// Live replication goes on while the table is checksummed
+	s.sendLive("replicate_query:1:UPDATE orders SET name = 'b'\n")
+	if got := nextFrame(t, sc); got != "replicate_query:1:UPDATE orders SET name = 'b'" {
+		t.Fatalf("got %q while checksumming, want the live frame", got)
+	}
+	close(release)
+
+	for _, w := range []string{"verification_data:begin", "table:orders:3:999", "verification_data:end"} {
+		if got := nextFrame(t, sc); got != w {
+			t.Fatalf("got %q, want %q", got, w)
+		}
+	}
+	<-done
+}
+
+func TestSilentlyEditedRowIsAMismatch(t *testing.T) {
+	f := useVerifyDB(t)
+	f.rows(`^SHOW TABLES$`, []string{"Tables"}, []driver.Value{"orders"})
+	f.rows(`^SELECT COUNT\(\*\) FROM orders$`, []string{"COUNT(*)"}, []driver.Value{int64(3)})
+	checksumColumns(f)
+	// Same three rows, one of them changed behind replication's back
+	f.rows(`^SELECT COUNT\(\*\), COALESCE\(SUM\(`, []string{"COUNT(*)", "SUM"}, []driver.Value{int64(3), int64(1000)})
+
+	var out bytes.Buffer
+	if code := verifyOnce(verifyMaster(t, "orders:3:999"), &out); code != 1 {
+		t.Fatalf("exit status %d, want 1; printed %s", code, out.String())
+	}
+	var report verifyOnceReport
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatalf("output isn't one JSON object: %v\n%s", err, out.String())
+	}
+	if report.Synchronized || len(report.Tables) != 1 {
+		t.Fatalf("report %+v", report)
+	}
+	got := report.Tables[0]
+	if got.Status != "MISMATCH" || !got.ContentDiffers || got.LocalRows != 3 || got.MasterRows != 3 {
+		t.Fatalf("table report %+v, want a content MISMATCH", got)
+	}
+}
+
+func TestTableWrittenDuringTheCheckIsNotAContentMismatch(t *testing.T) {
+	f := useVerifyDB(t)
+	f.rows(`^SHOW TABLES$`, []string{"Tables"}, []driver.Value{"orders"})
+	f.rows(`^SELECT COUNT\(\*\) FROM orders$`, []string{"COUNT(*)"}, []driver.Value{int64(3)})
+	checksumColumns(f)
+	f.rows(`^SELECT COUNT\(\*\), COALESCE\(SUM\(`, []string{"COUNT(*)", "SUM"}, []driver.Value{int64(4), int64(1000)})
+
+	var out bytes.Buffer
+	verifyOnce(verifyMaster(t, "orders:3:999"), &out)
+	var report verifyOnceReport
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatalf("output isn't one JSON object: %v\n%s", err, out.String())
+	}
+	if len(report.Tables) != 1 || report.Tables[0].ContentDiffers || len(report.Tables[0].Notes) != 1 {
+		t.Fatalf("report %+v, want a note that the content wasn't compared", report)
+	}
+}
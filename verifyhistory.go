@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Slave-side history of verification runs, so a past divergence can be
+// traced back to when it started. Only the last verifyHistorySize runs are
+// kept.
+
+const verifyHistorySize = 20
+
+type tableVerification struct {
+	table  string
+	status string // MATCH, MISMATCH, SCHEMA (same rows, other structure), MISSING or EXTRA
+	local  int
+	master int
+	schema []string // structural differences, see verifyschema.go
+	notes  []string // accepted differences
+
+	// A MISMATCH with equal row counts, found by the checksums (see
+	// verifychecksum.go)
+	contentDiffers bool
+}
+
+type verificationRun struct {
+	at     time.Time
+	inSync bool
+	tables []tableVerification
+}
+
+var (
+	verifyHistoryMu sync.Mutex
+	verifyHistory   []verificationRun
+)
+
+func recordVerification(run verificationRun) {
+	verifyHistoryMu.Lock()
+	defer verifyHistoryMu.Unlock()
+	if len(verifyHistory) == verifyHistorySize {
+		verifyHistory = append(verifyHistory[:0], verifyHistory[1:]...)
+	}
+	verifyHistory = append(verifyHistory, run)
+}
+
+// Runs oldest first
+func verificationRuns() []verificationRun {
+	verifyHistoryMu.Lock()
+	defer verifyHistoryMu.Unlock()
+	return append([]verificationRun(nil), verifyHistory...)
+}
+
+// Menu action: list past runs and show the tables of a chosen one
+func showVerificationHistory() {
+	runs := verificationRuns()
+	if len(runs) == 0 {
+		fmt.Println("No verification has been run yet")
+		return
+	}
+
+	fmt.Println("\nPast verification runs (oldest first):")
+	for i, run := range runs {
+		status := "SYNCHRONIZED"
+		var bad []string
+		for _, t := range run.tables {
+			if t.status != "MATCH" {
+				bad = append(bad, t.table)
+			}
+		}
+		if !run.inSync {
+			status = "OUT OF SYNC: " + strings.Join(bad, ", ")
+		}
+		fmt.Printf("%d. %s  %d table(s)  %s\n", i+1, run.at.Format("2006-01-02 15:04:05"), len(run.tables), status)
+	}
+
+	fmt.Print("Enter run number for details (0 to go back): ")
+	choice := readChoice()
+	if choice < 1 || choice > len(runs) {
+		return
+	}
+	run := runs[choice-1]
+	fmt.Printf("\n%-20s %-9s %10s %10s\n", "TABLE", "STATUS", "LOCAL", "MASTER")
+	for _, t := range run.tables {
+		fmt.Printf("%-20s %-9s %10d %10d\n", t.table, t.status, t.local, t.master)
+	}
+}
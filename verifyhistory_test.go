@@ -0,0 +1,65 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerificationRunsAccumulateInOrder(t *testing.T) {
+	reset := func() {
+		verifyHistoryMu.Lock()
+		verifyHistory = nil
+		verifyHistoryMu.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+
+	f := useFakeDB(t)
+	f.rows(`^SHOW TABLES$`, []string{"Tables"}, []driver.Value{"orders"})
+	// A row goes missing before the third run
+	local := []int64{3, 3, 2}
+	f.on(`^SELECT COUNT\(\*\) FROM orders$`, func([]driver.Value) fakeResult {
+		n := local[0]
+		local = local[1:]
+		return fakeResult{cols: []string{"COUNT(*)"}, rows: [][]driver.Value{{n}}}
+	})
+	data := newVerificationData()
+	data.tables["orders"] = 3
+	captureOutput(t, func() {
+		for range 3 {
+			compareReplication(data)
+		}
+	})
+
+	runs := verificationRuns()
+	if len(runs) != 3 {
+		t.Fatalf("%d run(s) kept, want 3", len(runs))
+	}
+	for i, want := range []string{"MATCH", "MATCH", "MISMATCH"} {
+		if got := runs[i].tables[0].status; got != want || runs[i].inSync != (want == "MATCH") {
+			t.Fatalf("run %d: %s, in sync %v, want %s", i+1, got, runs[i].inSync, want)
+		}
+		if i > 0 && runs[i].at.Before(runs[i-1].at) {
+			t.Fatalf("run %d is older than the one before", i+1)
+		}
+	}
+
+	feedInput(t, "3")
+	out := captureOutput(t, showVerificationHistory)
+	for _, want := range []string{"1. ", "SYNCHRONIZED", "3. ", "OUT OF SYNC: orders", "orders               MISMATCH           2          3"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("history lacks %q:\n%s", want, out)
+		}
+	}
+
+	// Only the latest are kept
+	for i := range verifyHistorySize {
+		recordVerification(verificationRun{at: time.Now(), inSync: true, tables: []tableVerification{{table: "t" + string(rune('a'+i))}}})
+	}
+	runs = verificationRuns()
+	if len(runs) != verifyHistorySize || runs[0].tables[0].table != "ta" {
+		t.Fatalf("%d run(s) kept, oldest %q", len(runs), runs[0].tables[0].table)
+	}
+}
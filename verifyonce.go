@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// One-shot verification for monitoring scripts ("ddb slave -verify-once").
+// The slave connects with subscribe:verify, which the master answers like a
+// health check, without registering a slave or starting a sync:
+//
+//	verify:ok:<database>
+//	verification_data:begin
+//	table:<name>:<rows>   (one per table)
+//	verification_data:end
+//
+// The slave compares that with its local copy of the database, prints the
+// result as one JSON object and exits 0 if it is synchronized, 1 if not and
+// 2 if the check couldn't be made.
+
+const verifyOnceTimeout = time.Minute
+
+// Opens the local database the check compares, replaced in tests
+var openVerifyDB = openMySQL
+
+// Master side of a verify-once connection
+func serveVerifyOnce(s *slaveConn) {
+	defer useDB()()
+	s.reply("verify:ok:%s\n", dbName)
+	handleVerifyReplication(s, true)
+}
+
+type verifyOnceTable struct {
+	Table       string   `json:"table"`
+	Status      string   `json:"status"`
+	LocalRows   int      `json:"local_rows"`
+	MasterRows  int      `json:"master_rows"`
+	SchemaDiffs []string `json:"schema_differences,omitempty"`
+	Notes       []string `json:"notes,omitempty"`
+	// Same row count, different rows
+	ContentDiffers bool `json:"content_differs,omitempty"`
+}
+
+type verifyOnceReport struct {
+	Synchronized bool              `json:"synchronized"`
+	Database     string            `json:"database,omitempty"`
+	Tables       []verifyOnceTable `json:"tables"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// Run the check and write its report to out, returns the exit status
+func verifyOnce(masterAddr string, out io.Writer) int {
+	report, err := runVerifyOnce(masterAddr, verifyOnceTimeout)
+	if err != nil {
+		report = &verifyOnceReport{Tables: []verifyOnceTable{}, Error: err.Error()}
+	}
+	text, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Fprintln(out, string(text))
+	switch {
+	case err != nil:
+		return 2
+	case !report.Synchronized:
+		return 1
+	}
+	return 0
+}
+
+func runVerifyOnce(masterAddr string, timeout time.Duration) (*verifyOnceReport, error) {
+	conn, err := dialMasterTimeout(masterAddr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	fmt.Fprintf(conn, "slave_info:table_checksums:1\n")
+	fmt.Fprintf(conn, "subscribe:verify\n")
+	r := bufio.NewReader(conn)
+	reply, err := readReply(r)
+	if err != nil {
+		return nil, err
+	}
+	name, ok := strings.CutPrefix(reply, "verify:ok:")
+	if !ok {
+		return nil, fmt.Errorf("unexpected reply %q", reply)
+	}
+	data, err := readVerificationData(r)
+	if err != nil {
+		return nil, err
+	}
+
+	run, err := verifyLocal(name, data)
+	if err != nil {
+		return nil, err
+	}
+	report := &verifyOnceReport{Synchronized: run.inSync, Database: name, Tables: []verifyOnceTable{}}
+	for _, t := range run.tables {
+		report.Tables = append(report.Tables, verifyOnceTable{t.table, t.status, t.local, t.master, t.schema, t.notes, t.contentDiffers})
+	}
+	return report, nil
+}
+
+// The master's report between verification_data:begin and
+// verification_data:end
+func readVerificationData(r *bufio.Reader) (*verificationData, error) {
+	reply, err := readReply(r)
+	if err != nil {
+		return nil, err
+	}
+	if reply != "verification_data:begin" {
+		return nil, fmt.Errorf("unexpected reply %q", reply)
+	}
+	data := newVerificationData()
+	for {
+		line, err := readReply(r)
+		if err != nil {
+			return nil, err
+		}
+		if line == "verification_data:end" {
+			return data, nil
+		}
+		if !data.add(line) {
+			return nil, fmt.Errorf("invalid table info %q", line)
+		}
+	}
+}
+
+// Compare the local copy of database name with the master's report. A
+// database that doesn't exist locally has every table missing.
+func verifyLocal(name string, data *verificationData) (verificationRun, error) {
+	cfg := newMySQLConfig(dbUser, dbPassword)
+	cfg.DBName = name
+	var err error
+	db, err = openVerifyDB(cfg)
+	if err != nil {
+		return verificationRun{}, fmt.Errorf("connection error: %v", err)
+	}
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		if isUnknownDatabase(err) {
+			return compareTables(data.tables, map[string]int{}), nil
+		}
+		return verificationRun{}, fmt.Errorf("failed to connect to local database: %v", err)
+	}
+	// A table that can't be counted shows up as missing
+	counts, _, err := localTableCounts()
+	if err != nil {
+		return verificationRun{}, err
+	}
+	run := compareTables(data.tables, counts)
+	compareSchemas(&run, data.schemas)
+	compareChecksums(&run, data.checksums)
+	return run, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// A master that answers one verify-once connection with the given tables
+// and row counts. Returns its address.
+func verifyMaster(t *testing.T, tables ...string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		// slave_info and subscribe
+		r.ReadString('\n')
+		r.ReadString('\n')
+		fmt.Fprintf(conn, "verify:ok:shop\nverification_data:begin\n")
+		for _, table := range tables {
+			fmt.Fprintf(conn, "table:%s\n", table)
+		}
+		fmt.Fprintf(conn, "verification_data:end\n")
+	}()
+	return ln.Addr().String()
+}
+
+// Compare against a fake local database
+func useVerifyDB(t *testing.T) *fakeDB {
+	t.Helper()
+	fake, conn := openFakeDB(t)
+	old, oldDB := openVerifyDB, db
+	openVerifyDB = func(*mysql.Config) (*sql.DB, error) { return conn, nil }
+	t.Cleanup(func() { openVerifyDB, db = old, oldDB })
+	return fake
+}
+
+func TestVerifyOnceExitStatusAndReport(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		localRows int64
+		code      int
+		status    string
+	}{
+		{"in sync", 3, 0, "MATCH"},
+		{"row count differs", 2, 1, "MISMATCH"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := useVerifyDB(t)
+			f.rows(`^SHOW TABLES$`, []string{"Tables"}, []driver.Value{"orders"})
+			f.rows(`^SELECT COUNT\(\*\) FROM orders$`, []string{"COUNT(*)"}, []driver.Value{tc.localRows})
+
+			var out bytes.Buffer
+			if code := verifyOnce(verifyMaster(t, "orders:3"), &out); code != tc.code {
+				t.Fatalf("exit status %d, want %d; printed %s", code, tc.code, out.String())
+			}
+			var report verifyOnceReport
+			if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+				t.Fatalf("output isn't one JSON object: %v\n%s", err, out.String())
+			}
+			if report.Synchronized != (tc.code == 0) || report.Database != "shop" || report.Error != "" {
+				t.Fatalf("report %+v", report)
+			}
+			if len(report.Tables) != 1 {
+				t.Fatalf("report lists %d tables", len(report.Tables))
+			}
+			got := report.Tables[0]
+			if got.Table != "orders" || got.Status != tc.status || got.LocalRows != int(tc.localRows) || got.MasterRows != 3 {
+				t.Fatalf("table report %+v, want status %s", got, tc.status)
+			}
+		})
+	}
+}
+
+func TestVerifyOnceReportsAnUnreachableMaster(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	var out bytes.Buffer
+	if code := verifyOnce(addr, &out); code != 2 {
+		t.Fatalf("exit status %d, want 2", code)
+	}
+	var report verifyOnceReport
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatalf("output isn't one JSON object: %v\n%s", err, out.String())
+	}
+	if report.Synchronized || report.Error == "" || report.Tables == nil {
+		t.Fatalf("report %+v, want the error and an empty table list", report)
+	}
+}
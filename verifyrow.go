@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Single-row verification. The slave sends verify_row:<table>:<id>, the
+// master answers with row_data:<json rowSnapshot> holding its copy of the
+// row, and the slave compares it field by field with its own.
+
+type rowSnapshot struct {
+	Table   string    `json:"table"`
+	ID      string    `json:"id"`
+	Found   bool      `json:"found"`
+	Columns []string  `json:"columns,omitempty"`
+	Values  []*string `json:"values,omitempty"` // nil for NULL, base64 for binary columns
+}
+
+var tableNameRe = regexp.MustCompile(`^\w+$`)
+
+// Read one row by its primary key from the local database. The table must
+// have a single-column primary key.
+func fetchRow(table, id string) (*rowSnapshot, error) {
+	if !tableNameRe.MatchString(table) {
+		return nil, fmt.Errorf("invalid table name %q", table)
+	}
+	keys, err := primaryKeyColumns(table)
+	if err != nil {
+		return nil, fmt.Errorf("reading primary key of %s: %v", table, err)
+	}
+	if len(keys) != 1 {
+		return nil, fmt.Errorf("table %s has no single-column primary key", table)
+	}
+
+	rows, err := db.Query(fmt.Sprintf("SELECT * FROM `%s` WHERE `%s` = ?", table, keys[0]), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snap := &rowSnapshot{Table: table, ID: id}
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	binary := binaryColumns(rows)
+	if !rows.Next() {
+		return snap, rows.Err()
+	}
+
+	values := make([]interface{}, len(columns))
+	scanArgs := make([]interface{}, len(columns))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+	if err := rows.Scan(scanArgs...); err != nil {
+		return nil, err
+	}
+	snap.Found = true
+	snap.Columns = columns
+	snap.Values = exportFields(values, binary)
+	return snap, nil
+}
+
+// Field-level differences between the master's and the slave's copy of a
+// row, empty if they match
+func diffRows(master, local *rowSnapshot) []string {
+	switch {
+	case !master.Found && !local.Found:
+		return nil
+	case !master.Found:
+		return []string{"row exists on the slave but not on the master"}
+	case !local.Found:
+		return []string{"row exists on the master but not on the slave"}
+	}
+
+	localValues := make(map[string]*string, len(local.Columns))
+	for i, c := range local.Columns {
+		localValues[c] = local.Values[i]
+	}
+
+	var diffs []string
+	seen := make(map[string]bool, len(master.Columns))
+	for i, c := range master.Columns {
+		seen[c] = true
+		lv, ok := localValues[c]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("%s: column missing on the slave", c))
+			continue
+		}
+		if mv := master.Values[i]; !sameField(mv, lv) {
+			diffs = append(diffs, fmt.Sprintf("%s: master=%s slave=%s", c, fieldText(mv), fieldText(lv)))
+		}
+	}
+	for _, c := range local.Columns {
+		if !seen[c] {
+			diffs = append(diffs, fmt.Sprintf("%s: column only exists on the slave", c))
+		}
+	}
+	return diffs
+}
+
+func sameField(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func fieldText(v *string) string {
+	if v == nil {
+		return "NULL"
+	}
+	return fmt.Sprintf("%q", *v)
+}
+
+// Master side of verify_row
+func handleVerifyRow(s *slaveConn, content string) {
+	table, id, ok := parseMessage(content)
+	if !ok {
+		s.reply("error:verify_row needs <table>:<id>\n")
+		return
+	}
+	snap, err := fetchRow(table, id)
+	if err != nil {
+		s.reply("error:Failed to read row %s from %s: %v\n", id, table, err)
+		return
+	}
+	data, _ := json.Marshal(snap)
+	s.reply("row_data:%s\n", data)
+}
+
+// Slave side of row_data: compare the master's copy with the local one
+func compareRow(content string) {
+	var master rowSnapshot
+	if err := json.Unmarshal([]byte(content), &master); err != nil {
+		fmt.Printf("Invalid row data from master: %v\n", err)
+		return
+	}
+	local, err := fetchRow(master.Table, master.ID)
+	if err != nil {
+		fmt.Printf("Failed to read local row %s from %s: %v\n", master.ID, master.Table, err)
+		return
+	}
+
+	fmt.Printf("\nRow %s of table '%s':\n", master.ID, master.Table)
+	diffs := diffRows(&master, local)
+	switch {
+	case len(diffs) == 0 && !master.Found:
+		fmt.Println("  Row doesn't exist on the master or the slave")
+	case len(diffs) == 0:
+		fmt.Printf("  ✓ All %d field(s) match the master\n", len(master.Columns))
+	default:
+		fmt.Printf("  ✗ %d difference(s):\n", len(diffs))
+		for _, d := range diffs {
+			fmt.Println("    -", d)
+		}
+	}
+}
+
+// Menu action: ask the master for one row and compare it with ours
+func verifyRow() {
+	if db == nil {
+		fmt.Println("Local database not set up yet")
+		return
+	}
+	if !connected {
+		fmt.Println("Not connected to master server")
+		return
+	}
+
+	fmt.Print("Enter table name: ")
+	table := strings.TrimSpace(readLine())
+	fmt.Print("Enter primary key value: ")
+	id := strings.TrimSpace(readLine())
+	if table == "" || id == "" {
+		fmt.Println("Table name and primary key value are required")
+		return
+	}
+
+	fmt.Fprintf(master, "verify_row:%s:%s\n", table, id)
+	// The comparison is done in listenToMaster when row_data arrives
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+func TestRowComparisonListsEachDifferingField(t *testing.T) {
+	f := useFakeDB(t)
+	useIntegerKey(f, "id")
+	// The slave's copy: name changed, note NULL, and an extra column
+	f.on("^SELECT \\* FROM `orders` WHERE `id` = \\?$", func([]driver.Value) fakeResult {
+		return fakeResult{cols: []string{"id", "name", "note", "extra"},
+			rows: [][]driver.Value{{int64(7), "bob", nil, "x"}}}
+	})
+
+	// The master's: has qty, which the slave lacks
+	out := captureOutput(t, func() {
+		compareRow(`{"table":"orders","id":"7","found":true,"columns":["id","name","note","qty"],"values":["7","alice","hi","2"]}`)
+	})
+	for _, want := range []string{
+		"Row 7 of table 'orders':",
+		"✗ 4 difference(s):",
+		`- name: master="alice" slave="bob"`,
+		`- note: master="hi" slave=NULL`,
+		"- qty: column missing on the slave",
+		"- extra: column only exists on the slave",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "- id:") {
+		t.Errorf("matching id listed as a difference:\n%s", out)
+	}
+}
+
+func TestRowComparisonOfMatchingOrMissingRows(t *testing.T) {
+	f := useFakeDB(t)
+	useIntegerKey(f, "id")
+	f.on("^SELECT \\* FROM `orders` WHERE `id` = \\?$", func(args []driver.Value) fakeResult {
+		res := fakeResult{cols: []string{"id", "name"}}
+		if args[0] == "7" {
+			res.rows = [][]driver.Value{{int64(7), "alice"}}
+		}
+		return res
+	})
+
+	cases := []struct{ snapshot, want string }{
+		{`{"table":"orders","id":"7","found":true,"columns":["id","name"],"values":["7","alice"]}`, "✓ All 2 field(s) match the master"},
+		{`{"table":"orders","id":"7","found":false}`, "- row exists on the slave but not on the master"},
+		{`{"table":"orders","id":"8","found":true,"columns":["id","name"],"values":["8","carol"]}`, "- row exists on the master but not on the slave"},
+		{`{"table":"orders","id":"8","found":false}`, "Row doesn't exist on the master or the slave"},
+	}
+	for _, c := range cases {
+		if out := captureOutput(t, func() { compareRow(c.snapshot) }); !strings.Contains(out, c.want) {
+			t.Errorf("comparing %s printed:\n%s\nwant %q", c.snapshot, out, c.want)
+		}
+	}
+}
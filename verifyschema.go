@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Structure check for Verify Replication. A slave asking with
+// verify_replication:schema gets each table's CREATE TABLE after its row
+// count, as table_schema:<name>:<base64 definition>, tailored for the
+// slave's server version like in a sync. The slave compares it with its own
+// SHOW CREATE TABLE line by line, after taking out what may legitimately
+// differ: the AUTO_INCREMENT counter and integer display widths, which
+// MySQL 8.0 no longer shows. Only columns and indexes that differ fail the
+// check and get the table copied again. Cosmetic differences, in the table
+// options (storage engine, character set, comment) or the order of the
+// indexes, are reported as notes. Masters that don't know the request send
+// no definitions, and the structure isn't compared.
+
+var (
+	autoIncOptionRe = regexp.MustCompile(`\s+AUTO_INCREMENT=\d+`)
+	engineOptionRe  = regexp.MustCompile(`\s+ENGINE=(\w+)`)
+	intWidthRe      = regexp.MustCompile(`(?i)\b(tinyint|smallint|mediumint|int|bigint)\(\d+\)`)
+)
+
+// Tables, row counts and, if sent, definitions from the master's
+// verification_data report
+type verificationData struct {
+	tables    map[string]int
+	schemas   map[string]string
+	checksums map[string]uint64 // see verifychecksum.go
+}
+
+func newVerificationData() *verificationData {
+	return &verificationData{tables: make(map[string]int), schemas: make(map[string]string),
+		checksums: make(map[string]uint64)}
+}
+
+// Take one line of the report, false if it isn't a valid one
+func (v *verificationData) add(line string) bool {
+	if rest, ok := strings.CutPrefix(line, "table_schema:"); ok {
+		name, encoded, ok := parseMessage(rest)
+		def, err := base64.StdEncoding.DecodeString(encoded)
+		if !ok || err != nil {
+			return false
+		}
+		v.schemas[name] = string(def)
+		return true
+	}
+	name, count, ok := parseTableInfo(line)
+	if ok {
+		v.tables[name] = count
+		if sum, ok := parseTableChecksum(line); ok {
+			v.checksums[name] = sum
+		}
+	}
+	return ok
+}
+
+// The lines of a CREATE TABLE that have to match, and its engine
+func normalizeCreateTable(def string) ([]string, string) {
+	engine := ""
+	if m := engineOptionRe.FindStringSubmatch(def); m != nil {
+		engine = m[1]
+	}
+	def = autoIncOptionRe.ReplaceAllString(def, "")
+	def = engineOptionRe.ReplaceAllString(def, "")
+	def = intWidthRe.ReplaceAllString(def, "$1")
+
+	var lines []string
+	for _, line := range strings.Split(def, "\n") {
+		line = strings.TrimSuffix(strings.Join(strings.Fields(line), " "), ",")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, engine
+}
+
+// Structural differences between the master's and the local definition of
+// a table, and the cosmetic ones, which are accepted
+func schemaDiff(masterDef, localDef string) (diffs, notes []string) {
+	master, masterEngine := normalizeCreateTable(masterDef)
+	local, localEngine := normalizeCreateTable(localDef)
+	master, masterOptions := splitTableOptions(master)
+	local, localOptions := splitTableOptions(local)
+
+	for _, line := range master {
+		if !slices.Contains(local, line) {
+			diffs = append(diffs, "only on master: "+line)
+		}
+	}
+	for _, line := range local {
+		if !slices.Contains(master, line) {
+			diffs = append(diffs, "only locally: "+line)
+		}
+	}
+	if len(diffs) == 0 && !slices.Equal(master, local) {
+		if slices.Equal(columnLines(master), columnLines(local)) {
+			notes = append(notes, "indexes are in a different order")
+		} else {
+			diffs = append(diffs, "columns are in a different order")
+		}
+	}
+	if masterEngine != localEngine {
+		notes = append(notes, fmt.Sprintf("engine is %s on master, %s locally", masterEngine, localEngine))
+	}
+	if masterOptions != localOptions {
+		notes = append(notes, fmt.Sprintf("table options are %q on master, %q locally", masterOptions, localOptions))
+	}
+	return diffs, notes
+}
+
+// Take the closing line, with the table options, off a normalized definition
+func splitTableOptions(lines []string) ([]string, string) {
+	if n := len(lines); n > 0 && strings.HasPrefix(lines[n-1], ")") {
+		return lines[:n-1], strings.TrimSpace(strings.TrimPrefix(lines[n-1], ")"))
+	}
+	return lines, ""
+}
+
+// The column definitions of a normalized definition, in order
+func columnLines(lines []string) []string {
+	var cols []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "`") {
+			cols = append(cols, line)
+		}
+	}
+	return cols
+}
+
+// Whether a create_table from the master describes a table as we already
+// have it. The master sends the definition on one line, the local one is
+// flattened the same way.
+func sameTableDefinition(masterDef, localDef string) bool {
+	diffs, _ := schemaDiff(strings.ReplaceAll(masterDef, "\n", " "), strings.ReplaceAll(localDef, "\n", " "))
+	return len(diffs) == 0
+}
+
+// Compare the definitions of the tables present on both sides, for the
+// tables the master sent one for
+func compareSchemas(run *verificationRun, masterSchemas map[string]string) {
+	for i := range run.tables {
+		t := &run.tables[i]
+		masterDef, ok := masterSchemas[t.table]
+		if !ok || t.status == "MISSING" || t.status == "EXTRA" {
+			continue
+		}
+		var name, localDef string
+		if err := db.QueryRow("SHOW CREATE TABLE "+t.table).Scan(&name, &localDef); err != nil {
+			t.schema = []string{fmt.Sprintf("local definition unreadable: %v", err)}
+		} else {
+			t.schema, t.notes = schemaDiff(masterDef, localDef)
+		}
+		if len(t.schema) > 0 {
+			run.inSync = false
+			if t.status == "MATCH" {
+				t.status = "SCHEMA"
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+const masterOrdersDef = "CREATE TABLE `orders` (\n" +
+	"  `id` int NOT NULL AUTO_INCREMENT,\n" +
+	"  `customer` varchar(100) DEFAULT NULL,\n" +
+	"  `total` decimal(10,2) DEFAULT NULL,\n" +
+	"  PRIMARY KEY (`id`),\n" +
+	"  KEY `by_customer` (`customer`),\n" +
+	"  KEY `by_total` (`total`)\n" +
+	") ENGINE=InnoDB AUTO_INCREMENT=12 DEFAULT CHARSET=utf8mb4 COMMENT='ddb:conflict=overwrite'"
+
+func TestCosmeticSchemaDifferencesAreNotes(t *testing.T) {
+	local := "CREATE TABLE `orders` (\n" +
+		"  `id` int(11) NOT NULL AUTO_INCREMENT,\n" +
+		"  `customer` varchar(100) DEFAULT NULL,\n" +
+		"  `total` decimal(10,2) DEFAULT NULL,\n" +
+		"  PRIMARY KEY (`id`),\n" +
+		"  KEY `by_total` (`total`),\n" +
+		"  KEY `by_customer` (`customer`)\n" +
+		") ENGINE=MyISAM DEFAULT CHARSET=latin1"
+	diffs, notes := schemaDiff(masterOrdersDef, local)
+	if len(diffs) != 0 {
+		t.Fatalf("structural differences %q, want none", diffs)
+	}
+	want := []string{"indexes are in a different order", "engine is InnoDB on master, MyISAM locally", "table options"}
+	if len(notes) != len(want) {
+		t.Fatalf("notes %q", notes)
+	}
+	for i, w := range want {
+		if !strings.HasPrefix(notes[i], w) {
+			t.Fatalf("note %d is %q, want %q", i, notes[i], w)
+		}
+	}
+}
+
+func TestColumnAndKeyDifferencesFailTheCheck(t *testing.T) {
+	for _, tc := range []struct {
+		name, local, want string
+	}{
+		{"column type", strings.Replace(masterOrdersDef, "decimal(10,2)", "float", 1), "only on master: `total` decimal(10,2) DEFAULT NULL"},
+		{"missing index", strings.Replace(masterOrdersDef, ",\n  KEY `by_total` (`total`)", "", 1), "only on master: KEY `by_total` (`total`)"},
+		{"column order", strings.Replace(masterOrdersDef,
+			"`customer` varchar(100) DEFAULT NULL,\n  `total` decimal(10,2) DEFAULT NULL",
+			"`total` decimal(10,2) DEFAULT NULL,\n  `customer` varchar(100) DEFAULT NULL", 1),
+			"columns are in a different order"},
+	} {
+		diffs, _ := schemaDiff(masterOrdersDef, tc.local)
+		if len(diffs) == 0 || diffs[0] != tc.want {
+			t.Errorf("%s: differences %q, want %q first", tc.name, diffs, tc.want)
+		}
+	}
+}
+
+func TestCosmeticDifferenceKeepsTheTableInSync(t *testing.T) {
+	f := useFakeDB(t)
+	local := strings.Replace(masterOrdersDef, " COMMENT='ddb:conflict=overwrite'", "", 1)
+	f.rows(`^SHOW CREATE TABLE orders$`, []string{"Table", "Create Table"}, []driver.Value{"orders", local})
+
+	run := verificationRun{inSync: true, tables: []tableVerification{{table: "orders", status: "MATCH", local: 3, master: 3}}}
+	compareSchemas(&run, map[string]string{"orders": masterOrdersDef})
+	got := run.tables[0]
+	if !run.inSync || got.status != "MATCH" || len(got.schema) != 0 || len(got.notes) != 1 {
+		t.Fatalf("got %+v, in sync %v; want a MATCH with a note", got, run.inSync)
+	}
+}